@@ -9,4 +9,5 @@ type Cache interface {
 	Get(key []byte) (value interface{}, found bool)
 	Set(key []byte, value interface{}) error
 	SetWithExpiration(key []byte, value interface{}, expiration time.Duration) error
+	Delete(key []byte) error
 }
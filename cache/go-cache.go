@@ -6,7 +6,12 @@ import (
 	gocache "github.com/patrickmn/go-cache"
 )
 
-// GoCache is the caching layer implemented by go-cache.
+// GoCache is the caching layer implemented by go-cache. Note that go-cache
+// computes expiration with wall clock time, so a wall clock jump (NTP step, VM
+// resume) changes when items expire: a forward jump expires items early (e.g.
+// a duplicate msg may be accepted again, or a late reply finds no reply chan),
+// and a backward jump keeps items longer. Timeouts that must be exact should
+// use timers instead of relying on cache expiration.
 type GoCache struct {
 	cache *gocache.Cache
 }
@@ -56,3 +61,10 @@ func (gc *GoCache) SetWithExpiration(key []byte, value interface{}, expiration t
 	gc.cache.Set(gc.byteKeyToStringKey(key), value, expiration)
 	return nil
 }
+
+// Delete deletes an item from the cache. Does nothing if the key is not in the
+// cache.
+func (gc *GoCache) Delete(key []byte) error {
+	gc.cache.Delete(gc.byteKeyToStringKey(key))
+	return nil
+}
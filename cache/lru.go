@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// LRUCache is a cache that holds at most a fixed number of items, evicting the
+// least recently used item when full. Items also expire after their expiration
+// like GoCache, but expiration is computed with the monotonic clock, so it is
+// not affected by wall clock jumps. Expired items are removed lazily when they
+// are accessed or evicted.
+type LRUCache struct {
+	sync.Mutex
+	size              int
+	defaultExpiration time.Duration
+	items             map[string]*list.Element
+	order             *list.List // front is the most recently used
+}
+
+// lruItem is an item in LRUCache
+type lruItem struct {
+	key        string
+	value      interface{}
+	expiration time.Time // zero means never expires
+}
+
+// NewLRUCache creates a LRU cache that holds at most size items with a given
+// default expiration duration. Expiration 0 means items never expire.
+func NewLRUCache(size int, defaultExpiration time.Duration) (*LRUCache, error) {
+	if size <= 0 {
+		return nil, errors.New("LRU cache size should be positive")
+	}
+	return &LRUCache{
+		size:              size,
+		defaultExpiration: defaultExpiration,
+		items:             make(map[string]*list.Element, size),
+		order:             list.New(),
+	}, nil
+}
+
+// isExpired returns if item has expired
+func (item *lruItem) isExpired() bool {
+	return !item.expiration.IsZero() && time.Now().After(item.expiration)
+}
+
+// expirationTime returns the expiration time of an item added now with
+// expiration, using default expiration if expiration is 0
+func (c *LRUCache) expirationTime(expiration time.Duration) time.Time {
+	if expiration == 0 {
+		expiration = c.defaultExpiration
+	}
+	if expiration <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(expiration)
+}
+
+// getElement returns the unexpired element of key, removing it if expired.
+// Caller should hold the lock.
+func (c *LRUCache) getElement(key string) *list.Element {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	if elem.Value.(*lruItem).isExpired() {
+		c.removeElement(elem)
+		return nil
+	}
+	return elem
+}
+
+// removeElement removes elem from cache. Caller should hold the lock.
+func (c *LRUCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*lruItem).key)
+}
+
+// set adds or replaces key, evicting the least recently used item if cache is
+// full. Caller should hold the lock.
+func (c *LRUCache) set(key string, value interface{}, expiration time.Duration) {
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*lruItem)
+		item.value = value
+		item.expiration = c.expirationTime(expiration)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	for c.order.Len() >= c.size {
+		c.removeElement(c.order.Back())
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{
+		key:        key,
+		value:      value,
+		expiration: c.expirationTime(expiration),
+	})
+}
+
+// Add adds an item to the cache only if an item doesn't already exist for the
+// given key, or if the existing item has expired, using the default expiration.
+// Returns an error otherwise.
+func (c *LRUCache) Add(key []byte, value interface{}) error {
+	return c.AddWithExpiration(key, value, 0)
+}
+
+// AddWithExpiration adds an item to the cache only if an item doesn't already
+// exist for the given key, or if the existing item has expired, using specified
+// expiration. Returns an error otherwise.
+func (c *LRUCache) AddWithExpiration(key []byte, value interface{}, expiration time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.getElement(string(key)) != nil {
+		return errors.New("Item already exists")
+	}
+
+	c.set(string(key), value, expiration)
+
+	return nil
+}
+
+// Get gets an item from the cache and marks it as most recently used. Returns
+// the item or nil, and a bool indicating whether the key was found.
+func (c *LRUCache) Get(key []byte) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	elem := c.getElement(string(key))
+	if elem == nil {
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*lruItem).value, true
+}
+
+// Set adds an item to the cache, replacing any existing item, using the default
+// expiration.
+func (c *LRUCache) Set(key []byte, value interface{}) error {
+	return c.SetWithExpiration(key, value, 0)
+}
+
+// SetWithExpiration adds an item to the cache, replacing any existing item,
+// using specified expiration.
+func (c *LRUCache) SetWithExpiration(key []byte, value interface{}, expiration time.Duration) error {
+	c.Lock()
+	defer c.Unlock()
+	c.set(string(key), value, expiration)
+	return nil
+}
+
+// Delete deletes an item from the cache. Does nothing if the key is not in the
+// cache.
+func (c *LRUCache) Delete(key []byte) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.items[string(key)]; ok {
+		c.removeElement(elem)
+	}
+
+	return nil
+}
+
+// Len returns the number of items in the cache, including expired items that
+// have not been removed yet
+func (c *LRUCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.order.Len()
+}
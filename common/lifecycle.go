@@ -1,12 +1,18 @@
 package common
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // LifeCycle is an abstract type that has thread-safe initialization and shutdown
 type LifeCycle struct {
 	StartOnce sync.Once
 	StopOnce  sync.Once
 	stopped   bool
+	stopChan  chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
 	stopLock  sync.RWMutex
 	ready     bool
 	readyLock sync.RWMutex
@@ -15,8 +21,50 @@ type LifeCycle struct {
 // Stop set lifecycle status to stopped
 func (l *LifeCycle) Stop() {
 	l.stopLock.Lock()
+	defer l.stopLock.Unlock()
+
+	if l.stopped {
+		return
+	}
+
 	l.stopped = true
-	l.stopLock.Unlock()
+	if l.stopChan != nil {
+		close(l.stopChan)
+	}
+	if l.cancel != nil {
+		l.cancel()
+	}
+}
+
+// Done returns a channel that is closed when lifecycle is stopped
+func (l *LifeCycle) Done() <-chan struct{} {
+	l.stopLock.Lock()
+	defer l.stopLock.Unlock()
+
+	if l.stopChan == nil {
+		l.stopChan = make(chan struct{})
+		if l.stopped {
+			close(l.stopChan)
+		}
+	}
+
+	return l.stopChan
+}
+
+// Context returns a context that is canceled when lifecycle is stopped, which
+// can be passed to functions that take a context instead of selecting on Done
+func (l *LifeCycle) Context() context.Context {
+	l.stopLock.Lock()
+	defer l.stopLock.Unlock()
+
+	if l.ctx == nil {
+		l.ctx, l.cancel = context.WithCancel(context.Background())
+		if l.stopped {
+			l.cancel()
+		}
+	}
+
+	return l.ctx
 }
 
 // IsStopped returns if lifecycle is stopped
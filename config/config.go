@@ -8,32 +8,88 @@ import (
 
 // Config is the configuration struct
 type Config struct {
-	Transport      string // which transport to use, e.g. tcp, udp, kcp
+	Transport      string // which transport to use, e.g. tcp, kcp, unix (unix domain socket), mem (in-memory pipe within the process)
 	Hostname       string // IP or domain name for remote node to connect to, e.g. 127.0.0.1, nkn.org. Empty string means remote nodes will fill it with your address they saw, which works if all nodes are not in the same local network or are all in the local network, but will cause problem if some nodes are in the same local network
 	Port           uint16 // port to listen to incoming connections
 	NodeIDBytes    uint32 // length of node id in bytes
 	MessageIDBytes uint8  // MsgIDBytes is the length of message id in RandBytes
 
+	ExtraAddrs []string // Additional addresses for remote node to connect to if the primary one is unreachable, e.g. tcp://relay.nkn.org:30001, tried in order
+
 	Multiplexer        string // which multiplexer to use, e.g. smux, yamux
 	NumStreamsToOpen   uint32 // number of streams to open per remote node
 	NumStreamsToAccept uint32 // number of streams to accept per remote node
+	EnableAppStreams   bool   // allow application to open streams to remote node on top of the multiplexer, needs to be enabled on both nodes
+
+	InProcessLoopback bool // pass msg directly between local nodes in the same process without transport and serialization, needs to be enabled on both nodes
 
 	LocalRxMsgChanLen              uint32        // Max number of msg that can be buffered per routing type
 	LocalHandleMsgChanLen          uint32        // Max number of msg to be processed that can be buffered
 	LocalRxMsgCacheExpiration      time.Duration // How long a received message id stays in cache before expiration
 	LocalRxMsgCacheCleanupInterval time.Duration // How often to check and delete expired received message id
+	LocalRxMsgCacheSize            uint32        // Max number of received message id in cache used to discard duplicate msg, least recently used id is evicted when full, 0 means unlimited
+	LocalMsgHandleTimeout          time.Duration // Max time to handle a single msg (including middleware) before moving on to the next one, 0 to disable
+	LocalMsgHandleTimeoutPolicy    string        // What to do when handling a msg exceeds LocalMsgHandleTimeout besides logging: none or stop (stop the remote node that sends the msg)
+	LocalMaxMsgHandlers            uint32        // Max number of msg handlers running at the same time when LocalMsgHandleTimeout is set, including the ones exceeding it and still running in background. When reached, handling the next msg waits until one of them finishes
 
 	RemoteRxMsgChanLen              uint32        // Max number of msg received that can be buffered
+	RemoteRxBufferSize              uint32        // Size of buffer conn is read through. Msg len and small msg are read from it to save syscalls, while msg body not smaller than it is read directly into msg buffer. Larger size helps high bandwidth links
+	RemoteRxOverflowPolicy          string        // What to do when msg received but rx msg chan is full: drop (discard msg) or block (wait for room up to BackpressureBlockTimeout)
 	RemoteTxMsgChanLen              uint32        // Max number of msg to be sent that can be buffered
+	RemoteTxPriorityChanLen         uint32        // Max number of node control msg (e.g. ping) and replies to be sent that can be buffered separately and sent before other msg. They go to tx msg chan when it is full
+	RemoteTxPriorityRatio           uint32        // Max number of consecutive msg sent from tx priority chan before one msg from tx msg chan is sent, so that other msg are not starved
+	RemoteTxOverflowPolicy          string        // What to do when sending msg but tx msg chan is full: reject (reject new msg) or dropoldest (discard the oldest msg in chan)
+	BackpressureBlockTimeout        time.Duration // Max time to wait for room in a full chan when backpressure action is block before discarding msg, 0 means wait until remote node stops
+	RemoteTxBatchSize               uint32        // Max number of msg already queued that are framed into one buffer and written to conn in one call, 0 or 1 to write each msg separately
+	RemoteTxBatchBytes              uint32        // Max total frame size of msg written in one call when RemoteTxBatchSize is greater than 1. A msg larger than it is still sent, but alone
+	RemoteBroadcastPacingLen        uint32        // If positive, broadcast msg that do not fit in tx msg chan are queued (up to this many) and fed to tx msg chan at the rate remote node can accept (its rate limit if set, otherwise measured write latency) instead of being dropped, 0 to disable
 	RemoteTxMsgCacheExpiration      time.Duration // How long a sent message id stays in cache before expiration
 	RemoteTxMsgCacheCleanupInterval time.Duration // How often to check and delete expired sent message
+	RemoteMsgJournalSize            uint32        // Number of recent msg sent and received per remote node to keep in journal for debugging, 0 to disable
 
 	MaxMessageSize               uint32        // Max message size in bytes
+	OversizedMsgPolicy           string        // What to do when receiving msg that exceeds MaxMessageSize, either as a frame or after decompression: stop (close connection) or skip (discard msg and keep connection)
+	OversizedMsgDiscardLimit     uint32        // Max size in bytes of an oversized frame that is read and discarded under skip policy, larger frame always closes connection
+	UnmarshalErrorPolicy         string        // What to do when msg received cannot be unmarshaled: stop (close connection) or drop (discard msg, close connection if UnmarshalErrorThreshold is reached)
+	UnmarshalErrorThreshold      uint32        // Close connection if this many msg cannot be unmarshaled within UnmarshalErrorWindow under drop policy, 0 means never
+	UnmarshalErrorWindow         time.Duration // Time window of UnmarshalErrorThreshold
+	Compression                  string        // Default compression codec for msg sent to remote node, e.g. none, gzip, flatedict. Remote node needs to support decompression
+	CompressionDictionary        []byte        // Pre-shared dictionary used by flatedict codec to compress small repetitive msg, remote node needs to have the same one
+	CompressionCodecs            []string      // Preferred compression codecs for msg sent to remote node in order. If not empty, the first one that remote node advertises at handshake is used instead of Compression
+	CompressionThreshold         uint32        // Msg smaller than this many bytes is sent uncompressed, 0 to compress all msg
+	MessageCodecs                []string      // Preferred codecs (e.g. protobuf, json) to encode msg sent to remote node in order, the first one that remote node advertises at handshake is used, protobuf if none
+	RateLimit                    uint32        // Default max bytes per second sent to each remote node, 0 means unlimited
+	RxRateLimitMsgs              uint32        // Default max msg per second received from each remote node, 0 means unlimited
+	RxRateLimitBytes             uint32        // Default max bytes per second received from each remote node, including length prefix, 0 means unlimited
+	RxRateLimitPolicy            string        // What to do when remote node exceeds RxRateLimitMsgs or RxRateLimitBytes: delay (stop reading from conn until within limit) or stop (close connection)
+	SendBudget                   uint32        // Max bytes of msg that can be sent to each remote node in each SendBudgetWindow, 0 means unlimited
+	SendBudgetWindow             time.Duration // Time window of SendBudget
+	SendBudgetPolicy             string        // What SendMessage does when SendBudget is exhausted: error (return error) or block (wait until window resets)
+	MaxTxQueuedBytes             uint32        // Max total bytes of msg queued to be sent to all remote nodes, 0 means unlimited. Node control msg are counted but never rejected
+	TxQueuedBytesPolicy          string        // What SendMessage does when MaxTxQueuedBytes is reached: error (return error) or block (wait until queues drain)
 	DefaultReplyTimeout          time.Duration // default timeout for receiving reply msg
+	AdaptiveReplyTimeoutFactor   float64       // If positive, default timeout for reply from a remote node is this factor times its measured round trip time, but no less than AdaptiveReplyTimeoutFloor
+	AdaptiveReplyTimeoutFloor    time.Duration // Min default timeout for reply from a remote node when AdaptiveReplyTimeoutFactor is positive
+	ReplyMismatchPolicy          string        // What to do when a msg is sent with or without waiting for reply but the msg type will never or always be replied: log or error
 	ReplyChanCleanupInterval     time.Duration // How often to check and delete expired reply chan
-	MeasureRoundTripTimeInterval time.Duration // Time interval between measuring round trip time
-	KeepAliveTimeout             time.Duration // Max idle time before considering node dead and closing connection
+	MeasureRoundTripTimeInterval time.Duration // Time interval between measuring round trip time, which is also the keepalive ping interval
+	KeepAliveTimeout             time.Duration // Max idle time before considering node dead and closing connection. Must be longer than MeasureRoundTripTimeInterval (preferably a few times) unless keepalive ping or timeout is disabled, otherwise an idle but healthy connection is closed between pings
+	DisableKeepAliveTimeout      bool          // Never close connection because of KeepAliveTimeout, dead remote node is then only detected by transport (e.g. TCP keepalive or write error). Only for trusted, reliable links
+	DisableKeepAlivePing         bool          // Do not send periodic ping to remote node, which also disables round trip time measurement
+	KeepAlivePingTimeout         time.Duration // Max time to wait for reply of keepalive ping, 0 means default reply timeout of remote node
+	KeepAliveMaxUnanswered       uint32        // Close connection after this many consecutive keepalive pings are not replied within KeepAlivePingTimeout, which detects half-open connection sooner than KeepAliveTimeout, 0 to disable
+	StallTimeout                 time.Duration // Close connection if neither rx nor tx makes progress within this duration even if conn reports no error, 0 to disable
+	IdleTimeout                  time.Duration // Close connection if no msg other than node control msg (e.g. ping) is sent or received within this duration, 0 to disable
+	DrainTimeout                 time.Duration // Close connection after it has been draining (either side sent Drain msg) for this duration, 0 to disable
 	DialTimeout                  time.Duration // Transport dial timeout
+	ReconnectBaseDelay           time.Duration // Delay before the first auto reconnect attempt to an outbound remote node, doubled after each failed attempt
+	ReconnectMaxDelay            time.Duration // Max delay between auto reconnect attempts
+	ReconnectMaxRetries          uint32        // Max number of auto reconnect attempts before giving up, 0 means unlimited
+	ReconnectInitialJitter       time.Duration // Max random delay added to the first auto reconnect attempt, so that nodes disconnected from the same peer at the same time do not all reconnect to it at the same time when it comes back, 0 to disable
+	TCPDelay                     bool          // Enable Nagle's algorithm (disable TCP_NODELAY) on TCP conn to favor throughput over latency
+	TLSHandshakeTimeout          time.Duration // Max time for TLS handshake if conn with remote node is a TLS conn
+	InboundSetupTimeout          time.Duration // Max time from accepting an inbound conn until remote node is ready (TLS handshake, GetNode, etc), 0 to disable
+	FrameAssemblyTimeout         time.Duration // Max time to receive a full msg after its length prefix is received before closing connection, 0 to disable
 
 	OverlayLocalMsgChanLen uint32 // Max number of msg to be processed by local node that can be buffered
 
@@ -58,18 +114,42 @@ func DefaultConfig() *Config {
 		LocalHandleMsgChanLen:          23333,
 		LocalRxMsgCacheExpiration:      300 * time.Second,
 		LocalRxMsgCacheCleanupInterval: 10 * time.Second,
+		LocalMsgHandleTimeoutPolicy:    "none",
+		LocalMaxMsgHandlers:            64,
 
 		RemoteRxMsgChanLen:              2333,
 		RemoteTxMsgChanLen:              2333,
+		RemoteRxBufferSize:              16 * 1024,
+		RemoteTxPriorityChanLen:         233,
+		RemoteTxPriorityRatio:           8,
+		RemoteTxBatchBytes:              64 * 1024,
+		RemoteRxOverflowPolicy:          "drop",
+		RemoteTxOverflowPolicy:          "reject",
 		RemoteTxMsgCacheExpiration:      300 * time.Second,
 		RemoteTxMsgCacheCleanupInterval: 10 * time.Second,
 
 		MaxMessageSize:               20 * 1024 * 1024,
+		OversizedMsgPolicy:           "stop",
+		OversizedMsgDiscardLimit:     64 * 1024 * 1024,
+		UnmarshalErrorPolicy:         "stop",
+		UnmarshalErrorThreshold:      10,
+		UnmarshalErrorWindow:         1 * time.Minute,
+		Compression:                  "none",
+		SendBudgetWindow:             1 * time.Second,
+		SendBudgetPolicy:             "error",
+		RxRateLimitPolicy:            "delay",
+		TxQueuedBytesPolicy:          "error",
 		DefaultReplyTimeout:          5 * time.Second,
+		AdaptiveReplyTimeoutFloor:    1 * time.Second,
+		ReplyMismatchPolicy:          "log",
 		ReplyChanCleanupInterval:     1 * time.Second,
 		MeasureRoundTripTimeInterval: 5 * time.Second,
 		KeepAliveTimeout:             20 * time.Second,
+		DrainTimeout:                 5 * time.Second,
 		DialTimeout:                  5 * time.Second,
+		ReconnectBaseDelay:           time.Second,
+		ReconnectMaxDelay:            time.Minute,
+		TLSHandshakeTimeout:          5 * time.Second,
 
 		OverlayLocalMsgChanLen: 23333,
 
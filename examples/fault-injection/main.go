@@ -0,0 +1,140 @@
+// This example shows how to use util.FaultyConn to inject faults (short reads,
+// latency, corrupted bytes, connection errors) into the connection between
+// two nodes and observe how remote node handles them.
+
+// Run with default options: go run main.go
+
+// Show usage: go run main.go -h
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/nknorg/nnet"
+	"github.com/nknorg/nnet/log"
+	"github.com/nknorg/nnet/node"
+	"github.com/nknorg/nnet/transport"
+	"github.com/nknorg/nnet/util"
+)
+
+func create(port uint16) (*nnet.NNet, error) {
+	conf := &nnet.Config{
+		Port:                  port,
+		Transport:             "tcp",
+		BaseStabilizeInterval: 233 * time.Millisecond,
+	}
+
+	nn, err := nnet.NewNNet(nil, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return nn, nil
+}
+
+// connectWithFaults dials the listening address of server, wraps the conn
+// with FaultyConn and starts a remote node of client using it
+func connectWithFaults(client, server *nnet.NNet, config util.FaultyConnConfig) (*node.RemoteNode, error) {
+	addr, err := transport.Parse(server.GetLocalNode().Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := addr.Dial(time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetLocalNode().StartRemoteNode(util.NewFaultyConn(conn, config), true)
+}
+
+func main() {
+	waitPtr := flag.Duration("w", 3*time.Second, "time to wait for each scenario")
+	flag.Parse()
+
+	const createPort uint16 = 23333
+
+	server, err := create(createPort)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	client, err := create(createPort + 1)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	err = server.Start(true)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer server.Stop(nil)
+
+	err = client.Start(true)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	defer client.Stop(nil)
+
+	time.Sleep(500 * time.Millisecond)
+
+	scenarios := []struct {
+		name   string
+		config util.FaultyConnConfig
+	}{
+		{
+			name: "short reads and latency, connection should recover",
+			config: util.FaultyConnConfig{
+				MaxReadSize: 3,
+				ReadDelay:   time.Millisecond,
+				WriteDelay:  time.Millisecond,
+			},
+		},
+		{
+			name: "corrupted bytes, connection should stop",
+			config: util.FaultyConnConfig{
+				FlipByteRate: 0.01,
+				RandomSeed:   time.Now().UnixNano(),
+			},
+		},
+		{
+			name: "read error after 1KB, connection should stop",
+			config: util.FaultyConnConfig{
+				ReadErrorAfter:   1024,
+				CloseOnInjection: true,
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		log.Infof("Scenario: %s", scenario.name)
+
+		remoteNode, err := connectWithFaults(client, server, scenario.config)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+
+		time.Sleep(*waitPtr)
+
+		if remoteNode.IsStopped() {
+			log.Infof("Remote node stopped with reason: %v", remoteNode.StopReason())
+			continue
+		}
+
+		err = remoteNode.Ping()
+		if err != nil {
+			log.Infof("Ping error: %v", err)
+		} else {
+			log.Infof("Remote node %v is ready: %v, ping succeeded", remoteNode, remoteNode.IsReady())
+		}
+
+		remoteNode.Stop(nil)
+	}
+}
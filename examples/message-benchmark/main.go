@@ -21,11 +21,12 @@ import (
 	"github.com/nknorg/nnet/util"
 )
 
-func create(transport string, port uint16, id []byte) (*nnet.NNet, error) {
+func create(transport string, port uint16, id []byte, inProcess bool) (*nnet.NNet, error) {
 	conf := &nnet.Config{
 		Port:                port,
 		Transport:           transport,
 		NumFingerSuccessors: 1,
+		InProcessLoopback:   inProcess,
 	}
 
 	nn, err := nnet.NewNNet(id, conf)
@@ -41,6 +42,7 @@ func main() {
 	numNodesPtr := flag.Int("n", 2, "number of nodes")
 	broadcastTypePtr := flag.String("b", "tree", "broadcast type, push or tree")
 	msgSizePtr := flag.Int("m", 1024, "message size in bytes")
+	inProcessPtr := flag.Bool("inproc", false, "pass messages between nodes in process without transport, to compare with transport loopback")
 	flag.Parse()
 
 	if *numNodesPtr < 2 {
@@ -80,7 +82,7 @@ func main() {
 			return
 		}
 
-		nn, err = create(*transportPtr, createPort+uint16(i), id)
+		nn, err = create(*transportPtr, createPort+uint16(i), id, *inProcessPtr)
 		if err != nil {
 			log.Error(err)
 			return
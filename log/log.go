@@ -1,6 +1,10 @@
 package log
 
-import logging "github.com/op/go-logging"
+import (
+	"fmt"
+
+	logging "github.com/op/go-logging"
+)
 
 // Logger is the logger interface
 type Logger interface {
@@ -50,3 +54,77 @@ func Error(args ...interface{}) {
 func Errorf(format string, args ...interface{}) {
 	logger.Errorf(format, args...)
 }
+
+// FieldLogger can be implemented by a Logger that supports structured fields
+// (e.g. one wrapping zap or slog) to attach a field to all logs
+type FieldLogger interface {
+	Logger
+	With(key string, value interface{}) Logger
+}
+
+// globalLogger forwards logs to the global logger object at the time of
+// logging, so it follows later calls to SetLogger
+type globalLogger struct{}
+
+// GlobalLogger returns a Logger that logs to the global logger object
+func GlobalLogger() Logger {
+	return globalLogger{}
+}
+
+func (globalLogger) Info(args ...interface{})                    { Info(args...) }
+func (globalLogger) Infof(format string, args ...interface{})    { Infof(format, args...) }
+func (globalLogger) Warning(args ...interface{})                 { Warning(args...) }
+func (globalLogger) Warningf(format string, args ...interface{}) { Warningf(format, args...) }
+func (globalLogger) Error(args ...interface{})                   { Error(args...) }
+func (globalLogger) Errorf(format string, args ...interface{})   { Errorf(format, args...) }
+
+// With returns a Logger that attaches field key with value to all logs of l.
+// If l is a FieldLogger, its With is used, otherwise logs are prefixed with
+// key=value.
+func With(l Logger, key string, value interface{}) Logger {
+	if fl, ok := l.(FieldLogger); ok {
+		return fl.With(key, value)
+	}
+	return &prefixLogger{
+		logger: l,
+		prefix: fmt.Sprintf("%s=%v ", key, value),
+	}
+}
+
+// prefixLogger is a Logger that prefixes all logs of logger with prefix
+type prefixLogger struct {
+	logger Logger
+	prefix string
+}
+
+func (l *prefixLogger) Info(args ...interface{}) {
+	l.logger.Info(l.prefix + fmt.Sprint(args...))
+}
+
+func (l *prefixLogger) Infof(format string, args ...interface{}) {
+	l.logger.Info(l.prefix + fmt.Sprintf(format, args...))
+}
+
+func (l *prefixLogger) Warning(args ...interface{}) {
+	l.logger.Warning(l.prefix + fmt.Sprint(args...))
+}
+
+func (l *prefixLogger) Warningf(format string, args ...interface{}) {
+	l.logger.Warning(l.prefix + fmt.Sprintf(format, args...))
+}
+
+func (l *prefixLogger) Error(args ...interface{}) {
+	l.logger.Error(l.prefix + fmt.Sprint(args...))
+}
+
+func (l *prefixLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Error(l.prefix + fmt.Sprintf(format, args...))
+}
+
+// With attaches another field after prefix
+func (l *prefixLogger) With(key string, value interface{}) Logger {
+	return &prefixLogger{
+		logger: l.logger,
+		prefix: fmt.Sprintf("%s%s=%v ", l.prefix, key, value),
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"time"
 
 	"github.com/gogo/protobuf/proto"
-	"github.com/nknorg/nnet/message"
 	"github.com/nknorg/nnet/node"
 	"github.com/nknorg/nnet/protobuf"
 )
@@ -12,7 +11,7 @@ import (
 // NewDirectBytesMessage creates a BYTES message that send arbitrary bytes to a
 // given remote node
 func (nn *NNet) NewDirectBytesMessage(data []byte) (*protobuf.Message, error) {
-	id, err := message.GenID(nn.GetLocalNode().MessageIDBytes)
+	id, err := nn.GetLocalNode().GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +38,7 @@ func (nn *NNet) NewDirectBytesMessage(data []byte) (*protobuf.Message, error) {
 // NewRelayBytesMessage creates a BYTES message that send arbitrary bytes to the
 // remote node that has the smallest distance to a given key
 func (nn *NNet) NewRelayBytesMessage(data, srcID, key []byte) (*protobuf.Message, error) {
-	id, err := message.GenID(nn.GetLocalNode().MessageIDBytes)
+	id, err := nn.GetLocalNode().GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +67,7 @@ func (nn *NNet) NewRelayBytesMessage(data, srcID, key []byte) (*protobuf.Message
 // NewBroadcastBytesMessage creates a BYTES message that send arbitrary bytes to
 // EVERY remote node in the network (not just neighbors)
 func (nn *NNet) NewBroadcastBytesMessage(data, srcID []byte, routingType protobuf.RoutingType) (*protobuf.Message, error) {
-	id, err := message.GenID(nn.GetLocalNode().MessageIDBytes)
+	id, err := nn.GetLocalNode().GenMessageID()
 	if err != nil {
 		return nil, err
 	}
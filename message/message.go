@@ -1,6 +1,17 @@
 package message
 
-import "github.com/nknorg/nnet/util"
+import (
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/nknorg/nnet/util"
+)
+
+// IDGenerator generates message id. Message id is arbitrary bytes, so any id
+// scheme (random bytes, counter, content hash, etc) can be used as long as ids
+// of different messages are unique within the rx msg cache expiration.
+type IDGenerator func() ([]byte, error)
 
 // GenID generates a random message id
 func GenID(msgIDBytes uint8) ([]byte, error) {
@@ -10,3 +21,40 @@ func GenID(msgIDBytes uint8) ([]byte, error) {
 	}
 	return id, nil
 }
+
+// RandomIDGenerator returns an IDGenerator that generates random message id
+// with length msgIDBytes
+func RandomIDGenerator(msgIDBytes uint8) IDGenerator {
+	return func() ([]byte, error) {
+		return GenID(msgIDBytes)
+	}
+}
+
+// CounterIDGenerator returns an IDGenerator that generates message id from an
+// increasing uint64 counter starting from start, encoded as 8 bytes and
+// prefixed by prefix. Counter values are only unique within the generator, so
+// prefix (e.g. node id) should be chosen to avoid collision with ids generated
+// by other nodes.
+func CounterIDGenerator(prefix []byte, start uint64) IDGenerator {
+	counter := start - 1
+	return func() ([]byte, error) {
+		id := make([]byte, len(prefix), len(prefix)+8)
+		copy(id, prefix)
+		return append(id, Uint64ToID(atomic.AddUint64(&counter, 1))...), nil
+	}
+}
+
+// Uint64ToID converts a uint64 to an 8 bytes message id
+func Uint64ToID(n uint64) []byte {
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, n)
+	return id
+}
+
+// IDToUint64 converts the last 8 bytes of a message id to uint64
+func IDToUint64(id []byte) (uint64, error) {
+	if len(id) < 8 {
+		return 0, fmt.Errorf("Message id has %d bytes, which is less than 8", len(id))
+	}
+	return binary.BigEndian.Uint64(id[len(id)-8:]), nil
+}
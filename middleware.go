@@ -1,13 +1,19 @@
 package nnet
 
 import (
+	"errors"
+
 	"github.com/nknorg/nnet/log"
+	"github.com/nknorg/nnet/middleware"
 	"github.com/nknorg/nnet/util"
 )
 
 // ApplyMiddleware add a middleware to node, network, router, etc. If multiple
-// middleware of the same type are applied, they will be called in the order of
-// being added.
+// middleware of the same type are applied, they will be called in descending
+// order of their Priority field, so a middleware that needs to run first (e.g.
+// authentication before logging) should have a higher priority regardless of
+// when it is added. Middleware with the same priority (e.g. the default 0) are
+// called in the order of being added.
 func (nn *NNet) ApplyMiddleware(mw interface{}) error {
 	applied := false
 	errs := util.NewErrors()
@@ -42,6 +48,93 @@ func (nn *NNet) ApplyMiddleware(mw interface{}) error {
 	return nil
 }
 
+// ApplyMiddlewareWithPriority is the same as ApplyMiddleware, but overrides the
+// Priority field of mw with priority. ApplyMiddleware uses the Priority field
+// as is, which defaults to 0.
+func (nn *NNet) ApplyMiddlewareWithPriority(mw interface{}, priority int) error {
+	mw, err := middleware.WithPriority(mw, priority)
+	if err != nil {
+		return err
+	}
+	return nn.ApplyMiddleware(mw)
+}
+
+// AddMiddleware is the same as ApplyMiddleware, but also returns the id of the
+// middleware, which can be used to remove it with RemoveMiddleware
+func (nn *NNet) AddMiddleware(mw interface{}) (middleware.ID, error) {
+	ids := make([]middleware.ID, 0, 1)
+	errs := util.NewErrors()
+
+	id, err := nn.GetLocalNode().AddMiddleware(mw)
+	if err == nil {
+		ids = append(ids, id)
+	} else {
+		errs = append(errs, err)
+	}
+
+	id, err = nn.Network.AddMiddleware(mw)
+	if err == nil {
+		ids = append(ids, id)
+	} else {
+		errs = append(errs, err)
+	}
+
+	for _, router := range nn.GetRouters() {
+		id, err = router.AddMiddleware(mw)
+		if err == nil {
+			ids = append(ids, id)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(ids) == 0 {
+		return 0, errs.Merged()
+	}
+
+	// middleware accepted by multiple stores (e.g. all routers) gets an id
+	// from each of them, the first one is used to remove all of them
+	if len(ids) > 1 {
+		nn.middlewareIDs.Store(ids[0], ids[1:])
+	}
+
+	return ids[0], nil
+}
+
+// RemoveMiddleware removes the middleware with id returned by AddMiddleware
+// from node, network, and routers that it is added to
+func (nn *NNet) RemoveMiddleware(id middleware.ID) error {
+	ids := []middleware.ID{id}
+	if value, ok := nn.middlewareIDs.Load(id); ok {
+		ids = append(ids, value.([]middleware.ID)...)
+		nn.middlewareIDs.Delete(id)
+	}
+
+	removed := false
+	for _, id := range ids {
+		if nn.GetLocalNode().RemoveMiddleware(id) == nil {
+			removed = true
+			continue
+		}
+		if nn.Network.RemoveMiddleware(id) == nil {
+			removed = true
+			continue
+		}
+		for _, router := range nn.GetRouters() {
+			if router.RemoveMiddleware(id) == nil {
+				removed = true
+				break
+			}
+		}
+	}
+
+	if !removed {
+		return errors.New("middleware not found")
+	}
+
+	return nil
+}
+
 // MustApplyMiddleware is the same as ApplyMiddleware, but will panic if an
 // error occurs. This is a convenient shortcut if ApplyMiddleware is not
 // expected to fail.
@@ -1,21 +1,33 @@
 package middleware
 
 import (
+	"errors"
 	"reflect"
-	"sort"
 )
 
 func getPriority(v reflect.Value) int32 {
 	return int32(v.FieldByName("Priority").Int())
 }
 
-// Sort sorts an array/slice of middleware
-func Sort(middlewares interface{}) {
-	sort.SliceStable(middlewares, func(i int, j int) bool {
-		s := reflect.ValueOf(middlewares)
-		if s.Kind() != reflect.Slice {
-			panic("Sort input is not a slice")
-		}
-		return getPriority(s.Index(i)) > getPriority(s.Index(j))
-	})
+// WithPriority returns a copy of middleware mw with its Priority field set to
+// priority. Returns error if mw does not have a Priority field.
+func WithPriority(mw interface{}, priority int) (interface{}, error) {
+	v := reflect.ValueOf(mw)
+	if v.Kind() != reflect.Struct {
+		return nil, errors.New("middleware is not a struct")
+	}
+
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+
+	f := c.FieldByName("Priority")
+	if !f.IsValid() || f.Kind() != reflect.Int32 {
+		return nil, errors.New("middleware does not have priority")
+	}
+	if f.OverflowInt(int64(priority)) {
+		return nil, errors.New("middleware priority out of range")
+	}
+	f.SetInt(int64(priority))
+
+	return c.Interface(), nil
 }
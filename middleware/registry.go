@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ID identifies an applied middleware so that it can be removed later. Go
+// funcs are not comparable, so middleware is removed by id rather than by its
+// func. Ids are unique within the process.
+type ID uint64
+
+// lastID is the last id assigned to a middleware, accessed atomically
+var lastID uint64
+
+// Registry keeps middleware slices of a store sorted by priority, and the id
+// of each middleware in them so that it can be removed. Each slice is stored in
+// an atomic.Value and replaced instead of modified in place, so readers can
+// load it without lock, and an event triggered concurrently keeps iterating
+// the slice it started with. The zero value is ready to use.
+type Registry struct {
+	sync.Mutex
+	ids    map[*atomic.Value][]ID // ids of middleware in each slice, in slice order
+	slices map[ID]*atomic.Value   // slice that each middleware is in
+}
+
+// CheckFunc returns error if the Func field of middleware mw is nil
+func CheckFunc(mw interface{}) error {
+	v := reflect.ValueOf(mw)
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	f := v.FieldByName("Func")
+	if f.IsValid() && f.Kind() == reflect.Func && f.IsNil() {
+		return errors.New("middleware function is nil")
+	}
+	return nil
+}
+
+// Add inserts mw into the slice stored in value after middleware with higher
+// or the same priority, and returns the id of mw. Value should already hold a
+// (possibly empty) slice of the type of mw. Returns error if the Func field of
+// mw is nil.
+func (r *Registry) Add(value *atomic.Value, mw interface{}) (ID, error) {
+	err := CheckFunc(mw)
+	if err != nil {
+		return 0, err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.ids == nil {
+		r.ids = make(map[*atomic.Value][]ID)
+		r.slices = make(map[ID]*atomic.Value)
+	}
+
+	s := reflect.ValueOf(value.Load())
+	v := reflect.ValueOf(mw)
+	ids := r.ids[value]
+
+	i := 0
+	for i < s.Len() && getPriority(s.Index(i)) >= getPriority(v) {
+		i++
+	}
+
+	newSlice := reflect.MakeSlice(s.Type(), 0, s.Len()+1)
+	newSlice = reflect.AppendSlice(newSlice, s.Slice(0, i))
+	newSlice = reflect.Append(newSlice, v)
+	newSlice = reflect.AppendSlice(newSlice, s.Slice(i, s.Len()))
+	value.Store(newSlice.Interface())
+
+	id := ID(atomic.AddUint64(&lastID, 1))
+	newIDs := make([]ID, 0, len(ids)+1)
+	newIDs = append(newIDs, ids[:i]...)
+	newIDs = append(newIDs, id)
+	newIDs = append(newIDs, ids[i:]...)
+	r.ids[value] = newIDs
+	r.slices[id] = value
+
+	return id, nil
+}
+
+// Remove removes the middleware with id from the slice it is in. Returns false
+// if id is not found.
+func (r *Registry) Remove(id ID) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	value, ok := r.slices[id]
+	if !ok {
+		return false
+	}
+
+	ids := r.ids[value]
+	i := 0
+	for i < len(ids) && ids[i] != id {
+		i++
+	}
+	if i == len(ids) {
+		return false
+	}
+
+	s := reflect.ValueOf(value.Load())
+	newSlice := reflect.MakeSlice(s.Type(), 0, s.Len()-1)
+	newSlice = reflect.AppendSlice(newSlice, s.Slice(0, i))
+	newSlice = reflect.AppendSlice(newSlice, s.Slice(i+1, s.Len()))
+	value.Store(newSlice.Interface())
+
+	newIDs := make([]ID, 0, len(ids)-1)
+	newIDs = append(newIDs, ids[:i]...)
+	newIDs = append(newIDs, ids[i+1:]...)
+	r.ids[value] = newIDs
+	delete(r.slices, id)
+
+	return true
+}
@@ -1,6 +1,8 @@
 package nnet
 
 import (
+	"sync"
+
 	"github.com/nknorg/nnet/config"
 	"github.com/nknorg/nnet/log"
 	"github.com/nknorg/nnet/node"
@@ -12,6 +14,7 @@ import (
 // NNet is is a peer to peer network
 type NNet struct {
 	overlay.Network
+	middlewareIDs sync.Map // id returned by AddMiddleware -> ids of the same middleware in other stores
 }
 
 // Config is an alias of config.Config for simpler usage
@@ -0,0 +1,115 @@
+package node
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestMessageAck(t *testing.T) {
+	const numMsgs = 5
+	const rxMsgChanLen = 2
+
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, &config.Config{LocalRxMsgChanLen: rxMsgChanLen})
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	var lock sync.Mutex
+	acks := make(map[string]bool)
+	err := ln.ApplyMiddleware(MessageAcked{func(msgID []byte, delivered bool, remoteNode *RemoteNode) bool {
+		if remoteNode != rn {
+			t.Errorf("ack is received from %v, expecting %v", remoteNode, rn)
+		}
+		lock.Lock()
+		acks[string(msgID)] = delivered
+		lock.Unlock()
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// direct msg is read by peer and delivered
+	msg := newTestMessage(t, ln, []byte("hello"))
+	msg.RequestAck = true
+	err = rn.SendMessageAsync(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteMsg := recvTestMessage(t, peer, time.Second)
+	if remoteMsg.Msg.RequestAck {
+		t.Fatal("request ack is not cleared before msg is passed to routing chan")
+	}
+	if !bytes.Equal(remoteMsg.Msg.MessageId, msg.MessageId) {
+		t.Fatalf("received msg id %x, expecting %x", remoteMsg.Msg.MessageId, msg.MessageId)
+	}
+
+	// relay msg is not read by peer, so msg beyond the chan len are dropped
+	msgs := make([]*protobuf.Message, numMsgs)
+	for i := range msgs {
+		msgs[i] = newTestMessage(t, ln, []byte{byte(i)})
+		msgs[i].RoutingType = protobuf.RELAY
+		msgs[i].RequestAck = true
+	}
+	errs := rn.SendBatch(msgs)
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, time.Second, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(acks) == numMsgs+1
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !acks[string(msg.MessageId)] {
+		t.Fatal("msg read by peer is acked as dropped")
+	}
+
+	numDelivered := 0
+	for _, m := range msgs {
+		if acks[string(m.MessageId)] {
+			numDelivered++
+		}
+	}
+	if numDelivered != rxMsgChanLen {
+		t.Fatalf("%d msg are acked as delivered, expecting %d", numDelivered, rxMsgChanLen)
+	}
+}
+
+func TestMessageNotAcked(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	acked := make(chan []byte, 1)
+	err := ln.ApplyMiddleware(MessageAcked{func(msgID []byte, delivered bool, remoteNode *RemoteNode) bool {
+		acked <- msgID
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rn.SendMessageAsync(newTestMessage(t, ln, []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, peer, time.Second)
+
+	select {
+	case msgID := <-acked:
+		t.Fatalf("msg %x is acked without requesting ack", msgID)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
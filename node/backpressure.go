@@ -0,0 +1,177 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+	"github.com/nknorg/nnet/util"
+)
+
+// BackpressureAction is the action to take when a msg cannot be buffered
+type BackpressureAction int
+
+const (
+	// BackpressureDrop discards the msg and keeps the connection
+	BackpressureDrop BackpressureAction = iota
+
+	// BackpressureBlock waits until the msg can be buffered, remote node stops,
+	// or BackpressureBlockTimeout (if not 0) elapses
+	BackpressureBlock
+
+	// BackpressureCloseConn discards the msg and stops the remote node
+	BackpressureCloseConn
+
+	// BackpressureDropOldest discards the oldest msg in chan to make room for
+	// the new msg. Only supported by OnTxFull, treated as Drop elsewhere.
+	BackpressureDropOldest
+)
+
+// BackpressureStrategy decides what to do when a msg cannot be buffered. All
+// places where msg may be dropped because of backpressure go through it so the
+// policy is consistent across the node.
+type BackpressureStrategy interface {
+	// OnTxFull is called when msg to be sent to remote node cannot be added to
+	// its full tx msg chan.
+	OnTxFull(remoteNode *RemoteNode, msg *protobuf.Message) BackpressureAction
+
+	// OnRxFull is called when msg received from remote node cannot be added to
+	// a full chan (rx msg chan of remote node, msg chan of routing type, handle
+	// msg chan of local node, or local msg chan of router). Remote node is nil
+	// if msg is sent by local node.
+	OnRxFull(remoteNode *RemoteNode, msg *protobuf.Message) BackpressureAction
+
+	// OnMemoryPressure is called when a msg of size bytes received from remote
+	// node cannot be buffered because it exceeds the memory limit. Block is not
+	// supported here and is treated as Drop.
+	OnMemoryPressure(remoteNode *RemoteNode, size uint32) BackpressureAction
+}
+
+// DefaultBackpressureStrategy drops new msg or the oldest msg when tx chan is
+// full according to RemoteTxOverflowPolicy, drops or blocks msg when rx msg
+// chan of remote node is full according to RemoteRxOverflowPolicy, drops msg
+// when other chans are full, and closes connection or drops msg on memory
+// pressure according to OversizedMsgPolicy.
+type DefaultBackpressureStrategy struct{}
+
+// OnTxFull implements BackpressureStrategy interface
+func (DefaultBackpressureStrategy) OnTxFull(remoteNode *RemoteNode, msg *protobuf.Message) BackpressureAction {
+	if remoteNode.LocalNode.RemoteTxOverflowPolicy == "dropoldest" {
+		return BackpressureDropOldest
+	}
+	return BackpressureDrop
+}
+
+// OnRxFull implements BackpressureStrategy interface
+func (DefaultBackpressureStrategy) OnRxFull(remoteNode *RemoteNode, msg *protobuf.Message) BackpressureAction {
+	if remoteNode != nil && remoteNode.LocalNode.RemoteRxOverflowPolicy == "block" {
+		return BackpressureBlock
+	}
+	return BackpressureDrop
+}
+
+// OnMemoryPressure implements BackpressureStrategy interface
+func (DefaultBackpressureStrategy) OnMemoryPressure(remoteNode *RemoteNode, size uint32) BackpressureAction {
+	if remoteNode.LocalNode.OversizedMsgPolicy == "skip" {
+		return BackpressureDrop
+	}
+	return BackpressureCloseConn
+}
+
+// SetBackpressureStrategy sets the backpressure strategy of local node. It
+// should be called before local node starts.
+func (ln *LocalNode) SetBackpressureStrategy(strategy BackpressureStrategy) error {
+	if strategy == nil {
+		return errors.New("Backpressure strategy is nil")
+	}
+	ln.backpressure = strategy
+	return nil
+}
+
+// GetBackpressureStrategy returns the backpressure strategy of local node
+func (ln *LocalNode) GetBackpressureStrategy() BackpressureStrategy {
+	return ln.backpressure
+}
+
+// ApplyBackpressure takes action when a msg related to remoteNode (nil if msg
+// is not related to any remote node) cannot be buffered for reason. block
+// should try to buffer the msg until done is closed and return if buffered. It
+// returns nil if msg is eventually buffered, otherwise error.
+func (ln *LocalNode) ApplyBackpressure(action BackpressureAction, remoteNode *RemoteNode, block func(done <-chan struct{}) bool, reason string) error {
+	err := ln.applyBackpressure(action, remoteNode, block, reason)
+	if err != nil && remoteNode != nil {
+		remoteNode.countMsgDropped()
+	}
+	return err
+}
+
+// applyBackpressure is the same as ApplyBackpressure, but does not count
+// dropped msg
+func (ln *LocalNode) applyBackpressure(action BackpressureAction, remoteNode *RemoteNode, block func(done <-chan struct{}) bool, reason string) error {
+	switch action {
+	case BackpressureBlock:
+		done := ln.Done()
+		if remoteNode != nil {
+			done = remoteNode.Done()
+		}
+		if ln.BackpressureBlockTimeout > 0 {
+			stop := make(chan struct{})
+			defer close(stop)
+			done = withTimeout(done, stop, ln.BackpressureBlockTimeout)
+		}
+		if block != nil && block(done) {
+			return nil
+		}
+		return fmt.Errorf("%s, stopped or timeout before msg is buffered", reason)
+	case BackpressureCloseConn:
+		if remoteNode != nil {
+			remoteNode.Stop(errors.New(reason))
+		}
+		return fmt.Errorf("%s, closing connection", reason)
+	default:
+		return fmt.Errorf("%s, discarding msg", reason)
+	}
+}
+
+// enqueueDropOldest adds msg to txMsgChan, discarding the oldest msg in it
+// until there is room for msg
+func (rn *RemoteNode) enqueueDropOldest(msg *protobuf.Message) {
+	txMsgChan := rn.getTxMsgChan()
+
+	for {
+		select {
+		case txMsgChan <- msg:
+			return
+		default:
+		}
+
+		select {
+		case oldest := <-txMsgChan:
+			rn.releaseTxQueue(int64(oldest.Size()))
+			rn.countMsgDropped()
+			atomic.AddUint64(&rn.txDropped, 1)
+			rn.LocalNode.logger.Warningf("Tx msg chan full, discarding oldest msg %x", oldest.MessageId)
+		default:
+		}
+	}
+}
+
+// withTimeout returns a chan that is closed when done is closed or timeout
+// elapses, whichever happens first. Closing stop releases the resources when
+// the returned chan is no longer needed.
+func withTimeout(done, stop <-chan struct{}, timeout time.Duration) <-chan struct{} {
+	c := make(chan struct{})
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer util.StopTimer(timer)
+		select {
+		case <-done:
+		case <-timer.C:
+		case <-stop:
+		}
+		close(c)
+	}()
+	return c
+}
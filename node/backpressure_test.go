@@ -0,0 +1,204 @@
+package node
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// testBackpressureStrategy returns fixed actions and counts how many times
+// each method is called
+type testBackpressureStrategy struct {
+	txAction  BackpressureAction
+	rxAction  BackpressureAction
+	numTxFull int32
+	numRxFull int32
+}
+
+func (s *testBackpressureStrategy) OnTxFull(remoteNode *RemoteNode, msg *protobuf.Message) BackpressureAction {
+	atomic.AddInt32(&s.numTxFull, 1)
+	return s.txAction
+}
+
+func (s *testBackpressureStrategy) OnRxFull(remoteNode *RemoteNode, msg *protobuf.Message) BackpressureAction {
+	atomic.AddInt32(&s.numRxFull, 1)
+	return s.rxAction
+}
+
+func (s *testBackpressureStrategy) OnMemoryPressure(remoteNode *RemoteNode, size uint32) BackpressureAction {
+	return BackpressureCloseConn
+}
+
+func TestBackpressureTxFull(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{RemoteTxMsgChanLen: 1})
+
+	strategy := &testBackpressureStrategy{txAction: BackpressureDrop}
+	err := ln.SetBackpressureStrategy(strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn := newTestIdleRemoteNode(t, ln)
+
+	first := newTestMessage(t, ln, []byte("first"))
+	err = rn.SendMessageAsync(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = rn.SendMessageAsync(newTestMessage(t, ln, []byte("second")))
+	if err == nil {
+		t.Fatal("msg should be dropped when tx msg chan is full")
+	}
+	if n := atomic.LoadInt32(&strategy.numTxFull); n != 1 {
+		t.Fatalf("OnTxFull is called %d times, expecting 1", n)
+	}
+
+	strategy.txAction = BackpressureDropOldest
+	third := newTestMessage(t, ln, []byte("third"))
+	err = rn.SendMessageAsync(third)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txMsgChan := rn.getTxMsgChan()
+	if n := len(txMsgChan); n != 1 {
+		t.Fatalf("tx msg chan has %d msg, expecting 1", n)
+	}
+	if msg := <-txMsgChan; string(msg.MessageId) != string(third.MessageId) {
+		t.Fatalf("tx msg chan has msg %x, expecting newest msg %x", msg.MessageId, third.MessageId)
+	}
+}
+
+func TestBackpressureRxFullCloseConn(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, &config.Config{LocalRxMsgChanLen: 1})
+
+	strategy := &testBackpressureStrategy{rxAction: BackpressureCloseConn}
+	err := peer.SetBackpressureStrategy(strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	// relay msg chan of peer is not read in test
+	for i := 0; i < 2; i++ {
+		msg := newTestMessage(t, ln, []byte{byte(i)})
+		msg.RoutingType = protobuf.RELAY
+		err = rn.SendMessageAsync(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, time.Second, peerRn.IsStopped)
+
+	if reason := peerRn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "Msg chan full") {
+		t.Fatalf("remote node stops because of %v, expecting msg chan full", reason)
+	}
+	if n := atomic.LoadInt32(&strategy.numRxFull); n != 1 {
+		t.Fatalf("OnRxFull is called %d times, expecting 1", n)
+	}
+}
+
+func TestBackpressureRxFullBlock(t *testing.T) {
+	const numMsgs = 5
+
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, &config.Config{LocalRxMsgChanLen: 1})
+
+	strategy := &testBackpressureStrategy{rxAction: BackpressureBlock}
+	err := peer.SetBackpressureStrategy(strategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	for i := 0; i < numMsgs; i++ {
+		msg := newTestMessage(t, ln, []byte{byte(i)})
+		msg.RoutingType = protobuf.RELAY
+		err = rn.SendMessageAsync(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, time.Second, func() bool {
+		return atomic.LoadInt32(&strategy.numRxFull) > 0
+	})
+
+	msgChan, err := peer.GetRxMsgChan(protobuf.RELAY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocked msg are delivered once there is room
+	received := make(map[byte]bool)
+	for i := 0; i < numMsgs; i++ {
+		select {
+		case remoteMsg := <-msgChan:
+			received[remoteMsg.Msg.Message[0]] = true
+		case <-time.After(time.Second):
+			t.Fatalf("timeout after receiving %d msg", i)
+		}
+	}
+	if len(received) != numMsgs {
+		t.Fatalf("received %d different msg, expecting %d", len(received), numMsgs)
+	}
+
+	if peerRn.IsStopped() {
+		t.Fatalf("remote node stops because of %v while blocking", peerRn.StopReason())
+	}
+}
+
+func TestTxOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		rejected bool
+		expected []string
+	}{
+		{"reject", true, []string{"first", "second"}},
+		{"dropoldest", false, []string{"second", "third"}},
+	}
+
+	for _, test := range tests {
+		ln := newTestLocalNode(t, &config.Config{RemoteTxMsgChanLen: 2, RemoteTxOverflowPolicy: test.policy})
+		rn := newTestIdleRemoteNode(t, ln)
+
+		for _, data := range []string{"first", "second"} {
+			err := rn.SendMessageAsync(newTestMessage(t, ln, []byte(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		err := rn.SendMessageAsync(newTestMessage(t, ln, []byte("third")))
+		if test.rejected && err == nil {
+			t.Fatalf("msg is queued to full tx msg chan with policy %s", test.policy)
+		}
+		if !test.rejected && err != nil {
+			t.Fatalf("send msg to full tx msg chan with policy %s error: %v", test.policy, err)
+		}
+
+		if n := rn.Stats().MsgDropped; n != 1 {
+			t.Fatalf("%d msg dropped with policy %s, expecting 1", n, test.policy)
+		}
+
+		txMsgChan := rn.getTxMsgChan()
+		if len(txMsgChan) != len(test.expected) {
+			t.Fatalf("%d msg queued with policy %s, expecting %d", len(txMsgChan), test.policy, len(test.expected))
+		}
+		for _, data := range test.expected {
+			msg := <-txMsgChan
+			if string(msg.Message) != data {
+				t.Fatalf("msg %q is queued with policy %s, expecting %q", msg.Message, test.policy, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// newSingleStreamConfig returns a config with a single multiplexer stream, so
+// that msgs are written to conn in the order they are queued
+func newSingleStreamConfig() *config.Config {
+	return &config.Config{NumStreamsToOpen: 1, NumStreamsToAccept: 1}
+}
+
+func TestSendBatchQueueOrder(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	rn := newTestIdleRemoteNode(t, ln)
+
+	msgs := make([]*protobuf.Message, 10)
+	for i := range msgs {
+		msgs[i] = newTestMessage(t, ln, []byte{byte(i)})
+	}
+
+	// sent before, duplicate msg is not sent again
+	err := rn.SendMessageAsync(msgs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, err := range rn.SendBatch(msgs) {
+		if err != nil {
+			t.Fatalf("msg %d error: %v", i, err)
+		}
+	}
+
+	for i := range msgs {
+		if msg := <-rn.txMsgChan; msg != msgs[i] {
+			t.Fatalf("msg %d in tx msg chan is %v, expecting %v", i, msg, msgs[i])
+		}
+	}
+	if n := len(rn.txMsgChan); n != 0 {
+		t.Fatalf("%d more msgs are queued", n)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	conf := newSingleStreamConfig()
+	conf.MaxMessageSize = 4096
+	ln := newTestLocalNode(t, conf)
+	peer := newTestLocalNode(t, newSingleStreamConfig())
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	msgs := []*protobuf.Message{
+		newTestMessage(t, ln, []byte("0")),
+		nil,
+		newTestMessage(t, ln, []byte("2")),
+		newTestMessage(t, ln, make([]byte, 8192)),
+		newTestMessage(t, ln, []byte("4")),
+		newTestMessage(t, ln, []byte("5")),
+	}
+
+	errs := rn.SendBatch(msgs)
+	if len(errs) != len(msgs) {
+		t.Fatalf("got %d errors for %d msgs", len(errs), len(msgs))
+	}
+	for i, err := range errs {
+		shouldFail := i == 1 || i == 3
+		if shouldFail && err == nil {
+			t.Fatalf("msg %d should fail", i)
+		}
+		if !shouldFail && err != nil {
+			t.Fatalf("msg %d error: %v", i, err)
+		}
+	}
+
+	for _, data := range []string{"0", "2", "4", "5"} {
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		if string(remoteMsg.Msg.Message) != data {
+			t.Fatalf("received msg %q, expecting %q", remoteMsg.Msg.Message, data)
+		}
+	}
+}
+
+func TestSendBatchConcurrent(t *testing.T) {
+	const numBatches = 4
+	const batchSize = 50
+
+	ln := newTestLocalNode(t, newSingleStreamConfig())
+	peer := newTestLocalNode(t, newSingleStreamConfig())
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numBatches; i++ {
+		msgs := make([]*protobuf.Message, batchSize)
+		for j := range msgs {
+			msgs[j] = newTestMessage(t, ln, []byte(fmt.Sprintf("%d-%d", i, j)))
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j, err := range rn.SendBatch(msgs) {
+				if err != nil {
+					t.Errorf("msg %d error: %v", j, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// msgs of each batch are received in order and not interleaved with
+	// msgs of other batches
+	for i := 0; i < numBatches; i++ {
+		var batch int
+		for j := 0; j < batchSize; j++ {
+			remoteMsg := recvTestMessage(t, peer, time.Second)
+			if j == 0 {
+				_, err := fmt.Sscanf(string(remoteMsg.Msg.Message), "%d-", &batch)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if expected := fmt.Sprintf("%d-%d", batch, j); string(remoteMsg.Msg.Message) != expected {
+				t.Fatalf("received msg %q, expecting %q", remoteMsg.Msg.Message, expected)
+			}
+		}
+	}
+}
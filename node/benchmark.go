@@ -0,0 +1,227 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nnet/util"
+)
+
+const (
+	// Max number of remote nodes probed at the same time by ProbeAllPeers
+	maxConcurrentProbes = 16
+)
+
+// LatencyReport is the result of benchmarking the round trip latency to a
+// remote node. Latency percentiles only include successful round trips.
+type LatencyReport struct {
+	Count      int           // Number of round trips attempted
+	Errors     int           // Number of round trips that failed or timed out
+	Duration   time.Duration // Total time of the benchmark
+	Throughput float64       // Successful round trips per second
+	Min        time.Duration
+	Mean       time.Duration
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+// BenchmarkPeer sends n ping msg to remote node with at most concurrency of
+// them waiting for reply at the same time, and reports the distribution of
+// round trip latency. Each ping waits for reply for the default reply timeout
+// of remote node, and its reply chan is freed when it times out, so the
+// benchmark does not leave anything behind on local node or remote node.
+// Concurrency should be kept well below RemoteTxMsgChanLen, otherwise ping
+// msg may be dropped by backpressure and counted as errors.
+func (ln *LocalNode) BenchmarkPeer(remoteNode *RemoteNode, n, concurrency int) (*LatencyReport, error) {
+	if remoteNode == nil {
+		return nil, errors.New("Remote node is nil")
+	}
+	if n <= 0 {
+		return nil, fmt.Errorf("Invalid number of round trips %d", n)
+	}
+	if concurrency <= 0 {
+		return nil, fmt.Errorf("Invalid concurrency %d", concurrency)
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	latencies := make([]time.Duration, 0, n)
+	jobs := make(chan struct{}, n)
+	report := &LatencyReport{Count: n}
+
+	for i := 0; i < n; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	startTime := time.Now()
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				if remoteNode.IsStopped() {
+					lock.Lock()
+					report.Errors++
+					lock.Unlock()
+					continue
+				}
+
+				pingStartTime := time.Now()
+				err := remoteNode.Ping()
+				latency := time.Since(pingStartTime)
+
+				lock.Lock()
+				if err != nil {
+					report.Errors++
+				} else {
+					latencies = append(latencies, latency)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	report.Duration = time.Since(startTime)
+
+	if len(latencies) == 0 {
+		return report, nil
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+
+	report.Throughput = float64(len(latencies)) / report.Duration.Seconds()
+	report.Min = latencies[0]
+	report.Mean = total / time.Duration(len(latencies))
+	report.P50 = latencyPercentile(latencies, 0.5)
+	report.P90 = latencyPercentile(latencies, 0.9)
+	report.P99 = latencyPercentile(latencies, 0.99)
+	report.Max = latencies[len(latencies)-1]
+
+	return report, nil
+}
+
+// latencyPercentile returns the p-th percentile (0 < p <= 1) of sorted
+// latencies using the nearest rank method
+func latencyPercentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// ProbeResult is the result of probing a remote node with a ping
+type ProbeResult struct {
+	RoundTripTime time.Duration // Round trip time of the ping, 0 if Err is not nil
+	Err           error         // Error if ping failed, timed out or was canceled
+}
+
+// ProbeAllPeers pings all ready remote nodes concurrently, with at most
+// maxConcurrentProbes pings in flight, and returns the round trip time or
+// error of each one. Each ping waits for reply for the reply timeout of remote
+// node, or until ctx is done. Remote nodes not yet probed when ctx is done get
+// ctx error. Ping is a node control msg, so it is not limited by send budget
+// and does not take budget from normal traffic.
+func (ln *LocalNode) ProbeAllPeers(ctx context.Context) map[*RemoteNode]ProbeResult {
+	remoteNodes, _ := ln.GetNeighbors(nil)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	results := make(map[*RemoteNode]ProbeResult, len(remoteNodes))
+	sem := make(chan struct{}, maxConcurrentProbes)
+
+	for _, remoteNode := range remoteNodes {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			lock.Lock()
+			results[remoteNode] = ProbeResult{Err: ctx.Err()}
+			lock.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(remoteNode *RemoteNode) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			roundTripTime, err := remoteNode.pingContext(ctx)
+
+			lock.Lock()
+			results[remoteNode] = ProbeResult{RoundTripTime: roundTripTime, Err: err}
+			lock.Unlock()
+		}(remoteNode)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// pingContext sends a ping msg to remote node and returns the round trip time,
+// waiting for reply until reply timeout of remote node or ctx is done
+func (rn *RemoteNode) pingContext(ctx context.Context) (time.Duration, error) {
+	msg, err := rn.LocalNode.NewPingMessage()
+	if err != nil {
+		return 0, err
+	}
+
+	replyTimeout := rn.ReplyTimeout()
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < replyTimeout {
+		replyTimeout = time.Until(deadline)
+		if replyTimeout <= 0 {
+			return 0, context.DeadlineExceeded
+		}
+	}
+
+	startTime := time.Now()
+
+	replyChan, err := rn.SendMessage(msg, true, replyTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(replyTimeout)
+	defer util.StopTimer(timer)
+
+	select {
+	case reply := <-replyChan:
+		if reply == nil {
+			return 0, errors.New("Remote node has stopped")
+		}
+		return time.Since(startTime), nil
+	case <-timer.C:
+		rn.countReplyTimeout()
+		err = errors.New("Wait for reply timeout")
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-rn.Done():
+		err = errors.New("Remote node has stopped")
+	}
+
+	rn.LocalNode.FreeReplyChan(msg.MessageId)
+
+	return 0, err
+}
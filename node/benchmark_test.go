@@ -0,0 +1,65 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestProbeAllPeers(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		DefaultReplyTimeout:  300 * time.Millisecond,
+		DisableKeepAlivePing: true,
+	})
+	peer := newTestLocalNode(t, nil)
+	silentPeer := newTestLocalNode(t, nil)
+
+	// silent peer never replies ping
+	err := silentPeer.ApplyMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+		if msg.MessageType == protobuf.PING {
+			return nil, false
+		}
+		return msg, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, _ := connectTestNodes(t, ln, peer)
+	silentRn, _ := connectTestNodes(t, ln, silentPeer)
+
+	results := ln.ProbeAllPeers(context.Background())
+	if len(results) != 2 {
+		t.Fatalf("%d remote nodes are probed, expecting 2", len(results))
+	}
+	if result := results[rn]; result.Err != nil || result.RoundTripTime <= 0 {
+		t.Fatalf("probe result of replying peer is %+v", result)
+	}
+	if result := results[silentRn]; result.Err == nil || result.RoundTripTime != 0 {
+		t.Fatalf("probe result of silent peer is %+v, expecting error", result)
+	}
+
+	// probe does not wait longer than ctx
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	startTime := time.Now()
+	results = ln.ProbeAllPeers(ctx)
+	if elapsed := time.Since(startTime); elapsed > 200*time.Millisecond {
+		t.Fatalf("probe returns after %v, expecting ctx timeout", elapsed)
+	}
+	if results[silentRn].Err == nil {
+		t.Fatal("probe of silent peer should fail when ctx is done")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	results = ln.ProbeAllPeers(ctx)
+	for remoteNode, result := range results {
+		if result.Err == nil {
+			t.Fatalf("probe of %v succeeds after ctx is canceled", remoteNode)
+		}
+	}
+}
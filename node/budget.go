@@ -0,0 +1,232 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// sendBudget limits the number of bytes sent in each fixed time window
+type sendBudget struct {
+	sync.Mutex
+	budget      uint32
+	window      time.Duration
+	windowStart time.Time
+	used        uint64
+}
+
+// newSendBudget creates a sendBudget that allows at most budget bytes in each
+// window. Budget 0 means unlimited.
+func newSendBudget(budget uint32, window time.Duration) *sendBudget {
+	return &sendBudget{
+		budget:      budget,
+		window:      window,
+		windowStart: time.Now(),
+	}
+}
+
+// take takes n bytes from the budget of current window. Returns true and the
+// start of current window if taken, otherwise false and how long until the
+// current window resets.
+func (sb *sendBudget) take(n int) (bool, time.Time, time.Duration) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	if sb.budget == 0 {
+		return true, time.Time{}, 0
+	}
+
+	now := time.Now()
+	if now.Sub(sb.windowStart) >= sb.window {
+		sb.windowStart = now
+		sb.used = 0
+	}
+
+	if sb.used+uint64(n) > uint64(sb.budget) {
+		return false, time.Time{}, sb.window - now.Sub(sb.windowStart)
+	}
+
+	sb.used += uint64(n)
+
+	return true, sb.windowStart, 0
+}
+
+// refund gives back n bytes taken at windowStart. Nothing is given back if
+// the window has reset since then, as the bytes are no longer counted.
+func (sb *sendBudget) refund(n int, windowStart time.Time) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	if sb.budget == 0 || !sb.windowStart.Equal(windowStart) {
+		return
+	}
+
+	if uint64(n) > sb.used {
+		n = int(sb.used)
+	}
+	sb.used -= uint64(n)
+}
+
+const (
+	// How often to check if there is room in tx queues under block policy
+	txQueueWaitInterval = 10 * time.Millisecond
+)
+
+// isNodeControlMsg returns if msg is used for connection maintenance, which
+// should not be limited by budgets
+func isNodeControlMsg(msg *protobuf.Message) bool {
+	switch msg.MessageType {
+	case protobuf.PING, protobuf.GET_NODE, protobuf.STOP, protobuf.OPEN_STREAM, protobuf.DRAIN, protobuf.ACK:
+		return true
+	default:
+		return false
+	}
+}
+
+// takeSendBudget takes the size of msg from the send budget to remote node.
+// If budget of current window is exhausted, it returns error or blocks until
+// window resets depending on SendBudgetPolicy. Node control messages are not
+// counted so that connection maintenance is not affected. Returns the start of
+// the window msg is counted in, which should be passed to refundSendBudget if
+// msg fails to be queued.
+func (rn *RemoteNode) takeSendBudget(msg *protobuf.Message) (time.Time, error) {
+	if isNodeControlMsg(msg) {
+		return time.Time{}, nil
+	}
+
+	size := msg.Size()
+	if rn.LocalNode.SendBudget > 0 && uint32(size) > rn.LocalNode.SendBudget {
+		return time.Time{}, fmt.Errorf("Msg size %d exceeds send budget %d", size, rn.LocalNode.SendBudget)
+	}
+
+	for {
+		ok, windowStart, wait := rn.sendBudget.take(size)
+		if ok {
+			return windowStart, nil
+		}
+
+		if rn.LocalNode.SendBudgetPolicy != "block" {
+			return time.Time{}, errors.New("Send budget exceeded")
+		}
+
+		if rn.IsStopped() {
+			return time.Time{}, errors.New("Remote node has stopped")
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+// refundSendBudget gives back the size of msg taken by takeSendBudget in the
+// window started at windowStart, as msg is not sent
+func (rn *RemoteNode) refundSendBudget(msg *protobuf.Message, windowStart time.Time) {
+	if isNodeControlMsg(msg) {
+		return
+	}
+	rn.sendBudget.refund(msg.Size(), windowStart)
+}
+
+// txQueue tracks the bytes of msg queued to be sent to a remote node, which
+// are also counted in the total of local node until the queue is closed
+type txQueue struct {
+	sync.Mutex
+	bytes  int64
+	closed bool
+}
+
+// TxQueuedBytes returns the total bytes of msg queued to be sent to all remote
+// nodes
+func (ln *LocalNode) TxQueuedBytes() int64 {
+	return atomic.LoadInt64(&ln.txQueuedBytes)
+}
+
+// reserveTxQueuedBytes adds size to the total queued bytes of local node if it
+// does not exceed MaxTxQueuedBytes. Control msg are always added.
+func (ln *LocalNode) reserveTxQueuedBytes(size int64, isControlMsg bool) bool {
+	max := int64(ln.MaxTxQueuedBytes)
+	for {
+		old := atomic.LoadInt64(&ln.txQueuedBytes)
+		if max > 0 && !isControlMsg && old+size > max {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&ln.txQueuedBytes, old, old+size) {
+			return true
+		}
+	}
+}
+
+// reserveTxQueue counts msg as queued to be sent to remote node before it is
+// added to tx msg chan. If MaxTxQueuedBytes of local node is reached, it
+// returns error or blocks until queues drain, ctx is done or remote node stops
+// depending on TxQueuedBytesPolicy. Returns the bytes counted, which should be
+// released when msg leaves the queue or fails to be added to it.
+func (rn *RemoteNode) reserveTxQueue(ctx context.Context, msg *protobuf.Message) (int64, error) {
+	size := int64(msg.Size())
+	isControlMsg := isNodeControlMsg(msg)
+	if rn.LocalNode.MaxTxQueuedBytes > 0 && !isControlMsg && size > int64(rn.LocalNode.MaxTxQueuedBytes) {
+		return 0, fmt.Errorf("Msg size %d exceeds max tx queued bytes %d", size, rn.LocalNode.MaxTxQueuedBytes)
+	}
+
+	for {
+		rn.txQueue.Lock()
+		if rn.txQueue.closed {
+			rn.txQueue.Unlock()
+			return 0, errors.New("Remote node has stopped")
+		}
+		if rn.LocalNode.reserveTxQueuedBytes(size, isControlMsg) {
+			rn.txQueue.bytes += size
+			rn.txQueue.Unlock()
+			return size, nil
+		}
+		rn.txQueue.Unlock()
+
+		if rn.LocalNode.TxQueuedBytesPolicy != "block" {
+			return 0, errors.New("Max tx queued bytes reached")
+		}
+
+		select {
+		case <-time.After(txQueueWaitInterval):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-rn.Done():
+			return 0, errors.New("Remote node has stopped")
+		}
+	}
+}
+
+// releaseTxQueue uncounts size bytes of msg that has left the queue of remote
+// node, either sent, dropped, or failed to be queued
+func (rn *RemoteNode) releaseTxQueue(size int64) {
+	rn.txQueue.Lock()
+	defer rn.txQueue.Unlock()
+
+	if rn.txQueue.closed {
+		return
+	}
+
+	if size > rn.txQueue.bytes {
+		size = rn.txQueue.bytes
+	}
+	rn.txQueue.bytes -= size
+	atomic.AddInt64(&rn.LocalNode.txQueuedBytes, -size)
+}
+
+// closeTxQueue uncounts all msg still queued when remote node stops, as they
+// will never be sent
+func (rn *RemoteNode) closeTxQueue() {
+	rn.txQueue.Lock()
+	defer rn.txQueue.Unlock()
+
+	if rn.txQueue.closed {
+		return
+	}
+
+	rn.txQueue.closed = true
+	atomic.AddInt64(&rn.LocalNode.txQueuedBytes, -rn.txQueue.bytes)
+	rn.txQueue.bytes = 0
+}
@@ -0,0 +1,209 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestTxQueuedBytesSaturated(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{MaxTxQueuedBytes: 4096})
+	rn := newTestIdleRemoteNode(t, ln)
+
+	size := int64(newTestMessage(t, ln, make([]byte, 1000)).Size())
+	numQueued := int64(4096) / size
+
+	for i := int64(0); i < numQueued; i++ {
+		err := rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 1000)))
+		if err != nil {
+			t.Fatalf("send msg %d error: %v", i, err)
+		}
+	}
+
+	if queued := ln.TxQueuedBytes(); queued != numQueued*size {
+		t.Fatalf("tx queued bytes is %d, expecting %d", queued, numQueued*size)
+	}
+
+	err := rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 1000)))
+	if err == nil {
+		t.Fatal("send msg beyond max tx queued bytes should fail")
+	}
+
+	// node control msg are not limited by the budget
+	ping, err := ln.NewPingMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rn.SendMessageAsync(ping)
+	if err != nil {
+		t.Fatalf("send ping error: %v", err)
+	}
+
+	rn.Stop(nil)
+	waitFor(t, time.Second, func() bool {
+		return ln.TxQueuedBytes() == 0
+	})
+}
+
+func TestTxQueuedBytesWithRetry(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{MaxTxQueuedBytes: 3500})
+	rn := newTestIdleRemoteNode(t, ln)
+
+	msg := newTestMessage(t, ln, make([]byte, 1000))
+	size := int64(msg.Size())
+
+	_, err := rn.SendMessageSyncWithRetry(msg, 20*time.Millisecond, 2)
+	if err == nil {
+		t.Fatal("send msg to idle remote node should time out")
+	}
+
+	// the original msg and both retries are still queued and all counted
+	if queued := ln.TxQueuedBytes(); queued != 3*size {
+		t.Fatalf("tx queued bytes is %d, expecting %d", queued, 3*size)
+	}
+
+	err = rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 1000)))
+	if err == nil {
+		t.Fatal("send msg beyond max tx queued bytes should fail")
+	}
+
+	rn.Stop(nil)
+	waitFor(t, time.Second, func() bool {
+		return ln.TxQueuedBytes() == 0
+	})
+}
+
+func TestTxQueuedBytesContextCanceled(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		MaxTxQueuedBytes:    2048,
+		TxQueuedBytesPolicy: "block",
+		RemoteTxMsgChanLen:  1,
+	})
+	rn := newTestIdleRemoteNode(t, ln)
+
+	msg := newTestMessage(t, ln, make([]byte, 1000))
+	size := int64(msg.Size())
+
+	err := rn.SendMessageAsync(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocked by full tx msg chan
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, err = rn.SendMessageWithContext(ctx, newTestMessage(t, ln, make([]byte, 10)), false)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("send error is %v, expecting %v", err, context.DeadlineExceeded)
+	}
+	if queued := ln.TxQueuedBytes(); queued != size {
+		t.Fatalf("tx queued bytes is %d after send is canceled, expecting %d", queued, size)
+	}
+
+	// blocked by max tx queued bytes
+	ctx, cancel = context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := rn.SendMessageWithContext(ctx, newTestMessage(t, ln, make([]byte, 1500)), false)
+		errChan <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err = <-errChan:
+	case <-time.After(time.Second):
+		t.Fatal("send is still blocked after ctx is canceled")
+	}
+	if err != context.Canceled {
+		t.Fatalf("send error is %v, expecting %v", err, context.Canceled)
+	}
+	if queued := ln.TxQueuedBytes(); queued != size {
+		t.Fatalf("tx queued bytes is %d after send is canceled, expecting %d", queued, size)
+	}
+
+	rn.Stop(nil)
+	waitFor(t, time.Second, func() bool {
+		return ln.TxQueuedBytes() == 0
+	})
+}
+
+func TestSendBudgetExceeded(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		SendBudget:       2500,
+		SendBudgetWindow: time.Minute,
+	})
+	rn := newTestIdleRemoteNode(t, ln)
+
+	for i := 0; i < 2; i++ {
+		err := rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 1000)))
+		if err != nil {
+			t.Fatalf("send msg %d error: %v", i, err)
+		}
+	}
+
+	err := rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 1000)))
+	if err == nil || err.Error() != "Send budget exceeded" {
+		t.Fatalf("send msg beyond send budget error is %v, expecting send budget exceeded", err)
+	}
+
+	err = rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 3000)))
+	if err == nil || !strings.Contains(err.Error(), "exceeds send budget") {
+		t.Fatalf("send msg larger than send budget error is %v, expecting exceeds send budget", err)
+	}
+
+	// node control msg are not limited by the budget
+	ping, err := ln.NewPingMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rn.SendMessageAsync(ping)
+	if err != nil {
+		t.Fatalf("send ping error: %v", err)
+	}
+}
+
+func TestSendBudgetRefund(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		SendBudget:             2500,
+		SendBudgetWindow:       time.Minute,
+		MaxTxQueuedBytes:       1500,
+		RemoteTxMsgChanLen:     1,
+		RemoteTxOverflowPolicy: "reject",
+	})
+	rn := newTestIdleRemoteNode(t, ln)
+
+	msg := newTestMessage(t, ln, make([]byte, 1000))
+	size := uint64(msg.Size())
+
+	err := rn.SendMessageAsync(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fails on max tx queued bytes after taking the budget
+	for i := 0; i < 3; i++ {
+		err = rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 1000)))
+		if err == nil || err.Error() != "Max tx queued bytes reached" {
+			t.Fatalf("send msg error is %v, expecting max tx queued bytes reached", err)
+		}
+	}
+
+	// fails on full tx msg chan after taking the budget
+	for i := 0; i < 3; i++ {
+		err = rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 10)))
+		if err == nil || strings.Contains(err.Error(), "budget") {
+			t.Fatalf("send msg error is %v, expecting tx msg chan full", err)
+		}
+	}
+
+	rn.sendBudget.Lock()
+	used := rn.sendBudget.used
+	rn.sendBudget.Unlock()
+	if used != size {
+		t.Fatalf("send budget used is %d after failed sends, expecting %d", used, size)
+	}
+}
@@ -0,0 +1,108 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestMsgChanLenOverride(t *testing.T) {
+	const rxMsgChanLen = 9
+	const txMsgChanLen = 7
+
+	ln := newTestLocalNode(t, &config.Config{RemoteRxMsgChanLen: 100, RemoteTxMsgChanLen: 100})
+	peer := newTestLocalNode(t, nil)
+
+	errChan := make(chan error, 4)
+	err := ln.ApplyMiddleware(RemoteNodeConnected{func(rn *RemoteNode) bool {
+		errChan <- rn.SetRxMsgChanLen(0)
+		errChan <- rn.SetTxMsgChanLen(0)
+		errChan <- rn.SetRxMsgChanLen(rxMsgChanLen)
+		errChan <- rn.SetTxMsgChanLen(txMsgChanLen)
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	if err = <-errChan; err == nil {
+		t.Fatal("set rx msg chan len to 0 should fail")
+	}
+	if err = <-errChan; err == nil {
+		t.Fatal("set tx msg chan len to 0 should fail")
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	stats := rn.Stats()
+	if rn.RxMsgChanLen() != rxMsgChanLen || stats.RxMsgChan.Cap != rxMsgChanLen {
+		t.Fatalf("rx msg chan len is %d, expecting %d", rn.RxMsgChanLen(), rxMsgChanLen)
+	}
+	if rn.TxMsgChanLen() != txMsgChanLen || stats.TxMsgChan.Cap != txMsgChanLen {
+		t.Fatalf("tx msg chan len is %d, expecting %d", rn.TxMsgChanLen(), txMsgChanLen)
+	}
+
+	// other remote nodes use the config
+	if peerRn.RxMsgChanLen() == rxMsgChanLen || peerRn.TxMsgChanLen() == txMsgChanLen {
+		t.Fatal("override should only apply to the remote node it is set on")
+	}
+
+	if err = rn.SetTxMsgChanLen(txMsgChanLen + 1); err == nil {
+		t.Fatal("set tx msg chan len after remote node starts should fail")
+	}
+	if err = rn.SetRxMsgChanLen(rxMsgChanLen + 1); err == nil {
+		t.Fatal("set rx msg chan len after remote node starts should fail")
+	}
+
+	// msg are sent and received through the new chans
+	for i := 0; i < 2*txMsgChanLen; i++ {
+		err = rn.SendMessageBlocking(newTestMessage(t, ln, []byte{byte(i)}), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = peerRn.SendMessageAsync(newTestMessage(t, peer, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2*txMsgChanLen; i++ {
+		recvTestMessage(t, peer, time.Second)
+		recvTestMessage(t, ln, time.Second)
+	}
+}
+
+func TestMsgChanLenOverrideBeforeStart(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	rn := newTestIdleRemoteNode(t, ln)
+
+	for i := 0; i < 3; i++ {
+		err := rn.SendMessageAsync(newTestMessage(t, ln, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rn.SetTxMsgChanLen(2); err == nil {
+		t.Fatal("set tx msg chan len less than number of queued msg should fail")
+	}
+
+	err := rn.SetTxMsgChanLen(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// queued msg are kept in the new chan
+	if n := len(rn.getTxMsgChan()); n != 3 {
+		t.Fatalf("%d msg in tx msg chan, expecting 3", n)
+	}
+	if err = rn.SendMessageAsync(newTestMessage(t, ln, nil)); err == nil {
+		t.Fatal("send msg to full tx msg chan should fail")
+	}
+}
@@ -0,0 +1,189 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+const (
+	// Msg buf encoded with a codec other than protobuf starts with this byte
+	// followed by codec id. Like compressedMsgFlag, a marshaled protobuf message
+	// never starts with it, so msg buf encoded by nodes that do not support
+	// codecs can still be decoded.
+	encodedMsgFlag byte = 1
+
+	// Default codec, msg encoded with it has no header so that it can be decoded
+	// by all nodes
+	msgCodecProtobuf = "protobuf"
+)
+
+// MessageCodec encodes msg sent to remote node into bytes and decodes msg
+// received from remote node
+type MessageCodec interface {
+	Marshal(msg *protobuf.Message) ([]byte, error)
+	Unmarshal(buf []byte, msg *protobuf.Message) error
+}
+
+// msgCodec is a registered msg codec
+type msgCodec struct {
+	name  string
+	id    byte
+	codec MessageCodec
+}
+
+// msgCodecs stores registered msg codecs by name and by the codec id used on
+// the wire. Protobuf codec has no id as msg encoded with it has no header.
+var (
+	msgCodecs = map[string]*msgCodec{
+		msgCodecProtobuf: {name: msgCodecProtobuf, codec: protobufCodec{}},
+		"json":           {name: "json", id: 1, codec: jsonCodec{}},
+	}
+	msgCodecIDs = map[byte]*msgCodec{
+		1: msgCodecs["json"],
+	}
+	msgCodecsLock sync.RWMutex
+)
+
+// RegisterMessageCodec registers a msg codec with name used in config and
+// handshake, and id used on the wire. Both nodes of a connection need to
+// register the codec with the same name and id to use it. A node keeps
+// decoding msg with all registered codecs regardless of the codec it sends
+// with, so a network can migrate to a new codec without downtime by
+// registering it on all nodes first and then preferring it in MessageCodecs.
+// It returns error if name or id is already registered. It should be called
+// before any local node is created.
+func RegisterMessageCodec(name string, id byte, codec MessageCodec) error {
+	if name == "" {
+		return errors.New("Msg codec name is empty")
+	}
+	if codec == nil {
+		return errors.New("Msg codec is nil")
+	}
+
+	msgCodecsLock.Lock()
+	defer msgCodecsLock.Unlock()
+
+	if _, ok := msgCodecs[name]; ok {
+		return fmt.Errorf("Msg codec %s is already registered", name)
+	}
+	if _, ok := msgCodecIDs[id]; ok {
+		return fmt.Errorf("Msg codec id %d is already registered", id)
+	}
+
+	c := &msgCodec{name: name, id: id, codec: codec}
+	msgCodecs[name] = c
+	msgCodecIDs[id] = c
+
+	return nil
+}
+
+// getMsgCodec returns the registered msg codec with name, or nil if not found
+func getMsgCodec(name string) *msgCodec {
+	msgCodecsLock.RLock()
+	defer msgCodecsLock.RUnlock()
+	return msgCodecs[name]
+}
+
+// getMsgCodecByID returns the registered msg codec with id, or nil if not
+// found
+func getMsgCodecByID(id byte) *msgCodec {
+	msgCodecsLock.RLock()
+	defer msgCodecsLock.RUnlock()
+	return msgCodecIDs[id]
+}
+
+// supportedMsgCodecs returns the sorted names of registered msg codecs, which
+// are advertised to remote node at handshake
+func supportedMsgCodecs() []string {
+	msgCodecsLock.RLock()
+	defer msgCodecsLock.RUnlock()
+
+	codecs := make([]string, 0, len(msgCodecs))
+	for name := range msgCodecs {
+		codecs = append(codecs, name)
+	}
+	sort.Strings(codecs)
+
+	return codecs
+}
+
+// negotiateMsgCodec returns the first codec in preferred that is also
+// supported by remote node, or protobuf if there is no such codec
+func negotiateMsgCodec(preferred, remoteCodecs []string) string {
+	for _, codec := range preferred {
+		for _, remoteCodec := range remoteCodecs {
+			if codec == remoteCodec {
+				return codec
+			}
+		}
+	}
+	return msgCodecProtobuf
+}
+
+// marshalMsg encodes msg with codec, prepending codec header if codec is not
+// protobuf
+func marshalMsg(msg *protobuf.Message, codec string) ([]byte, error) {
+	if codec == msgCodecProtobuf {
+		return proto.Marshal(msg)
+	}
+
+	c := getMsgCodec(codec)
+	if c == nil {
+		return nil, fmt.Errorf("Unknown msg codec %s", codec)
+	}
+
+	buf, err := c.codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{encodedMsgFlag, c.id}, buf...), nil
+}
+
+// unmarshalMsg decodes buf into msg with the codec indicated by its header, or
+// protobuf if it has no header
+func unmarshalMsg(buf []byte, msg *protobuf.Message) error {
+	if len(buf) == 0 || buf[0] != encodedMsgFlag {
+		return proto.Unmarshal(buf, msg)
+	}
+
+	if len(buf) < 2 {
+		return errors.New("Encoded msg has no codec id")
+	}
+
+	c := getMsgCodecByID(buf[1])
+	if c == nil {
+		return fmt.Errorf("Unknown msg codec id %d", buf[1])
+	}
+
+	return c.codec.Unmarshal(buf[2:], msg)
+}
+
+// protobufCodec is the default msg codec
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(msg *protobuf.Message) ([]byte, error) {
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(buf []byte, msg *protobuf.Message) error {
+	return proto.Unmarshal(buf, msg)
+}
+
+// jsonCodec encodes msg as JSON, which is larger and slower than protobuf but
+// easier to inspect
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(msg *protobuf.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+func (jsonCodec) Unmarshal(buf []byte, msg *protobuf.Message) error {
+	return json.Unmarshal(buf, msg)
+}
@@ -0,0 +1,69 @@
+package node
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestMixedMessageCodecs(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{MessageCodecs: []string{"json"}})
+	peer := newTestLocalNode(t, nil)
+	jsonPeer := newTestLocalNode(t, &config.Config{MessageCodecs: []string{"json", msgCodecProtobuf}})
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+	waitFor(t, 5*time.Second, peerRn.IsReady)
+
+	// each side encodes with its own preference among codecs both support,
+	// and decodes whatever codec the other side uses
+	if codec := rn.MessageCodec(); codec != "json" {
+		t.Fatalf("msg codec is %s, expecting json", codec)
+	}
+	if codec := peerRn.MessageCodec(); codec != msgCodecProtobuf {
+		t.Fatalf("msg codec of peer is %s, expecting %s", codec, msgCodecProtobuf)
+	}
+
+	for i := 0; i < 3; i++ {
+		data := []byte{byte(i), 'l', 'n'}
+		err := rn.SendMessageAsync(newTestMessage(t, ln, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remoteMsg := recvTestMessage(t, peer, time.Second); !bytes.Equal(remoteMsg.Msg.Message, data) {
+			t.Fatalf("peer receives %v, expecting %v", remoteMsg.Msg.Message, data)
+		}
+
+		data = []byte{byte(i), 'p', 'e', 'e', 'r'}
+		err = peerRn.SendMessageAsync(newTestMessage(t, peer, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remoteMsg := recvTestMessage(t, ln, time.Second); !bytes.Equal(remoteMsg.Msg.Message, data) {
+			t.Fatalf("local node receives %v, expecting %v", remoteMsg.Msg.Message, data)
+		}
+	}
+
+	// request and reply in different codecs
+	go func() {
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte("reply"))
+		err := remoteMsg.RemoteNode.SendMessageAsync(reply)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	reply, err := rn.SendMessageSync(newTestMessage(t, ln, []byte("request")), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Msg.Message, []byte("reply")) {
+		t.Fatalf("reply is %q, expecting %q", reply.Msg.Message, "reply")
+	}
+
+	jsonRn, _ := connectTestNodes(t, ln, jsonPeer)
+	if codec := jsonRn.MessageCodec(); codec != "json" {
+		t.Fatalf("msg codec with peer preferring json is %s, expecting json", codec)
+	}
+}
@@ -0,0 +1,294 @@
+package node
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+const (
+	// Compressed msg buf starts with this byte followed by codec id. A
+	// marshaled protobuf message never starts with 0 because field number 0 is
+	// invalid, so uncompressed msg buf can be distinguished from compressed one.
+	compressedMsgFlag byte = 0
+
+	// No compression
+	compressionNone = "none"
+
+	// Codec that compresses with CompressionDictionary as preset dictionary
+	compressionFlateDict = "flatedict"
+
+	// Number of bytes of dictionary id in flatedict compressed msg
+	compressionDictIDLen = 4
+)
+
+// CompressionCodec compresses msg sent to remote node and decompresses msg
+// received from remote node. dict is the CompressionDictionary of local node,
+// nil if not set, which can be ignored by codecs that do not use a dictionary.
+type CompressionCodec interface {
+	// NewWriter returns a writer that compresses data written to it into w
+	NewWriter(w io.Writer, dict []byte) (io.WriteCloser, error)
+
+	// NewReader returns a reader that decompresses data read from r
+	NewReader(r io.Reader, dict []byte) (io.Reader, error)
+}
+
+// compressionCodec is a registered compression codec
+type compressionCodec struct {
+	name  string
+	id    byte
+	codec CompressionCodec
+}
+
+// compressionCodecs stores registered compression codecs by name and by the
+// codec id used on the wire
+var (
+	compressionCodecs = map[string]*compressionCodec{
+		"gzip":               {name: "gzip", id: 1, codec: gzipCodec{}},
+		compressionFlateDict: {name: compressionFlateDict, id: 2, codec: flateDictCodec{}},
+	}
+	compressionCodecIDs = map[byte]*compressionCodec{
+		1: compressionCodecs["gzip"],
+		2: compressionCodecs[compressionFlateDict],
+	}
+	compressionCodecsLock sync.RWMutex
+)
+
+// RegisterCompressionCodec registers a compression codec (e.g. snappy, zstd)
+// with name used in config and handshake, and id used on the wire. Both nodes
+// of a connection need to register the codec with the same name and id to use
+// it. It returns error if name or id is already registered. It should be
+// called before any local node is created.
+func RegisterCompressionCodec(name string, id byte, codec CompressionCodec) error {
+	if name == "" || name == compressionNone {
+		return fmt.Errorf("Invalid compression codec name %q", name)
+	}
+	if codec == nil {
+		return errors.New("Compression codec is nil")
+	}
+
+	compressionCodecsLock.Lock()
+	defer compressionCodecsLock.Unlock()
+
+	if _, ok := compressionCodecs[name]; ok {
+		return fmt.Errorf("Compression codec %s is already registered", name)
+	}
+	if _, ok := compressionCodecIDs[id]; ok {
+		return fmt.Errorf("Compression codec id %d is already registered", id)
+	}
+
+	c := &compressionCodec{name: name, id: id, codec: codec}
+	compressionCodecs[name] = c
+	compressionCodecIDs[id] = c
+
+	return nil
+}
+
+// getCompressionCodec returns the registered codec with name, or nil if not
+// found
+func getCompressionCodec(name string) *compressionCodec {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+	return compressionCodecs[name]
+}
+
+// getCompressionCodecByID returns the registered codec with id, or nil if not
+// found
+func getCompressionCodecByID(id byte) *compressionCodec {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+	return compressionCodecIDs[id]
+}
+
+// supportedCompressionCodecs returns the sorted names of codecs that can be
+// used with dict, which are advertised to remote node at handshake
+func supportedCompressionCodecs(dict []byte) []string {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+
+	codecs := make([]string, 0, len(compressionCodecs))
+	for name := range compressionCodecs {
+		if name == compressionFlateDict && len(dict) == 0 {
+			continue
+		}
+		codecs = append(codecs, name)
+	}
+	sort.Strings(codecs)
+
+	return codecs
+}
+
+// negotiateCompression returns the first codec in preferred that is also
+// supported by remote node, or none if there is no such codec. flatedict is
+// only chosen if remote node has the same dictionary.
+func negotiateCompression(preferred, remoteCodecs []string, dict, remoteDictID []byte) string {
+	for _, codec := range preferred {
+		if codec == compressionFlateDict && (len(dict) == 0 || !bytes.Equal(compressionDictID(dict), remoteDictID)) {
+			continue
+		}
+		for _, remoteCodec := range remoteCodecs {
+			if codec == remoteCodec {
+				return codec
+			}
+		}
+	}
+	return compressionNone
+}
+
+// compressionDictID returns the id of a compression dictionary, which is
+// put in the header of flatedict compressed msg so that receiver can check it
+// has the same dictionary. Returns nil if dict is empty.
+func compressionDictID(dict []byte) []byte {
+	if len(dict) == 0 {
+		return nil
+	}
+	hash := sha256.Sum256(dict)
+	return hash[:compressionDictIDLen]
+}
+
+// checkCompression returns error if compression codec is not supported, or
+// codec requires a dictionary but dict is empty
+func checkCompression(codec string, dict []byte) error {
+	if codec == compressionNone {
+		return nil
+	}
+	if getCompressionCodec(codec) == nil {
+		return fmt.Errorf("Unknown compression codec %s", codec)
+	}
+	if codec == compressionFlateDict && len(dict) == 0 {
+		return fmt.Errorf("Compression codec %s requires CompressionDictionary", codec)
+	}
+	return nil
+}
+
+// compressMsgBuf compresses marshaled msg buf with codec. dict is the preset
+// dictionary used by flatedict codec. Buf shorter than threshold is not
+// compressed.
+func compressMsgBuf(buf []byte, codec string, dict []byte, threshold uint32) ([]byte, error) {
+	if codec == compressionNone || uint32(len(buf)) < threshold {
+		return buf, nil
+	}
+
+	c := getCompressionCodec(codec)
+	if c == nil {
+		return nil, fmt.Errorf("Unknown compression codec %s", codec)
+	}
+
+	var b bytes.Buffer
+	b.WriteByte(compressedMsgFlag)
+	b.WriteByte(c.id)
+
+	w, err := c.codec.NewWriter(&b, dict)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// errDecompressedMsgTooLarge is returned by decompressMsgBuf if decompressed
+// size exceeds max size
+var errDecompressedMsgTooLarge = errors.New("Decompressed msg size exceeds max msg size")
+
+// decompressMsgBuf decompresses msg buf if it is compressed, otherwise returns
+// buf as it is. dict is the preset dictionary used by flatedict codec, and msg
+// compressed with a different dictionary is rejected. Returns
+// errDecompressedMsgTooLarge if decompressed size exceeds maxSize.
+func decompressMsgBuf(buf []byte, maxSize uint32, dict []byte) ([]byte, error) {
+	if len(buf) == 0 || buf[0] != compressedMsgFlag {
+		return buf, nil
+	}
+
+	if len(buf) < 2 {
+		return nil, errors.New("Compressed msg has no codec id")
+	}
+
+	c := getCompressionCodecByID(buf[1])
+	if c == nil {
+		return nil, fmt.Errorf("Unknown compression codec id %d", buf[1])
+	}
+
+	r, err := c.codec.NewReader(bytes.NewReader(buf[2:]), dict)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(len(decompressed)) > maxSize {
+		return nil, errDecompressedMsgTooLarge
+	}
+
+	return decompressed, nil
+}
+
+// gzipCodec is the gzip compression codec
+type gzipCodec struct{}
+
+func (gzipCodec) NewWriter(w io.Writer, dict []byte) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader, dict []byte) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// flateDictCodec is the flate compression codec with dict as preset
+// dictionary. Compressed data starts with dictionary id so that msg compressed
+// with a different dictionary is rejected.
+type flateDictCodec struct{}
+
+func (flateDictCodec) NewWriter(w io.Writer, dict []byte) (io.WriteCloser, error) {
+	if len(dict) == 0 {
+		return nil, errors.New("Compression dictionary is empty")
+	}
+	_, err := w.Write(compressionDictID(dict))
+	if err != nil {
+		return nil, err
+	}
+	// lower levels may not find matches in preset dictionary for msg as small
+	// as the ones this codec is meant for, which then gain nothing from it
+	return flate.NewWriterDict(w, flate.BestCompression, dict)
+}
+
+func (flateDictCodec) NewReader(r io.Reader, dict []byte) (io.Reader, error) {
+	dictID := make([]byte, compressionDictIDLen)
+	_, err := io.ReadFull(r, dictID)
+	if err != nil {
+		return nil, errors.New("Compressed msg has no dictionary id")
+	}
+	if !bytes.Equal(dictID, compressionDictID(dict)) {
+		return nil, fmt.Errorf("Compression dictionary id %x does not match local dictionary", dictID)
+	}
+	return flate.NewReaderDict(r, dict), nil
+}
+
+// initialCompression returns the compression codec used for msg sent to a
+// remote node before handshake. If codecs are negotiated, msg is not
+// compressed until remote node advertises the codecs it supports.
+func (ln *LocalNode) initialCompression() string {
+	if len(ln.CompressionCodecs) > 0 {
+		return compressionNone
+	}
+	return ln.Compression
+}
@@ -0,0 +1,234 @@
+package node
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+// sendTestMessages sends msg with data to rn count times and waits until peer
+// receives all of them. Returns the number of bytes sent to rn and the time
+// it takes.
+func sendTestMessages(tb testing.TB, rn *RemoteNode, peer *LocalNode, data []byte, count int) (uint64, time.Duration) {
+	tb.Helper()
+
+	stats := rn.Stats()
+	startTime := time.Now()
+
+	for i := 0; i < count; i++ {
+		err := rn.SendMessageAsync(newTestMessage(tb, rn.LocalNode, data))
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	for i := 0; i < count; i++ {
+		remoteMsg := recvTestMessage(tb, peer, 5*time.Second)
+		if !bytes.Equal(remoteMsg.Msg.Message, data) {
+			tb.Fatalf("received msg of %d bytes, expecting %d bytes", len(remoteMsg.Msg.Message), len(data))
+		}
+	}
+
+	elapsed := time.Since(startTime)
+
+	// tx counters are updated after msg is written to conn
+	waitFor(tb, time.Second, func() bool {
+		return rn.Stats().MsgTx >= stats.MsgTx+uint64(count)
+	})
+
+	return rn.Stats().BytesTx - stats.BytesTx, elapsed
+}
+
+func TestCompressionOverride(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{Compression: "gzip"})
+	peer := newTestLocalNode(t, nil)
+	lanPeer := newTestLocalNode(t, nil)
+
+	err := ln.ApplyMiddleware(RemoteNodeReady{func(rn *RemoteNode) bool {
+		if bytes.Equal(rn.Id, lanPeer.Id) {
+			err := rn.SetCompression(compressionNone)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, _ := connectTestNodes(t, ln, peer)
+	lanRn, _ := connectTestNodes(t, ln, lanPeer)
+
+	if codec := rn.Compression(); codec != "gzip" {
+		t.Fatalf("compression is %s, expecting default gzip", codec)
+	}
+	if codec := lanRn.Compression(); codec != compressionNone {
+		t.Fatalf("compression is %s after override, expecting %s", codec, compressionNone)
+	}
+
+	data := bytes.Repeat([]byte("compressible "), 1000)
+
+	compressedBytes, _ := sendTestMessages(t, rn, peer, data, 1)
+	if compressedBytes >= uint64(len(data)) {
+		t.Fatalf("sent %d bytes with gzip, expecting less than %d", compressedBytes, len(data))
+	}
+
+	uncompressedBytes, _ := sendTestMessages(t, lanRn, lanPeer, data, 1)
+	if uncompressedBytes < uint64(len(data)) {
+		t.Fatalf("sent %d bytes without compression, expecting at least %d", uncompressedBytes, len(data))
+	}
+
+	if err = lanRn.SetCompression("unknown"); err == nil {
+		t.Fatal("set unknown compression codec should fail")
+	}
+}
+
+func TestRateLimitOverride(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{RateLimit: 10 * 1024 * 1024})
+	peer := newTestLocalNode(t, nil)
+	meteredPeer := newTestLocalNode(t, nil)
+
+	err := ln.ApplyMiddleware(RemoteNodeReady{func(rn *RemoteNode) bool {
+		if bytes.Equal(rn.Id, meteredPeer.Id) {
+			rn.SetRateLimit(20 * 1024)
+		}
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, _ := connectTestNodes(t, ln, peer)
+	meteredRn, _ := connectTestNodes(t, ln, meteredPeer)
+
+	if rate := rn.RateLimit(); rate != ln.RateLimit {
+		t.Fatalf("rate limit is %d, expecting default %d", rate, ln.RateLimit)
+	}
+	if rate := meteredRn.RateLimit(); rate != 20*1024 {
+		t.Fatalf("rate limit is %d after override, expecting %d", rate, 20*1024)
+	}
+
+	// the first 20KB are sent at once, the next 20KB take about 1s
+	data := make([]byte, 10*1024)
+
+	_, elapsed := sendTestMessages(t, rn, peer, data, 4)
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("sending 40KB with default rate limit takes %v", elapsed)
+	}
+
+	_, elapsed = sendTestMessages(t, meteredRn, meteredPeer, data, 4)
+	if elapsed < 500*time.Millisecond {
+		t.Fatalf("sending 40KB with rate limit of 20KB/s takes %v, expecting about 1s", elapsed)
+	}
+}
+
+func TestFlateDictCompression(t *testing.T) {
+	dict := []byte(`{"type":"update","version":1,"status":"ok","payload":{"key":"","value":""},"timestamp":}`)
+	data := []byte(`{"type":"update","version":1,"status":"ok","payload":{"key":"a","value":"b"},"timestamp":1}`)
+
+	ln := newTestLocalNode(t, &config.Config{Compression: compressionFlateDict, CompressionDictionary: dict})
+	peer := newTestLocalNode(t, &config.Config{CompressionDictionary: dict})
+	gzipLn := newTestLocalNode(t, &config.Config{Compression: "gzip"})
+	gzipPeer := newTestLocalNode(t, nil)
+
+	rn, _ := connectTestNodes(t, ln, peer)
+	gzipRn, _ := connectTestNodes(t, gzipLn, gzipPeer)
+
+	const count = 10
+	dictBytes, _ := sendTestMessages(t, rn, peer, data, count)
+	gzipBytes, _ := sendTestMessages(t, gzipRn, gzipPeer, data, count)
+	if dictBytes >= gzipBytes {
+		t.Fatalf("sent %d bytes with flatedict, expecting less than %d bytes with gzip", dictBytes, gzipBytes)
+	}
+
+	buf, err := marshalMsg(newTestMessage(t, ln, data), msgCodecProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := compressMsgBuf(buf, compressionFlateDict, dict, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(buf) {
+		t.Fatalf("small msg of %d bytes is compressed to %d bytes with flatedict, expecting less", len(buf), len(compressed))
+	}
+
+	// msg compressed with a different dictionary is rejected
+	_, err = decompressMsgBuf(compressed, ln.MaxMessageSize, []byte("another dictionary"))
+	if err == nil {
+		t.Fatal("msg compressed with another dictionary is decompressed")
+	}
+	decompressed, err := decompressMsgBuf(compressed, ln.MaxMessageSize, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, buf) {
+		t.Fatal("decompressed msg is different from the original one")
+	}
+
+	if err = checkCompression(compressionFlateDict, nil); err == nil {
+		t.Fatal("flatedict without dictionary should be rejected")
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	const threshold = 1024
+
+	dict := bytes.Repeat([]byte("dictionary "), 10)
+	ln := newTestLocalNode(t, &config.Config{
+		CompressionCodecs:     []string{compressionFlateDict, "gzip"},
+		CompressionDictionary: dict,
+		CompressionThreshold:  threshold,
+	})
+	dictPeer := newTestLocalNode(t, &config.Config{CompressionDictionary: dict})
+	noDictPeer := newTestLocalNode(t, nil)
+	otherDictPeer := newTestLocalNode(t, &config.Config{CompressionDictionary: []byte("another dictionary")})
+
+	// flatedict is only chosen if peer has the same dictionary
+	tests := []struct {
+		peer     *LocalNode
+		expected string
+	}{
+		{dictPeer, compressionFlateDict},
+		{noDictPeer, "gzip"},
+		{otherDictPeer, "gzip"},
+	}
+	for _, test := range tests {
+		rn, _ := connectTestNodes(t, ln, test.peer)
+		if codec := rn.Compression(); codec != test.expected {
+			t.Fatalf("negotiated compression is %s, expecting %s", codec, test.expected)
+		}
+
+		// msg smaller than threshold is sent uncompressed
+		small := bytes.Repeat([]byte("a"), threshold/2)
+		sent, _ := sendTestMessages(t, rn, test.peer, small, 1)
+		if sent < uint64(len(small)) {
+			t.Fatalf("sent %d bytes for msg below threshold, expecting at least %d", sent, len(small))
+		}
+
+		large := bytes.Repeat([]byte("a"), threshold*4)
+		sent, _ = sendTestMessages(t, rn, test.peer, large, 1)
+		if sent >= uint64(len(large)) {
+			t.Fatalf("sent %d bytes for msg above threshold with %s, expecting less than %d", sent, test.expected, len(large))
+		}
+	}
+
+	// no codec in common falls back to no compression
+	noneLn := newTestLocalNode(t, &config.Config{CompressionCodecs: []string{compressionFlateDict}, CompressionDictionary: dict})
+	rn, _ := connectTestNodes(t, noneLn, noDictPeer)
+	if codec := rn.Compression(); codec != compressionNone {
+		t.Fatalf("negotiated compression is %s without codec in common, expecting %s", codec, compressionNone)
+	}
+
+	conf, err := config.MergedConfig(&config.Config{Transport: "mem", CompressionCodecs: []string{"unknown"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewLocalNode(ln.Id, conf)
+	if err == nil || !strings.Contains(err.Error(), "Unknown compression codec") {
+		t.Fatal("local node with unknown compression codec is created")
+	}
+}
@@ -0,0 +1,134 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSendMessageSyncUntilPastDeadline(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	startTime := time.Now()
+	_, err := rn.SendMessageSyncUntil(newTestMessage(t, ln, []byte("request")), time.Now().Add(-time.Second))
+	if err == nil {
+		t.Fatal("send msg with deadline in the past should time out")
+	}
+	if elapsed := time.Since(startTime); elapsed > 100*time.Millisecond {
+		t.Fatalf("send msg with deadline in the past returns after %v, expecting immediate timeout", elapsed)
+	}
+
+	// msg is not sent and no reply chan is left behind
+	time.Sleep(100 * time.Millisecond)
+	value, _ := testMsgChans.Load(peer)
+	if n := len(value.(<-chan *RemoteMessage)); n != 0 {
+		t.Fatalf("peer received %d msg after deadline has passed", n)
+	}
+	if pending := ln.PendingReplies(); len(pending) != 0 {
+		t.Fatalf("%d reply chans are pending after deadline has passed", len(pending))
+	}
+}
+
+func TestSendMessageSyncUntilFutureDeadline(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	go func() {
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte("reply"))
+		err := remoteMsg.RemoteNode.SendMessageAsync(reply)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	reply, err := rn.SendMessageSyncUntil(newTestMessage(t, ln, []byte("request")), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Msg.Message, []byte("reply")) {
+		t.Fatalf("reply is %q, expecting %q", reply.Msg.Message, "reply")
+	}
+
+	// peer does not reply, so send times out at deadline and frees reply chan
+	const timeout = 200 * time.Millisecond
+	startTime := time.Now()
+	_, err = rn.SendMessageSyncUntil(newTestMessage(t, ln, []byte("request")), startTime.Add(timeout))
+	if err == nil {
+		t.Fatal("send msg without reply should time out")
+	}
+	if elapsed := time.Since(startTime); elapsed < timeout || elapsed > timeout+500*time.Millisecond {
+		t.Fatalf("send msg returns after %v, expecting around deadline %v", elapsed, timeout)
+	}
+	if pending := ln.PendingReplies(); len(pending) != 0 {
+		t.Fatalf("%d reply chans are pending after timeout", len(pending))
+	}
+}
+
+func TestSendMessageWithContext(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	// reply is returned before deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg := newTestMessage(t, ln, []byte("request"))
+	go func() {
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte("reply"))
+		err := remoteMsg.RemoteNode.SendMessageAsync(reply)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	reply, err := rn.SendMessageWithContext(ctx, msg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Msg.Message, []byte("reply")) {
+		t.Fatalf("reply is %q, expecting %q", reply.Msg.Message, "reply")
+	}
+
+	// waiting for reply stops with the error of ctx
+	idle := newTestIdleRemoteNode(t, ln)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = idle.SendMessageWithContext(ctx, newTestMessage(t, ln, []byte("request")), true)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("send error is %v after deadline, expecting %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	startTime := time.Now()
+	_, err = idle.SendMessageWithContext(ctx, newTestMessage(t, ln, []byte("request")), true)
+	if err != context.Canceled {
+		t.Fatalf("send error is %v after cancel, expecting %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(startTime); elapsed > ln.DefaultReplyTimeout/2 {
+		t.Fatalf("send returns %v after cancel, expecting right after cancel", elapsed)
+	}
+
+	if pending := ln.PendingReplies(); len(pending) != 0 {
+		t.Fatalf("%d reply chans are pending after ctx is done", len(pending))
+	}
+
+	// ctx already done fails without sending
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = idle.SendMessageWithContext(ctx, newTestMessage(t, ln, []byte("request")), false)
+	if err != context.Canceled {
+		t.Fatalf("send error is %v with canceled ctx, expecting %v", err, context.Canceled)
+	}
+	if n := idle.Stats().TxMsgChan.Len; n != 2 {
+		t.Fatalf("%d msg are queued, expecting 2 sent before ctx is done", n)
+	}
+}
@@ -0,0 +1,106 @@
+package node
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSendDedup(t *testing.T) {
+	const numCalls = 10
+
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	numReceived := make(chan int, 1)
+	go func() {
+		n := 0
+		deadline := time.After(500 * time.Millisecond)
+		for {
+			value, _ := testMsgChans.Load(peer)
+			select {
+			case remoteMsg := <-value.(<-chan *RemoteMessage):
+				n++
+				// reply after all calls are in flight
+				time.Sleep(200 * time.Millisecond)
+				reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte("reply"))
+				err := remoteMsg.RemoteNode.SendMessageAsync(reply)
+				if err != nil {
+					t.Error(err)
+				}
+			case <-deadline:
+				numReceived <- n
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	replies := make([]*RemoteMessage, numCalls)
+	errs := make([]error, numCalls)
+	for i := 0; i < numCalls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies[i], errs[i] = ln.SendDedup(rn, "key", newTestMessage(t, ln, []byte("request")))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < numCalls; i++ {
+		if errs[i] != nil {
+			t.Fatalf("call %d error: %v", i, errs[i])
+		}
+		if replies[i] != replies[0] {
+			t.Fatalf("call %d gets a different reply", i)
+		}
+	}
+
+	if n := <-numReceived; n != 1 {
+		t.Fatalf("peer received %d msg, expecting 1", n)
+	}
+}
+
+func TestSendDedupDifferentKeys(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	go func() {
+		for i := 0; i < 2; i++ {
+			value, _ := testMsgChans.Load(peer)
+			select {
+			case remoteMsg := <-value.(<-chan *RemoteMessage):
+				reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, remoteMsg.Msg.Message)
+				err := remoteMsg.RemoteNode.SendMessageAsync(reply)
+				if err != nil {
+					t.Error(err)
+				}
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	replies := make([]*RemoteMessage, 2)
+	for i, key := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			var err error
+			replies[i], err = ln.SendDedup(rn, key, newTestMessage(t, ln, []byte(key)))
+			if err != nil {
+				t.Error(err)
+			}
+		}(i, key)
+	}
+	wg.Wait()
+
+	for i, key := range []string{"a", "b"} {
+		if replies[i] == nil || string(replies[i].Msg.Message) != key {
+			t.Fatalf("reply of key %s is %v", key, replies[i])
+		}
+	}
+}
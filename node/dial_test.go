@@ -0,0 +1,220 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+	"github.com/nknorg/nnet/transport"
+)
+
+func TestGetOrDial(t *testing.T) {
+	const numDials = 10
+
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+
+	var wg sync.WaitGroup
+	remoteNodes := make([]*RemoteNode, numDials)
+	errs := make([]error, numDials)
+	for i := 0; i < numDials; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			remoteNodes[i], errs[i] = ln.GetOrDial(peer.Addr)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < numDials; i++ {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if remoteNodes[i] != remoteNodes[0] {
+			t.Fatalf("dial %d returns remote node %v, expecting %v", i, remoteNodes[i], remoteNodes[0])
+		}
+	}
+
+	rn := remoteNodes[0]
+	waitFor(t, 5*time.Second, rn.IsReady)
+
+	// connected remote node is returned without dialing again
+	existing, err := ln.GetOrDial(peer.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if existing != rn {
+		t.Fatalf("remote node %v is returned, expecting connected remote node %v", existing, rn)
+	}
+
+	waitFor(t, time.Second, func() bool {
+		neighbors, err := peer.GetNeighbors(nil)
+		return err == nil && len(neighbors) == 1
+	})
+	time.Sleep(100 * time.Millisecond)
+	neighbors, err := peer.GetNeighbors(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(neighbors) != 1 {
+		t.Fatalf("peer has %d neighbors, expecting 1", len(neighbors))
+	}
+
+	_, err = ln.GetOrDial("invalid addr")
+	if err == nil {
+		t.Fatal("dial invalid addr should fail")
+	}
+}
+
+func TestConnectNodeFallback(t *testing.T) {
+	const unreachableAddr = "mem://localhost:65001"
+
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, &config.Config{ExtraAddrs: []string{unreachableAddr}})
+
+	// extra addrs are advertised at handshake
+	rn, _ := connectTestNodes(t, ln, peer)
+	if len(rn.Addrs) != 1 || rn.Addrs[0] != unreachableAddr {
+		t.Fatalf("remote node advertises addrs %v, expecting %v", rn.Addrs, peer.ExtraAddrs)
+	}
+
+	other := newTestLocalNode(t, nil)
+
+	// the first addr is unreachable, the second one succeeds
+	otherRn, _, err := other.ConnectNode(&protobuf.Node{Addr: unreachableAddr, Addrs: []string{peer.Addr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, otherRn.IsReady)
+	if !bytes.Equal(otherRn.Id, peer.Id) {
+		t.Fatalf("connected to node %x, expecting %x", otherRn.Id, peer.Id)
+	}
+
+	_, _, err = other.ConnectNode(&protobuf.Node{Addr: unreachableAddr, Addrs: []string{"mem://localhost:65002"}})
+	if err == nil {
+		t.Fatal("connect node with all addrs unreachable should fail")
+	}
+}
+
+func TestConnectAndSend(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+
+	type result struct {
+		reply *RemoteMessage
+		err   error
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resultChan := make(chan result, 1)
+	go func() {
+		reply, err := ln.ConnectAndSend(ctx, peer.Addr, newTestMessage(t, ln, []byte("ping")), true)
+		resultChan <- result{reply: reply, err: err}
+	}()
+
+	remoteMsg := recvTestMessage(t, peer, 5*time.Second)
+	reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte("pong"))
+	_, err := remoteMsg.RemoteNode.SendMessage(reply, false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-resultChan
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.reply == nil || string(res.reply.Msg.Message) != "pong" {
+		t.Fatalf("reply is %v, expecting pong", res.reply)
+	}
+
+	// existing remote node is reused and kept
+	rn := ln.GetRemoteNodeByID(peer.Id)
+	if rn == nil {
+		t.Fatal("remote node is not kept after ConnectAndSend succeeds")
+	}
+	_, err = ln.ConnectAndSend(ctx, peer.Addr, newTestMessage(t, ln, []byte("data")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteMsg = recvTestMessage(t, peer, 5*time.Second)
+	if string(remoteMsg.Msg.Message) != "data" {
+		t.Fatalf("peer receives %q, expecting %q", remoteMsg.Msg.Message, "data")
+	}
+	if rn.IsStopped() {
+		t.Fatal("existing remote node is stopped by ConnectAndSend")
+	}
+}
+
+func TestConnectAndSendDeadline(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+
+	ln := newTestLocalNode(t, nil)
+
+	// silent peer accepts transport conn but never completes handshake
+	listener, err := transport.NewMemoryTransport().Listen(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	connChan := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		connChan <- conn
+		<-ln.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	_, err = ln.ConnectAndSend(ctx, "mem://"+listener.Addr().String(), newTestMessage(t, ln, []byte("data")), true)
+	elapsed := time.Since(startTime)
+	if err == nil {
+		t.Fatal("ConnectAndSend to silent peer should fail")
+	}
+	if !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("ConnectAndSend error is %v, expecting %v", err, context.DeadlineExceeded)
+	}
+	if elapsed > timeout+time.Second {
+		t.Fatalf("ConnectAndSend returns after %v, expecting about %v", elapsed, timeout)
+	}
+
+	// remote node created by the call is stopped and its conn closed
+	var conn net.Conn
+	select {
+	case conn = <-connChan:
+	case <-time.After(time.Second):
+		t.Fatal("silent peer does not accept conn")
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			_, err := conn.Read(buf)
+			if err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-errChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("conn is not closed after ConnectAndSend deadline")
+	}
+}
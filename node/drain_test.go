@@ -0,0 +1,84 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestDrainStopsRouting(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	drainingPeer := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+
+	drainingRn, _ := connectTestNodes(t, ln, drainingPeer)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	err := drainingPeer.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, time.Second, drainingRn.IsDraining)
+	if rn.IsDraining() {
+		t.Fatal("remote node that is not draining is marked as draining")
+	}
+
+	// new msg are not routed to draining remote node
+	newMsg := &RemoteMessage{Msg: newTestMessage(t, ln, []byte("request"))}
+	neighbors, err := ln.GetNeighbors(newMsg.CanRouteTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(neighbors) != 1 || neighbors[0] != rn {
+		t.Fatalf("new msg can be routed to %v, expecting only %v", neighbors, rn)
+	}
+
+	// replies are still routed so that in-flight requests can complete
+	reply := &RemoteMessage{Msg: newTestReply(t, ln, []byte("request id"), []byte("reply"))}
+	if !reply.CanRouteTo(drainingRn) {
+		t.Fatal("reply cannot be routed to draining remote node")
+	}
+
+	// draining remote node is still connected and can receive msg
+	err = drainingRn.SendMessageAsync(newTestMessage(t, ln, []byte("in-flight")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, drainingPeer, time.Second)
+}
+
+func TestDrainTimeoutWithoutPing(t *testing.T) {
+	conf := func() *config.Config {
+		return &config.Config{
+			MeasureRoundTripTimeInterval: 50 * time.Millisecond,
+			DrainTimeout:                 300 * time.Millisecond,
+		}
+	}
+	ln := newTestLocalNode(t, conf())
+	peer := newTestLocalNode(t, conf())
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	err := peer.Drain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, rn.IsDraining)
+
+	// keepalive pings are suppressed on both sides while draining
+	time.Sleep(50 * time.Millisecond)
+	msgTx, peerMsgTx := rn.Stats().MsgTx, peerRn.Stats().MsgTx
+	time.Sleep(200 * time.Millisecond)
+	if n := rn.Stats().MsgTx - msgTx; n > 1 {
+		t.Fatalf("%d msg are sent to draining remote node without any work", n)
+	}
+	if n := peerRn.Stats().MsgTx - peerMsgTx; n > 1 {
+		t.Fatalf("%d msg are sent by draining node without any work", n)
+	}
+
+	// tx loop checks drain timeout at least every second
+	waitFor(t, ln.DrainTimeout+2*time.Second, func() bool {
+		return rn.IsStopped() && peerRn.IsStopped()
+	})
+}
@@ -0,0 +1,208 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// Frames up to this size are assembled into a single buffer so that length
+	// prefix and body are written in one call. Larger frames are written in
+	// two calls as the cost of copying them outweighs the extra call.
+	maxCoalescedFrameSize = 64 * 1024
+)
+
+// frameBufPool stores buffers used to assemble frames, shared by all remote
+// nodes so that tx does not allocate a buffer for each frame
+var frameBufPool = sync.Pool{
+	New: func() interface{} {
+		return new([]byte)
+	},
+}
+
+// FrameAction is the action to take on a frame after its length prefix is
+// parsed
+type FrameAction int
+
+const (
+	// FrameAccept reads the frame and handles it as usual
+	FrameAccept FrameAction = iota
+
+	// FrameReject consumes and discards the frame, and keeps the connection
+	FrameReject
+
+	// FrameCloseConn stops the remote node without reading the frame
+	FrameCloseConn
+)
+
+// FrameValidator decides whether a frame of msgLen bytes received from remote
+// node should be read into memory. It is called in rx right after the length
+// prefix is parsed and before any buffer is allocated for the frame, so it can
+// be used to enforce memory budget of local node or remote node. Frame larger
+// than MaxMessageSize is discarded or stops the remote node according to
+// OversizedMsgPolicy before the validator is called, so the validator never
+// sees a frame that is too large to read.
+type FrameValidator func(remoteNode *RemoteNode, msgLen uint32) FrameAction
+
+// DefaultFrameValidator accepts every frame. Frame size is already bounded by
+// MaxMessageSize in rx before the validator is called.
+func DefaultFrameValidator(remoteNode *RemoteNode, msgLen uint32) FrameAction {
+	return FrameAccept
+}
+
+// SetFrameValidator sets the frame validator of local node. It should be
+// called before local node starts.
+func (ln *LocalNode) SetFrameValidator(validator FrameValidator) error {
+	if validator == nil {
+		return errors.New("Frame validator is nil")
+	}
+	ln.frameValidator = validator
+	return nil
+}
+
+// GetFrameValidator returns the frame validator of local node
+func (ln *LocalNode) GetFrameValidator() FrameValidator {
+	return ln.frameValidator
+}
+
+// Framer splits the byte stream of a conn into frames, each of which carries
+// one encoded msg
+type Framer interface {
+	// ReadFrame reads the next frame from r and returns its body. It should
+	// limit the body size it reads into memory as r is untrusted.
+	ReadFrame(r io.Reader) ([]byte, error)
+
+	// WriteFrame writes buf to w as a frame. It should keep writing until the
+	// whole frame is written even if w returns a short write without error,
+	// otherwise framing of subsequent frames is corrupted.
+	WriteFrame(w io.Writer, buf []byte) error
+
+	// FrameSize returns the number of bytes of a frame whose body has bodyLen
+	// bytes, including header
+	FrameSize(bodyLen int) int
+}
+
+// FrameHeaderReader can be implemented by Framer to read frame header
+// separately from frame body. If implemented, frame is checked by frame
+// validator and FrameAssemblyTimeout before its body is read, otherwise frame
+// validator is only called after the whole frame is read and
+// FrameAssemblyTimeout does not apply.
+type FrameHeaderReader interface {
+	// ReadFrameHeader reads the header of next frame from r, and returns the
+	// length of frame body which follows it in r
+	ReadFrameHeader(r io.Reader) (uint32, error)
+}
+
+// LengthPrefixFramer is the default framer, which prefixes each frame body
+// with its length encoded by 32 bit big endian int
+type LengthPrefixFramer struct {
+	// Max frame body size that ReadFrame reads into memory, 0 means unlimited
+	MaxFrameSize uint32
+}
+
+// ReadFrameHeader implements FrameHeaderReader interface
+func (f LengthPrefixFramer) ReadFrameHeader(r io.Reader) (uint32, error) {
+	msgLenBuf := make([]byte, msgLenBytes)
+	l, err := io.ReadFull(r, msgLenBuf)
+	if err != nil {
+		if l > 0 {
+			return 0, fmt.Errorf("Msg len has %d bytes, which is less than expected %d", l, msgLenBytes)
+		}
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(msgLenBuf), nil
+}
+
+// ReadFrame implements Framer interface
+func (f LengthPrefixFramer) ReadFrame(r io.Reader) ([]byte, error) {
+	msgLen, err := f.ReadFrameHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.MaxFrameSize > 0 && msgLen > f.MaxFrameSize {
+		return nil, fmt.Errorf("Msg size %d exceeds max msg size %d", msgLen, f.MaxFrameSize)
+	}
+
+	buf := make([]byte, msgLen)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// WriteFrame implements Framer interface
+func (f LengthPrefixFramer) WriteFrame(w io.Writer, buf []byte) error {
+	var msgLenBuf [msgLenBytes]byte
+	binary.BigEndian.PutUint32(msgLenBuf[:], uint32(len(buf)))
+
+	frameBuf := frameBufPool.Get().(*[]byte)
+	frame := append((*frameBuf)[:0], msgLenBuf[:]...)
+
+	var err error
+	if msgLenBytes+len(buf) > maxCoalescedFrameSize {
+		*frameBuf = frame
+		err = writeFull(w, frame)
+		if err == nil {
+			err = writeFull(w, buf)
+		}
+	} else {
+		frame = append(frame, buf...)
+		*frameBuf = frame
+		err = writeFull(w, frame)
+	}
+
+	// A writer may still hold frame after a failed write (e.g. yamux stream
+	// returns on session shutdown before its send loop finishes writing), so
+	// frame buffer is only reused after a successful write
+	if err == nil {
+		frameBufPool.Put(frameBuf)
+	}
+
+	return err
+}
+
+// writeFull writes all of buf to w. A writer should return error if it writes
+// less than len(buf), but conns wrapped by other layers may not, and a short
+// write without error would corrupt the framing of all subsequent frames, so
+// the rest is written again until all of buf is written. Returns
+// io.ErrShortWrite if w makes no progress without error.
+func writeFull(w io.Writer, buf []byte) error {
+	for len(buf) > 0 {
+		n, err := w.Write(buf)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// FrameSize implements Framer interface
+func (f LengthPrefixFramer) FrameSize(bodyLen int) int {
+	return msgLenBytes + bodyLen
+}
+
+// SetFramer sets the framer used by all remote nodes of local node, which
+// needs to match the one of remote nodes. It should be called before local
+// node starts.
+func (ln *LocalNode) SetFramer(framer Framer) error {
+	if framer == nil {
+		return errors.New("Framer is nil")
+	}
+	ln.framer = framer
+	return nil
+}
+
+// GetFramer returns the framer of local node
+func (ln *LocalNode) GetFramer() Framer {
+	return ln.framer
+}
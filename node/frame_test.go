@@ -0,0 +1,607 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/multiplexer"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// newTestRawStream starts an inbound remote node of ln, and returns it with a
+// stream to it that frames can be written to directly
+func newTestRawStream(tb testing.TB, ln *LocalNode) (*RemoteNode, net.Conn) {
+	tb.Helper()
+	return newTestRawStreamWithHook(tb, ln, nil)
+}
+
+// newTestRawStreamWithHook is the same as newTestRawStream, but sets
+// onFrameComplete of remote node before it starts
+func newTestRawStreamWithHook(tb testing.TB, ln *LocalNode, onFrameComplete func(size int)) (*RemoteNode, net.Conn) {
+	tb.Helper()
+
+	conn, peerConn := net.Pipe()
+	tb.Cleanup(func() {
+		peerConn.Close()
+	})
+
+	rn, err := NewRemoteNode(ln, conn, false)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	rn.onFrameComplete = onFrameComplete
+
+	err = ln.startRemoteNode(rn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	mux, err := multiplexer.NewMultiplexer(ln.Multiplexer, peerConn, true)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	stream, err := mux.OpenStream()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return rn, stream
+}
+
+// writeTestFrame writes a frame with body to conn
+func writeTestFrame(tb testing.TB, conn net.Conn, msgLen uint32, body []byte) {
+	tb.Helper()
+
+	buf := make([]byte, msgLenBytes+len(body))
+	binary.BigEndian.PutUint32(buf, msgLen)
+	copy(buf[msgLenBytes:], body)
+
+	err := conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	_, err = conn.Write(buf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestRxMaxUint32MsgLen(t *testing.T) {
+	for _, action := range []FrameAction{FrameAccept, FrameReject, FrameCloseConn} {
+		ln := newTestLocalNode(t, &config.Config{OversizedMsgPolicy: "skip"})
+
+		// a validator that would consume the frame if it were called
+		action := action
+		err := ln.SetFrameValidator(func(remoteNode *RemoteNode, msgLen uint32) FrameAction {
+			return action
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rn, stream := newTestRawStream(t, ln)
+		writeTestFrame(t, stream, 0xFFFFFFFF, nil)
+
+		waitFor(t, time.Second, rn.IsStopped)
+		if reason := rn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "exceeds max msg size") {
+			t.Fatalf("remote node stops because of %v, expecting msg size exceeding max msg size", reason)
+		}
+	}
+}
+
+func TestRxFrameReject(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+
+	err := ln.SetFrameValidator(func(remoteNode *RemoteNode, msgLen uint32) FrameAction {
+		if msgLen > 10 {
+			return FrameReject
+		}
+		return FrameAccept
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, stream := newTestRawStream(t, ln)
+	writeTestFrame(t, stream, 100, make([]byte, 100))
+
+	waitFor(t, time.Second, func() bool {
+		return rn.Stats().MsgDropped == 1
+	})
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v after frame is rejected", rn.StopReason())
+	}
+}
+
+func TestFrameValidator(t *testing.T) {
+	const (
+		rejectLen = 100
+		closeLen  = 200
+	)
+
+	ln := newTestLocalNode(t, nil)
+
+	err := ln.SetFrameValidator(nil)
+	if err == nil {
+		t.Fatal("nil frame validator is set")
+	}
+
+	msgLenChan := make(chan uint32, 8)
+	err = ln.SetFrameValidator(func(remoteNode *RemoteNode, msgLen uint32) FrameAction {
+		msgLenChan <- msgLen
+		switch msgLen {
+		case rejectLen:
+			return FrameReject
+		case closeLen:
+			return FrameCloseConn
+		default:
+			return FrameAccept
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, stream := newTestRawStream(t, ln)
+
+	msgs := make([]*protobuf.Message, 2)
+	bufs := make([][]byte, 2)
+	for i := range msgs {
+		msgs[i] = newTestMessage(t, ln, []byte{byte(i)})
+		bufs[i], err = marshalMsg(msgs[i], msgCodecProtobuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// accepted frames around a rejected one are handled as usual
+	writeTestFrame(t, stream, uint32(len(bufs[0])), bufs[0])
+	writeTestFrame(t, stream, rejectLen, make([]byte, rejectLen))
+	writeTestFrame(t, stream, uint32(len(bufs[1])), bufs[1])
+
+	for _, msg := range msgs {
+		remoteMsg := recvTestMessage(t, ln, time.Second)
+		if !bytes.Equal(remoteMsg.Msg.MessageId, msg.MessageId) {
+			t.Fatalf("received msg %x, expecting %x", remoteMsg.Msg.MessageId, msg.MessageId)
+		}
+	}
+	for _, expected := range []uint32{uint32(len(bufs[0])), rejectLen, uint32(len(bufs[1]))} {
+		if msgLen := <-msgLenChan; msgLen != expected {
+			t.Fatalf("frame validator is called with msg len %d, expecting %d", msgLen, expected)
+		}
+	}
+	if n := rn.Stats().MsgDropped; n != 1 {
+		t.Fatalf("%d msg are dropped, expecting 1", n)
+	}
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v after frame is rejected", rn.StopReason())
+	}
+
+	// frame body is never read before remote node stops
+	writeTestFrame(t, stream, closeLen, nil)
+	waitFor(t, time.Second, rn.IsStopped)
+	if msgLen := <-msgLenChan; msgLen != closeLen {
+		t.Fatalf("frame validator is called with msg len %d, expecting %d", msgLen, closeLen)
+	}
+}
+
+func TestRxDecompressedMsgTooLarge(t *testing.T) {
+	for _, policy := range []string{"skip", "stop"} {
+		ln := newTestLocalNode(t, &config.Config{MaxMessageSize: 1024, OversizedMsgPolicy: policy})
+		rn, stream := newTestRawStream(t, ln)
+
+		buf, err := marshalMsg(newTestMessage(t, ln, make([]byte, 4096)), msgCodecProtobuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err = compressMsgBuf(buf, "gzip", nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(buf) > 1024 {
+			t.Fatalf("compressed msg has %d bytes, expecting no more than max msg size", len(buf))
+		}
+
+		writeTestFrame(t, stream, uint32(len(buf)), buf)
+
+		if policy == "stop" {
+			waitFor(t, time.Second, rn.IsStopped)
+			continue
+		}
+
+		waitFor(t, time.Second, func() bool {
+			return rn.Stats().MsgDropped == 1
+		})
+		if rn.IsStopped() {
+			t.Fatalf("remote node stops because of %v under %s policy", rn.StopReason(), policy)
+		}
+	}
+}
+
+func TestOversizedFrameSkip(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{MaxMessageSize: 1024, OversizedMsgPolicy: "skip"})
+	rn, stream := newTestRawStream(t, ln)
+
+	msgs := make([]*protobuf.Message, 2)
+	bufs := make([][]byte, 2)
+	for i := range msgs {
+		msgs[i] = newTestMessage(t, ln, []byte{byte(i)})
+		buf, err := marshalMsg(msgs[i], msgCodecProtobuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufs[i] = buf
+	}
+
+	// oversized frame between two valid ones is read and discarded exactly
+	oversized := make([]byte, 4096)
+	for i := range oversized {
+		oversized[i] = 0xFF
+	}
+	writeTestFrame(t, stream, uint32(len(bufs[0])), bufs[0])
+	writeTestFrame(t, stream, uint32(len(oversized)), oversized)
+	writeTestFrame(t, stream, uint32(len(bufs[1])), bufs[1])
+
+	for _, msg := range msgs {
+		remoteMsg := recvTestMessage(t, ln, time.Second)
+		if !bytes.Equal(remoteMsg.Msg.MessageId, msg.MessageId) {
+			t.Fatalf("received msg %x, expecting %x", remoteMsg.Msg.MessageId, msg.MessageId)
+		}
+	}
+	if n := rn.Stats().MsgDropped; n != 1 {
+		t.Fatalf("%d msg are dropped, expecting 1", n)
+	}
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v under skip policy", rn.StopReason())
+	}
+}
+
+func TestOversizedFrameAboveDiscardLimit(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		MaxMessageSize:           1024,
+		OversizedMsgPolicy:       "skip",
+		OversizedMsgDiscardLimit: 2048,
+	})
+	rn, stream := newTestRawStream(t, ln)
+
+	writeTestFrame(t, stream, 4096, nil)
+
+	waitFor(t, time.Second, rn.IsStopped)
+	if reason := rn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "exceeds max msg size") {
+		t.Fatalf("remote node stops because of %v, expecting msg size exceeding max msg size", reason)
+	}
+}
+
+func TestOversizedDecompressedMsgSkip(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{MaxMessageSize: 1024, OversizedMsgPolicy: "skip"})
+	rn, stream := newTestRawStream(t, ln)
+
+	oversized, err := marshalMsg(newTestMessage(t, ln, make([]byte, 4096)), msgCodecProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oversized, err = compressMsgBuf(oversized, "gzip", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := newTestMessage(t, ln, []byte("hello"))
+	buf, err := marshalMsg(msg, msgCodecProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// msg after the skipped one is still received on the same conn
+	writeTestFrame(t, stream, uint32(len(oversized)), oversized)
+	writeTestFrame(t, stream, uint32(len(buf)), buf)
+
+	remoteMsg := recvTestMessage(t, ln, time.Second)
+	if !bytes.Equal(remoteMsg.Msg.MessageId, msg.MessageId) {
+		t.Fatalf("received msg %x, expecting %x", remoteMsg.Msg.MessageId, msg.MessageId)
+	}
+	if n := rn.Stats().MsgDropped; n != 1 {
+		t.Fatalf("%d msg are dropped, expecting 1", n)
+	}
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v under skip policy", rn.StopReason())
+	}
+}
+
+func TestOversizedFrameStop(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{MaxMessageSize: 1024})
+
+	// validator is not called for oversized frame
+	err := ln.SetFrameValidator(func(remoteNode *RemoteNode, msgLen uint32) FrameAction {
+		if msgLen > 1024 {
+			t.Errorf("frame validator is called with oversized frame of %d bytes", msgLen)
+		}
+		return FrameAccept
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, stream := newTestRawStream(t, ln)
+	writeTestFrame(t, stream, 2048, make([]byte, 2048))
+
+	waitFor(t, time.Second, rn.IsStopped)
+	if reason := rn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "exceeds max msg size") {
+		t.Fatalf("remote node stops because of %v, expecting msg size exceeding max msg size", reason)
+	}
+}
+
+func TestFrameCompleteHook(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{UnmarshalErrorPolicy: "drop"})
+
+	sizes := make(chan int, 8)
+	rn, stream := newTestRawStreamWithHook(t, ln, func(size int) {
+		sizes <- size
+	})
+
+	msgs := make([]*protobuf.Message, 2)
+	bufs := make([][]byte, 2)
+	for i := range msgs {
+		msgs[i] = newTestMessage(t, ln, []byte{byte(i)})
+		buf, err := marshalMsg(msgs[i], msgCodecProtobuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bufs[i] = buf
+	}
+
+	// a frame that cannot be unmarshaled between two valid ones
+	invalid := []byte{0xFF, 0xFF, 0xFF}
+	writeTestFrame(t, stream, uint32(len(bufs[0])), bufs[0])
+	writeTestFrame(t, stream, uint32(len(invalid)), invalid)
+	writeTestFrame(t, stream, uint32(len(bufs[1])), bufs[1])
+
+	for _, msg := range msgs {
+		remoteMsg := recvTestMessage(t, ln, time.Second)
+		if !bytes.Equal(remoteMsg.Msg.MessageId, msg.MessageId) {
+			t.Fatalf("received msg %x, expecting %x", remoteMsg.Msg.MessageId, msg.MessageId)
+		}
+	}
+
+	// frames of msg sent by remote node itself (e.g. handshake) are counted too
+	var completed []int
+	for len(sizes) > 0 {
+		completed = append(completed, <-sizes)
+	}
+	expected := []int{len(bufs[0]), len(invalid), len(bufs[1])}
+	if len(completed) < len(expected) {
+		t.Fatalf("%d frames are completed, expecting at least %d", len(completed), len(expected))
+	}
+	completed = completed[len(completed)-len(expected):]
+	for i := range expected {
+		if completed[i] != expected[i] {
+			t.Fatalf("completed frame sizes are %v, expecting %v at the end", completed, expected)
+		}
+	}
+
+	if n := rn.Stats().MsgDropped; n != 1 {
+		t.Fatalf("%d msg are dropped, expecting 1 that cannot be unmarshaled", n)
+	}
+}
+
+func TestRxFrameAssemblyTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{FrameAssemblyTimeout: 200 * time.Millisecond})
+	rn, stream := newTestRawStream(t, ln)
+
+	// waiting for the next msg len is not bounded by frame assembly timeout
+	time.Sleep(400 * time.Millisecond)
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v while idle", rn.StopReason())
+	}
+
+	// only part of msg body is sent
+	writeTestFrame(t, stream, 100, make([]byte, 10))
+
+	waitFor(t, time.Second, rn.IsStopped)
+	if reason := rn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "is not complete within") {
+		t.Fatalf("remote node stops because of %v, expecting frame assembly timeout", reason)
+	}
+}
+
+func TestLengthPrefixFramer(t *testing.T) {
+	framer := LengthPrefixFramer{MaxFrameSize: 1 << 20}
+
+	for _, size := range []int{0, 1, 1000, maxCoalescedFrameSize - msgLenBytes, maxCoalescedFrameSize, 1 << 20} {
+		body := make([]byte, size)
+		for i := range body {
+			body[i] = byte(i)
+		}
+
+		var buf bytes.Buffer
+		err := framer.WriteFrame(&buf, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != framer.FrameSize(size) {
+			t.Fatalf("frame of body size %d has %d bytes, expecting %d", size, buf.Len(), framer.FrameSize(size))
+		}
+
+		frame, err := framer.ReadFrame(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame, body) {
+			t.Fatalf("frame of body size %d is changed after read", size)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := framer.WriteFrame(&buf, make([]byte, 1<<20+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = framer.ReadFrame(&buf)
+	if err == nil {
+		t.Fatal("read frame larger than max frame size should fail")
+	}
+}
+
+func BenchmarkLengthPrefixFramerWriteFrame(b *testing.B) {
+	framer := LengthPrefixFramer{}
+
+	for _, size := range []int{64, 1024, 16384, 1 << 20} {
+		body := make([]byte, size)
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(framer.FrameSize(size)))
+			for i := 0; i < b.N; i++ {
+				err := framer.WriteFrame(ioutil.Discard, body)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRxLargeMessage measures throughput of large msg over TCP, where rx
+// reads each frame body directly into a buffer of msg len
+func BenchmarkRxLargeMessage(b *testing.B) {
+	tcp := func() *config.Config {
+		return &config.Config{Transport: "tcp", Hostname: "127.0.0.1"}
+	}
+
+	for _, size := range []int{64 * 1024, 1024 * 1024, 4 * 1024 * 1024} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			benchmarkLoopbackThroughput(b, tcp, size)
+		})
+	}
+}
+
+// BenchmarkRxBufferSize measures throughput over TCP with different rx buffer
+// sizes, for small msg read from the buffer and large msg read directly into
+// msg buffer
+func BenchmarkRxBufferSize(b *testing.B) {
+	for _, bufSize := range []uint32{4 * 1024, 16 * 1024, 256 * 1024} {
+		tcp := func() *config.Config {
+			return &config.Config{Transport: "tcp", Hostname: "127.0.0.1", RemoteRxBufferSize: bufSize}
+		}
+		for _, size := range []int{1024, 1024 * 1024} {
+			b.Run(fmt.Sprintf("%d/%d", bufSize, size), func(b *testing.B) {
+				benchmarkLoopbackThroughput(b, tcp, size)
+			})
+		}
+	}
+}
+
+func TestUnmarshalErrorThreshold(t *testing.T) {
+	invalidFrame := []byte{0xFF, 0xFF, 0xFF}
+
+	// stop policy closes conn on the first invalid msg
+	ln := newTestLocalNode(t, nil)
+	rn, stream := newTestRawStream(t, ln)
+	writeTestFrame(t, stream, uint32(len(invalidFrame)), invalidFrame)
+	waitFor(t, time.Second, rn.IsStopped)
+
+	// drop policy closes conn when threshold is reached within window
+	ln = newTestLocalNode(t, &config.Config{
+		UnmarshalErrorPolicy:    "drop",
+		UnmarshalErrorThreshold: 3,
+		UnmarshalErrorWindow:    time.Minute,
+	})
+	rn, stream = newTestRawStream(t, ln)
+
+	msg := newTestMessage(t, ln, []byte("valid"))
+	buf, err := marshalMsg(msg, msgCodecProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFrame(t, stream, uint32(len(invalidFrame)), invalidFrame)
+	writeTestFrame(t, stream, uint32(len(invalidFrame)), invalidFrame)
+	writeTestFrame(t, stream, uint32(len(buf)), buf)
+
+	remoteMsg := recvTestMessage(t, ln, time.Second)
+	if !bytes.Equal(remoteMsg.Msg.MessageId, msg.MessageId) {
+		t.Fatalf("received msg %x, expecting %x", remoteMsg.Msg.MessageId, msg.MessageId)
+	}
+	if n := rn.Stats().MsgDropped; n != 2 {
+		t.Fatalf("%d msg are dropped, expecting 2", n)
+	}
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v below unmarshal error threshold", rn.StopReason())
+	}
+
+	writeTestFrame(t, stream, uint32(len(invalidFrame)), invalidFrame)
+	waitFor(t, time.Second, rn.IsStopped)
+	if reason := rn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "cannot be unmarshaled") {
+		t.Fatalf("remote node stops because of %v, expecting unmarshal error threshold", reason)
+	}
+
+	// errors in different windows do not add up
+	ln = newTestLocalNode(t, &config.Config{
+		UnmarshalErrorPolicy:    "drop",
+		UnmarshalErrorThreshold: 2,
+		UnmarshalErrorWindow:    100 * time.Millisecond,
+	})
+	rn, stream = newTestRawStream(t, ln)
+
+	writeTestFrame(t, stream, uint32(len(invalidFrame)), invalidFrame)
+	waitFor(t, time.Second, func() bool {
+		return rn.Stats().MsgDropped == 1
+	})
+	time.Sleep(2 * ln.UnmarshalErrorWindow)
+	writeTestFrame(t, stream, uint32(len(invalidFrame)), invalidFrame)
+	waitFor(t, time.Second, func() bool {
+		return rn.Stats().MsgDropped == 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v with errors in different windows", rn.StopReason())
+	}
+}
+
+func TestSetMaxMessageSize(t *testing.T) {
+	const maxMsgSize = 1024
+
+	ln := newTestUnstartedLocalNode(t, nil)
+	if err := ln.SetMaxMessageSize(0); err == nil {
+		t.Fatal("max msg size 0 is set")
+	}
+	if err := ln.SetMaxMessageSize(maxMsgSize); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ln.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := ln.Shutdown(ctx)
+		if err != nil {
+			t.Errorf("shutdown local node error: %v", err)
+		}
+	})
+
+	// oversized msg is not sent
+	rn := newTestIdleRemoteNode(t, ln)
+	err = rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 2*maxMsgSize)))
+	if err == nil || !strings.Contains(err.Error(), "exceeds max msg size") {
+		t.Fatalf("send oversized msg error is %v, expecting msg size exceeding max msg size", err)
+	}
+
+	// oversized frame received stops remote node with its size in stop reason
+	rn, stream := newTestRawStream(t, ln)
+	writeTestFrame(t, stream, 2*maxMsgSize, nil)
+
+	waitFor(t, time.Second, rn.IsStopped)
+	expected := fmt.Sprintf("Msg size %d exceeds max msg size %d", 2*maxMsgSize, maxMsgSize)
+	if reason := rn.StopReason(); reason == nil || reason.Error() != expected {
+		t.Fatalf("remote node stops because of %v, expecting %q", reason, expected)
+	}
+}
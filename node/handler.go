@@ -0,0 +1,56 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// HandleFunc registers handler to be called for each msg received with
+// routingType, as an alternative to consuming the chan returned by
+// GetRxMsgChan. It starts numWorkers goroutines that read from the rx msg chan
+// of routingType and call handler, so handler may be called concurrently by up
+// to numWorkers goroutines and msg may be handled out of order if numWorkers is
+// greater than 1. A slow handler blocks its worker, and msg are dropped by
+// remote node when the rx msg chan is full. Workers exit when local node
+// stops.
+//
+// Since workers consume the same chan as GetRxMsgChan, a routing type should
+// either have a handler or be consumed directly (e.g. by an overlay), not both.
+// Calling HandleFunc multiple times for the same routing type adds more
+// workers that share the chan.
+func (ln *LocalNode) HandleFunc(routingType protobuf.RoutingType, handler func(*RemoteMessage), numWorkers int) error {
+	if handler == nil {
+		return errors.New("Handler is nil")
+	}
+	if numWorkers <= 0 {
+		return fmt.Errorf("Invalid number of workers %d", numWorkers)
+	}
+
+	msgChan, err := ln.GetRxMsgChan(routingType)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < numWorkers; i++ {
+		ln.wg.Add(1)
+		go ln.runHandler(msgChan, handler)
+	}
+
+	return nil
+}
+
+// runHandler calls handler for each msg in msgChan until local node stops
+func (ln *LocalNode) runHandler(msgChan chan *RemoteMessage, handler func(*RemoteMessage)) {
+	defer ln.wg.Done()
+
+	for {
+		select {
+		case remoteMsg := <-msgChan:
+			handler(remoteMsg)
+		case <-ln.Done():
+			return
+		}
+	}
+}
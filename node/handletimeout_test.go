@@ -0,0 +1,99 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func newTestBytesMessage(tb testing.TB, ln *LocalNode, data string) *RemoteMessage {
+	tb.Helper()
+
+	body, err := proto.Marshal(&protobuf.Bytes{Data: []byte(data)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &RemoteMessage{Msg: newTestMessage(tb, ln, body)}
+}
+
+func TestSlowMsgHandlerTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		LocalMsgHandleTimeout: 50 * time.Millisecond,
+		LocalMaxMsgHandlers:   2,
+	})
+
+	release := make(chan struct{})
+	handled := make(chan string, 8)
+	err := ln.ApplyMiddleware(BytesReceived{func(msg, msgID, srcID []byte, remoteNode *RemoteNode) ([]byte, bool) {
+		if string(msg) == "slow" {
+			<-release
+		}
+		handled <- string(msg)
+		return msg, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectHandled := func(data string) {
+		t.Helper()
+		select {
+		case got := <-handled:
+			if got != data {
+				t.Fatalf("handled msg %q, expecting %q", got, data)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("msg %q is not handled", data)
+		}
+	}
+
+	// a slow handler times out and does not block the next msg
+	for _, data := range []string{"slow", "fast"} {
+		err = ln.HandleRemoteMessage(newTestBytesMessage(t, ln, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectHandled("fast")
+
+	if n := ln.GetNumMsgHandleTimeouts(); n != 1 {
+		t.Fatalf("got %d msg handle timeouts, expecting 1", n)
+	}
+
+	// with LocalMaxMsgHandlers slow handlers running, the next msg waits
+	for _, data := range []string{"slow", "blocked"} {
+		err = ln.HandleRemoteMessage(newTestBytesMessage(t, ln, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case got := <-handled:
+		t.Fatalf("msg %q is handled while %d slow handlers are running", got, ln.LocalMaxMsgHandlers)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if n := ln.GetNumMsgHandleTimeouts(); n != 2 {
+		t.Fatalf("got %d msg handle timeouts, expecting 2", n)
+	}
+
+	close(release)
+
+	got := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		select {
+		case data := <-handled:
+			got[data]++
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %v are handled after slow handlers finish", got)
+		}
+	}
+	if got["slow"] != 2 || got["blocked"] != 1 {
+		t.Fatalf("handled %v after slow handlers finish", got)
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestIdleEviction(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		IdleTimeout:                  300 * time.Millisecond,
+		MeasureRoundTripTimeInterval: 50 * time.Millisecond,
+	})
+
+	idleRn, _ := connectTestNodes(t, ln, newTestLocalNode(t, nil))
+	exemptRn, _ := connectTestNodes(t, ln, newTestLocalNode(t, nil))
+	activeRn, _ := connectTestNodes(t, ln, newTestLocalNode(t, nil))
+
+	exemptRn.SetIdleExempt(true)
+
+	stopChan := make(chan struct{})
+	defer close(stopChan)
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				activeRn.SendMessageAsync(newTestMessage(t, ln, []byte("hello")))
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	// pings keep being exchanged but do not count as activity
+	waitFor(t, 3*time.Second, idleRn.IsStopped)
+
+	if reason := idleRn.StopReason(); reason != nil {
+		t.Fatalf("idle remote node stops because of %v, expecting no error", reason)
+	}
+	if exemptRn.IsStopped() {
+		t.Fatalf("exempt remote node stops because of %v", exemptRn.StopReason())
+	}
+	if activeRn.IsStopped() {
+		t.Fatalf("active remote node stops because of %v", activeRn.StopReason())
+	}
+}
+
+func TestIdleEvictionTinyTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{IdleTimeout: time.Nanosecond})
+
+	time.Sleep(5 * minWatchdogInterval)
+	if ln.IsStopped() {
+		t.Fatal("local node stops with tiny idle timeout")
+	}
+}
@@ -0,0 +1,97 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// JournalEntry is the header of a msg sent to or received from remote node
+type JournalEntry struct {
+	Time        time.Time
+	Direction   Direction
+	MessageType protobuf.MessageType
+	RoutingType protobuf.RoutingType
+	MessageID   []byte
+	ReplyToID   []byte
+	SrcID       []byte
+	DestID      []byte
+	Size        int // size of message body in bytes
+}
+
+func (entry JournalEntry) String() string {
+	direction := "rx"
+	if entry.Direction == Egress {
+		direction = "tx"
+	}
+	return fmt.Sprintf("%s %s %v %v id=%x reply_to=%x src=%x dest=%x size=%d", entry.Time.Format(time.RFC3339Nano), direction, entry.MessageType, entry.RoutingType, entry.MessageID, entry.ReplyToID, entry.SrcID, entry.DestID, entry.Size)
+}
+
+// journal is a fixed size ring buffer of the most recent journal entries
+type journal struct {
+	sync.Mutex
+	entries []JournalEntry
+	next    int
+	full    bool
+}
+
+// newJournal creates a journal that keeps at most size entries
+func newJournal(size uint32) *journal {
+	return &journal{
+		entries: make([]JournalEntry, size),
+	}
+}
+
+// record adds the header of msg to journal, overwriting the oldest entry if
+// journal is full
+func (j *journal) record(msg *protobuf.Message, direction Direction) {
+	entry := JournalEntry{
+		Time:        time.Now(),
+		Direction:   direction,
+		MessageType: msg.MessageType,
+		RoutingType: msg.RoutingType,
+		MessageID:   msg.MessageId,
+		ReplyToID:   msg.ReplyToId,
+		SrcID:       msg.SrcId,
+		DestID:      msg.DestId,
+		Size:        len(msg.Message),
+	}
+
+	j.Lock()
+	j.entries[j.next] = entry
+	j.next++
+	if j.next == len(j.entries) {
+		j.next = 0
+		j.full = true
+	}
+	j.Unlock()
+}
+
+// dump returns a copy of entries in journal from the oldest to the newest
+func (j *journal) dump() []JournalEntry {
+	j.Lock()
+	defer j.Unlock()
+
+	if !j.full {
+		entries := make([]JournalEntry, j.next)
+		copy(entries, j.entries[:j.next])
+		return entries
+	}
+
+	entries := make([]JournalEntry, 0, len(j.entries))
+	entries = append(entries, j.entries[j.next:]...)
+	entries = append(entries, j.entries[:j.next]...)
+	return entries
+}
+
+// DumpJournal returns the headers of the most recent msg sent to and received
+// from remote node from the oldest to the newest. Will return nil if journal
+// is disabled (RemoteMsgJournalSize is 0).
+func (rn *RemoteNode) DumpJournal() []JournalEntry {
+	if rn.journal == nil {
+		return nil
+	}
+	return rn.journal.dump()
+}
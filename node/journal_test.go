@@ -0,0 +1,71 @@
+package node
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestJournal(t *testing.T) {
+	conf := func() *config.Config {
+		return &config.Config{
+			RemoteMsgJournalSize: 4,
+			DisableKeepAlivePing: true,
+		}
+	}
+	ln := newTestLocalNode(t, conf())
+	peer := newTestLocalNode(t, conf())
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	msgs := make([]*protobuf.Message, 6)
+	for i := range msgs {
+		msgs[i] = newTestMessage(t, ln, []byte{byte(i)})
+		err := rn.SendMessageAsync(msgs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range msgs {
+		recvTestMessage(t, peer, time.Second)
+	}
+
+	// only the most recent msg are kept, from the oldest to the newest
+	checkJournal := func(journal []JournalEntry, direction Direction) bool {
+		if len(journal) != 4 {
+			return false
+		}
+		for i, entry := range journal {
+			msg := msgs[len(msgs)-len(journal)+i]
+			if entry.Direction != direction || entry.MessageType != protobuf.BYTES || !bytes.Equal(entry.MessageID, msg.MessageId) || entry.Size != len(msg.Message) {
+				return false
+			}
+		}
+		return true
+	}
+
+	waitFor(t, time.Second, func() bool {
+		return checkJournal(rn.DumpJournal(), Egress)
+	})
+	if journal := peerRn.DumpJournal(); !checkJournal(journal, Ingress) {
+		t.Fatalf("journal of receiver is %v, expecting the last 4 msg received", journal)
+	}
+}
+
+func TestJournalDisabled(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	err := rn.SendMessageAsync(newTestMessage(t, ln, []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, peer, time.Second)
+
+	if journal := rn.DumpJournal(); journal != nil {
+		t.Fatalf("journal is %v when disabled, expecting nil", journal)
+	}
+}
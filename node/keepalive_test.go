@@ -0,0 +1,157 @@
+package node
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestKeepAliveTimeout(t *testing.T) {
+	// ln does not ping peer, and peer does not ping ln within the test, so ln
+	// receives nothing from peer
+	ln := newTestLocalNode(t, &config.Config{
+		KeepAliveTimeout:     300 * time.Millisecond,
+		DisableKeepAlivePing: true,
+	})
+	peer := newTestLocalNode(t, nil)
+
+	var timedOut int32
+	err := ln.ApplyMiddleware(RemoteNodeKeepAliveTimeout{func(rn *RemoteNode) bool {
+		atomic.AddInt32(&timedOut, 1)
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	waitFor(t, 2*time.Second, rn.IsStopped)
+
+	if reason := rn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "keepalive timeout") {
+		t.Fatalf("remote node stops because of %v, expecting keepalive timeout", reason)
+	}
+	if n := ln.GetNumKeepAliveTimeouts(); n != 1 {
+		t.Fatalf("number of keepalive timeouts is %d, expecting 1", n)
+	}
+	if n := atomic.LoadInt32(&timedOut); n != 1 {
+		t.Fatalf("keepalive timeout middleware is called %d times, expecting 1", n)
+	}
+}
+
+func TestKeepAliveTimeoutVeto(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		KeepAliveTimeout:     100 * time.Millisecond,
+		DisableKeepAlivePing: true,
+	})
+	peer := newTestLocalNode(t, nil)
+
+	err := ln.ApplyMiddleware(KeepAliveTimeoutVeto{func(rn *RemoteNode) (bool, bool) {
+		return true, false
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	time.Sleep(500 * time.Millisecond)
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v after keepalive timeout is vetoed", rn.StopReason())
+	}
+	if n := ln.GetNumKeepAliveTimeouts(); n != 0 {
+		t.Fatalf("number of keepalive timeouts is %d, expecting 0", n)
+	}
+}
+
+func TestKeepAliveMaxUnanswered(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		MeasureRoundTripTimeInterval: 100 * time.Millisecond,
+		KeepAlivePingTimeout:         100 * time.Millisecond,
+		KeepAliveMaxUnanswered:       3,
+		KeepAliveTimeout:             time.Minute,
+	})
+	peer := newTestLocalNode(t, nil)
+	silentPeer := newTestLocalNode(t, nil)
+
+	var timedOut int32
+	err := ln.ApplyMiddleware(RemoteNodeKeepAliveTimeout{func(rn *RemoteNode) bool {
+		atomic.AddInt32(&timedOut, 1)
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// silent peer still sends msg to ln, but never replies ping, like a
+	// half-open connection
+	err = silentPeer.ApplyMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+		if msg.MessageType == protobuf.PING {
+			return nil, false
+		}
+		return msg, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, _ := connectTestNodes(t, ln, peer)
+	silentRn, _ := connectTestNodes(t, ln, silentPeer)
+
+	waitFor(t, 3*time.Second, silentRn.IsStopped)
+
+	if reason := silentRn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "3 consecutive pings unanswered") {
+		t.Fatalf("remote node stops because of %v, expecting unanswered pings", reason)
+	}
+	if n := atomic.LoadInt32(&timedOut); n != 1 {
+		t.Fatalf("keepalive timeout middleware is called %d times, expecting 1", n)
+	}
+	if rn.IsStopped() {
+		t.Fatalf("remote node replying ping stops because of %v", rn.StopReason())
+	}
+}
+
+func TestDisableKeepAliveTimeout(t *testing.T) {
+	const keepAliveTimeout = 100 * time.Millisecond
+
+	ln := newTestLocalNode(t, &config.Config{
+		KeepAliveTimeout:        keepAliveTimeout,
+		DisableKeepAliveTimeout: true,
+		DisableKeepAlivePing:    true,
+	})
+	peer := newTestLocalNode(t, nil)
+	otherPeer := newTestLocalNode(t, nil)
+
+	rn, _ := connectTestNodes(t, ln, peer)
+	if rn.IsKeepAliveTimeoutEnabled() {
+		t.Fatal("keepalive timeout is enabled with DisableKeepAliveTimeout")
+	}
+
+	// per remote node override re-enables it for another remote node
+	otherRn, _ := connectTestNodes(t, ln, otherPeer)
+	otherRn.SetKeepAliveTimeoutEnabled(true)
+
+	waitFor(t, 2*time.Second, otherRn.IsStopped)
+	if reason := otherRn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "keepalive timeout") {
+		t.Fatalf("remote node stops because of %v, expecting keepalive timeout", reason)
+	}
+
+	time.Sleep(5 * keepAliveTimeout)
+	if rn.IsStopped() {
+		t.Fatalf("remote node stops because of %v with keepalive timeout disabled", rn.StopReason())
+	}
+	if n := ln.GetNumKeepAliveTimeouts(); n != 1 {
+		t.Fatalf("number of keepalive timeouts is %d, expecting 1", n)
+	}
+
+	// remote node still works after keepalive timeout has long passed
+	err := rn.SendMessageAsync(newTestMessage(t, ln, []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, peer, time.Second)
+}
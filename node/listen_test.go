@@ -0,0 +1,102 @@
+package node
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/transport"
+)
+
+func TestListenAddr(t *testing.T) {
+	ln := newTestUnstartedLocalNode(t, &config.Config{Transport: "tcp", Hostname: "127.0.0.1"})
+	if addr := ln.ListenAddr(); addr != nil {
+		t.Fatalf("listen addr before start is %v, expecting nil", addr)
+	}
+
+	err := ln.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ln.Shutdown(ctx)
+	}()
+	testMsgChans.Store(ln, serveTestNode(t, ln))
+
+	addr := ln.ListenAddr()
+	if addr == nil {
+		t.Fatal("listen addr after start is nil")
+	}
+	_, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port == 0 {
+		t.Fatal("listen port is 0 after start")
+	}
+	if !strings.HasSuffix(ln.Addr, ":"+portStr) {
+		t.Fatalf("advertised addr %s does not use listen port %d", ln.Addr, port)
+	}
+
+	// listen port is already in use, so start fails
+	conflict := newTestUnstartedLocalNode(t, &config.Config{Transport: "tcp", Hostname: "127.0.0.1", Port: uint16(port)})
+	err = conflict.Start()
+	if err == nil {
+		t.Fatalf("start should fail when port %d is in use", port)
+	}
+	if !conflict.IsStopped() {
+		t.Fatal("local node is not stopped after it fails to start")
+	}
+
+	peer := newTestLocalNode(t, &config.Config{Transport: "tcp", Hostname: "127.0.0.1"})
+	connectTestNodes(t, peer, ln)
+}
+
+func TestInboundSetupTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{InboundSetupTimeout: 300 * time.Millisecond})
+
+	// peer completes transport connection but never sends anything
+	addr, err := transport.Parse(ln.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := addr.Dial(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	startTime := time.Now()
+	err = conn.SetReadDeadline(startTime.Add(5 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	for err == nil {
+		_, err = conn.Read(buf)
+	}
+	elapsed := time.Since(startTime)
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("conn is not closed after inbound setup timeout")
+	}
+	if elapsed < ln.InboundSetupTimeout/2 {
+		t.Fatalf("conn is closed after %v, before inbound setup timeout %v", elapsed, ln.InboundSetupTimeout)
+	}
+
+	waitFor(t, time.Second, func() bool {
+		neighbors, err := ln.GetNeighbors(nil)
+		return err == nil && len(neighbors) == 0
+	})
+}
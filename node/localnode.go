@@ -1,27 +1,53 @@
 package node
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nknorg/nnet/cache"
 	"github.com/nknorg/nnet/config"
 	"github.com/nknorg/nnet/log"
+	"github.com/nknorg/nnet/message"
 	"github.com/nknorg/nnet/protobuf"
 	"github.com/nknorg/nnet/transport"
+	"github.com/nknorg/nnet/util"
 )
 
 const (
 	// How many concurrent goroutines are handling messages
 	numWorkers = 1
+
+	// Number of bytes of node id attached to logs of local node
+	shortNodeIDBytes = 4
+
+	// Max number of remote nodes being stopped gracefully at the same time
+	// during graceful shutdown
+	maxConcurrentGracefulStops = 16
+
+	// Min interval of checking remote nodes for stall or idle, so that a tiny
+	// timeout does not make the check loop spin
+	minWatchdogInterval = 10 * time.Millisecond
 )
 
 // LocalNode is a local node
 type LocalNode struct {
+	numKeepAliveTimeouts uint64 // accessed atomically, keep 64-bit aligned
+	numMsgHandleTimeouts uint64 // accessed atomically, keep 64-bit aligned
+	numDisconnects       uint64 // accessed atomically, keep 64-bit aligned
+	txQueuedBytes        int64  // accessed atomically, keep 64-bit aligned
+	rxMsgCacheHits       uint64 // accessed atomically, keep 64-bit aligned
+	rxMsgCacheMisses     uint64 // accessed atomically, keep 64-bit aligned
+	shuttingDown         int32  // accessed atomically
+
 	*Node
 	*config.Config
 	*middlewareStore
@@ -29,11 +55,29 @@ type LocalNode struct {
 	port           uint16
 	listener       net.Listener
 	handleMsgChan  chan *RemoteMessage
+	msgHandlers    chan struct{} // limits msg handlers running at the same time
 	rxMsgChan      map[protobuf.RoutingType]chan *RemoteMessage
 	rxMsgCache     cache.Cache
 	replyChanCache cache.Cache
+	repliedCache   cache.Cache
 	replyTimeout   time.Duration
 	neighbors      sync.Map
+	msgIDGenerator message.IDGenerator
+	backpressure   BackpressureStrategy
+	replyValidator ReplyValidator
+	frameValidator FrameValidator
+	framer         Framer
+	tlsConfig      *tls.Config
+	logger         log.Logger
+	metrics        MetricsCollector
+	sendDedup      *util.SingleFlight
+	dialGroup      *util.SingleFlight
+	pendingReplies sync.Map
+	reconnects     map[string]chan struct{}
+	reconnectLock  sync.Mutex
+	wg             sync.WaitGroup // goroutines of local node and remote nodes
+	wgDone         chan struct{}  // closed when wg is done after local node stops
+	wgDoneOnce     sync.Once
 }
 
 // NewLocalNode creates a local node
@@ -47,19 +91,58 @@ func NewLocalNode(id []byte, conf *config.Config) (*LocalNode, error) {
 		return nil, err
 	}
 
+	err = checkCompression(conf.Compression, conf.CompressionDictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, codec := range conf.CompressionCodecs {
+		if getCompressionCodec(codec) == nil && codec != compressionNone {
+			return nil, fmt.Errorf("Unknown compression codec %s", codec)
+		}
+	}
+
+	for _, codec := range conf.MessageCodecs {
+		if getMsgCodec(codec) == nil {
+			return nil, fmt.Errorf("Unknown msg codec %s", codec)
+		}
+	}
+
+	if !conf.DisableKeepAlivePing && !conf.DisableKeepAliveTimeout && conf.KeepAliveTimeout <= conf.MeasureRoundTripTimeInterval {
+		return nil, fmt.Errorf("KeepAliveTimeout %v should be longer than keepalive ping interval MeasureRoundTripTimeInterval %v", conf.KeepAliveTimeout, conf.MeasureRoundTripTimeInterval)
+	}
+
 	node, err := NewNode(id, address.String())
 	if err != nil {
 		return nil, err
 	}
 
+	for _, addr := range conf.ExtraAddrs {
+		_, err = transport.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Parse extra addr %s error: %s", addr, err)
+		}
+	}
+	node.Addrs = conf.ExtraAddrs
+
 	handleMsgChan := make(chan *RemoteMessage, conf.LocalHandleMsgChanLen)
 
 	rxMsgChan := make(map[protobuf.RoutingType]chan *RemoteMessage)
 
-	rxMsgCache := cache.NewGoCache(conf.LocalRxMsgCacheExpiration, conf.LocalRxMsgCacheCleanupInterval)
+	var rxMsgCache cache.Cache
+	if conf.LocalRxMsgCacheSize > 0 {
+		rxMsgCache, err = cache.NewLRUCache(int(conf.LocalRxMsgCacheSize), conf.LocalRxMsgCacheExpiration)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		rxMsgCache = cache.NewGoCache(conf.LocalRxMsgCacheExpiration, conf.LocalRxMsgCacheCleanupInterval)
+	}
 
 	replyChanCache := cache.NewGoCache(conf.DefaultReplyTimeout, conf.ReplyChanCleanupInterval)
 
+	repliedCache := cache.NewGoCache(conf.DefaultReplyTimeout, conf.ReplyChanCleanupInterval)
+
 	middlewareStore := newMiddlewareStore()
 
 	localNode := &LocalNode{
@@ -69,10 +152,21 @@ func NewLocalNode(id []byte, conf *config.Config) (*LocalNode, error) {
 		address:         address,
 		port:            conf.Port,
 		handleMsgChan:   handleMsgChan,
+		msgHandlers:     make(chan struct{}, conf.LocalMaxMsgHandlers),
 		rxMsgChan:       rxMsgChan,
 		rxMsgCache:      rxMsgCache,
 		replyChanCache:  replyChanCache,
+		repliedCache:    repliedCache,
 		replyTimeout:    conf.DefaultReplyTimeout,
+		reconnects:      make(map[string]chan struct{}),
+		msgIDGenerator:  message.RandomIDGenerator(conf.MessageIDBytes),
+		backpressure:    DefaultBackpressureStrategy{},
+		frameValidator:  DefaultFrameValidator,
+		framer:          LengthPrefixFramer{MaxFrameSize: conf.MaxMessageSize},
+		sendDedup:       util.NewSingleFlight(),
+		dialGroup:       util.NewSingleFlight(),
+		logger:          log.With(log.GlobalLogger(), "node", shortNodeID(id)),
+		metrics:         NoopMetricsCollector{},
 	}
 
 	for routingType := range protobuf.RoutingType_name {
@@ -84,42 +178,64 @@ func NewLocalNode(id []byte, conf *config.Config) (*LocalNode, error) {
 
 // Start starts the runtime loop of the local node
 func (ln *LocalNode) Start() error {
+	var err error
 	ln.StartOnce.Do(func() {
-		for _, mw := range ln.middlewareStore.localNodeWillStart {
+		for _, mw := range ln.middlewareStore.localNodeWillStart.Load().([]LocalNodeWillStart) {
 			if !mw.Func(ln) {
 				break
 			}
 		}
 
+		err = ln.bind()
+		if err != nil {
+			ln.Stop(err)
+			return
+		}
+
 		for i := 0; i < numWorkers; i++ {
+			ln.wg.Add(1)
 			go ln.handleMsg()
 		}
 
+		ln.wg.Add(1)
 		go ln.listen()
 
-		for _, mw := range ln.middlewareStore.localNodeStarted {
+		ln.wg.Add(1)
+		go ln.cleanupPendingReplies()
+
+		if ln.StallTimeout > 0 {
+			ln.wg.Add(1)
+			go ln.startStallWatchdog()
+		}
+
+		if ln.IdleTimeout > 0 {
+			ln.wg.Add(1)
+			go ln.startIdleEviction()
+		}
+
+		for _, mw := range ln.middlewareStore.localNodeStarted.Load().([]LocalNodeStarted) {
 			if !mw.Func(ln) {
 				break
 			}
 		}
 	})
 
-	return nil
+	return err
 }
 
 // Stop stops the local node
 func (ln *LocalNode) Stop(err error) {
 	ln.StopOnce.Do(func() {
-		for _, mw := range ln.middlewareStore.localNodeWillStop {
+		for _, mw := range ln.middlewareStore.localNodeWillStop.Load().([]LocalNodeWillStop) {
 			if !mw.Func(ln) {
 				break
 			}
 		}
 
 		if err != nil {
-			log.Warningf("Local node %v stops because of error: %s", ln, err)
+			ln.logger.Warningf("Local node %v stops because of error: %s", ln, err)
 		} else {
-			log.Infof("Local node %v stops", ln)
+			ln.logger.Infof("Local node %v stops", ln)
 		}
 
 		ln.neighbors.Range(func(key, value interface{}) bool {
@@ -134,11 +250,15 @@ func (ln *LocalNode) Stop(err error) {
 
 		ln.LifeCycle.Stop()
 
+		if ln.InProcessLoopback {
+			ln.unregisterInProcess()
+		}
+
 		if ln.listener != nil {
 			ln.listener.Close()
 		}
 
-		for _, mw := range ln.middlewareStore.localNodeStopped {
+		for _, mw := range ln.middlewareStore.localNodeStopped.Load().([]LocalNodeStopped) {
 			if !mw.Func(ln) {
 				break
 			}
@@ -146,8 +266,115 @@ func (ln *LocalNode) Stop(err error) {
 	})
 }
 
+// Shutdown stops local node and all remote nodes, then waits until all
+// goroutines of local node and remote nodes have exited, including the accept
+// loop of listener. Returns ctx.Err() if ctx is done before that. Remote nodes
+// are stopped before listener is closed, and no new remote node can be started
+// once shutdown begins.
+func (ln *LocalNode) Shutdown(ctx context.Context) error {
+	return ln.shutdown(ctx, false)
+}
+
+// ShutdownGracefully is the same as Shutdown, but stops remote nodes with
+// StopGracefully first, so that msg already queued to be sent to them are
+// delivered. At most maxConcurrentGracefulStops remote nodes are drained at
+// the same time. Remote nodes not drained when ctx is done are stopped
+// immediately with the remaining msg discarded.
+func (ln *LocalNode) ShutdownGracefully(ctx context.Context) error {
+	return ln.shutdown(ctx, true)
+}
+
+// shutdown stops local node and waits for all goroutines to exit until ctx is
+// done. If graceful is true, remote nodes are stopped gracefully first.
+func (ln *LocalNode) shutdown(ctx context.Context, graceful bool) error {
+	atomic.StoreInt32(&ln.shuttingDown, 1)
+
+	if graceful {
+		ln.stopRemoteNodesGracefully(ctx)
+	}
+
+	ln.Stop(nil)
+
+	select {
+	case <-ln.waitGoroutines():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isShuttingDown returns if Shutdown or ShutdownGracefully has been called
+func (ln *LocalNode) isShuttingDown() bool {
+	return atomic.LoadInt32(&ln.shuttingDown) != 0
+}
+
+// stopRemoteNodesGracefully stops all remote nodes gracefully with at most
+// maxConcurrentGracefulStops at the same time, and returns when all of them
+// have started to stop. Remote nodes still draining when ctx is done are
+// stopped with ctx.Err().
+func (ln *LocalNode) stopRemoteNodesGracefully(ctx context.Context) {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentGracefulStops)
+
+	for _, remoteNode := range ln.GetRemoteNodes() {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			remoteNode.Stop(ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func(remoteNode *RemoteNode) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			stopped := make(chan struct{})
+			go func() {
+				remoteNode.StopGracefully(timeout)
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				// StopGracefully returns once remote node stops
+				remoteNode.Stop(ctx.Err())
+				<-stopped
+			}
+		}(remoteNode)
+	}
+
+	wg.Wait()
+}
+
+// waitGoroutines returns a chan that is closed when all goroutines of local
+// node and remote nodes have exited after local node stops. The same chan is
+// returned for all calls, so that at most one goroutine is waiting for them no
+// matter how many times shutdown times out.
+func (ln *LocalNode) waitGoroutines() <-chan struct{} {
+	ln.wgDoneOnce.Do(func() {
+		ln.wgDone = make(chan struct{})
+		go func() {
+			ln.wg.Wait()
+			close(ln.wgDone)
+		}()
+	})
+	return ln.wgDone
+}
+
 // handleMsg starts a loop that handles received msg
 func (ln *LocalNode) handleMsg() {
+	defer ln.wg.Done()
+
 	var remoteMsg *RemoteMessage
 	var err error
 
@@ -156,35 +383,152 @@ func (ln *LocalNode) handleMsg() {
 			return
 		}
 
-		remoteMsg = <-ln.handleMsgChan
+		select {
+		case remoteMsg = <-ln.handleMsgChan:
+		case <-ln.Done():
+			return
+		}
 
-		err = ln.handleRemoteMessage(remoteMsg)
+		if ln.LocalMsgHandleTimeout > 0 {
+			err = ln.handleRemoteMessageWithTimeout(remoteMsg)
+		} else {
+			err = ln.handleRemoteMessage(remoteMsg)
+		}
 		if err != nil {
-			log.Error(err)
+			ln.logger.Error(err)
 			continue
 		}
 
 	}
 }
 
-// listen listens for incoming connections
-func (ln *LocalNode) listen() {
+// startStallWatchdog starts a loop that periodically checks all remote nodes
+// and stops the ones that have made no rx or tx progress within StallTimeout.
+// A single watchdog is shared by all remote nodes instead of one goroutine
+// and timer per remote node.
+func (ln *LocalNode) startStallWatchdog() {
+	defer ln.wg.Done()
+
+	ticker := time.NewTicker(watchdogInterval(ln.StallTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ln.Done():
+			return
+		}
+
+		ln.neighbors.Range(func(key, value interface{}) bool {
+			remoteNode, ok := value.(*RemoteNode)
+			if ok && !remoteNode.IsStopped() && remoteNode.isStalled(ln.StallTimeout) {
+				remoteNode.Stop(fmt.Errorf("No rx or tx progress within stall timeout %v", ln.StallTimeout))
+			}
+			return true
+		})
+	}
+}
+
+// watchdogInterval returns how often to check remote nodes against timeout,
+// which is half of timeout but no less than minWatchdogInterval
+func watchdogInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 2
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+	return interval
+}
+
+// startIdleEviction starts a loop that periodically checks all remote nodes
+// and stops the ones that have not sent or received any msg other than node
+// control msg within IdleTimeout, unless they are exempt.
+func (ln *LocalNode) startIdleEviction() {
+	defer ln.wg.Done()
+
+	ticker := time.NewTicker(watchdogInterval(ln.IdleTimeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ln.Done():
+			return
+		}
+
+		ln.neighbors.Range(func(key, value interface{}) bool {
+			remoteNode, ok := value.(*RemoteNode)
+			if ok && !remoteNode.IsStopped() && remoteNode.isIdle(ln.IdleTimeout) {
+				// idle eviction is intentional, so remote node is stopped without
+				// error and will not be auto reconnected
+				ln.logger.Infof("No msg sent to or received from remote node %v within idle timeout %v, closing", remoteNode, ln.IdleTimeout)
+				remoteNode.Stop(nil)
+			}
+			return true
+		})
+	}
+}
+
+// handleRemoteMessageWithTimeout is the same as handleRemoteMessage, but
+// returns error if handling msg does not finish within LocalMsgHandleTimeout so
+// that a slow handler will not block subsequent msg. The slow handler will
+// keep running in the background and is tracked by ln.wg. At most
+// LocalMaxMsgHandlers handlers can run at the same time, so if that many slow
+// handlers are still running, handling the next msg waits until one of them
+// finishes.
+func (ln *LocalNode) handleRemoteMessageWithTimeout(remoteMsg *RemoteMessage) error {
+	select {
+	case ln.msgHandlers <- struct{}{}:
+	case <-ln.Done():
+		return errors.New("Local node has stopped")
+	}
+
+	errChan := make(chan error, 1)
+	ln.wg.Add(1)
+	go func() {
+		defer ln.wg.Done()
+		defer func() { <-ln.msgHandlers }()
+		errChan <- ln.handleRemoteMessage(remoteMsg)
+	}()
+
+	timer := time.NewTimer(ln.LocalMsgHandleTimeout)
+	defer util.StopTimer(timer)
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-timer.C:
+	}
+
+	atomic.AddUint64(&ln.numMsgHandleTimeouts, 1)
+
+	err := fmt.Errorf("Handle msg %x timeout after %v", remoteMsg.Msg.MessageId, ln.LocalMsgHandleTimeout)
+
+	if ln.LocalMsgHandleTimeoutPolicy == "stop" && remoteMsg.RemoteNode != nil {
+		remoteMsg.RemoteNode.Stop(err)
+	}
+
+	return err
+}
+
+// bind listens on local port so that the concrete listen address is known
+// when Start returns. If port is 0, the port assigned by OS is used as the
+// internal port, and also the advertised port if it is not set.
+func (ln *LocalNode) bind() error {
 	listener, err := ln.address.Transport.Listen(ln.port)
 	if err != nil {
-		ln.Stop(fmt.Errorf("failed to listen to port %d", ln.port))
-		return
+		return fmt.Errorf("failed to listen to port %d: %v", ln.port, err)
 	}
 	ln.listener = listener
 
 	if ln.port == 0 {
 		_, portStr, err := net.SplitHostPort(listener.Addr().String())
 		if err != nil {
-			ln.Stop(err)
+			return err
 		}
 
 		port, err := strconv.Atoi(portStr)
 		if err != nil {
-			ln.Stop(err)
+			return err
 		}
 
 		ln.SetInternalPort(uint16(port))
@@ -195,10 +539,31 @@ func (ln *LocalNode) listen() {
 		ln.Addr = ln.address.String()
 	}
 
+	if ln.InProcessLoopback {
+		ln.registerInProcess()
+	}
+
+	return nil
+}
+
+// ListenAddr returns the address local node listens on, which has the
+// concrete port even if local node is configured to listen on port 0. Returns
+// nil if local node has not started.
+func (ln *LocalNode) ListenAddr() net.Addr {
+	if ln.listener == nil {
+		return nil
+	}
+	return ln.listener.Addr()
+}
+
+// listen accepts incoming connections
+func (ln *LocalNode) listen() {
+	defer ln.wg.Done()
+
 	for {
 		// listener.Accept() is placed before checking stops to prevent the error
 		// log when local node is stopped and thus conn is closed
-		conn, err := listener.Accept()
+		conn, err := ln.listener.Accept()
 
 		if ln.IsStopped() {
 			if conn != nil {
@@ -208,23 +573,25 @@ func (ln *LocalNode) listen() {
 		}
 
 		if err != nil {
-			log.Error("Error accepting connection:", err)
+			ln.logger.Error("Error accepting connection:", err)
 			time.Sleep(1 * time.Second)
 			continue
 		}
 
+		conn = ln.wrapConn(conn, false)
+
 		_, loaded := ln.neighbors.LoadOrStore(conn.RemoteAddr().String(), nil)
 		if loaded {
-			log.Errorf("Remote addr %s is already connected, reject connection", conn.RemoteAddr().String())
+			ln.logger.Errorf("Remote addr %s is already connected, reject connection", conn.RemoteAddr().String())
 			conn.Close()
 			continue
 		}
 
-		log.Infof("Remote node connect from %s to local address %s", conn.RemoteAddr().String(), conn.LocalAddr())
+		ln.logger.Infof("Remote node connect from %s to local address %s", conn.RemoteAddr().String(), conn.LocalAddr())
 
 		rn, err := ln.StartRemoteNode(conn, false)
 		if err != nil {
-			log.Error("Error creating remote node:", err)
+			ln.logger.Error("Error creating remote node:", err)
 			ln.neighbors.Delete(conn.RemoteAddr().String())
 			conn.Close()
 			continue
@@ -241,19 +608,42 @@ func (ln *LocalNode) SetInternalPort(port uint16) {
 	ln.port = port
 }
 
+// SetMaxMessageSize changes the max size in bytes of msg sent to and received
+// from remote nodes. Larger msg received is rejected right after its length
+// prefix is read, before any buffer is allocated for it. It should not be
+// called once the node starts.
+func (ln *LocalNode) SetMaxMessageSize(size uint32) error {
+	if size == 0 {
+		return errors.New("Max message size is zero")
+	}
+	ln.MaxMessageSize = size
+	if framer, ok := ln.framer.(LengthPrefixFramer); ok {
+		framer.MaxFrameSize = size
+		ln.framer = framer
+	}
+	return nil
+}
+
 // Connect try to establish connection with address remoteNodeAddr, returns the
 // remote node, if the remote node is ready, and error. The remote rode can be
 // nil if another goroutine is connecting to the same address concurrently. The
 // remote node is ready if an active connection to the remoteNodeAddr exists and
 // node info has been exchanged.
 func (ln *LocalNode) Connect(remoteNodeAddr string) (*RemoteNode, bool, error) {
+	remoteNode, ready, _, err := ln.connect(remoteNodeAddr)
+	return remoteNode, ready, err
+}
+
+// connect is the same as Connect, but also returns if the remote node is
+// created by this call rather than loaded from neighbors
+func (ln *LocalNode) connect(remoteNodeAddr string) (*RemoteNode, bool, bool, error) {
 	if remoteNodeAddr == ln.address.String() {
-		return nil, false, errors.New("trying to connect to self")
+		return nil, false, false, errors.New("trying to connect to self")
 	}
 
 	remoteAddress, err := transport.Parse(remoteNodeAddr)
 	if err != nil {
-		return nil, false, err
+		return nil, false, false, err
 	}
 
 	key := remoteAddress.ConnRemoteAddr()
@@ -262,34 +652,192 @@ func (ln *LocalNode) Connect(remoteNodeAddr string) (*RemoteNode, bool, error) {
 		remoteNode, ok := value.(*RemoteNode)
 		if ok {
 			if remoteNode.IsStopped() {
-				log.Warningf("Remove stopped remote node %v from list", remoteNode)
+				ln.logger.Warningf("Remove stopped remote node %v from list", remoteNode)
 				ln.neighbors.Delete(key)
 			} else {
-				log.Infof("Load remote node %v from list", remoteNode)
-				return remoteNode, remoteNode.IsReady(), nil
+				ln.logger.Infof("Load remote node %v from list", remoteNode)
+				return remoteNode, remoteNode.IsReady(), false, nil
 			}
 		} else {
-			log.Infof("Another goroutine is connecting to %s", key)
-			return nil, false, nil
+			ln.logger.Infof("Another goroutine is connecting to %s", key)
+			return nil, false, false, nil
+		}
+	}
+
+	if ln.InProcessLoopback {
+		target := lookupInProcess(remoteAddress)
+		if target != nil && target.InProcessLoopback {
+			remoteNode, err := ln.connectInProcess(target, key)
+			if err != nil {
+				ln.neighbors.Delete(key)
+				return nil, false, false, err
+			}
+
+			ln.neighbors.Store(key, remoteNode)
+
+			return remoteNode, false, true, nil
 		}
 	}
 
+	dialStartTime := time.Now()
 	conn, err := remoteAddress.Dial(ln.DialTimeout)
 	if err != nil {
 		ln.neighbors.Delete(key)
-		return nil, false, err
+		return nil, false, false, err
 	}
 
-	remoteNode, err := ln.StartRemoteNode(conn, true)
+	conn = ln.wrapConn(conn, true)
+
+	remoteNode, err := NewRemoteNode(ln, conn, true)
+	if err != nil {
+		ln.neighbors.Delete(key)
+		conn.Close()
+		return nil, false, false, err
+	}
+
+	remoteNode.setupTimings.Dial = time.Since(dialStartTime)
+	remoteNode.transportType = remoteAddress.Transport.String()
+	remoteNode.dialAddr = remoteNodeAddr
+
+	err = ln.startRemoteNode(remoteNode)
 	if err != nil {
 		ln.neighbors.Delete(key)
 		conn.Close()
-		return nil, false, err
+		return nil, false, false, err
 	}
 
 	ln.neighbors.Store(key, remoteNode)
 
-	return remoteNode, false, nil
+	return remoteNode, false, true, nil
+}
+
+// GetOrDial returns the remote node that is connected or connecting to
+// remoteNodeAddr, or dials remoteNodeAddr if there is none. Concurrent calls
+// with the same address share a single dial. The returned remote node may not
+// be ready yet.
+func (ln *LocalNode) GetOrDial(remoteNodeAddr string) (*RemoteNode, error) {
+	remoteAddress, err := transport.Parse(remoteNodeAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	value, _, err := ln.dialGroup.Do(remoteAddress.ConnRemoteAddr(), func() (interface{}, error) {
+		remoteNode, _, err := ln.Connect(remoteNodeAddr)
+		if err != nil {
+			return nil, err
+		}
+		if remoteNode == nil {
+			return nil, fmt.Errorf("Another goroutine is connecting to %s", remoteNodeAddr)
+		}
+		return remoteNode, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.(*RemoteNode), nil
+}
+
+// ConnectNode is the same as Connect, but will try the additional addresses of
+// n in order if connecting to n.Addr fails
+func (ln *LocalNode) ConnectNode(n *protobuf.Node) (*RemoteNode, bool, error) {
+	errs := util.NewErrors()
+	for _, addr := range append([]string{n.Addr}, n.Addrs...) {
+		remoteNode, ready, err := ln.Connect(addr)
+		if err == nil {
+			return remoteNode, ready, nil
+		}
+		ln.logger.Warningf("Connect to %s error: %v", addr, err)
+		errs = append(errs, err)
+	}
+	return nil, false, errs.Merged()
+}
+
+// ConnectAndSend connects to remoteNodeAddr, waits until the remote node is
+// ready and sends msg to it, all within the deadline of ctx. If hasReply is
+// true, it waits for and returns the reply msg. If any step fails or ctx is done
+// before it completes, the remote node created by this call is stopped, while
+// an existing remote node is kept.
+func (ln *LocalNode) ConnectAndSend(ctx context.Context, remoteNodeAddr string, msg *protobuf.Message, hasReply bool) (*RemoteMessage, error) {
+	type connectResult struct {
+		remoteNode *RemoteNode
+		created    bool
+		err        error
+	}
+
+	resultChan := make(chan connectResult, 1)
+	go func() {
+		remoteNode, _, created, err := ln.connect(remoteNodeAddr)
+		resultChan <- connectResult{remoteNode: remoteNode, created: created, err: err}
+	}()
+
+	var result connectResult
+	select {
+	case result = <-resultChan:
+	case <-ctx.Done():
+		go func() {
+			result := <-resultChan
+			if result.created {
+				result.remoteNode.Stop(ctx.Err())
+			}
+		}()
+		return nil, fmt.Errorf("Connect to %s error: %v", remoteNodeAddr, ctx.Err())
+	}
+
+	if result.err != nil {
+		return nil, fmt.Errorf("Connect to %s error: %v", remoteNodeAddr, result.err)
+	}
+
+	if result.remoteNode == nil {
+		return nil, fmt.Errorf("Another goroutine is connecting to %s", remoteNodeAddr)
+	}
+
+	reply, err := ln.sendWhenReady(ctx, result.remoteNode, msg, hasReply)
+	if err != nil && result.created {
+		result.remoteNode.Stop(err)
+	}
+
+	return reply, err
+}
+
+// sendWhenReady waits until remoteNode is ready, sends msg to it and waits for
+// reply if hasReply is true, all within the deadline of ctx
+func (ln *LocalNode) sendWhenReady(ctx context.Context, remoteNode *RemoteNode, msg *protobuf.Message, hasReply bool) (*RemoteMessage, error) {
+	select {
+	case <-remoteNode.Ready():
+	case <-remoteNode.Done():
+		return nil, fmt.Errorf("Remote node stopped before ready: %v", remoteNode.StopReason())
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Wait for remote node ready error: %v", ctx.Err())
+	}
+
+	replyTimeout := ln.DefaultReplyTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		replyTimeout = time.Until(deadline)
+	}
+
+	replyChan, err := remoteNode.SendMessage(msg, hasReply, replyTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Send msg error: %v", err)
+	}
+
+	if !hasReply {
+		return nil, nil
+	}
+
+	select {
+	case reply := <-replyChan:
+		if reply == nil {
+			return nil, errors.New("Remote node has stopped before reply")
+		}
+		return reply, nil
+	case <-remoteNode.Done():
+		ln.FreeReplyChan(msg.MessageId)
+		return nil, errors.New("Remote node has stopped before reply")
+	case <-ctx.Done():
+		ln.FreeReplyChan(msg.MessageId)
+		return nil, fmt.Errorf("Wait for reply error: %v", ctx.Err())
+	}
 }
 
 // StartRemoteNode creates and starts a remote node using conn
@@ -299,18 +847,48 @@ func (ln *LocalNode) StartRemoteNode(conn net.Conn, isOutbound bool) (*RemoteNod
 		return nil, err
 	}
 
-	for _, mw := range ln.middlewareStore.remoteNodeConnected {
+	err = ln.startRemoteNode(remoteNode)
+	if err != nil {
+		return nil, err
+	}
+
+	return remoteNode, nil
+}
+
+// startRemoteNode calls RemoteNodeConnected middleware and starts remoteNode
+func (ln *LocalNode) startRemoteNode(remoteNode *RemoteNode) error {
+	if ln.IsStopped() {
+		return errors.New("Local node has stopped")
+	}
+
+	if ln.isShuttingDown() {
+		return errors.New("Local node is shutting down")
+	}
+
+	for _, mw := range ln.middlewareStore.remoteNodeConnectedVeto.Load().([]RemoteNodeConnectedVeto) {
+		err, shouldCallNextMiddleware := mw.Func(remoteNode)
+		if err != nil {
+			return fmt.Errorf("Remote node %v is rejected: %v", remoteNode, err)
+		}
+		if !shouldCallNextMiddleware {
+			break
+		}
+	}
+
+	for _, mw := range ln.middlewareStore.remoteNodeConnected.Load().([]RemoteNodeConnected) {
 		if !mw.Func(remoteNode) {
 			break
 		}
 	}
 
-	err = remoteNode.Start()
+	err := remoteNode.Start()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return remoteNode, nil
+	ln.metrics.RemoteNodeConnected(remoteNode)
+
+	return nil
 }
 
 // RegisterRoutingType register a routing type and creates the rxMsgChan for it
@@ -318,8 +896,54 @@ func (ln *LocalNode) RegisterRoutingType(routingType protobuf.RoutingType) {
 	ln.rxMsgChan[routingType] = make(chan *RemoteMessage, ln.LocalRxMsgChanLen)
 }
 
+// SetMessageIDGenerator sets the generator of message id used by local node.
+// Default generator generates random id with MessageIDBytes. It should not be
+// called once the node starts.
+func (ln *LocalNode) SetMessageIDGenerator(gen message.IDGenerator) error {
+	if gen == nil {
+		return errors.New("Message id generator is nil")
+	}
+	ln.msgIDGenerator = gen
+	return nil
+}
+
+// GenMessageID generates a new message id using the message id generator
+func (ln *LocalNode) GenMessageID() ([]byte, error) {
+	return ln.msgIDGenerator()
+}
+
+// SetLogger sets the logger used by local node and its remote nodes, e.g. one
+// wrapping zap or slog. Id of local node is attached to all logs (see
+// log.With) so that logs of different local nodes in the same process can be
+// told apart. Default logger logs to the global logger of log package. It
+// should be called before local node starts.
+func (ln *LocalNode) SetLogger(logger log.Logger) error {
+	if logger == nil {
+		return errors.New("Logger is nil")
+	}
+	ln.logger = log.With(logger, "node", shortNodeID(ln.Id))
+	return nil
+}
+
+// GetLogger returns the logger used by local node and its remote nodes, with
+// id of local node attached
+func (ln *LocalNode) GetLogger() log.Logger {
+	return ln.logger
+}
+
+// shortNodeID returns the hex encoded first few bytes of node id, which is
+// enough to tell nodes apart in logs
+func shortNodeID(id []byte) string {
+	if len(id) > shortNodeIDBytes {
+		id = id[:shortNodeIDBytes]
+	}
+	return hex.EncodeToString(id)
+}
+
 // GetRxMsgChan gets the message channel of a routing type, or return error if
-// channel for routing type does not exist
+// channel for routing type does not exist. The channel is never closed, so
+// consumers should also select on Done (or Context) of local node to exit when
+// local node stops.
 func (ln *LocalNode) GetRxMsgChan(routingType protobuf.RoutingType) (chan *RemoteMessage, error) {
 	c, ok := ln.rxMsgChan[routingType]
 	if !ok {
@@ -328,23 +952,39 @@ func (ln *LocalNode) GetRxMsgChan(routingType protobuf.RoutingType) (chan *Remot
 	return c, nil
 }
 
-// AllocReplyChan creates a reply chan for msg with id msgID
+// AllocReplyChan creates a reply chan for msg with id msgID. It returns error
+// if a reply chan for msgID is still outstanding. Reply chan can buffer one
+// reply. Reply chan allocated by RemoteNode.SendMessage receives nil instead of
+// a reply if the remote node stops before reply is received, so that callers
+// waiting on it do not need to wait for reply timeout.
 func (ln *LocalNode) AllocReplyChan(msgID []byte, expiration time.Duration) (chan *RemoteMessage, error) {
+	return ln.allocReplyChan(msgID, expiration, nil, nil)
+}
+
+// allocReplyChan creates a reply chan for msg with id msgID that is sent to
+// remoteNode. request is the msg, or nil if unknown.
+func (ln *LocalNode) allocReplyChan(msgID []byte, expiration time.Duration, remoteNode *RemoteNode, request *protobuf.Message) (chan *RemoteMessage, error) {
 	if len(msgID) == 0 {
 		return nil, errors.New("Message id is empty")
 	}
 
-	replyChan := make(chan *RemoteMessage)
+	replyChan := make(chan *RemoteMessage, 1)
 
 	err := ln.replyChanCache.AddWithExpiration(msgID, replyChan, expiration)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Reply chan for msg %x is already allocated", msgID)
 	}
 
+	// msgID may be reused after its previous reply chan is freed
+	ln.repliedCache.Delete(msgID)
+
+	ln.addPendingReply(msgID, remoteNode, request, expiration)
+
 	return replyChan, nil
 }
 
-// GetReplyChan gets the message reply channel for message id msgID
+// GetReplyChan gets the message reply channel for message id msgID. The reply
+// chan stays pending until a reply is claimed by ClaimReply.
 func (ln *LocalNode) GetReplyChan(msgID []byte) (chan *RemoteMessage, bool) {
 	value, ok := ln.replyChanCache.Get(msgID)
 	if !ok {
@@ -359,22 +999,79 @@ func (ln *LocalNode) GetReplyChan(msgID []byte) (chan *RemoteMessage, bool) {
 	return replyChan, true
 }
 
+// GetNumKeepAliveTimeouts returns the number of remote nodes that have been
+// stopped because of keepalive timeout
+func (ln *LocalNode) GetNumKeepAliveTimeouts() uint64 {
+	return atomic.LoadUint64(&ln.numKeepAliveTimeouts)
+}
+
+// GetNumMsgHandleTimeouts returns the number of msg whose handling exceeds
+// LocalMsgHandleTimeout
+func (ln *LocalNode) GetNumMsgHandleTimeouts() uint64 {
+	return atomic.LoadUint64(&ln.numMsgHandleTimeouts)
+}
+
+// FreeReplyChan deletes the reply chan for message id msgID so that it can be
+// garbage collected before expiration
+func (ln *LocalNode) FreeReplyChan(msgID []byte) error {
+	ln.removePendingReply(msgID)
+	return ln.replyChanCache.Delete(msgID)
+}
+
+// SendMessageToMany sends msg to each of remoteNodes without waiting for
+// reply, and returns the error of each remote node that msg could not be
+// queued to, or an empty map if all succeed. The same msg is shared by all
+// remote nodes rather than copied, so it should not be modified after calling
+// this. Msg is still encoded per remote node in tx as remote nodes may have
+// negotiated different codecs and compression.
+func (ln *LocalNode) SendMessageToMany(remoteNodes []*RemoteNode, msg *protobuf.Message) map[*RemoteNode]error {
+	errs := make(map[*RemoteNode]error)
+	for _, remoteNode := range remoteNodes {
+		if remoteNode == nil {
+			continue
+		}
+		err := remoteNode.SendMessageAsync(msg)
+		if err != nil {
+			errs[remoteNode] = err
+		}
+	}
+	return errs
+}
+
+// SendDedup sends msg to remoteNode and waits for reply like SendMessageSync,
+// but concurrent calls with the same key to the same remote node are
+// coalesced: only the first msg is sent and its reply (or error) is shared by
+// all callers. The shared reply should not be modified by callers.
+func (ln *LocalNode) SendDedup(remoteNode *RemoteNode, key string, msg *protobuf.Message) (*RemoteMessage, error) {
+	reply, _, err := ln.sendDedup.Do(remoteNode.conn.RemoteAddr().String()+"/"+key, func() (interface{}, error) {
+		return remoteNode.SendMessageSync(msg, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reply.(*RemoteMessage), nil
+}
+
 // AddToRxCache add RemoteMessage id to rxMsgCache if not exists. Returns if msg
 // id is added (instead of loaded) and error when adding
 func (ln *LocalNode) AddToRxCache(msgID []byte) (bool, error) {
 	_, found := ln.rxMsgCache.Get(msgID)
 	if found {
+		atomic.AddUint64(&ln.rxMsgCacheHits, 1)
 		return false, nil
 	}
 
 	err := ln.rxMsgCache.Add(msgID, struct{}{})
 	if err != nil {
 		if _, found := ln.rxMsgCache.Get(msgID); found {
+			atomic.AddUint64(&ln.rxMsgCacheHits, 1)
 			return false, nil
 		}
 		return false, err
 	}
 
+	atomic.AddUint64(&ln.rxMsgCacheMisses, 1)
+
 	return true, nil
 }
 
@@ -383,8 +1080,38 @@ func (ln *LocalNode) HandleRemoteMessage(remoteMsg *RemoteMessage) error {
 	select {
 	case ln.handleMsgChan <- remoteMsg:
 	default:
-		log.Warningf("Local node handle msg chan full, discarding msg")
+		action := ln.backpressure.OnRxFull(remoteMsg.RemoteNode, remoteMsg.Msg)
+		err := ln.ApplyBackpressure(action, remoteMsg.RemoteNode, func(done <-chan struct{}) bool {
+			select {
+			case ln.handleMsgChan <- remoteMsg:
+				return true
+			case <-done:
+				return false
+			}
+		}, "Local node handle msg chan full")
+		if err != nil {
+			ln.logger.Warning(err)
+		}
+	}
+	return nil
+}
+
+// Drain notifies all neighbors that local node is about to shut down so they
+// stop routing new messages to it. Connections are kept open until
+// DrainTimeout so that in-flight messages and replies can still be delivered.
+func (ln *LocalNode) Drain() error {
+	neighbors, err := ln.GetNeighbors(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, remoteNode := range neighbors {
+		err = remoteNode.NotifyDrain()
+		if err != nil {
+			ln.logger.Warningf("Notify remote node %v draining error: %v", remoteNode, err)
+		}
 	}
+
 	return nil
 }
 
@@ -404,3 +1131,35 @@ func (ln *LocalNode) GetNeighbors(filter func(*RemoteNode) bool) ([]*RemoteNode,
 	})
 	return nodes, nil
 }
+
+// GetRemoteNodes returns a snapshot of all remote nodes that have not stopped,
+// including the ones that are not ready yet (e.g. still in handshake), unlike
+// GetNeighbors. The returned slice is a copy, so it can be iterated and kept
+// while remote nodes connect and disconnect, but remote nodes in it may stop
+// at any time.
+func (ln *LocalNode) GetRemoteNodes() []*RemoteNode {
+	nodes := make([]*RemoteNode, 0)
+	ln.neighbors.Range(func(key, value interface{}) bool {
+		remoteNode, ok := value.(*RemoteNode)
+		if ok && !remoteNode.IsStopped() {
+			nodes = append(nodes, remoteNode)
+		}
+		return true
+	})
+	return nodes
+}
+
+// GetRemoteNodeByID returns the ready remote node with node id id, or nil if
+// there is none. Node id of a remote node is only known once it is ready.
+func (ln *LocalNode) GetRemoteNodeByID(id []byte) *RemoteNode {
+	var found *RemoteNode
+	ln.neighbors.Range(func(key, value interface{}) bool {
+		remoteNode, ok := value.(*RemoteNode)
+		if ok && remoteNode.IsReady() && !remoteNode.IsStopped() && bytes.Equal(remoteNode.Id, id) {
+			found = remoteNode
+			return false
+		}
+		return true
+	})
+	return found
+}
@@ -0,0 +1,215 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+	"github.com/nknorg/nnet/transport"
+)
+
+// inProcessNodes stores the local nodes in current process that accept
+// in-process loopback connections, keyed by transport and listening port
+var inProcessNodes sync.Map
+
+// inProcessKey returns the key of a local node in inProcessNodes
+func inProcessKey(transport string, port uint16) string {
+	return fmt.Sprintf("%s:%d", transport, port)
+}
+
+// registerInProcess makes local node available for in-process loopback
+// connections from other local nodes in the same process
+func (ln *LocalNode) registerInProcess() {
+	inProcessNodes.Store(inProcessKey(ln.address.Transport.String(), ln.port), ln)
+}
+
+// unregisterInProcess stops accepting in-process loopback connections
+func (ln *LocalNode) unregisterInProcess() {
+	key := inProcessKey(ln.address.Transport.String(), ln.port)
+	if value, ok := inProcessNodes.Load(key); ok && value == ln {
+		inProcessNodes.Delete(key)
+	}
+}
+
+// lookupInProcess returns the local node in the same process that listens on
+// addr, or nil if not found
+func lookupInProcess(addr *transport.Address) *LocalNode {
+	value, ok := inProcessNodes.Load(inProcessKey(addr.Transport.String(), addr.Port))
+	if !ok {
+		return nil
+	}
+
+	ln, ok := value.(*LocalNode)
+	if !ok || ln.IsStopped() {
+		return nil
+	}
+
+	if addr.Host != "" && addr.Host != "localhost" && addr.Host != ln.address.Host {
+		ip := net.ParseIP(addr.Host)
+		if ip == nil || !ip.IsLoopback() {
+			return nil
+		}
+	}
+
+	return ln
+}
+
+// loopbackAddr is the address of an in-process loopback conn
+type loopbackAddr string
+
+func (addr loopbackAddr) Network() string {
+	return "inproc"
+}
+
+func (addr loopbackAddr) String() string {
+	return string(addr)
+}
+
+// loopbackConn is the conn of an in-process loopback connection. Data is not
+// transmitted through it, it only provides addresses and Close.
+type loopbackConn struct {
+	net.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (conn *loopbackConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+func (conn *loopbackConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// SecurityLevel implements SecureConn, as msg on loopback conn never leave
+// the process
+func (conn *loopbackConn) SecurityLevel() SecurityLevel {
+	return SecurityInProcess
+}
+
+// connectInProcess creates an in-process loopback connection to target, which
+// is a local node in the same process. The returned outbound remote node and
+// the inbound remote node created on target pass messages directly to each
+// other without transport and serialization. remoteConnAddr is the conn remote
+// address used as the key of the outbound remote node.
+func (ln *LocalNode) connectInProcess(target *LocalNode, remoteConnAddr string) (*RemoteNode, error) {
+	if target == ln {
+		return nil, errors.New("trying to connect to self")
+	}
+
+	localConnAddr := fmt.Sprintf("127.0.0.1:%d", ln.port)
+	_, loaded := target.neighbors.LoadOrStore(localConnAddr, nil)
+	if loaded {
+		return nil, fmt.Errorf("Remote addr %s is already connected to %v", localConnAddr, target)
+	}
+
+	c1, c2 := net.Pipe()
+	outboundConn := &loopbackConn{Conn: c1, localAddr: loopbackAddr(localConnAddr), remoteAddr: loopbackAddr(remoteConnAddr)}
+	inboundConn := &loopbackConn{Conn: c2, localAddr: loopbackAddr(remoteConnAddr), remoteAddr: loopbackAddr(localConnAddr)}
+
+	outbound, err := NewRemoteNode(ln, outboundConn, true)
+	if err != nil {
+		target.neighbors.Delete(localConnAddr)
+		c1.Close()
+		c2.Close()
+		return nil, err
+	}
+
+	inbound, err := NewRemoteNode(target, inboundConn, false)
+	if err != nil {
+		target.neighbors.Delete(localConnAddr)
+		c2.Close()
+		outbound.Stop(err)
+		return nil, err
+	}
+
+	outbound.loopbackPeer = inbound
+	inbound.loopbackPeer = outbound
+
+	err = target.startRemoteNode(inbound)
+	if err != nil {
+		target.neighbors.Delete(localConnAddr)
+		outbound.Stop(err)
+		return nil, err
+	}
+
+	target.neighbors.Store(localConnAddr, inbound)
+
+	err = ln.startRemoteNode(outbound)
+	if err != nil {
+		inbound.Stop(err)
+		return nil, err
+	}
+
+	return outbound, nil
+}
+
+// txLoopback passes msg in priorityChan and txMsgChan directly to the
+// rxMsgChan of loopback peer
+func (rn *RemoteNode) txLoopback() {
+	defer rn.LocalNode.wg.Done()
+
+	var msg *protobuf.Message
+	var numPriority uint32
+	txMsgChan := rn.getTxMsgChan()
+
+	for {
+		if rn.IsStopped() {
+			return
+		}
+
+		msg = rn.pollTxMsg(&numPriority)
+		if msg == nil {
+			select {
+			case msg = <-rn.priorityChan:
+				numPriority++
+			case msg = <-txMsgChan:
+				numPriority = 0
+			case <-rn.Done():
+				return
+			}
+		}
+
+		rn.passMsg(msg)
+	}
+}
+
+// passMsg passes msg to the rxMsgChan of loopback peer
+func (rn *RemoteNode) passMsg(msg *protobuf.Message) {
+	rn.releaseTxQueue(int64(msg.Size()))
+
+	// msg may be shared with other remote nodes and will be modified by
+	// peer, so we pass a copy
+	msgCopy := *msg
+	msgCopy.RoutingType = rn.mapRoutingType(msg.RoutingType, Egress)
+
+	rn.Lock()
+	rn.lastTxTime = time.Now()
+	rn.Unlock()
+
+	rn.markActive(&msgCopy)
+
+	// no serialization for in-process msg, count marshaled size
+	size := uint64(rn.LocalNode.framer.FrameSize(msgCopy.Size()))
+	atomic.AddUint64(&rn.bytesTx, size)
+	atomic.AddUint64(&rn.msgTx, 1)
+	atomic.AddUint64(&rn.loopbackPeer.bytesRx, size)
+	rn.LocalNode.metrics.BytesSent(rn, int(size))
+	rn.loopbackPeer.LocalNode.metrics.BytesReceived(rn.loopbackPeer, int(size))
+
+	if rn.journal != nil {
+		rn.journal.record(&msgCopy, Egress)
+	}
+
+	rn.messageSent(&msgCopy)
+
+	rn.loopbackPeer.Lock()
+	rn.loopbackPeer.lastRxTime = time.Now()
+	rn.loopbackPeer.Unlock()
+
+	rn.loopbackPeer.receiveMessage(&msgCopy)
+}
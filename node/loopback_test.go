@@ -0,0 +1,120 @@
+package node
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestInProcessLoopback(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{InProcessLoopback: true})
+	peer := newTestLocalNode(t, &config.Config{InProcessLoopback: true})
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	if rn.loopbackPeer != peerRn || peerRn.loopbackPeer != rn {
+		t.Fatal("remote nodes are not connected by in-process loopback")
+	}
+	if level := rn.SecurityLevel(); level != SecurityInProcess {
+		t.Fatalf("security level is %v, expecting %v", level, SecurityInProcess)
+	}
+
+	msg := newTestMessage(t, ln, []byte("hello"))
+	err := rn.SendMessageAsync(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteMsg := recvTestMessage(t, peer, time.Second)
+	if !bytes.Equal(remoteMsg.Msg.Message, msg.Message) {
+		t.Fatalf("received msg %q, expecting %q", remoteMsg.Msg.Message, msg.Message)
+	}
+	if remoteMsg.RemoteNode != peerRn {
+		t.Fatal("msg is not received from loopback peer")
+	}
+}
+
+func TestInProcessLoopbackAlreadyConnected(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{InProcessLoopback: true})
+	peer := newTestLocalNode(t, &config.Config{InProcessLoopback: true})
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	numGoroutines := runtime.NumGoroutine()
+
+	_, err := ln.connectInProcess(peer, "duplicate")
+	if err == nil {
+		t.Fatal("connect to a node that is already connected should fail")
+	}
+
+	// no remote node is left behind, and the existing connection still works
+	time.Sleep(2 * stopGracePeriod)
+	if n := runtime.NumGoroutine(); n > numGoroutines {
+		t.Fatalf("%d goroutines after failed connect, expecting at most %d", n, numGoroutines)
+	}
+	if rn.IsStopped() || peerRn.IsStopped() {
+		t.Fatal("existing remote node is stopped by failed connect")
+	}
+	if peer.GetRemoteNodeByID(ln.Id) != peerRn {
+		t.Fatal("existing remote node is removed by failed connect")
+	}
+
+	err = rn.SendMessageAsync(newTestMessage(t, ln, []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, peer, time.Second)
+}
+
+// benchmarkLoopbackThroughput sends b.N msg of size bytes from one local node
+// to another in the same process and waits until all are received
+func benchmarkLoopbackThroughput(b *testing.B, conf func() *config.Config, size int) {
+	ln := newTestLocalNode(b, conf())
+	peer := newTestLocalNode(b, conf())
+	rn, _ := connectTestNodes(b, ln, peer)
+
+	data := make([]byte, size)
+	errChan := make(chan error, 1)
+
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			err := rn.SendMessageBlocking(newTestMessage(b, ln, data), 10*time.Second)
+			if err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		select {
+		case err := <-errChan:
+			b.Fatal(err)
+		default:
+		}
+		recvTestMessage(b, peer, 10*time.Second)
+	}
+}
+
+func BenchmarkLoopbackThroughput(b *testing.B) {
+	inProcess := func() *config.Config {
+		return &config.Config{InProcessLoopback: true}
+	}
+	tcp := func() *config.Config {
+		return &config.Config{Transport: "tcp", Hostname: "127.0.0.1"}
+	}
+
+	for _, size := range []int{64, 1024, 16384} {
+		b.Run(fmt.Sprintf("InProcess/%d", size), func(b *testing.B) {
+			benchmarkLoopbackThroughput(b, inProcess, size)
+		})
+		b.Run(fmt.Sprintf("TCP/%d", size), func(b *testing.B) {
+			benchmarkLoopbackThroughput(b, tcp, size)
+		})
+	}
+}
@@ -2,10 +2,9 @@ package node
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/gogo/protobuf/proto"
-	"github.com/nknorg/nnet/log"
-	"github.com/nknorg/nnet/message"
 	"github.com/nknorg/nnet/protobuf"
 )
 
@@ -21,6 +20,45 @@ type RemoteMessage struct {
 	Msg        *protobuf.Message
 }
 
+// replyExpected maps message type to whether the receiver replies to it.
+// Message types not in it (e.g. BYTES) may or may not have a reply.
+var replyExpected = map[protobuf.MessageType]bool{
+	protobuf.PING:               true,
+	protobuf.GET_NODE:           true,
+	protobuf.STOP:               false,
+	protobuf.GET_SUCC_AND_PRED:  true,
+	protobuf.FIND_SUCC_AND_PRED: true,
+	protobuf.OPEN_STREAM:        false,
+	protobuf.DRAIN:              false,
+	protobuf.ACK:                false,
+}
+
+// checkReplyExpectation returns error if hasReply does not match whether msg
+// will be replied, e.g. waiting for reply of a msg that is never replied, or
+// sending a direct msg that will be replied without waiting for reply. Relayed
+// msg is not checked if hasReply is false because it may be forwarded by a
+// node that is not the sender.
+func checkReplyExpectation(msg *protobuf.Message, hasReply bool) error {
+	if hasReply && len(msg.ReplyToId) > 0 {
+		return fmt.Errorf("Waiting for reply of a reply msg (type %v) that will never be replied", msg.MessageType)
+	}
+
+	expected, ok := replyExpected[msg.MessageType]
+	if !ok || len(msg.ReplyToId) > 0 {
+		return nil
+	}
+
+	if hasReply && !expected {
+		return fmt.Errorf("Waiting for reply of msg type %v that will never be replied", msg.MessageType)
+	}
+
+	if !hasReply && expected && msg.RoutingType == protobuf.DIRECT {
+		return fmt.Errorf("Sending msg type %v without waiting for reply, reply will be lost", msg.MessageType)
+	}
+
+	return nil
+}
+
 // NewRemoteMessage creates a RemoteMessage with remote node rn and msg
 func NewRemoteMessage(rn *RemoteNode, msg *protobuf.Message) (*RemoteMessage, error) {
 	remoteMsg := &RemoteMessage{
@@ -30,9 +68,16 @@ func NewRemoteMessage(rn *RemoteNode, msg *protobuf.Message) (*RemoteMessage, er
 	return remoteMsg, nil
 }
 
+// CanRouteTo returns if remote message can be routed to remote node rn. New
+// messages are not routed to a draining remote node, but replies still are so
+// that in-flight requests can complete.
+func (remoteMsg *RemoteMessage) CanRouteTo(rn *RemoteNode) bool {
+	return len(remoteMsg.Msg.ReplyToId) > 0 || !rn.IsDraining()
+}
+
 // NewPingMessage creates a PING message for heartbeat
 func (ln *LocalNode) NewPingMessage() (*protobuf.Message, error) {
-	id, err := message.GenID(ln.MessageIDBytes)
+	id, err := ln.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +101,7 @@ func (ln *LocalNode) NewPingMessage() (*protobuf.Message, error) {
 
 // NewPingReply creates a PING reply for heartbeat
 func (ln *LocalNode) NewPingReply(replyToID []byte) (*protobuf.Message, error) {
-	id, err := message.GenID(ln.MessageIDBytes)
+	id, err := ln.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +125,7 @@ func (ln *LocalNode) NewPingReply(replyToID []byte) (*protobuf.Message, error) {
 
 // NewGetNodeMessage creates a GET_NODE message to get node info
 func (ln *LocalNode) NewGetNodeMessage() (*protobuf.Message, error) {
-	id, err := message.GenID(ln.MessageIDBytes)
+	id, err := ln.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -104,13 +149,16 @@ func (ln *LocalNode) NewGetNodeMessage() (*protobuf.Message, error) {
 
 // NewGetNodeReply creates a GET_NODE reply to send node info
 func (ln *LocalNode) NewGetNodeReply(replyToID []byte, n *protobuf.Node) (*protobuf.Message, error) {
-	id, err := message.GenID(ln.MessageIDBytes)
+	id, err := ln.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
 
 	msgBody := &protobuf.GetNodeReply{
-		Node: n,
+		Node:              n,
+		CompressionCodecs: supportedCompressionCodecs(ln.CompressionDictionary),
+		CompressionDictId: compressionDictID(ln.CompressionDictionary),
+		MessageCodecs:     supportedMsgCodecs(),
 	}
 
 	buf, err := proto.Marshal(msgBody)
@@ -132,7 +180,7 @@ func (ln *LocalNode) NewGetNodeReply(replyToID []byte, n *protobuf.Node) (*proto
 // NewStopMessage creates a STOP message to notify local node to close
 // connection with remote node
 func (ln *LocalNode) NewStopMessage() (*protobuf.Message, error) {
-	id, err := message.GenID(ln.MessageIDBytes)
+	id, err := ln.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -154,6 +202,84 @@ func (ln *LocalNode) NewStopMessage() (*protobuf.Message, error) {
 	return msg, nil
 }
 
+// NewOpenStreamMessage creates an OPEN_STREAM message to request remote node
+// to open an application stream
+func (ln *LocalNode) NewOpenStreamMessage() (*protobuf.Message, error) {
+	id, err := ln.GenMessageID()
+	if err != nil {
+		return nil, err
+	}
+
+	msgBody := &protobuf.OpenStream{}
+
+	buf, err := proto.Marshal(msgBody)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &protobuf.Message{
+		MessageType: protobuf.OPEN_STREAM,
+		RoutingType: protobuf.DIRECT,
+		MessageId:   id,
+		Message:     buf,
+	}
+
+	return msg, nil
+}
+
+// NewDrainMessage creates a DRAIN message to notify remote node that local node
+// is draining
+func (ln *LocalNode) NewDrainMessage() (*protobuf.Message, error) {
+	id, err := ln.GenMessageID()
+	if err != nil {
+		return nil, err
+	}
+
+	msgBody := &protobuf.Drain{}
+
+	buf, err := proto.Marshal(msgBody)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &protobuf.Message{
+		MessageType: protobuf.DRAIN,
+		RoutingType: protobuf.DIRECT,
+		MessageId:   id,
+		Message:     buf,
+	}
+
+	return msg, nil
+}
+
+// NewAckMessage creates an ACK message to notify remote node whether the msg
+// with msgID is delivered to routing chan
+func (ln *LocalNode) NewAckMessage(msgID []byte, delivered bool) (*protobuf.Message, error) {
+	id, err := ln.GenMessageID()
+	if err != nil {
+		return nil, err
+	}
+
+	msgBody := &protobuf.Ack{
+		MessageId: msgID,
+		Delivered: delivered,
+	}
+
+	buf, err := proto.Marshal(msgBody)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &protobuf.Message{
+		MessageType: protobuf.ACK,
+		RoutingType: protobuf.DIRECT,
+		MessageId:   id,
+		Message:     buf,
+	}
+
+	return msg, nil
+}
+
 // handleRemoteMessage handles a remote message and returns error
 func (ln *LocalNode) handleRemoteMessage(remoteMsg *RemoteMessage) error {
 	if remoteMsg.RemoteNode == nil && remoteMsg.Msg.MessageType != protobuf.BYTES {
@@ -184,9 +310,32 @@ func (ln *LocalNode) handleRemoteMessage(remoteMsg *RemoteMessage) error {
 		}
 
 	case protobuf.STOP:
-		log.Infof("Received stop message from remote node %v", remoteMsg.RemoteNode)
+		ln.logger.Infof("Received stop message from remote node %v", remoteMsg.RemoteNode)
 		remoteMsg.RemoteNode.Stop(nil)
 
+	case protobuf.OPEN_STREAM:
+		err := remoteMsg.RemoteNode.handleOpenStream(remoteMsg.Msg.MessageId)
+		if err != nil {
+			return err
+		}
+
+	case protobuf.DRAIN:
+		ln.logger.Infof("Remote node %v is draining", remoteMsg.RemoteNode)
+		remoteMsg.RemoteNode.setDraining()
+
+	case protobuf.ACK:
+		msgBody := &protobuf.Ack{}
+		err := proto.Unmarshal(remoteMsg.Msg.Message, msgBody)
+		if err != nil {
+			return err
+		}
+
+		for _, mw := range ln.middlewareStore.messageAcked.Load().([]MessageAcked) {
+			if !mw.Func(msgBody.MessageId, msgBody.Delivered, remoteMsg.RemoteNode) {
+				break
+			}
+		}
+
 	case protobuf.BYTES:
 		msgBody := &protobuf.Bytes{}
 		err := proto.Unmarshal(remoteMsg.Msg.Message, msgBody)
@@ -196,7 +345,7 @@ func (ln *LocalNode) handleRemoteMessage(remoteMsg *RemoteMessage) error {
 
 		data := msgBody.Data
 		var shouldCallNextMiddleware bool
-		for _, mw := range ln.middlewareStore.bytesReceived {
+		for _, mw := range ln.middlewareStore.bytesReceived.Load().([]BytesReceived) {
 			data, shouldCallNextMiddleware = mw.Func(data, remoteMsg.Msg.MessageId, remoteMsg.Msg.SrcId, remoteMsg.RemoteNode)
 			if !shouldCallNextMiddleware {
 				break
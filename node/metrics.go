@@ -0,0 +1,92 @@
+package node
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// MetricsCollector receives aggregate metrics events of local node and its
+// remote nodes, which can be implemented to export metrics to a monitoring
+// system (e.g. Prometheus or OpenTelemetry) without nnet depending on it.
+// Methods are called synchronously on hot paths such as rx and tx, so they
+// should be cheap and must not block, e.g. only increase counters.
+type MetricsCollector interface {
+	// RemoteNodeConnected is called when a remote node is started
+	RemoteNodeConnected(remoteNode *RemoteNode)
+
+	// RemoteNodeDisconnected is called when a remote node stops, err is the
+	// reason it stops, nil if stopped normally
+	RemoteNodeDisconnected(remoteNode *RemoteNode, err error)
+
+	// BytesReceived is called when n bytes (including length prefix) of a msg
+	// are received from remote node
+	BytesReceived(remoteNode *RemoteNode, n int)
+
+	// BytesSent is called when n bytes (including length prefix) of msg are
+	// sent to remote node
+	BytesSent(remoteNode *RemoteNode, n int)
+
+	// MsgDropped is called when a msg sent to or received from remote node is
+	// dropped, e.g. because of backpressure or it cannot be unmarshaled
+	MsgDropped(remoteNode *RemoteNode)
+
+	// ReplyTimeout is called when reply of a msg sent to remote node is not
+	// received within reply timeout
+	ReplyTimeout(remoteNode *RemoteNode)
+
+	// KeepAliveTimeout is called when remote node is about to be stopped
+	// because of keepalive timeout
+	KeepAliveTimeout(remoteNode *RemoteNode)
+}
+
+// NoopMetricsCollector is the default metrics collector that does nothing
+type NoopMetricsCollector struct{}
+
+// RemoteNodeConnected implements MetricsCollector interface
+func (NoopMetricsCollector) RemoteNodeConnected(remoteNode *RemoteNode) {}
+
+// RemoteNodeDisconnected implements MetricsCollector interface
+func (NoopMetricsCollector) RemoteNodeDisconnected(remoteNode *RemoteNode, err error) {}
+
+// BytesReceived implements MetricsCollector interface
+func (NoopMetricsCollector) BytesReceived(remoteNode *RemoteNode, n int) {}
+
+// BytesSent implements MetricsCollector interface
+func (NoopMetricsCollector) BytesSent(remoteNode *RemoteNode, n int) {}
+
+// MsgDropped implements MetricsCollector interface
+func (NoopMetricsCollector) MsgDropped(remoteNode *RemoteNode) {}
+
+// ReplyTimeout implements MetricsCollector interface
+func (NoopMetricsCollector) ReplyTimeout(remoteNode *RemoteNode) {}
+
+// KeepAliveTimeout implements MetricsCollector interface
+func (NoopMetricsCollector) KeepAliveTimeout(remoteNode *RemoteNode) {}
+
+// SetMetricsCollector sets the collector that receives metrics events of local
+// node and its remote nodes. It should be called before local node starts.
+func (ln *LocalNode) SetMetricsCollector(collector MetricsCollector) error {
+	if collector == nil {
+		return errors.New("Metrics collector is nil")
+	}
+	ln.metrics = collector
+	return nil
+}
+
+// GetMetricsCollector returns the metrics collector of local node
+func (ln *LocalNode) GetMetricsCollector() MetricsCollector {
+	return ln.metrics
+}
+
+// countMsgDropped counts a msg sent to or received from remote node that is
+// dropped
+func (rn *RemoteNode) countMsgDropped() {
+	atomic.AddUint64(&rn.msgDropped, 1)
+	rn.LocalNode.metrics.MsgDropped(rn)
+}
+
+// countReplyTimeout counts a msg sent to remote node whose reply is not
+// received within reply timeout
+func (rn *RemoteNode) countReplyTimeout() {
+	rn.LocalNode.metrics.ReplyTimeout(rn)
+}
@@ -1,11 +1,109 @@
 package node
 
 import (
+	"crypto/x509"
 	"errors"
+	"sync/atomic"
 
 	"github.com/nknorg/nnet/middleware"
+	"github.com/nknorg/nnet/protobuf"
 )
 
+// Direction is the direction of a message relative to local node
+type Direction int
+
+const (
+	// Ingress is a message received from a remote node
+	Ingress Direction = iota
+	// Egress is a message to be sent to a remote node
+	Egress
+)
+
+// ConnDirection is the direction of the connection with a remote node
+type ConnDirection int
+
+const (
+	// BothConnDirections matches both inbound and outbound connections
+	BothConnDirections ConnDirection = iota
+	// InboundConn matches connections accepted by local node
+	InboundConn
+	// OutboundConn matches connections dialed by local node
+	OutboundConn
+)
+
+// matches returns if the connection with remoteNode has direction d
+func (d ConnDirection) matches(remoteNode *RemoteNode) bool {
+	switch d {
+	case InboundConn:
+		return !remoteNode.IsOutbound
+	case OutboundConn:
+		return remoteNode.IsOutbound
+	default:
+		return true
+	}
+}
+
+// DirectionFiltered wraps a remote node middleware (RemoteNodeConnected,
+// RemoteNodeReady, RemoteNodeConnectedVeto, RemoteNodeReadyVeto,
+// RemoteNodeDisconnected or RemoteNodeKeepAliveTimeout) so
+// that it is only called for remote nodes whose connection direction matches
+// Direction. For other remote nodes it is skipped and the next middleware is
+// called. Middleware applied without it runs for both directions.
+type DirectionFiltered struct {
+	Middleware interface{}
+	Direction  ConnDirection
+}
+
+// unwrap returns the wrapped middleware whose function is only called for
+// remote nodes matching direction
+func (f DirectionFiltered) unwrap() (interface{}, error) {
+	direction := f.Direction
+	filter := func(fn func(*RemoteNode) bool) func(*RemoteNode) bool {
+		return func(remoteNode *RemoteNode) bool {
+			if !direction.matches(remoteNode) {
+				return true
+			}
+			return fn(remoteNode)
+		}
+	}
+	filterVeto := func(fn func(*RemoteNode) (error, bool)) func(*RemoteNode) (error, bool) {
+		return func(remoteNode *RemoteNode) (error, bool) {
+			if !direction.matches(remoteNode) {
+				return nil, true
+			}
+			return fn(remoteNode)
+		}
+	}
+
+	err := middleware.CheckFunc(f.Middleware)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mw := f.Middleware.(type) {
+	case RemoteNodeConnected:
+		mw.Func = filter(mw.Func)
+		return mw, nil
+	case RemoteNodeReady:
+		mw.Func = filter(mw.Func)
+		return mw, nil
+	case RemoteNodeConnectedVeto:
+		mw.Func = filterVeto(mw.Func)
+		return mw, nil
+	case RemoteNodeReadyVeto:
+		mw.Func = filterVeto(mw.Func)
+		return mw, nil
+	case RemoteNodeDisconnected:
+		mw.Func = filter(mw.Func)
+		return mw, nil
+	case RemoteNodeKeepAliveTimeout:
+		mw.Func = filter(mw.Func)
+		return mw, nil
+	default:
+		return nil, errors.New("middleware type does not support direction filter")
+	}
+}
+
 // BytesReceived is called when local node receive user-defined BYTES message.
 // Message with the same message id will only trigger this middleware once. The
 // argument it accepts are bytes data, message ID (can be used to reply
@@ -63,6 +161,45 @@ type RemoteNodeReady struct {
 	Priority int32
 }
 
+// RemoteNodeConnectedVeto is called when a new connection is established,
+// before RemoteNodeConnected middleware. It can be used to reject a connection
+// with a reason (e.g. banned peer), which is returned by the call that creates
+// the remote node and logged. Returns the reason to reject the connection (nil
+// to accept it) and if we should proceed to the next middleware. If any of
+// them rejects, the connection is closed and the remaining middleware are not
+// called.
+type RemoteNodeConnectedVeto struct {
+	Func     func(*RemoteNode) (error, bool)
+	Priority int32
+}
+
+// RemoteNodeReadyVeto is called when local node has received the node info
+// from remote node, before remote node is marked as ready and before
+// RemoteNodeReady middleware. It can be used to reject a remote node based on
+// its node info (e.g. wrong network id). Returns the reason to reject the
+// remote node (nil to accept it) and if we should proceed to the next
+// middleware. If any of them rejects, remote node is stopped with the reason as
+// error and never becomes ready.
+type RemoteNodeReadyVeto struct {
+	Func     func(*RemoteNode) (error, bool)
+	Priority int32
+}
+
+// RemoteNodeIdentityVerify is called when local node has received the node
+// info from remote node, before RemoteNodeReadyVeto middleware. It can be used
+// to verify that remote node owns the node id it claims, e.g. by checking the
+// id against the public key of its TLS certificate. The arguments it accepts
+// are the remote node, the leaf certificate it presented in TLS handshake (nil
+// if conn is not a TLS conn or it presented none, which should be rejected if
+// certificates are required), and its node info. Returns the reason to reject
+// the remote node (nil to accept it) and if we should proceed to the next
+// middleware. If any of them rejects, remote node is stopped with the reason
+// as error and never becomes ready.
+type RemoteNodeIdentityVerify struct {
+	Func     func(remoteNode *RemoteNode, cert *x509.Certificate, n *protobuf.Node) (error, bool)
+	Priority int32
+}
+
 // RemoteNodeDisconnected is called when connection to remote node is closed.
 // The cause of the connection close can be on either local node or remote node.
 // Returns if we should proceed to the next middleware.
@@ -71,87 +208,218 @@ type RemoteNodeDisconnected struct {
 	Priority int32
 }
 
+// RemoteNodeFinalStats is called with the final statistics of remote node
+// when connection to remote node is closed, right before RemoteNodeDisconnected
+// middleware. Traffic counters no longer change at this point, so it can be
+// used to account usage over the whole lifetime of the connection. Returns if
+// we should proceed to the next middleware.
+type RemoteNodeFinalStats struct {
+	Func     func(*RemoteNode, *RemoteNodeStats) bool
+	Priority int32
+}
+
+// RemoteNodeKeepAliveTimeout is called when local node has not received
+// anything from remote node for KeepAliveTimeout, or KeepAliveMaxUnanswered
+// consecutive keepalive pings are not replied, and is about to stop the
+// remote node. It can be used to distinguish a silent remote node from other
+// disconnect causes. Returns if we should proceed to the next middleware.
+type RemoteNodeKeepAliveTimeout struct {
+	Func     func(*RemoteNode) bool
+	Priority int32
+}
+
+// KeepAliveTimeoutVeto is called when local node has not received anything
+// from remote node for KeepAliveTimeout, before RemoteNodeKeepAliveTimeout
+// middleware. It can be used as a last-chance check, e.g. whether remote node
+// is known to be in a maintenance window. Returns if the timeout should be
+// vetoed and if we should proceed to the next middleware. If any of them
+// vetoes, the connection is kept and keepalive timer is reset, so it should be
+// used with care as it can keep dead connections alive.
+type KeepAliveTimeoutVeto struct {
+	Func     func(*RemoteNode) (bool, bool)
+	Priority int32
+}
+
+// MessageAcked is called when local node receives an ack for a message that
+// requests ack (RequestAck is true) from the remote node it was sent to. The
+// arguments it accepts are the id of the acked message, whether the message is
+// delivered to the routing chan of remote node (false if it was dropped
+// because the chan is full), and the remote node that sends the ack. Returns
+// if we should proceed to the next middleware.
+type MessageAcked struct {
+	Func     func(msgID []byte, delivered bool, remoteNode *RemoteNode) bool
+	Priority int32
+}
+
+// RemoteNodeMessageReceived is called when a message received from a remote
+// node is about to be dispatched to the msg chan of its routing type. Message
+// with the same message id will only trigger this middleware once, except node
+// control msg and replies which are not deduplicated by message id. Returns the
+// message to be passed in the next middleware (nil to drop the message, in
+// which case a negative ack is sent if the message requests ack) and if we
+// should proceed to the next middleware.
+type RemoteNodeMessageReceived struct {
+	Func     func(*RemoteNode, *protobuf.Message) (*protobuf.Message, bool)
+	Priority int32
+}
+
+// RemoteNodeMessageSent is called after a message is written to the conn of a
+// remote node. The message should not be modified as it may be shared with
+// other remote nodes. Returns if we should proceed to the next middleware.
+type RemoteNodeMessageSent struct {
+	Func     func(*RemoteNode, *protobuf.Message) bool
+	Priority int32
+}
+
+// RemoteNodeReconnectAttempt is called after each auto reconnect attempt to
+// an outbound remote node that has auto reconnect enabled. The arguments it
+// accepts are the address being reconnected, the attempt number starting from
+// 1, and the error of the attempt (nil if remote node is ready). Returns if we
+// should proceed to the next middleware.
+type RemoteNodeReconnectAttempt struct {
+	Func     func(remoteNodeAddr string, attempt uint32, err error) bool
+	Priority int32
+}
+
+// RoutingTypeMapper is called when a message is received from a remote node
+// (before it is dispatched by routing type) or is about to be sent to a remote
+// node. It can be used to rewrite routing type, e.g. translating between
+// versioned routing schemes in a gateway. The arguments it accepts are routing
+// type, direction of the message, and the neighbor that the message is received
+// from or sent to. Returns the routing type to be passed in the next middleware
+// and if we should proceed to the next middleware. Without this middleware
+// routing type is not changed.
+type RoutingTypeMapper struct {
+	Func     func(routingType protobuf.RoutingType, direction Direction, remoteNode *RemoteNode) (protobuf.RoutingType, bool)
+	Priority int32
+}
+
 // middlewareStore stores the functions that will be called when certain events
 // are triggered or in some pipeline
 type middlewareStore struct {
-	bytesReceived          []BytesReceived
-	localNodeWillStart     []LocalNodeWillStart
-	localNodeStarted       []LocalNodeStarted
-	localNodeWillStop      []LocalNodeWillStop
-	localNodeStopped       []LocalNodeStopped
-	remoteNodeConnected    []RemoteNodeConnected
-	remoteNodeReady        []RemoteNodeReady
-	remoteNodeDisconnected []RemoteNodeDisconnected
+	bytesReceived              atomic.Value // []BytesReceived
+	localNodeWillStart         atomic.Value // []LocalNodeWillStart
+	localNodeStarted           atomic.Value // []LocalNodeStarted
+	localNodeWillStop          atomic.Value // []LocalNodeWillStop
+	localNodeStopped           atomic.Value // []LocalNodeStopped
+	remoteNodeConnected        atomic.Value // []RemoteNodeConnected
+	remoteNodeReady            atomic.Value // []RemoteNodeReady
+	remoteNodeConnectedVeto    atomic.Value // []RemoteNodeConnectedVeto
+	remoteNodeReadyVeto        atomic.Value // []RemoteNodeReadyVeto
+	remoteNodeIdentityVerify   atomic.Value // []RemoteNodeIdentityVerify
+	remoteNodeDisconnected     atomic.Value // []RemoteNodeDisconnected
+	remoteNodeFinalStats       atomic.Value // []RemoteNodeFinalStats
+	remoteNodeKeepAliveTimeout atomic.Value // []RemoteNodeKeepAliveTimeout
+	keepAliveTimeoutVeto       atomic.Value // []KeepAliveTimeoutVeto
+	routingTypeMapper          atomic.Value // []RoutingTypeMapper
+	messageAcked               atomic.Value // []MessageAcked
+	remoteNodeMessageReceived  atomic.Value // []RemoteNodeMessageReceived
+	remoteNodeMessageSent      atomic.Value // []RemoteNodeMessageSent
+	remoteNodeReconnectAttempt atomic.Value // []RemoteNodeReconnectAttempt
+
+	registry middleware.Registry
 }
 
 // newMiddlewareStore creates a middlewareStore
 func newMiddlewareStore() *middlewareStore {
-	return &middlewareStore{
-		bytesReceived:          make([]BytesReceived, 0),
-		localNodeWillStart:     make([]LocalNodeWillStart, 0),
-		localNodeStarted:       make([]LocalNodeStarted, 0),
-		localNodeWillStop:      make([]LocalNodeWillStop, 0),
-		localNodeStopped:       make([]LocalNodeStopped, 0),
-		remoteNodeConnected:    make([]RemoteNodeConnected, 0),
-		remoteNodeReady:        make([]RemoteNodeReady, 0),
-		remoteNodeDisconnected: make([]RemoteNodeDisconnected, 0),
-	}
+	store := &middlewareStore{}
+	store.bytesReceived.Store(make([]BytesReceived, 0))
+	store.localNodeWillStart.Store(make([]LocalNodeWillStart, 0))
+	store.localNodeStarted.Store(make([]LocalNodeStarted, 0))
+	store.localNodeWillStop.Store(make([]LocalNodeWillStop, 0))
+	store.localNodeStopped.Store(make([]LocalNodeStopped, 0))
+	store.remoteNodeConnected.Store(make([]RemoteNodeConnected, 0))
+	store.remoteNodeReady.Store(make([]RemoteNodeReady, 0))
+	store.remoteNodeConnectedVeto.Store(make([]RemoteNodeConnectedVeto, 0))
+	store.remoteNodeReadyVeto.Store(make([]RemoteNodeReadyVeto, 0))
+	store.remoteNodeIdentityVerify.Store(make([]RemoteNodeIdentityVerify, 0))
+	store.remoteNodeDisconnected.Store(make([]RemoteNodeDisconnected, 0))
+	store.remoteNodeFinalStats.Store(make([]RemoteNodeFinalStats, 0))
+	store.remoteNodeKeepAliveTimeout.Store(make([]RemoteNodeKeepAliveTimeout, 0))
+	store.keepAliveTimeoutVeto.Store(make([]KeepAliveTimeoutVeto, 0))
+	store.routingTypeMapper.Store(make([]RoutingTypeMapper, 0))
+	store.messageAcked.Store(make([]MessageAcked, 0))
+	store.remoteNodeMessageReceived.Store(make([]RemoteNodeMessageReceived, 0))
+	store.remoteNodeMessageSent.Store(make([]RemoteNodeMessageSent, 0))
+	store.remoteNodeReconnectAttempt.Store(make([]RemoteNodeReconnectAttempt, 0))
+	return store
 }
 
 // ApplyMiddleware add a middleware to the store
 func (store *middlewareStore) ApplyMiddleware(mw interface{}) error {
+	_, err := store.AddMiddleware(mw)
+	return err
+}
+
+// ApplyMiddlewareWithPriority is the same as ApplyMiddleware, but overrides the
+// Priority field of mw with priority
+func (store *middlewareStore) ApplyMiddlewareWithPriority(mw interface{}, priority int) error {
+	mw, err := middleware.WithPriority(mw, priority)
+	if err != nil {
+		return err
+	}
+	return store.ApplyMiddleware(mw)
+}
+
+// AddMiddleware is the same as ApplyMiddleware, but also returns the id of the
+// middleware, which can be used to remove it with RemoveMiddleware
+func (store *middlewareStore) AddMiddleware(mw interface{}) (middleware.ID, error) {
 	switch mw := mw.(type) {
 	case BytesReceived:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.bytesReceived = append(store.bytesReceived, mw)
-		middleware.Sort(store.bytesReceived)
+		return store.registry.Add(&store.bytesReceived, mw)
 	case LocalNodeWillStart:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.localNodeWillStart = append(store.localNodeWillStart, mw)
-		middleware.Sort(store.localNodeWillStart)
+		return store.registry.Add(&store.localNodeWillStart, mw)
 	case LocalNodeStarted:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.localNodeStarted = append(store.localNodeStarted, mw)
-		middleware.Sort(store.localNodeStarted)
+		return store.registry.Add(&store.localNodeStarted, mw)
 	case LocalNodeWillStop:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.localNodeWillStop = append(store.localNodeWillStop, mw)
-		middleware.Sort(store.localNodeWillStop)
+		return store.registry.Add(&store.localNodeWillStop, mw)
 	case LocalNodeStopped:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.localNodeStopped = append(store.localNodeStopped, mw)
-		middleware.Sort(store.localNodeStopped)
+		return store.registry.Add(&store.localNodeStopped, mw)
 	case RemoteNodeConnected:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.remoteNodeConnected = append(store.remoteNodeConnected, mw)
-		middleware.Sort(store.remoteNodeConnected)
+		return store.registry.Add(&store.remoteNodeConnected, mw)
 	case RemoteNodeReady:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.remoteNodeReady = append(store.remoteNodeReady, mw)
-		middleware.Sort(store.remoteNodeReady)
+		return store.registry.Add(&store.remoteNodeReady, mw)
+	case RemoteNodeConnectedVeto:
+		return store.registry.Add(&store.remoteNodeConnectedVeto, mw)
+	case RemoteNodeReadyVeto:
+		return store.registry.Add(&store.remoteNodeReadyVeto, mw)
+	case RemoteNodeIdentityVerify:
+		return store.registry.Add(&store.remoteNodeIdentityVerify, mw)
 	case RemoteNodeDisconnected:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
+		return store.registry.Add(&store.remoteNodeDisconnected, mw)
+	case RemoteNodeFinalStats:
+		return store.registry.Add(&store.remoteNodeFinalStats, mw)
+	case RemoteNodeKeepAliveTimeout:
+		return store.registry.Add(&store.remoteNodeKeepAliveTimeout, mw)
+	case KeepAliveTimeoutVeto:
+		return store.registry.Add(&store.keepAliveTimeoutVeto, mw)
+	case RoutingTypeMapper:
+		return store.registry.Add(&store.routingTypeMapper, mw)
+	case MessageAcked:
+		return store.registry.Add(&store.messageAcked, mw)
+	case RemoteNodeMessageReceived:
+		return store.registry.Add(&store.remoteNodeMessageReceived, mw)
+	case RemoteNodeMessageSent:
+		return store.registry.Add(&store.remoteNodeMessageSent, mw)
+	case RemoteNodeReconnectAttempt:
+		return store.registry.Add(&store.remoteNodeReconnectAttempt, mw)
+	case DirectionFiltered:
+		unwrapped, err := mw.unwrap()
+		if err != nil {
+			return 0, err
 		}
-		store.remoteNodeDisconnected = append(store.remoteNodeDisconnected, mw)
-		middleware.Sort(store.remoteNodeDisconnected)
+		return store.AddMiddleware(unwrapped)
 	default:
-		return errors.New("unknown middleware type")
+		return 0, errors.New("unknown middleware type")
 	}
+}
 
+// RemoveMiddleware removes the middleware with id returned by AddMiddleware.
+// It can be called at any time, e.g. when a plugin is torn down, but an event
+// already being triggered may still call the removed middleware.
+func (store *middlewareStore) RemoveMiddleware(id middleware.ID) error {
+	if !store.registry.Remove(id) {
+		return errors.New("middleware not found")
+	}
 	return nil
 }
@@ -0,0 +1,346 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestMiddlewarePriority(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+
+	var order []int32
+	for _, priority := range []int32{0, 10, -10, 10} {
+		priority := priority
+		err := ln.ApplyMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+			order = append(order, priority)
+			return msg, true
+		}, priority})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, mw := range ln.middlewareStore.remoteNodeMessageReceived.Load().([]RemoteNodeMessageReceived) {
+		mw.Func(nil, nil)
+	}
+
+	expected := []int32{10, 10, 0, -10}
+	if len(order) != len(expected) {
+		t.Fatalf("middleware called in order %v, expecting %v", order, expected)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("middleware called in order %v, expecting %v", order, expected)
+		}
+	}
+}
+
+func TestMiddlewareAddRemoveConcurrent(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	var numCalled int64
+	stopChan := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// add and remove middleware while msg are being received and sent
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stopChan:
+					return
+				default:
+				}
+
+				id, err := peer.AddMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+					atomic.AddInt64(&numCalled, 1)
+					return msg, true
+				}, 0})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				id2, err := ln.AddMiddleware(RemoteNodeMessageSent{func(rn *RemoteNode, msg *protobuf.Message) bool {
+					return true
+				}, 0})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				time.Sleep(time.Millisecond)
+
+				if err = peer.RemoveMiddleware(id); err != nil {
+					t.Error(err)
+					return
+				}
+				if err = ln.RemoveMiddleware(id2); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		err := rn.SendMessageAsync(newTestMessage(t, ln, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recvTestMessage(t, peer, 5*time.Second)
+	}
+
+	close(stopChan)
+	wg.Wait()
+
+	if atomic.LoadInt64(&numCalled) == 0 {
+		t.Fatal("middleware added concurrently is never called")
+	}
+	if n := len(peer.middlewareStore.remoteNodeMessageReceived.Load().([]RemoteNodeMessageReceived)); n != 0 {
+		t.Fatalf("%d middleware left after all are removed", n)
+	}
+}
+
+func TestMiddlewareNilFunc(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+
+	for _, mw := range []interface{}{
+		RemoteNodeMessageReceived{},
+		RemoteNodeReady{},
+		DirectionFiltered{Direction: InboundConn, Middleware: RemoteNodeReady{}},
+	} {
+		_, err := ln.AddMiddleware(mw)
+		if err == nil {
+			t.Fatalf("middleware %T with nil func is added", mw)
+		}
+	}
+
+	if n := len(ln.middlewareStore.remoteNodeReady.Load().([]RemoteNodeReady)); n != 0 {
+		t.Fatalf("%d middleware with nil func are stored", n)
+	}
+}
+
+func TestApplyMiddlewareWithPriority(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+
+	var order []string
+	apply := func(name string, priority int) {
+		err := ln.ApplyMiddlewareWithPriority(RemoteNodeReady{func(rn *RemoteNode) bool {
+			order = append(order, name)
+			return true
+		}, 0}, priority)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// registered in arbitrary order, called by priority then registration
+	apply("log", 0)
+	apply("auth", 100)
+	apply("metrics", 0)
+	apply("ratelimit", 100)
+	apply("trace", -1)
+
+	for _, mw := range ln.middlewareStore.remoteNodeReady.Load().([]RemoteNodeReady) {
+		mw.Func(nil)
+	}
+
+	expected := []string{"auth", "ratelimit", "log", "metrics", "trace"}
+	if len(order) != len(expected) {
+		t.Fatalf("middleware called in order %v, expecting %v", order, expected)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("middleware called in order %v, expecting %v", order, expected)
+		}
+	}
+
+	err := ln.ApplyMiddlewareWithPriority(RemoteNodeReady{func(rn *RemoteNode) bool { return true }, 0}, 1<<40)
+	if err == nil {
+		t.Fatal("middleware with out of range priority is applied")
+	}
+}
+
+func TestDirectionFiltered(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	inboundPeer := newTestLocalNode(t, nil)
+
+	var lock sync.Mutex
+	called := make(map[string][]*RemoteNode)
+	record := func(name string, rn *RemoteNode) {
+		lock.Lock()
+		called[name] = append(called[name], rn)
+		lock.Unlock()
+	}
+
+	// inbound only middleware stops the chain for inbound remote nodes, while
+	// outbound remote nodes skip it and proceed to the next middleware
+	err := ln.ApplyMiddleware(DirectionFiltered{
+		Direction: InboundConn,
+		Middleware: RemoteNodeReady{func(rn *RemoteNode) bool {
+			record("inbound", rn)
+			return false
+		}, 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ln.ApplyMiddleware(DirectionFiltered{
+		Direction: OutboundConn,
+		Middleware: RemoteNodeReady{func(rn *RemoteNode) bool {
+			record("outbound", rn)
+			return true
+		}, 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ln.ApplyMiddleware(RemoteNodeReady{func(rn *RemoteNode) bool {
+		record("all", rn)
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// outbound only veto does not reject inbound conns
+	err = peer.ApplyMiddleware(DirectionFiltered{
+		Direction: OutboundConn,
+		Middleware: RemoteNodeConnectedVeto{func(rn *RemoteNode) (error, bool) {
+			return errors.New("outbound conn rejected"), false
+		}, 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outbound, _ := connectTestNodes(t, ln, peer)
+	_, inbound := connectTestNodes(t, inboundPeer, ln)
+
+	waitFor(t, time.Second, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(called["inbound"]) > 0 && len(called["all"]) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	expected := map[string][]*RemoteNode{
+		"inbound":  {inbound},
+		"outbound": {outbound},
+		"all":      {outbound},
+	}
+	for name, remoteNodes := range expected {
+		if len(called[name]) != len(remoteNodes) || called[name][0] != remoteNodes[0] {
+			t.Fatalf("%s middleware called with %v, expecting %v", name, called[name], remoteNodes)
+		}
+	}
+
+	_, err = ln.AddMiddleware(DirectionFiltered{
+		Direction: InboundConn,
+		Middleware: BytesReceived{func(data, msgID, srcID []byte, rn *RemoteNode) ([]byte, bool) {
+			return data, true
+		}, 0},
+	})
+	if err == nil {
+		t.Fatal("middleware type without direction is added with direction filter")
+	}
+}
+
+func TestRemoteNodeMessageMiddleware(t *testing.T) {
+	ln := newTestLocalNode(t, newSingleStreamConfig())
+	peer := newTestLocalNode(t, newSingleStreamConfig())
+
+	// drop or rewrite msg received by peer
+	err := peer.ApplyMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+		if msg.MessageType != protobuf.BYTES {
+			return msg, true
+		}
+		switch string(msg.Message) {
+		case "drop":
+			return nil, true
+		case "rewrite":
+			msg.Message = []byte("rewritten")
+		}
+		return msg, true
+	}, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	var seen []string
+	err = peer.ApplyMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+		if msg.MessageType == protobuf.BYTES {
+			lock.Lock()
+			seen = append(seen, string(msg.Message))
+			lock.Unlock()
+		}
+		return msg, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sentChan := make(chan *protobuf.Message, 8)
+	err = ln.ApplyMiddleware(RemoteNodeMessageSent{func(rn *RemoteNode, msg *protobuf.Message) bool {
+		if msg.MessageType == protobuf.BYTES {
+			sentChan <- msg
+		}
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	msgs := make([]*protobuf.Message, 0, 3)
+	for _, data := range []string{"drop", "rewrite", "keep"} {
+		msg := newTestMessage(t, ln, []byte(data))
+		err = rn.SendMessageAsync(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	// sent middleware is called for every msg written to conn
+	for _, msg := range msgs {
+		select {
+		case sent := <-sentChan:
+			if !bytes.Equal(sent.MessageId, msg.MessageId) {
+				t.Fatalf("sent middleware is called with msg %x, expecting %x", sent.MessageId, msg.MessageId)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("sent middleware is not called")
+		}
+	}
+
+	for _, expected := range []string{"rewritten", "keep"} {
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		if string(remoteMsg.Msg.Message) != expected {
+			t.Fatalf("peer receives %q, expecting %q", remoteMsg.Msg.Message, expected)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(seen) != 2 || seen[0] != "rewritten" || seen[1] != "keep" {
+		t.Fatalf("next middleware sees %v, expecting dropped msg skipped and rewritten msg passed on", seen)
+	}
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/message"
+)
+
+func TestCounterMessageID(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	if err := ln.SetMessageIDGenerator(nil); err == nil {
+		t.Fatal("set nil message id generator should fail")
+	}
+
+	prefix := ln.Id[:4]
+	err := ln.SetMessageIDGenerator(message.CounterIDGenerator(prefix, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(100); i < 103; i++ {
+		msg := newTestMessage(t, ln, []byte("hello"))
+		if !bytes.HasPrefix(msg.MessageId, prefix) || len(msg.MessageId) != len(prefix)+8 {
+			t.Fatalf("message id %x does not have prefix %x and 8 bytes counter", msg.MessageId, prefix)
+		}
+
+		n, err := message.IDToUint64(msg.MessageId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Fatalf("message id counter is %d, expecting %d", n, i)
+		}
+
+		// msg with counter id is sent like msg with random id
+		err = rn.SendMessageAsync(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		if !bytes.Equal(remoteMsg.Msg.MessageId, msg.MessageId) {
+			t.Fatalf("received msg id %x, expecting %x", remoteMsg.Msg.MessageId, msg.MessageId)
+		}
+	}
+
+	if _, err := message.IDToUint64([]byte{1, 2, 3}); err == nil {
+		t.Fatal("convert message id shorter than 8 bytes should fail")
+	}
+}
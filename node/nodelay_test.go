@@ -0,0 +1,91 @@
+package node
+
+import (
+	"net"
+	"testing"
+
+	"github.com/nknorg/nnet/config"
+)
+
+// testNoDelayConn records the last no delay option set on conn
+type testNoDelayConn struct {
+	net.Conn
+	noDelay []bool
+}
+
+func (conn *testNoDelayConn) SetNoDelay(noDelay bool) error {
+	conn.noDelay = append(conn.noDelay, noDelay)
+	return nil
+}
+
+func TestSetNoDelay(t *testing.T) {
+	for _, tcpDelay := range []bool{false, true} {
+		ln := newTestLocalNode(t, &config.Config{TCPDelay: tcpDelay})
+
+		conn, peerConn := net.Pipe()
+		defer peerConn.Close()
+		noDelayConn := &testNoDelayConn{Conn: conn}
+
+		rn, err := NewRemoteNode(ln, noDelayConn, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// default keeps the no delay option of conn unchanged
+		if tcpDelay {
+			if len(noDelayConn.noDelay) != 1 || noDelayConn.noDelay[0] {
+				t.Fatalf("no delay options set are %v with TCPDelay, expecting [false]", noDelayConn.noDelay)
+			}
+		} else if len(noDelayConn.noDelay) != 0 {
+			t.Fatalf("no delay options set are %v by default, expecting none", noDelayConn.noDelay)
+		}
+
+		// per remote node override
+		err = rn.SetNoDelay(!tcpDelay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := noDelayConn.noDelay[len(noDelayConn.noDelay)-1]; last != !tcpDelay {
+			t.Fatalf("no delay option is %v after override, expecting %v", last, !tcpDelay)
+		}
+	}
+}
+
+func TestSetNoDelayTCP(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	rn, err := NewRemoteNode(ln, conn, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, noDelay := range []bool{false, true} {
+		err = rn.SetNoDelay(noDelay)
+		if err != nil {
+			t.Fatalf("set no delay %v on TCP conn error: %v", noDelay, err)
+		}
+	}
+
+	// conn that does not support it is ignored
+	pipeConn, peerConn := net.Pipe()
+	defer peerConn.Close()
+	rn, err = NewRemoteNode(ln, pipeConn, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rn.SetNoDelay(false)
+	if err != nil {
+		t.Fatalf("set no delay on non-TCP conn error: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package node
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+	"github.com/nknorg/nnet/util"
+)
+
+const (
+	// How often to check if tx msg chan has room for paced msg
+	pacedMsgFeedInterval = 10 * time.Millisecond
+)
+
+// isBroadcastMsg returns if msg is routed by one of the broadcast routing
+// types
+func isBroadcastMsg(msg *protobuf.Message) bool {
+	switch msg.RoutingType {
+	case protobuf.BROADCAST_PUSH, protobuf.BROADCAST_PULL, protobuf.BROADCAST_TREE:
+		return true
+	default:
+		return false
+	}
+}
+
+// enqueuePaced adds broadcast msg to tx msg chan, or to paced msg chan if tx
+// msg chan is more than half full or there are earlier msg in paced msg chan
+// so that order is kept. Returns false if paced msg chan is full.
+func (rn *RemoteNode) enqueuePaced(msg *protobuf.Message) bool {
+	if len(rn.pacedMsgChan) == 0 && !rn.isTxMsgChanBusy() {
+		select {
+		case rn.getTxMsgChan() <- msg:
+			return true
+		default:
+		}
+	}
+
+	select {
+	case rn.pacedMsgChan <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTxMsgChanBusy returns if tx msg chan is more than half full, in which case
+// paced msg should wait so that other msg (e.g. ping, replies) still have room
+func (rn *RemoteNode) isTxMsgChanBusy() bool {
+	txMsgChan := rn.getTxMsgChan()
+	return len(txMsgChan) > cap(txMsgChan)/2
+}
+
+// pacedMsgDelay returns how long to wait after feeding a paced msg of size
+// bytes before feeding the next one, which is the time remote node needs to
+// accept it: size divided by the rate limit of remote node if set, otherwise
+// the measured write latency to remote node.
+func (rn *RemoteNode) pacedMsgDelay(size int) time.Duration {
+	if rate := rn.RateLimit(); rate > 0 {
+		return time.Duration(float64(size) / float64(rate) * float64(time.Second))
+	}
+	return time.Duration(atomic.LoadInt64(&rn.writeLatency))
+}
+
+// feedPacedMsg moves msg from paced msg chan to tx msg chan at the rate remote
+// node can accept (see pacedMsgDelay), and never when tx msg chan is busy, so
+// that a slow remote node receives broadcast msg at its capacity instead of
+// having them dropped.
+func (rn *RemoteNode) feedPacedMsg() {
+	defer rn.LocalNode.wg.Done()
+
+	var msg *protobuf.Message
+	var nextFeedTime time.Time
+	txMsgChan := rn.getTxMsgChan()
+	timer := time.NewTimer(pacedMsgFeedInterval)
+
+	for {
+		select {
+		case msg = <-rn.pacedMsgChan:
+		case <-rn.Done():
+			util.StopTimer(timer)
+			return
+		}
+
+		if delay := time.Until(nextFeedTime); delay > 0 {
+			util.ResetTimer(timer, delay)
+			select {
+			case <-timer.C:
+			case <-rn.Done():
+				util.StopTimer(timer)
+				return
+			}
+		}
+
+		for rn.isTxMsgChanBusy() {
+			util.ResetTimer(timer, pacedMsgFeedInterval)
+			select {
+			case <-timer.C:
+			case <-rn.Done():
+				util.StopTimer(timer)
+				return
+			}
+		}
+
+		select {
+		case txMsgChan <- msg:
+			updateWatermark(&rn.txMsgChanWatermark, len(txMsgChan))
+			nextFeedTime = time.Now().Add(rn.pacedMsgDelay(msg.Size()))
+		case <-rn.Done():
+			util.StopTimer(timer)
+			return
+		}
+	}
+}
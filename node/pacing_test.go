@@ -0,0 +1,109 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// countBroadcastMsg counts msg of routing type BROADCAST_PUSH received by ln
+// in background, and returns a func that returns the count so far
+func countBroadcastMsg(t *testing.T, ln *LocalNode) func() int {
+	rxMsgChan, err := ln.GetRxMsgChan(protobuf.BROADCAST_PUSH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countChan := make(chan int, 1)
+	countChan <- 0
+
+	go func() {
+		for {
+			select {
+			case <-rxMsgChan:
+				countChan <- <-countChan + 1
+			case <-ln.Done():
+				return
+			}
+		}
+	}()
+
+	return func() int {
+		n := <-countChan
+		countChan <- n
+		return n
+	}
+}
+
+func TestBroadcastPacing(t *testing.T) {
+	const numMsgs = 30
+	const msgSize = 500
+	const slowRate = 5000
+
+	ln := newTestLocalNode(t, &config.Config{
+		RemoteTxMsgChanLen:       10,
+		RemoteBroadcastPacingLen: numMsgs,
+	})
+	fastPeer := newTestLocalNode(t, nil)
+	slowPeer := newTestLocalNode(t, nil)
+
+	fastRn, _ := connectTestNodes(t, ln, fastPeer)
+	slowRn, _ := connectTestNodes(t, ln, slowPeer)
+	slowRn.SetRateLimit(slowRate)
+
+	numFastRx := countBroadcastMsg(t, fastPeer)
+	numSlowRx := countBroadcastMsg(t, slowPeer)
+
+	startTime := time.Now()
+	for i := 0; i < numMsgs; i++ {
+		msg := newTestMessage(t, ln, make([]byte, msgSize))
+		msg.RoutingType = protobuf.BROADCAST_PUSH
+		errs := ln.SendMessageToMany([]*RemoteNode{fastRn, slowRn}, msg)
+		if len(errs) > 0 {
+			t.Fatalf("broadcast msg %d error: %v", i, errs)
+		}
+	}
+
+	waitFor(t, time.Second, func() bool {
+		return numFastRx() == numMsgs
+	})
+	fastTime := time.Since(startTime)
+
+	if numSlowRx() == numMsgs {
+		t.Fatalf("slow peer received all msg as fast as fast peer in %v", fastTime)
+	}
+
+	// slow peer is fed at its rate limit, after the initial burst allowed by
+	// rate limiter
+	minSlowTime := time.Duration(float64(numMsgs*msgSize-slowRate) / slowRate * float64(time.Second))
+	waitFor(t, 2*minSlowTime+time.Second, func() bool {
+		return numSlowRx() == numMsgs
+	})
+	if slowTime := time.Since(startTime); slowTime < minSlowTime/2 {
+		t.Fatalf("slow peer received all msg in %v, expecting at least %v", slowTime, minSlowTime/2)
+	}
+
+	for _, rn := range []*RemoteNode{fastRn, slowRn} {
+		if dropped := rn.Stats().TxHealth.Dropped; dropped > 0 {
+			t.Fatalf("%d msg to remote node %v are dropped", dropped, rn)
+		}
+	}
+}
+
+func TestPacedMsgDelay(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	rn := newTestIdleRemoteNode(t, ln)
+
+	rn.SetRateLimit(1000)
+	if delay := rn.pacedMsgDelay(500); delay != 500*time.Millisecond {
+		t.Fatalf("paced msg delay is %v with rate limit, expecting %v", delay, 500*time.Millisecond)
+	}
+
+	rn.SetRateLimit(0)
+	rn.updateWriteLatency(3 * time.Millisecond)
+	if delay := rn.pacedMsgDelay(500); delay != 3*time.Millisecond {
+		t.Fatalf("paced msg delay is %v without rate limit, expecting write latency %v", delay, 3*time.Millisecond)
+	}
+}
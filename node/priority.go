@@ -0,0 +1,69 @@
+package node
+
+import (
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// isPriorityMsg returns if msg should be sent before other msg queued to be
+// sent, which includes node control msg (e.g. ping) and replies as they are
+// latency sensitive
+func isPriorityMsg(msg *protobuf.Message) bool {
+	return isNodeControlMsg(msg) || len(msg.ReplyToId) > 0
+}
+
+// enqueuePriority adds msg to tx priority chan if it is a priority msg and
+// there is room. Returns false if msg is not added, in which case it should be
+// added to tx msg chan instead.
+func (rn *RemoteNode) enqueuePriority(msg *protobuf.Message) bool {
+	if !isPriorityMsg(msg) {
+		return false
+	}
+
+	select {
+	case rn.priorityChan <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// pollTxMsg returns the next msg to send without blocking, or nil if both tx
+// priority chan and tx msg chan are empty. Msg in tx priority chan are
+// returned first, but after RemoteTxPriorityRatio consecutive priority msg, a
+// msg in tx msg chan is returned if there is any so that it is not starved.
+// numPriority is the number of consecutive priority msg returned, which is
+// updated by this call.
+func (rn *RemoteNode) pollTxMsg(numPriority *uint32) *protobuf.Message {
+	txMsgChan := rn.getTxMsgChan()
+
+	if *numPriority >= rn.LocalNode.RemoteTxPriorityRatio {
+		*numPriority = 0
+		select {
+		case msg := <-txMsgChan:
+			return msg
+		default:
+		}
+	}
+
+	select {
+	case msg := <-rn.priorityChan:
+		*numPriority++
+		return msg
+	default:
+	}
+
+	select {
+	case msg := <-txMsgChan:
+		*numPriority = 0
+		return msg
+	default:
+	}
+
+	return nil
+}
+
+// numTxQueued returns the number of msg queued to be sent to remote node in
+// all tx chans
+func (rn *RemoteNode) numTxQueued() int {
+	return len(rn.priorityChan) + len(rn.getTxMsgChan()) + len(rn.pacedMsgChan)
+}
@@ -0,0 +1,194 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/nknorg/nnet/util"
+)
+
+const (
+	// Jitter of reconnect delay as a fraction of the delay
+	reconnectDelayJitter = 0.2
+)
+
+// SetAutoReconnect enables or disables auto reconnect of an outbound remote
+// node. In-process loopback remote node is never reconnected. When enabled, if
+// remote node stops with an error (e.g. network error or keepalive timeout),
+// local node dials the same address again with exponential backoff, starting
+// from ReconnectBaseDelay (plus a random delay up to ReconnectInitialJitter)
+// and doubling up to ReconnectMaxDelay, until it succeeds, ReconnectMaxRetries
+// is reached, local node stops or the reconnect is canceled by
+// CancelReconnect. Remote node stopped without an error (e.g. by Stop(nil) or
+// StopGracefully) is not reconnected. The new remote node has auto reconnect
+// enabled as well.
+func (rn *RemoteNode) SetAutoReconnect(enabled bool) error {
+	if !rn.IsOutbound {
+		return errors.New("Auto reconnect is only supported for outbound remote node")
+	}
+
+	rn.Lock()
+	rn.autoReconnect = enabled
+	rn.Unlock()
+
+	return nil
+}
+
+// IsAutoReconnect returns if auto reconnect is enabled for remote node
+func (rn *RemoteNode) IsAutoReconnect() bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.autoReconnect
+}
+
+// CancelReconnect cancels the pending auto reconnect to remoteNodeAddr.
+// Returns false if there is no pending reconnect to it.
+func (ln *LocalNode) CancelReconnect(remoteNodeAddr string) bool {
+	ln.reconnectLock.Lock()
+	defer ln.reconnectLock.Unlock()
+
+	cancel, ok := ln.reconnects[remoteNodeAddr]
+	if !ok {
+		return false
+	}
+
+	close(cancel)
+	delete(ln.reconnects, remoteNodeAddr)
+
+	return true
+}
+
+// PendingReconnects returns the addresses that local node is trying to
+// reconnect to
+func (ln *LocalNode) PendingReconnects() []string {
+	ln.reconnectLock.Lock()
+	defer ln.reconnectLock.Unlock()
+
+	addrs := make([]string, 0, len(ln.reconnects))
+	for addr := range ln.reconnects {
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+// scheduleReconnect starts to reconnect to remoteNodeAddr in background if
+// there is no pending reconnect to it
+func (ln *LocalNode) scheduleReconnect(remoteNodeAddr string) {
+	ln.reconnectLock.Lock()
+	defer ln.reconnectLock.Unlock()
+
+	if _, ok := ln.reconnects[remoteNodeAddr]; ok {
+		return
+	}
+
+	cancel := make(chan struct{})
+	ln.reconnects[remoteNodeAddr] = cancel
+
+	ln.wg.Add(1)
+	go ln.reconnect(remoteNodeAddr, cancel)
+}
+
+// finishReconnect removes the pending reconnect to remoteNodeAddr if it has not
+// been canceled
+func (ln *LocalNode) finishReconnect(remoteNodeAddr string, cancel chan struct{}) {
+	ln.reconnectLock.Lock()
+	defer ln.reconnectLock.Unlock()
+
+	if ln.reconnects[remoteNodeAddr] == cancel {
+		delete(ln.reconnects, remoteNodeAddr)
+	}
+}
+
+// reconnectDelay returns the delay before the attempt-th (starting from 1)
+// reconnect attempt, with jitter so that nodes disconnected at the same time do
+// not reconnect at the same time. The first attempt has an extra random delay
+// up to ReconnectInitialJitter, as the backoff jitter alone is too small to
+// spread out the first attempts of many nodes that lose the same peer at the
+// same time.
+func (ln *LocalNode) reconnectDelay(attempt uint32) time.Duration {
+	delay := ln.ReconnectBaseDelay
+	for i := uint32(1); i < attempt && delay < ln.ReconnectMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > ln.ReconnectMaxDelay {
+		delay = ln.ReconnectMaxDelay
+	}
+
+	delay = util.RandDuration(delay, reconnectDelayJitter)
+
+	if attempt == 1 && ln.ReconnectInitialJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(ln.ReconnectInitialJitter)))
+	}
+
+	return delay
+}
+
+// reconnect dials remoteNodeAddr with exponential backoff until remote node
+// becomes ready, max retries is reached, local node stops or cancel is closed
+func (ln *LocalNode) reconnect(remoteNodeAddr string, cancel chan struct{}) {
+	defer ln.wg.Done()
+	defer ln.finishReconnect(remoteNodeAddr, cancel)
+
+	timer := time.NewTimer(0)
+	util.StopTimer(timer)
+
+	for attempt := uint32(1); ln.ReconnectMaxRetries == 0 || attempt <= ln.ReconnectMaxRetries; attempt++ {
+		util.ResetTimer(timer, ln.reconnectDelay(attempt))
+		select {
+		case <-timer.C:
+		case <-cancel:
+			util.StopTimer(timer)
+			return
+		case <-ln.Done():
+			util.StopTimer(timer)
+			return
+		}
+
+		err := ln.reconnectOnce(remoteNodeAddr, cancel)
+
+		for _, mw := range ln.middlewareStore.remoteNodeReconnectAttempt.Load().([]RemoteNodeReconnectAttempt) {
+			if !mw.Func(remoteNodeAddr, attempt, err) {
+				break
+			}
+		}
+
+		if err == nil {
+			ln.logger.Infof("Reconnected to %s after %d attempts", remoteNodeAddr, attempt)
+			return
+		}
+
+		ln.logger.Warningf("Reconnect to %s attempt %d error: %v", remoteNodeAddr, attempt, err)
+	}
+
+	ln.logger.Warningf("Give up reconnecting to %s after %d attempts", remoteNodeAddr, ln.ReconnectMaxRetries)
+}
+
+// reconnectOnce connects to remoteNodeAddr and waits until remote node is
+// ready, enabling auto reconnect of the new remote node
+func (ln *LocalNode) reconnectOnce(remoteNodeAddr string, cancel chan struct{}) error {
+	remoteNode, _, err := ln.Connect(remoteNodeAddr)
+	if err != nil {
+		return err
+	}
+	if remoteNode == nil {
+		return fmt.Errorf("Another goroutine is connecting to %s", remoteNodeAddr)
+	}
+
+	if remoteNode.IsOutbound {
+		remoteNode.SetAutoReconnect(true)
+	}
+
+	select {
+	case <-remoteNode.Ready():
+		return nil
+	case <-remoteNode.Done():
+		return fmt.Errorf("Remote node stopped before ready: %v", remoteNode.StopReason())
+	case <-cancel:
+		return errors.New("Reconnect canceled")
+	case <-ln.Done():
+		return errors.New("Local node has stopped")
+	}
+}
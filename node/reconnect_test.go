@@ -0,0 +1,102 @@
+package node
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestReconnectDelayInitialJitter(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		ReconnectBaseDelay:     100 * time.Millisecond,
+		ReconnectInitialJitter: time.Second,
+	})
+
+	minDelay, maxDelay := time.Duration(1<<62), time.Duration(0)
+	for i := 0; i < 100; i++ {
+		delay := ln.reconnectDelay(1)
+		if delay < 80*time.Millisecond || delay > 1120*time.Millisecond {
+			t.Fatalf("first reconnect delay %v out of range", delay)
+		}
+		if delay < minDelay {
+			minDelay = delay
+		}
+		if delay > maxDelay {
+			maxDelay = delay
+		}
+
+		// later attempts only have backoff jitter
+		delay = ln.reconnectDelay(2)
+		if delay < 160*time.Millisecond || delay > 240*time.Millisecond {
+			t.Fatalf("second reconnect delay %v out of range", delay)
+		}
+	}
+
+	if maxDelay-minDelay < 500*time.Millisecond {
+		t.Fatalf("first reconnect delays are within %v, expecting spread over initial jitter", maxDelay-minDelay)
+	}
+}
+
+func TestReconnectNotSynchronized(t *testing.T) {
+	const numNodes = 5
+	const initialJitter = 500 * time.Millisecond
+
+	hub := newTestLocalNode(t, nil)
+
+	var lock sync.Mutex
+	var attemptTimes []time.Time
+	remoteNodes := make([]*RemoteNode, 0, numNodes)
+
+	for i := 0; i < numNodes; i++ {
+		ln := newTestLocalNode(t, &config.Config{
+			ReconnectBaseDelay:     10 * time.Millisecond,
+			ReconnectInitialJitter: initialJitter,
+		})
+		err := ln.ApplyMiddleware(RemoteNodeReconnectAttempt{func(remoteNodeAddr string, attempt uint32, err error) bool {
+			if attempt == 1 {
+				lock.Lock()
+				attemptTimes = append(attemptTimes, time.Now())
+				lock.Unlock()
+			}
+			return true
+		}, 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rn, _ := connectTestNodes(t, ln, hub)
+		err = rn.SetAutoReconnect(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		remoteNodes = append(remoteNodes, rn)
+	}
+
+	// all nodes lose the hub at the same time
+	for _, rn := range remoteNodes {
+		go rn.Stop(errors.New("connection lost"))
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(attemptTimes) == numNodes
+	})
+
+	first, last := attemptTimes[0], attemptTimes[0]
+	for _, attemptTime := range attemptTimes {
+		if attemptTime.Before(first) {
+			first = attemptTime
+		}
+		if attemptTime.After(last) {
+			last = attemptTime
+		}
+	}
+
+	if last.Sub(first) < initialJitter/10 {
+		t.Fatalf("first reconnect of %d nodes are all within %v", numNodes, last.Sub(first))
+	}
+}
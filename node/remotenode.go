@@ -1,17 +1,22 @@
 package node
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/binary"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/nknorg/nnet/cache"
-	"github.com/nknorg/nnet/log"
 	"github.com/nknorg/nnet/multiplexer"
 	"github.com/nknorg/nnet/protobuf"
 	"github.com/nknorg/nnet/transport"
@@ -22,23 +27,133 @@ const (
 	// A grace period that allows remote node to send messages in queue
 	stopGracePeriod = 100 * time.Millisecond
 
+	// How often to check if tx msg chan is drained when stopping gracefully
+	gracefulStopPollInterval = 10 * time.Millisecond
+
 	// Number of retries to get remote node when remote node starts
 	startRetries = 3
+
+	// Weight of a new sample in the moving average of round trip time
+	roundTripTimeSampleWeight = 0.25
 )
 
+// SessionParams is the parameters of the session with a remote node, which are
+// determined when the remote node becomes ready
+type SessionParams struct {
+	Transport      string // transport of the connection, e.g. tcp, kcp
+	Multiplexer    string // multiplexer used on the connection, e.g. smux, yamux
+	MaxMessageSize uint32 // max message size in bytes
+	RemoteAddr     string // address of the remote node, e.g. tcp://127.0.0.1:30001
+	Compression    string // compression codec for msg sent to remote node, e.g. none, gzip
+	MessageCodec   string // codec to encode msg sent to remote node, e.g. protobuf, json
+}
+
+// SetupTimings is the time spent in each phase of setting up the connection
+// with a remote node. Phases that do not apply to the connection (e.g. Dial for
+// inbound connection, TLSHandshake for non-TLS connection) are zero. GetNode
+// starts concurrently with TLS handshake and multiplexer setup, so it overlaps
+// with them and the phases do not add up to Total.
+type SetupTimings struct {
+	Dial         time.Duration // dial remote address, outbound only
+	TLSHandshake time.Duration // TLS handshake, TLS connection only
+	Multiplexer  time.Duration // create multiplexer and open or accept the first stream
+	GetNode      time.Duration // get node info of remote node, including retries
+	Total        time.Duration // from dial (or remote node creation if not dialed) to ready
+}
+
+// TLSHandshakeError is the error that remote node stops with if conn is a TLS
+// conn and TLS handshake fails or does not complete within TLSHandshakeTimeout
+type TLSHandshakeError struct {
+	Err error
+}
+
+func (e *TLSHandshakeError) Error() string {
+	return fmt.Sprintf("TLS handshake error: %s", e.Err)
+}
+
 // RemoteNode is a remote node
 type RemoteNode struct {
-	*Node
-	LocalNode  *LocalNode
-	IsOutbound bool
-	conn       net.Conn
-	rxMsgChan  chan *protobuf.Message
-	txMsgChan  chan *protobuf.Message
-	txMsgCache cache.Cache
+	bytesRx            uint64 // accessed atomically, keep 64-bit aligned
+	bytesTx            uint64 // accessed atomically, keep 64-bit aligned
+	msgRx              uint64 // accessed atomically, keep 64-bit aligned
+	msgTx              uint64 // accessed atomically, keep 64-bit aligned
+	msgDropped         uint64 // accessed atomically, keep 64-bit aligned
+	rxDropped          uint64 // accessed atomically, keep 64-bit aligned
+	txDropped          uint64 // accessed atomically, keep 64-bit aligned
+	readErrors         uint64 // accessed atomically, keep 64-bit aligned
+	writeErrors        uint64 // accessed atomically, keep 64-bit aligned
+	rxRateLimited      uint64 // accessed atomically, keep 64-bit aligned
+	writeLatency       int64  // accessed atomically, keep 64-bit aligned
+	rxMsgChanWatermark uint32 // accessed atomically
+	txMsgChanWatermark uint32 // accessed atomically
 
+	*Node
+	LocalNode     *LocalNode
+	IsOutbound    bool
+	conn          net.Conn
+	rxMsgChan     chan *protobuf.Message // replaced only before start, use getRxMsgChan if remote node may not have started
+	txMsgChan     chan *protobuf.Message // replaced only before start, use getTxMsgChan if remote node may not have started
+	priorityChan  chan *protobuf.Message // node control msg and replies sent before msg in txMsgChan
+	pacedMsgChan  chan *protobuf.Message // broadcast msg waiting for room in txMsgChan
+	txMsgCache    cache.Cache
+	appStreamChan chan net.Conn
+	readyChan     chan struct{}
+	journal       *journal
+	rateLimiter   *util.RateLimiter
+	rxMsgLimiter  *util.RateLimiter
+	rxByteLimiter *util.RateLimiter
+	sendBudget    *sendBudget
+	txQueue       txQueue
+	batchLock     sync.Mutex // serializes SendBatch calls, not held by other send methods
+
+	// onFrameComplete, if not nil, is called in rx each time a full frame is
+	// read from conn, before it is unmarshaled. Frame size is msg len.
+	onFrameComplete func(size int)
+
+	// loopbackPeer is the remote node on the other side of an in-process
+	// loopback connection, nil if connection is not in-process
+	loopbackPeer *RemoteNode
+
+	// securityLevel and transportType describe the conn, set when remote node
+	// is created and never changed
+	securityLevel SecurityLevel
+	transportType string
+
+	// dialAddr is the address dialed to create outbound remote node, used to
+	// reconnect, empty for inbound or in-process loopback remote node
+	dialAddr string
+
+	// createdTime is when remote node is created, used to compute throughput
+	createdTime time.Time
+
+	// lastRxTime and lastTxTime are only compared with time.Since, which uses
+	// the monotonic clock reading of time.Now, so wall clock jumps do not
+	// cause false keepalive timeout or stall. Do not strip the monotonic
+	// reading (e.g. Round(0)) or persist them.
 	sync.RWMutex
-	lastRxTime    time.Time
-	roundTripTime time.Duration
+	lastRxTime        time.Time
+	lastTxTime        time.Time
+	lastActiveTime    time.Time
+	idleExempt        bool
+	roundTripTime     time.Duration
+	sessionParams     SessionParams
+	setupTimings      SetupTimings
+	publicKey         []byte
+	stopReason        error
+	stoppedTime       time.Time
+	stopping          bool
+	unmarshalErrCount uint32
+	unmarshalErrStart time.Time
+	compression       string
+	msgCodec          string
+	keepAliveDisabled bool
+	frameTimeout      time.Duration
+	autoReconnect     bool
+	draining          bool
+	drainStartTime    time.Time
+	started           bool
+	mux               multiplexer.Multiplexer
+	pendingAppStreams map[string]chan net.Conn
 }
 
 // NewRemoteNode creates a remote node
@@ -57,15 +172,50 @@ func NewRemoteNode(localNode *LocalNode, conn net.Conn, isOutbound bool) (*Remot
 
 	txMsgCache := cache.NewGoCache(localNode.RemoteTxMsgCacheExpiration, localNode.RemoteTxMsgCacheCleanupInterval)
 
+	var msgJournal *journal
+	if localNode.RemoteMsgJournalSize > 0 {
+		msgJournal = newJournal(localNode.RemoteMsgJournalSize)
+	}
+
 	remoteNode := &RemoteNode{
-		Node:       node,
-		LocalNode:  localNode,
-		conn:       conn,
-		IsOutbound: isOutbound,
-		rxMsgChan:  make(chan *protobuf.Message, localNode.RemoteRxMsgChanLen),
-		txMsgChan:  make(chan *protobuf.Message, localNode.RemoteTxMsgChanLen),
-		txMsgCache: txMsgCache,
-		lastRxTime: time.Now(),
+		Node:              node,
+		LocalNode:         localNode,
+		conn:              conn,
+		IsOutbound:        isOutbound,
+		rxMsgChan:         make(chan *protobuf.Message, localNode.RemoteRxMsgChanLen),
+		txMsgChan:         make(chan *protobuf.Message, localNode.RemoteTxMsgChanLen),
+		priorityChan:      make(chan *protobuf.Message, localNode.RemoteTxPriorityChanLen),
+		pacedMsgChan:      make(chan *protobuf.Message, localNode.RemoteBroadcastPacingLen),
+		txMsgCache:        txMsgCache,
+		appStreamChan:     make(chan net.Conn, appStreamChanLen),
+		readyChan:         make(chan struct{}),
+		journal:           msgJournal,
+		rateLimiter:       util.NewRateLimiter(localNode.RateLimit),
+		rxMsgLimiter:      util.NewRateLimiter(localNode.RxRateLimitMsgs),
+		rxByteLimiter:     util.NewRateLimiter(localNode.RxRateLimitBytes),
+		sendBudget:        newSendBudget(localNode.SendBudget, localNode.SendBudgetWindow),
+		compression:       localNode.initialCompression(),
+		msgCodec:          msgCodecProtobuf,
+		keepAliveDisabled: localNode.DisableKeepAliveTimeout,
+		frameTimeout:      localNode.FrameAssemblyTimeout,
+		lastRxTime:        time.Now(),
+		lastTxTime:        time.Now(),
+		lastActiveTime:    time.Now(),
+		pendingAppStreams: make(map[string]chan net.Conn),
+		createdTime:       time.Now(),
+		securityLevel:     connSecurityLevel(conn),
+		transportType:     localNode.address.Transport.String(),
+	}
+
+	if _, ok := conn.(*loopbackConn); ok {
+		remoteNode.transportType = transportLoopback
+	}
+
+	if localNode.TCPDelay {
+		err = remoteNode.SetNoDelay(false)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return remoteNode, nil
@@ -78,11 +228,269 @@ func (rn *RemoteNode) String() string {
 	return fmt.Sprintf("%v<%s>", rn.Node, rn.conn.RemoteAddr().String())
 }
 
+// ConnRemoteAddr returns the remote address of the underlying conn, which is
+// the actual address remote node connects from and may differ from the
+// advertised address in node info (e.g. behind NAT). It can be used by
+// middleware to allow or deny remote node by IP. Returns nil if remote node
+// has no conn.
+func (rn *RemoteNode) ConnRemoteAddr() net.Addr {
+	if rn.conn == nil {
+		return nil
+	}
+	return rn.conn.RemoteAddr()
+}
+
+// ConnLocalAddr returns the local address of the underlying conn, or nil if
+// remote node has no conn
+func (rn *RemoteNode) ConnLocalAddr() net.Addr {
+	if rn.conn == nil {
+		return nil
+	}
+	return rn.conn.LocalAddr()
+}
+
+// Ready returns a channel that is closed when remote node becomes ready
+func (rn *RemoteNode) Ready() <-chan struct{} {
+	return rn.readyChan
+}
+
 // GetConn returns the connection with remote node
 func (rn *RemoteNode) GetConn() net.Conn {
 	return rn.conn
 }
 
+// SetNoDelay controls whether the operating system should delay packet
+// transmission in hopes of sending fewer packets (Nagle's algorithm) on the
+// conn with remote node. Default is true (no delay) unless TCPDelay is set in
+// config. Does nothing if conn does not support it, e.g. not a TCP conn.
+func (rn *RemoteNode) SetNoDelay(noDelay bool) error {
+	conn, ok := rn.conn.(interface {
+		SetNoDelay(bool) error
+	})
+	if !ok {
+		return nil
+	}
+	return conn.SetNoDelay(noDelay)
+}
+
+// SessionParams returns the parameters of the session with remote node. Will
+// return zero value if remote node is not ready yet.
+func (rn *RemoteNode) SessionParams() SessionParams {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.sessionParams
+}
+
+// PublicKey returns the public key of remote node in PKIX, ASN.1 DER form. It
+// is only available after TLS handshake succeeds and remote node presents a
+// certificate, otherwise returns nil. The certificate is verified by TLS
+// config of the conn, so callers should make sure it verifies peer
+// certificates before using the key for authorization.
+func (rn *RemoteNode) PublicKey() []byte {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.publicKey
+}
+
+// SetupTimings returns the time spent in each setup phase of remote node.
+// Phases that have not completed yet are zero, and Total is zero if remote
+// node is not ready yet.
+func (rn *RemoteNode) SetupTimings() SetupTimings {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.setupTimings
+}
+
+// Compression returns the compression codec used for msg sent to remote node
+func (rn *RemoteNode) Compression() string {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.compression
+}
+
+// SetCompression sets the compression codec (e.g. none, gzip, flatedict) used
+// for msg sent to remote node, overriding the default one in config. Remote
+// node needs to support decompression of codec, and have the same
+// CompressionDictionary if codec is flatedict. Can be called in RemoteNodeReady
+// middleware to set compression based on remote node.
+func (rn *RemoteNode) SetCompression(codec string) error {
+	err := checkCompression(codec, rn.LocalNode.CompressionDictionary)
+	if err != nil {
+		return err
+	}
+
+	rn.Lock()
+	rn.compression = codec
+	rn.Unlock()
+
+	return nil
+}
+
+// MessageCodec returns the codec used to encode msg sent to remote node, which
+// is negotiated at handshake and is protobuf before that
+func (rn *RemoteNode) MessageCodec() string {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.msgCodec
+}
+
+// RateLimit returns the max bytes per second sent to remote node, 0 means
+// unlimited
+func (rn *RemoteNode) RateLimit() uint32 {
+	return rn.rateLimiter.Rate()
+}
+
+// SetRateLimit sets the max bytes per second sent to remote node, overriding
+// the default one in config. 0 means unlimited. Can be called in
+// RemoteNodeReady middleware to set rate limit based on remote node.
+func (rn *RemoteNode) SetRateLimit(bytesPerSec uint32) {
+	rn.rateLimiter.SetRate(bytesPerSec)
+}
+
+// RxMsgChanLen returns the max number of msg received from remote node that
+// can be buffered
+func (rn *RemoteNode) RxMsgChanLen() uint32 {
+	rn.RLock()
+	defer rn.RUnlock()
+	return uint32(cap(rn.rxMsgChan))
+}
+
+// SetRxMsgChanLen sets the max number of msg received from remote node that
+// can be buffered, overriding RemoteRxMsgChanLen in config. It can only be
+// called before remote node starts, e.g. in RemoteNodeConnected middleware.
+func (rn *RemoteNode) SetRxMsgChanLen(length uint32) error {
+	if length == 0 {
+		return errors.New("Rx msg chan len should be greater than 0")
+	}
+
+	rn.Lock()
+	defer rn.Unlock()
+
+	if rn.started {
+		return errors.New("Cannot set rx msg chan len after remote node starts")
+	}
+
+	if uint32(len(rn.rxMsgChan)) > length {
+		return fmt.Errorf("Rx msg chan len %d is less than number of buffered msg %d", length, len(rn.rxMsgChan))
+	}
+
+	rxMsgChan := make(chan *protobuf.Message, length)
+	for len(rn.rxMsgChan) > 0 {
+		rxMsgChan <- <-rn.rxMsgChan
+	}
+	rn.rxMsgChan = rxMsgChan
+
+	return nil
+}
+
+// getRxMsgChan returns rx msg chan, which is safe to call concurrently with
+// SetRxMsgChanLen
+func (rn *RemoteNode) getRxMsgChan() chan *protobuf.Message {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.rxMsgChan
+}
+
+// TxMsgChanLen returns the max number of msg to be sent to remote node that
+// can be buffered
+func (rn *RemoteNode) TxMsgChanLen() uint32 {
+	rn.RLock()
+	defer rn.RUnlock()
+	return uint32(cap(rn.txMsgChan))
+}
+
+// SetTxMsgChanLen sets the max number of msg to be sent to remote node that
+// can be buffered, overriding RemoteTxMsgChanLen in config. It can only be
+// called before remote node starts, e.g. in RemoteNodeConnected middleware.
+func (rn *RemoteNode) SetTxMsgChanLen(length uint32) error {
+	if length == 0 {
+		return errors.New("Tx msg chan len should be greater than 0")
+	}
+
+	rn.Lock()
+	defer rn.Unlock()
+
+	if rn.started {
+		return errors.New("Cannot set tx msg chan len after remote node starts")
+	}
+
+	if uint32(len(rn.txMsgChan)) > length {
+		return fmt.Errorf("Tx msg chan len %d is less than number of buffered msg %d", length, len(rn.txMsgChan))
+	}
+
+	txMsgChan := make(chan *protobuf.Message, length)
+	for len(rn.txMsgChan) > 0 {
+		txMsgChan <- <-rn.txMsgChan
+	}
+	rn.txMsgChan = txMsgChan
+
+	return nil
+}
+
+// getTxMsgChan returns tx msg chan, which is safe to call concurrently with
+// SetTxMsgChanLen
+func (rn *RemoteNode) getTxMsgChan() chan *protobuf.Message {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.txMsgChan
+}
+
+// DroppedRxCount returns the number of msg received from remote node that are
+// discarded because rx msg chan is full
+func (rn *RemoteNode) DroppedRxCount() uint64 {
+	return atomic.LoadUint64(&rn.rxDropped)
+}
+
+// StopReason returns the error that remote node stops with. Will return nil if
+// remote node is not stopped or stopped without error.
+func (rn *RemoteNode) StopReason() error {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.stopReason
+}
+
+// IsDraining returns if remote node has notified us that it is draining. New
+// messages should not be routed to a draining remote node.
+func (rn *RemoteNode) IsDraining() bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.draining
+}
+
+// setDraining marks remote node as draining
+func (rn *RemoteNode) setDraining() {
+	rn.Lock()
+	rn.draining = true
+	rn.startDrainLocked()
+	rn.Unlock()
+}
+
+// startDrainLocked records the time when connection starts draining, either
+// because remote node is draining or we are. Caller should hold the lock.
+func (rn *RemoteNode) startDrainLocked() {
+	if rn.drainStartTime.IsZero() {
+		rn.drainStartTime = time.Now()
+	}
+}
+
+// isConnDraining returns if connection is draining on either side
+func (rn *RemoteNode) isConnDraining() bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return !rn.drainStartTime.IsZero()
+}
+
+// isDrainTimeout returns if connection has been draining for longer than
+// DrainTimeout
+func (rn *RemoteNode) isDrainTimeout() bool {
+	if rn.LocalNode.DrainTimeout == 0 {
+		return false
+	}
+	rn.RLock()
+	defer rn.RUnlock()
+	return !rn.drainStartTime.IsZero() && time.Since(rn.drainStartTime) > rn.LocalNode.DrainTimeout
+}
+
 // GetRoundTripTime returns the measured round trip time between local node and
 // remote node. Will return 0 if no result available yet.
 func (rn *RemoteNode) GetRoundTripTime() time.Duration {
@@ -91,6 +499,75 @@ func (rn *RemoteNode) GetRoundTripTime() time.Duration {
 	return rn.roundTripTime
 }
 
+// RoundTripTime returns the exponentially weighted moving average of round
+// trip time between local node and remote node, measured by keepalive ping and
+// SendMessageSyncTimed. The bool is false if no measurement has succeeded yet.
+// A reconnect creates a new remote node, so the estimate starts over.
+func (rn *RemoteNode) RoundTripTime() (time.Duration, bool) {
+	roundTripTime := rn.GetRoundTripTime()
+	return roundTripTime, roundTripTime > 0
+}
+
+// IsKeepAliveTimeoutEnabled returns if remote node will be stopped when
+// nothing is received from it for KeepAliveTimeout
+func (rn *RemoteNode) IsKeepAliveTimeoutEnabled() bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return !rn.keepAliveDisabled
+}
+
+// SetKeepAliveTimeoutEnabled enables or disables keepalive timeout of remote
+// node, overriding DisableKeepAliveTimeout in config. When disabled, a dead
+// remote node that stops responding without closing the connection is only
+// detected by transport, e.g. TCP keepalive or write error, which may take much
+// longer or never happen. Should only be disabled for trusted, reliable links.
+func (rn *RemoteNode) SetKeepAliveTimeoutEnabled(enabled bool) {
+	rn.Lock()
+	rn.keepAliveDisabled = !enabled
+	rn.Unlock()
+}
+
+// FrameAssemblyTimeout returns the max time to receive a full msg from remote
+// node after its length prefix is received, 0 means no limit
+func (rn *RemoteNode) FrameAssemblyTimeout() time.Duration {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.frameTimeout
+}
+
+// SetFrameAssemblyTimeout overrides FrameAssemblyTimeout in config for remote
+// node, e.g. in RemoteNodeReady middleware to allow a peer on a slow link to
+// trickle data slower. It takes effect from the next msg received, and has no
+// effect if framer does not implement FrameHeaderReader.
+func (rn *RemoteNode) SetFrameAssemblyTimeout(timeout time.Duration) {
+	rn.Lock()
+	rn.frameTimeout = timeout
+	rn.Unlock()
+}
+
+// ReplyTimeout returns the default timeout for reply from remote node. It is
+// AdaptiveReplyTimeoutFactor times the measured round trip time but no less
+// than AdaptiveReplyTimeoutFloor if AdaptiveReplyTimeoutFactor is positive and
+// round trip time has been measured, otherwise DefaultReplyTimeout.
+func (rn *RemoteNode) ReplyTimeout() time.Duration {
+	factor := rn.LocalNode.AdaptiveReplyTimeoutFactor
+	if factor <= 0 {
+		return rn.LocalNode.DefaultReplyTimeout
+	}
+
+	roundTripTime := rn.GetRoundTripTime()
+	if roundTripTime <= 0 {
+		return rn.LocalNode.DefaultReplyTimeout
+	}
+
+	replyTimeout := time.Duration(factor * float64(roundTripTime))
+	if replyTimeout < rn.LocalNode.AdaptiveReplyTimeoutFloor {
+		replyTimeout = rn.LocalNode.AdaptiveReplyTimeoutFloor
+	}
+
+	return replyTimeout
+}
+
 // Start starts the runtime loop of the remote node
 func (rn *RemoteNode) Start() error {
 	rn.StartOnce.Do(func() {
@@ -98,31 +575,74 @@ func (rn *RemoteNode) Start() error {
 			return
 		}
 
+		rn.Lock()
+		rn.started = true
+		rn.Unlock()
+
+		rn.LocalNode.wg.Add(3)
 		go rn.handleMsg()
-		go rn.startMultiplexer()
-		go rn.startMeasuringRoundTripTime()
+		if rn.loopbackPeer != nil {
+			go rn.txLoopback()
+		} else {
+			go rn.startMultiplexer()
+		}
+
+		if !rn.LocalNode.DisableKeepAlivePing {
+			rn.LocalNode.wg.Add(1)
+			go rn.startMeasuringRoundTripTime()
+		}
+
+		if rn.LocalNode.RemoteBroadcastPacingLen > 0 {
+			rn.LocalNode.wg.Add(1)
+			go rn.feedPacedMsg()
+		}
+
+		if !rn.IsOutbound && rn.LocalNode.InboundSetupTimeout > 0 {
+			rn.LocalNode.wg.Add(1)
+			go rn.watchInboundSetup()
+		}
 
 		go func() {
-			var n *protobuf.Node
+			defer rn.LocalNode.wg.Done()
+
+			var reply *protobuf.GetNodeReply
 			var err error
 
+			getNodeStartTime := time.Now()
 			for i := 0; i < startRetries; i++ {
-				n, err = rn.GetNode()
+				reply, err = rn.getNodeReply()
 				if err == nil {
 					break
 				}
 			}
+
+			rn.Lock()
+			rn.setupTimings.GetNode = time.Since(getNodeStartTime)
+			rn.Unlock()
+
 			if err != nil {
 				rn.Stop(fmt.Errorf("Get node error: %s", err))
 				return
 			}
 
+			n := reply.Node
+			if n == nil {
+				rn.Stop(errors.New("Get node reply has no node"))
+				return
+			}
+
+			err = rn.verifyIdentity(n)
+			if err != nil {
+				rn.Stop(fmt.Errorf("Verify remote node identity error: %v", err))
+				return
+			}
+
 			var existing *RemoteNode
 			rn.LocalNode.neighbors.Range(func(key, value interface{}) bool {
 				remoteNode, ok := value.(*RemoteNode)
 				if ok && remoteNode.IsReady() && bytes.Equal(remoteNode.Id, n.Id) {
 					if remoteNode.IsStopped() {
-						log.Warningf("Remove stopped remote node %v from list", remoteNode)
+						rn.LocalNode.logger.Warningf("Remove stopped remote node %v from list", remoteNode)
 						rn.LocalNode.neighbors.Delete(key)
 					} else {
 						existing = remoteNode
@@ -154,9 +674,48 @@ func (rn *RemoteNode) Start() error {
 
 			rn.Node.Node = n
 
+			connTransport := rn.LocalNode.address.Transport.String()
+			if rn.IsOutbound {
+				connTransport = remoteAddr.Transport.String()
+			}
+
+			multiplexer := rn.LocalNode.Multiplexer
+			if rn.loopbackPeer != nil {
+				connTransport = rn.conn.RemoteAddr().Network()
+				multiplexer = ""
+			}
+
+			rn.Lock()
+			if len(rn.LocalNode.CompressionCodecs) > 0 {
+				rn.compression = negotiateCompression(rn.LocalNode.CompressionCodecs, reply.CompressionCodecs, rn.LocalNode.CompressionDictionary, reply.CompressionDictId)
+			}
+			rn.msgCodec = negotiateMsgCodec(rn.LocalNode.MessageCodecs, reply.MessageCodecs)
+			rn.sessionParams = SessionParams{
+				Transport:      connTransport,
+				Multiplexer:    multiplexer,
+				MaxMessageSize: rn.LocalNode.MaxMessageSize,
+				RemoteAddr:     n.Addr,
+				Compression:    rn.compression,
+				MessageCodec:   rn.msgCodec,
+			}
+			rn.setupTimings.Total = rn.setupTimings.Dial + time.Since(rn.createdTime)
+			rn.Unlock()
+
+			for _, mw := range rn.LocalNode.middlewareStore.remoteNodeReadyVeto.Load().([]RemoteNodeReadyVeto) {
+				err, shouldCallNextMiddleware := mw.Func(rn)
+				if err != nil {
+					rn.Stop(fmt.Errorf("Remote node is rejected: %v", err))
+					return
+				}
+				if !shouldCallNextMiddleware {
+					break
+				}
+			}
+
 			rn.SetReady(true)
+			close(rn.readyChan)
 
-			for _, mw := range rn.LocalNode.middlewareStore.remoteNodeReady {
+			for _, mw := range rn.LocalNode.middlewareStore.remoteNodeReady.Load().([]RemoteNodeReady) {
 				if !mw.Func(rn) {
 					break
 				}
@@ -167,29 +726,84 @@ func (rn *RemoteNode) Start() error {
 	return nil
 }
 
+// watchInboundSetup stops the inbound remote node if it does not become ready
+// within InboundSetupTimeout, so that a conn that never completes setup does
+// not tie up resources
+func (rn *RemoteNode) watchInboundSetup() {
+	defer rn.LocalNode.wg.Done()
+
+	timer := time.NewTimer(rn.LocalNode.InboundSetupTimeout)
+	select {
+	case <-timer.C:
+	case <-rn.Done():
+		util.StopTimer(timer)
+		return
+	}
+
+	if !rn.IsReady() {
+		rn.Stop(fmt.Errorf("Inbound remote node is not ready after %v", rn.LocalNode.InboundSetupTimeout))
+	}
+}
+
 // Stop stops the runtime loop of the remote node
 func (rn *RemoteNode) Stop(err error) {
 	rn.StopOnce.Do(func() {
+		rn.Lock()
+		rn.stopReason = err
+		rn.stoppedTime = time.Now()
+		rn.Unlock()
+
+		atomic.AddUint64(&rn.LocalNode.numDisconnects, 1)
+		rn.LocalNode.metrics.RemoteNodeDisconnected(rn, err)
+
+		rn.LocalNode.failPendingReplies(rn)
+
 		if err != nil {
-			log.Warningf("Remote node %v stops because of error: %s", rn, err)
+			rn.LocalNode.logger.Warningf("Remote node %v stops because of error: %s", rn, err)
+			for _, entry := range rn.DumpJournal() {
+				rn.LocalNode.logger.Infof("Journal of remote node %v: %v", rn, entry)
+			}
 		} else {
-			log.Infof("Remote node %v stops", rn)
+			rn.LocalNode.logger.Infof("Remote node %v stops", rn)
+		}
+
+		if err != nil && rn.dialAddr != "" && rn.IsAutoReconnect() {
+			rn.LocalNode.scheduleReconnect(rn.dialAddr)
 		}
 
 		err = rn.NotifyStop()
 		if err != nil {
-			log.Warning("Notify remote node stop error:", err)
+			rn.LocalNode.logger.Warning("Notify remote node stop error:", err)
 		}
 
+		rn.LocalNode.wg.Add(1)
 		time.AfterFunc(stopGracePeriod, func() {
+			defer rn.LocalNode.wg.Done()
+
 			rn.LifeCycle.Stop()
 
+			rn.closeTxQueue()
+
 			if rn.conn != nil {
 				rn.LocalNode.neighbors.Delete(rn.conn.RemoteAddr().String())
 				rn.conn.Close()
 			}
 
-			for _, mw := range rn.LocalNode.middlewareStore.remoteNodeDisconnected {
+			if rn.loopbackPeer != nil {
+				rn.loopbackPeer.Stop(errors.New("Loopback peer has stopped"))
+			}
+
+			finalStats := rn.LocalNode.middlewareStore.remoteNodeFinalStats.Load().([]RemoteNodeFinalStats)
+			if len(finalStats) > 0 {
+				stats := rn.Stats()
+				for _, mw := range finalStats {
+					if !mw.Func(rn, stats) {
+						break
+					}
+				}
+			}
+
+			for _, mw := range rn.LocalNode.middlewareStore.remoteNodeDisconnected.Load().([]RemoteNodeDisconnected) {
 				if !mw.Func(rn) {
 					break
 				}
@@ -198,119 +812,439 @@ func (rn *RemoteNode) Stop(err error) {
 	})
 }
 
-func (rn *RemoteNode) startMultiplexer() {
-	mux, err := multiplexer.NewMultiplexer(rn.LocalNode.Multiplexer, rn.conn, rn.IsOutbound)
-	if err != nil {
-		rn.Stop(fmt.Errorf("Create multiplexer error: %s", err))
-		return
+// StopGracefully stops accepting new msg except node control msg, waits until
+// all msg already queued to be sent to remote node are written to conn, and
+// then stops remote node. Msg sent before calling it (e.g. a leave notice) are
+// delivered unless remote node stops for other reasons. If queued msg are not
+// drained within timeout, remote node is stopped anyway with an error and the
+// remaining msg are discarded. Zero timeout means no timeout. It blocks until
+// remote node starts to stop.
+func (rn *RemoteNode) StopGracefully(timeout time.Duration) {
+	rn.Lock()
+	rn.stopping = true
+	rn.Unlock()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer util.StopTimer(timer)
+		deadline = timer.C
 	}
 
-	var conn net.Conn
-	if rn.IsOutbound {
-		for i := uint32(0); i < rn.LocalNode.NumStreamsToOpen; i++ {
-			conn, err = mux.OpenStream()
-			if err != nil {
-				rn.Stop(fmt.Errorf("Open stream error: %s", err))
-				return
-			}
-			go rn.rx(conn, false)
-		}
-	} else {
-		for i := uint32(0); i < rn.LocalNode.NumStreamsToAccept; i++ {
-			conn, err = mux.AcceptStream()
-			if err != nil {
-				rn.Stop(fmt.Errorf("Accept stream error: %s", err))
-				return
-			}
-			go rn.rx(conn, true)
+	ticker := time.NewTicker(gracefulStopPollInterval)
+	defer ticker.Stop()
+
+	for rn.numTxQueued() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			rn.Stop(fmt.Errorf("Graceful stop timeout after %v with %d msg not sent", timeout, rn.numTxQueued()))
+			return
+		case <-rn.Done():
+			return
 		}
 	}
+
+	// Msg being written to conn is given stopGracePeriod to finish before conn
+	// is closed by Stop
+	rn.Stop(nil)
 }
 
-// handleMsg starts a loop that handles received msg
-func (rn *RemoteNode) handleMsg() {
-	var msg *protobuf.Message
-	var remoteMsg *RemoteMessage
-	var msgChan chan *RemoteMessage
-	var lastRxTime time.Time
-	var added, ok bool
-	var err error
-	keepAliveTimeoutTimer := time.NewTimer(rn.LocalNode.KeepAliveTimeout)
+// IsStopping returns if remote node is stopping gracefully and no longer
+// accepts new msg
+func (rn *RemoteNode) IsStopping() bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.stopping
+}
 
-	for {
-		if rn.IsStopped() {
-			util.StopTimer(keepAliveTimeoutTimer)
+// tlsHandshake runs TLS handshake explicitly if conn is a TLS conn, so that
+// handshake errors are not surfaced later in rx or tx when the handshake is
+// triggered lazily by the first read or write.
+func (rn *RemoteNode) tlsHandshake() error {
+	tlsConn, ok := rn.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	// Deadline is an absolute time, but conn computes the remaining time with
+	// the monotonic clock reading, so it is not affected by wall clock jumps.
+	err := tlsConn.SetDeadline(time.Now().Add(rn.LocalNode.TLSHandshakeTimeout))
+	if err != nil {
+		return &TLSHandshakeError{Err: err}
+	}
+
+	err = tlsConn.Handshake()
+	if err != nil {
+		return &TLSHandshakeError{Err: err}
+	}
+
+	err = tlsConn.SetDeadline(time.Time{})
+	if err != nil {
+		return &TLSHandshakeError{Err: err}
+	}
+
+	peerCerts := tlsConn.ConnectionState().PeerCertificates
+	if len(peerCerts) > 0 {
+		publicKey, err := x509.MarshalPKIXPublicKey(peerCerts[0].PublicKey)
+		if err != nil {
+			return &TLSHandshakeError{Err: err}
+		}
+
+		rn.Lock()
+		rn.publicKey = publicKey
+		rn.Unlock()
+	}
+
+	return nil
+}
+
+func (rn *RemoteNode) startMultiplexer() {
+	defer rn.LocalNode.wg.Done()
+
+	tlsHandshakeStartTime := time.Now()
+	err := rn.tlsHandshake()
+	if err != nil {
+		rn.Stop(err)
+		return
+	}
+
+	muxStartTime := time.Now()
+	rn.Lock()
+	if _, ok := rn.conn.(*tls.Conn); ok {
+		rn.setupTimings.TLSHandshake = muxStartTime.Sub(tlsHandshakeStartTime)
+	}
+	rn.Unlock()
+
+	mux, err := multiplexer.NewMultiplexer(rn.LocalNode.Multiplexer, rn.conn, rn.IsOutbound)
+	if err != nil {
+		rn.Stop(fmt.Errorf("Create multiplexer error: %s", err))
+		return
+	}
+
+	rn.Lock()
+	rn.mux = mux
+	rn.Unlock()
+
+	var conn net.Conn
+	if rn.IsOutbound {
+		for i := uint32(0); i < rn.LocalNode.NumStreamsToOpen; i++ {
+			conn, err = mux.OpenStream()
+			if err != nil {
+				rn.Stop(fmt.Errorf("Open stream error: %s", err))
+				return
+			}
+			if i == 0 {
+				rn.setMultiplexerSetupTime(muxStartTime)
+			}
+			rn.LocalNode.wg.Add(1)
+			go rn.rx(conn, false)
+		}
+
+		if rn.LocalNode.EnableAppStreams {
+			rn.acceptAppStreams()
+		}
+	} else {
+		for i := uint32(0); i < rn.LocalNode.NumStreamsToAccept; i++ {
+			conn, err = mux.AcceptStream()
+			if err != nil {
+				rn.Stop(fmt.Errorf("Accept stream error: %s", err))
+				return
+			}
+			if i == 0 {
+				rn.setMultiplexerSetupTime(muxStartTime)
+			}
+			rn.LocalNode.wg.Add(1)
+			go rn.rx(conn, true)
+		}
+	}
+}
+
+// setMultiplexerSetupTime records the multiplexer setup phase, which ends when
+// the first stream is opened or accepted and msg can be exchanged
+func (rn *RemoteNode) setMultiplexerSetupTime(startTime time.Time) {
+	rn.Lock()
+	rn.setupTimings.Multiplexer = time.Since(startTime)
+	rn.Unlock()
+}
+
+// handleMsg starts a loop that handles received msg
+func (rn *RemoteNode) handleMsg() {
+	defer rn.LocalNode.wg.Done()
+
+	var msg *protobuf.Message
+	var remoteMsg *RemoteMessage
+	var msgChan chan *RemoteMessage
+	var lastRxTime time.Time
+	var msgID []byte
+	var added, ok, requestAck, delivered, shouldCallNextMiddleware bool
+	var err error
+	rxMsgChan := rn.getRxMsgChan()
+	keepAliveTimeoutTimer := time.NewTimer(rn.LocalNode.KeepAliveTimeout)
+
+	for {
+		if rn.IsStopped() {
+			util.StopTimer(keepAliveTimeoutTimer)
 			return
 		}
 
 		select {
-		case msg, ok = <-rn.rxMsgChan:
-			if !ok {
+		case msg, ok = <-rxMsgChan:
+			if !ok || rn.IsStopped() {
 				util.StopTimer(keepAliveTimeoutTimer)
 				return
 			}
 
-			added, err = rn.LocalNode.AddToRxCache(msg.MessageId)
-			if err != nil {
-				log.Error(err)
-				continue
+			// node control msg and replies are not deduplicated so that they
+			// are not dropped when legitimately repeated, duplicate replies are
+			// discarded when they are passed to reply chan
+			if !isPriorityMsg(msg) {
+				added, err = rn.LocalNode.AddToRxCache(msg.MessageId)
+				if err != nil {
+					rn.LocalNode.logger.Error(err)
+					continue
+				}
+				if !added {
+					continue
+				}
+			}
+
+			msg.RoutingType = rn.mapRoutingType(msg.RoutingType, Ingress)
+
+			// Ack is only sent by the first receiver, not by the nodes msg is
+			// routed to later
+			requestAck = msg.RequestAck
+			msgID = msg.MessageId
+
+			for _, mw := range rn.LocalNode.middlewareStore.remoteNodeMessageReceived.Load().([]RemoteNodeMessageReceived) {
+				msg, shouldCallNextMiddleware = mw.Func(rn, msg)
+				if msg == nil || !shouldCallNextMiddleware {
+					break
+				}
 			}
-			if !added {
+
+			if msg == nil {
+				if requestAck {
+					err = rn.sendAck(msgID, false)
+					if err != nil {
+						rn.LocalNode.logger.Errorf("Send ack to remote node %v error: %v", rn, err)
+					}
+				}
 				continue
 			}
 
+			msg.RequestAck = false
+
 			remoteMsg, err = NewRemoteMessage(rn, msg)
 			if err != nil {
-				log.Error(err)
+				rn.LocalNode.logger.Error(err)
 				continue
 			}
 
 			msgChan, err = rn.LocalNode.GetRxMsgChan(msg.RoutingType)
 			if err != nil {
-				log.Error(err)
+				rn.LocalNode.logger.Error(err)
 				continue
 			}
 
 			select {
 			case msgChan <- remoteMsg:
+				delivered = true
 			default:
-				log.Warningf("Msg chan full for routing type %d, discarding msg", msg.RoutingType)
+				err = rn.LocalNode.ApplyBackpressure(rn.LocalNode.backpressure.OnRxFull(rn, msg), rn, func(done <-chan struct{}) bool {
+					select {
+					case msgChan <- remoteMsg:
+						return true
+					case <-done:
+						return false
+					}
+				}, fmt.Sprintf("Msg chan full for routing type %d", msg.RoutingType))
+				delivered = err == nil
+				if err != nil {
+					rn.LocalNode.logger.Warning(err)
+				}
+			}
+
+			if requestAck {
+				err = rn.sendAck(msgID, delivered)
+				if err != nil {
+					rn.LocalNode.logger.Errorf("Send ack to remote node %v error: %v", rn, err)
+				}
 			}
 		case <-keepAliveTimeoutTimer.C:
 			rn.RLock()
 			lastRxTime = rn.lastRxTime
 			rn.RUnlock()
 			if time.Since(lastRxTime) > rn.LocalNode.KeepAliveTimeout {
-				rn.Stop(errors.New("keepalive timeout"))
+				rn.stopOnKeepAliveTimeout(errors.New("keepalive timeout"))
 			}
+		case <-rn.Done():
+			util.StopTimer(keepAliveTimeoutTimer)
+			return
 		}
 
 		util.ResetTimer(keepAliveTimeoutTimer, rn.LocalNode.KeepAliveTimeout)
 	}
 }
 
+// stopOnKeepAliveTimeout stops remote node with err because of keepalive
+// timeout, unless keepalive timeout is disabled or vetoed, or remote node is
+// already stopping within stop grace period
+func (rn *RemoteNode) stopOnKeepAliveTimeout(err error) {
+	rn.RLock()
+	stopped := !rn.stoppedTime.IsZero()
+	rn.RUnlock()
+	if stopped {
+		return
+	}
+
+	if !rn.IsKeepAliveTimeoutEnabled() || rn.isKeepAliveTimeoutVetoed() {
+		return
+	}
+
+	atomic.AddUint64(&rn.LocalNode.numKeepAliveTimeouts, 1)
+	rn.LocalNode.metrics.KeepAliveTimeout(rn)
+	for _, mw := range rn.LocalNode.middlewareStore.remoteNodeKeepAliveTimeout.Load().([]RemoteNodeKeepAliveTimeout) {
+		if !mw.Func(rn) {
+			break
+		}
+	}
+
+	rn.Stop(err)
+}
+
+// isKeepAliveTimeoutVetoed returns if any KeepAliveTimeoutVeto middleware
+// vetoes the keepalive timeout of remote node
+func (rn *RemoteNode) isKeepAliveTimeoutVetoed() bool {
+	var vetoed, shouldCallNextMiddleware bool
+	for _, mw := range rn.LocalNode.middlewareStore.keepAliveTimeoutVeto.Load().([]KeepAliveTimeoutVeto) {
+		vetoed, shouldCallNextMiddleware = mw.Func(rn)
+		if vetoed {
+			rn.LocalNode.logger.Infof("Keepalive timeout of remote node %v is vetoed", rn)
+			return true
+		}
+		if !shouldCallNextMiddleware {
+			break
+		}
+	}
+	return false
+}
+
 // handleMsgBuf unmarshal buf to msg and send it to msg chan of the local node
 func (rn *RemoteNode) handleMsgBuf(buf []byte) {
+	buf, err := decompressMsgBuf(buf, rn.LocalNode.MaxMessageSize, rn.LocalNode.CompressionDictionary)
+	if err == errDecompressedMsgTooLarge && rn.LocalNode.backpressure.OnMemoryPressure(rn, rn.LocalNode.MaxMessageSize+1) != BackpressureCloseConn {
+		rn.countMsgDropped()
+		rn.LocalNode.logger.Warningf("Decompressed msg from %v exceeds max msg size %d, discarding msg", rn, rn.LocalNode.MaxMessageSize)
+		return
+	}
+	if err != nil {
+		rn.Stop(fmt.Errorf("decompress msg error: %s", err))
+		return
+	}
+
 	msg := &protobuf.Message{}
-	err := proto.Unmarshal(buf, msg)
+	err = unmarshalMsg(buf, msg)
 	if err != nil {
+		rn.handleUnmarshalError(err)
+		return
+	}
+
+	rn.receiveMessage(msg)
+}
+
+// handleUnmarshalError stops remote node or drops the msg that cannot be
+// unmarshaled according to UnmarshalErrorPolicy. Under drop policy, remote node
+// is stopped if UnmarshalErrorThreshold msg cannot be unmarshaled in the same
+// UnmarshalErrorWindow, as it is likely buggy or malicious.
+func (rn *RemoteNode) handleUnmarshalError(err error) {
+	if rn.LocalNode.UnmarshalErrorPolicy != "drop" {
 		rn.Stop(fmt.Errorf("unmarshal msg error: %s", err))
 		return
 	}
 
+	rn.countMsgDropped()
+
+	rn.Lock()
+	now := time.Now()
+	if now.Sub(rn.unmarshalErrStart) >= rn.LocalNode.UnmarshalErrorWindow {
+		rn.unmarshalErrStart = now
+		rn.unmarshalErrCount = 0
+	}
+	rn.unmarshalErrCount++
+	numErrors := rn.unmarshalErrCount
+	rn.Unlock()
+
+	threshold := rn.LocalNode.UnmarshalErrorThreshold
+	if threshold > 0 && numErrors >= threshold {
+		rn.Stop(fmt.Errorf("%d msg cannot be unmarshaled within %v, last error: %s", numErrors, rn.LocalNode.UnmarshalErrorWindow, err))
+		return
+	}
+
+	rn.LocalNode.logger.Warningf("Unmarshal msg from remote node %v error: %s, discarding msg", rn, err)
+}
+
+// receiveMessage sends msg received from remote node to rxMsgChan
+func (rn *RemoteNode) receiveMessage(msg *protobuf.Message) {
+	// no msg should be delivered after remote node stops
+	if rn.IsStopped() {
+		return
+	}
+
+	atomic.AddUint64(&rn.msgRx, 1)
+
+	rn.markActive(msg)
+
+	if rn.journal != nil {
+		rn.journal.record(msg, Ingress)
+	}
+
+	rxMsgChan := rn.getRxMsgChan()
+
 	select {
-	case rn.rxMsgChan <- msg:
+	case rxMsgChan <- msg:
 	default:
-		log.Warning("Rx msg chan full, discarding msg")
+		err := rn.LocalNode.ApplyBackpressure(rn.LocalNode.backpressure.OnRxFull(rn, msg), rn, func(done <-chan struct{}) bool {
+			select {
+			case rxMsgChan <- msg:
+				return true
+			case <-done:
+				return false
+			}
+		}, "Rx msg chan full")
+		if err != nil {
+			atomic.AddUint64(&rn.rxDropped, 1)
+			rn.LocalNode.logger.Warning(err)
+			return
+		}
 	}
+
+	updateWatermark(&rn.rxMsgChanWatermark, len(rxMsgChan))
 }
 
 // rx receives and handle data from RemoteNode rn
 func (rn *RemoteNode) rx(conn net.Conn, isActive bool) {
-	msgLenBuf := make([]byte, msgLenBytes)
-	var readLen uint32
+	defer rn.LocalNode.wg.Done()
+
+	var buf []byte
+	var msgLen, readLen uint32
+	var l int
+	var err error
+	framer := rn.LocalNode.framer
+	headerReader, hasHeader := framer.(FrameHeaderReader)
+	var frameTimeout time.Duration
+	var hasDeadline bool
+	var frameAction FrameAction
+	var rejectReason string
+
+	// Reads go through a buffer to save syscalls for msg len and small msg.
+	// bufio reads directly into the caller's buffer when the buffer is empty
+	// and the read is at least buffer size, so large msg body is not copied
+	// twice. Deadlines are still set on conn, which the buffer reads from.
+	r := bufio.NewReaderSize(conn, int(rn.LocalNode.RemoteRxBufferSize))
 
 	if isActive {
+		rn.LocalNode.wg.Add(1)
 		go rn.tx(conn)
 	}
 
@@ -319,18 +1253,37 @@ func (rn *RemoteNode) rx(conn net.Conn, isActive bool) {
 			return
 		}
 
-		l, err := conn.Read(msgLenBuf)
-		if err != nil {
-			rn.Stop(fmt.Errorf("Read msg len error: %s", err))
-			continue
+		if hasDeadline {
+			// clear the deadline of previous frame so that waiting for the next
+			// msg len is only bounded by keepalive
+			err := conn.SetReadDeadline(time.Time{})
+			if err != nil {
+				rn.Stop(fmt.Errorf("Clear read deadline error: %s", err))
+				continue
+			}
+			hasDeadline = false
 		}
-		if l != msgLenBytes {
-			rn.Stop(fmt.Errorf("Msg len has %d bytes, which is less than expected %d", l, msgLenBytes))
-			continue
+
+		if hasHeader {
+			msgLen, err = headerReader.ReadFrameHeader(r)
+			if err != nil {
+				rn.countConnError(&rn.readErrors)
+				rn.Stop(fmt.Errorf("Read msg len error: %s", err))
+				continue
+			}
+		} else {
+			buf, err = framer.ReadFrame(r)
+			if err != nil {
+				rn.countConnError(&rn.readErrors)
+				rn.Stop(fmt.Errorf("Read msg error: %s", err))
+				continue
+			}
+			msgLen = uint32(len(buf))
 		}
 
 		if !isActive {
 			isActive = true
+			rn.LocalNode.wg.Add(1)
 			go rn.tx(conn)
 		}
 
@@ -338,37 +1291,105 @@ func (rn *RemoteNode) rx(conn net.Conn, isActive bool) {
 		rn.lastRxTime = time.Now()
 		rn.Unlock()
 
-		msgLen := binary.BigEndian.Uint32(msgLenBuf)
-		if msgLen < 0 {
-			rn.Stop(fmt.Errorf("Msg len %d overflow", msgLen))
-			continue
+		frameStartTime := time.Now()
+		frameTimeout = 0
+		if hasHeader {
+			frameTimeout = rn.FrameAssemblyTimeout()
+		}
+		if frameTimeout > 0 {
+			// Deadline is computed with monotonic clock by conn, see tlsHandshake
+			err = conn.SetReadDeadline(frameStartTime.Add(frameTimeout))
+			if err != nil {
+				rn.Stop(fmt.Errorf("Set read deadline error: %s", err))
+				continue
+			}
+			hasDeadline = true
 		}
 
+		// MaxMessageSize is checked before the frame validator, so that a
+		// crafted length prefix (e.g. 0xFFFFFFFF) never makes rx allocate a
+		// huge buffer. Oversized frame is discarded to keep the connection only
+		// if backpressure strategy allows it and it is no larger than
+		// OversizedMsgDiscardLimit, otherwise remote node is stopped.
 		if msgLen > rn.LocalNode.MaxMessageSize {
-			rn.Stop(fmt.Errorf("Msg size %d exceeds max msg size %d", msgLen, rn.LocalNode.MaxMessageSize))
+			if !rn.canDiscardOversizedFrame(msgLen, hasHeader) {
+				rn.Stop(fmt.Errorf("Msg size %d exceeds max msg size %d", msgLen, rn.LocalNode.MaxMessageSize))
+				continue
+			}
+			frameAction = FrameReject
+			rejectReason = "exceeds max msg size"
+		} else {
+			frameAction = rn.LocalNode.frameValidator(rn, msgLen)
+			rejectReason = "rejected by frame validator"
+		}
+
+		switch frameAction {
+		case FrameCloseConn:
+			rn.Stop(fmt.Errorf("Msg of size %d rejected by frame validator", msgLen))
+			continue
+		case FrameReject:
+			if hasHeader {
+				// consume exactly msgLen bytes so that the next read starts at
+				// the next msg len
+				_, err = io.CopyN(ioutil.Discard, r, int64(msgLen))
+				if err != nil {
+					rn.countConnError(&rn.readErrors)
+					rn.Stop(rn.frameReadError("Discard rejected msg error", msgLen, frameStartTime, frameTimeout, err))
+					continue
+				}
+
+				rn.Lock()
+				rn.lastRxTime = time.Now()
+				rn.Unlock()
+			}
+
+			atomic.AddUint64(&rn.bytesRx, uint64(framer.FrameSize(int(msgLen))))
+			rn.LocalNode.metrics.BytesReceived(rn, framer.FrameSize(int(msgLen)))
+			rn.countMsgDropped()
+
+			rn.LocalNode.logger.Warningf("Msg of size %d %s, discarding msg", msgLen, rejectReason)
 			continue
 		}
 
-		buf := make([]byte, msgLen)
+		if hasHeader {
+			buf = make([]byte, msgLen)
+
+			for readLen = 0; readLen < msgLen; readLen += uint32(l) {
+				l, err = r.Read(buf[readLen:])
+				if err != nil {
+					break
+				}
+
+				rn.Lock()
+				rn.lastRxTime = time.Now()
+				rn.Unlock()
+			}
 
-		for readLen = 0; readLen < msgLen; readLen += uint32(l) {
-			l, err = conn.Read(buf[readLen:])
 			if err != nil {
-				break
+				rn.countConnError(&rn.readErrors)
+				rn.Stop(rn.frameReadError("Read msg error", msgLen, frameStartTime, frameTimeout, err))
+				continue
 			}
 
-			rn.Lock()
-			rn.lastRxTime = time.Now()
-			rn.Unlock()
+			if readLen > msgLen {
+				rn.Stop(fmt.Errorf("Msg has %d bytes, which is more than expected %d", readLen, msgLen))
+				continue
+			}
 		}
 
-		if err != nil {
-			rn.Stop(fmt.Errorf("Read msg error: %s", err))
-			continue
+		atomic.AddUint64(&rn.bytesRx, uint64(framer.FrameSize(int(msgLen))))
+		rn.LocalNode.metrics.BytesReceived(rn, framer.FrameSize(int(msgLen)))
+
+		// discard frame completed after remote node stops
+		if rn.IsStopped() {
+			return
+		}
+
+		if rn.onFrameComplete != nil {
+			rn.onFrameComplete(int(msgLen))
 		}
 
-		if readLen > msgLen {
-			rn.Stop(fmt.Errorf("Msg has %d bytes, which is more than expected %d", readLen, msgLen))
+		if !rn.limitRxRate(framer.FrameSize(int(msgLen))) {
 			continue
 		}
 
@@ -376,13 +1397,40 @@ func (rn *RemoteNode) rx(conn net.Conn, isActive bool) {
 	}
 }
 
-// tx marshals and sends data in txMsgChan to RemoteNode rn
+// canDiscardOversizedFrame returns if a frame of msgLen bytes, which exceeds
+// MaxMessageSize, can be discarded while keeping the connection. If the frame
+// body is not read yet (hasHeader is true), it also needs to be no larger than
+// OversizedMsgDiscardLimit so that rx does not read a huge frame just to throw
+// it away.
+func (rn *RemoteNode) canDiscardOversizedFrame(msgLen uint32, hasHeader bool) bool {
+	if hasHeader && msgLen > rn.LocalNode.OversizedMsgDiscardLimit {
+		return false
+	}
+	return rn.LocalNode.backpressure.OnMemoryPressure(rn, msgLen) != BackpressureCloseConn
+}
+
+// frameReadError returns the error to stop remote node with when reading the
+// body of a msg of size msgLen, whose length prefix is received at
+// frameStartTime with frame assembly timeout, fails with err. Multiplexers do
+// not return the same error type on deadline, so timeout is detected by
+// elapsed time.
+func (rn *RemoteNode) frameReadError(prefix string, msgLen uint32, frameStartTime time.Time, timeout time.Duration, err error) error {
+	if timeout > 0 && time.Since(frameStartTime) >= timeout {
+		return fmt.Errorf("Msg of size %d is not complete within %v", msgLen, timeout)
+	}
+	return fmt.Errorf("%s: %s", prefix, err)
+}
+
+// tx marshals and sends msg in priorityChan and txMsgChan to RemoteNode rn
 func (rn *RemoteNode) tx(conn net.Conn) {
+	defer rn.LocalNode.wg.Done()
+
 	var msg *protobuf.Message
-	var buf []byte
-	var ok bool
-	var err error
-	msgLenBuf := make([]byte, msgLenBytes)
+	var numPriority uint32
+	var batch []*protobuf.Message
+	var batchBuf bytes.Buffer
+	framer := rn.LocalNode.framer
+	txMsgChan := rn.getTxMsgChan()
 	txTimeoutTimer := time.NewTimer(time.Second)
 
 	for {
@@ -391,85 +1439,364 @@ func (rn *RemoteNode) tx(conn net.Conn) {
 			return
 		}
 
-		select {
-		case msg, ok = <-rn.txMsgChan:
-			if !ok {
+		msg = rn.pollTxMsg(&numPriority)
+		if msg == nil {
+			select {
+			case msg = <-rn.priorityChan:
+				numPriority++
+			case msg = <-txMsgChan:
+				numPriority = 0
+			case <-txTimeoutTimer.C:
+			case <-rn.Done():
 				util.StopTimer(txTimeoutTimer)
 				return
 			}
+		}
 
-			buf, err = proto.Marshal(msg)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-
-			if uint32(len(buf)) > rn.LocalNode.MaxMessageSize {
-				log.Errorf("Msg size %d exceeds max msg size %d", len(buf), rn.LocalNode.MaxMessageSize)
-				continue
-			}
-
-			binary.BigEndian.PutUint32(msgLenBuf, uint32(len(buf)))
-
-			_, err = conn.Write(msgLenBuf)
-			if err != nil {
-				rn.Stop(fmt.Errorf("Write to conn error: %s", err))
-				continue
-			}
+		if msg != nil {
+			batch = rn.collectTxBatch(msg, &numPriority, batch)
+			rn.writeMsgs(conn, framer, batch, &batchBuf)
+		}
 
-			_, err = conn.Write(buf)
-			if err != nil {
-				rn.Stop(fmt.Errorf("Write to conn error: %s", err))
-				continue
-			}
-		case <-txTimeoutTimer.C:
+		if rn.isDrainTimeout() {
+			rn.Stop(errors.New("Drain timeout"))
 		}
 
 		util.ResetTimer(txTimeoutTimer, time.Second)
 	}
 }
 
-// startMeasuringRoundTripTime starts to periodically send ping message to
-// measure round trip time to remote node.
-func (rn *RemoteNode) startMeasuringRoundTripTime() {
-	var err error
-	var startTime time.Time
-	var roundTripTime time.Duration
-
-	for {
-		time.Sleep(util.RandDuration(rn.LocalNode.MeasureRoundTripTimeInterval, 1.0/3.0))
+// collectTxBatch returns msg followed by msg already queued in tx chans, up
+// to RemoteTxBatchSize msg in total. It never blocks, so tx keeps sending
+// keepalive ping and checking timeouts at the same pace when batching.
+func (rn *RemoteNode) collectTxBatch(msg *protobuf.Message, numPriority *uint32, batch []*protobuf.Message) []*protobuf.Message {
+	batch = append(batch[:0], msg)
+	for uint32(len(batch)) < rn.LocalNode.RemoteTxBatchSize {
+		msg = rn.pollTxMsg(numPriority)
+		if msg == nil {
+			break
+		}
+		batch = append(batch, msg)
+	}
+	return batch
+}
 
-		if rn.IsStopped() {
-			return
+// writeMsgs encodes msgs and writes them to conn. Frames of multiple msgs are
+// assembled in batchBuf and written in one call, starting a new write whenever
+// RemoteTxBatchBytes would be exceeded.
+func (rn *RemoteNode) writeMsgs(conn net.Conn, framer Framer, msgs []*protobuf.Message, batchBuf *bytes.Buffer) {
+	// msgs written to batchBuf are stored in msgs in place as they never go
+	// ahead of the msg being encoded
+	batched := msgs[:0]
+	batchBuf.Reset()
+
+	for _, msg := range msgs {
+		rn.releaseTxQueue(int64(msg.Size()))
+
+		routingType := rn.mapRoutingType(msg.RoutingType, Egress)
+		if routingType != msg.RoutingType {
+			// msg may be shared with other remote nodes, so we make a copy
+			msgCopy := *msg
+			msgCopy.RoutingType = routingType
+			msg = &msgCopy
 		}
 
-		startTime = time.Now()
-		err = rn.Ping()
+		buf, err := rn.encodeMessage(msg)
 		if err != nil {
-			log.Warningf("Ping error: %v", err)
+			rn.LocalNode.logger.Error(err)
 			continue
 		}
-		roundTripTime = time.Since(startTime)
 
-		rn.Lock()
-		if rn.roundTripTime > 0 {
-			rn.roundTripTime = (rn.roundTripTime + roundTripTime) / 2
-		} else {
-			rn.roundTripTime = roundTripTime
+		frameSize := framer.FrameSize(len(buf))
+
+		if batchBuf.Len() > 0 && batchBuf.Len()+frameSize > int(rn.LocalNode.RemoteTxBatchBytes) {
+			if !rn.writeFrames(conn, nil, batchBuf.Bytes(), batched, batchBuf.Len()) {
+				return
+			}
+			batched = batched[:0]
+			batchBuf.Reset()
 		}
-		rn.Unlock()
-	}
-}
 
-// SendMessage marshals and sends msg, will returns a RemoteMessage chan if
-// hasReply is true and reply is received within replyTimeout.
-func (rn *RemoteNode) SendMessage(msg *protobuf.Message, hasReply bool, replyTimeout time.Duration) (<-chan *RemoteMessage, error) {
+		if len(msgs) == 1 || frameSize > int(rn.LocalNode.RemoteTxBatchBytes) {
+			if !rn.writeFrames(conn, framer, buf, []*protobuf.Message{msg}, frameSize) {
+				return
+			}
+			continue
+		}
+
+		err = framer.WriteFrame(batchBuf, buf)
+		if err != nil {
+			rn.LocalNode.logger.Error(err)
+			continue
+		}
+		batched = append(batched, msg)
+	}
+
+	if batchBuf.Len() > 0 {
+		rn.writeFrames(conn, nil, batchBuf.Bytes(), batched, batchBuf.Len())
+	}
+}
+
+// writeFrames writes buf that contains frames of msgs to conn, framing buf
+// with framer first if framer is not nil. frameSize is the number of bytes
+// written to conn. Returns false if write fails, in which case remote node is
+// stopped.
+func (rn *RemoteNode) writeFrames(conn net.Conn, framer Framer, buf []byte, msgs []*protobuf.Message, frameSize int) bool {
+	rn.rateLimiter.Wait(frameSize)
+
+	var err error
+	writeStartTime := time.Now()
+	if framer != nil {
+		err = framer.WriteFrame(conn, buf)
+	} else {
+		err = writeFull(conn, buf)
+	}
+	if err != nil {
+		rn.countConnError(&rn.writeErrors)
+		rn.Stop(fmt.Errorf("Write to conn error: %s", err))
+		return false
+	}
+	rn.updateWriteLatency(time.Since(writeStartTime))
+
+	rn.Lock()
+	rn.lastTxTime = time.Now()
+	rn.Unlock()
+
+	atomic.AddUint64(&rn.bytesTx, uint64(frameSize))
+	rn.LocalNode.metrics.BytesSent(rn, frameSize)
+	atomic.AddUint64(&rn.msgTx, uint64(len(msgs)))
+
+	for _, msg := range msgs {
+		rn.markActive(msg)
+
+		if rn.journal != nil {
+			rn.journal.record(msg, Egress)
+		}
+
+		rn.messageSent(msg)
+	}
+
+	return true
+}
+
+// messageSent applies RemoteNodeMessageSent middleware to msg that has been
+// sent to remote node
+func (rn *RemoteNode) messageSent(msg *protobuf.Message) {
+	for _, mw := range rn.LocalNode.middlewareStore.remoteNodeMessageSent.Load().([]RemoteNodeMessageSent) {
+		if !mw.Func(rn, msg) {
+			break
+		}
+	}
+}
+
+// startMeasuringRoundTripTime starts to periodically send ping message to
+// measure round trip time to remote node. Ping also serves as keepalive, and
+// remote node is stopped after KeepAliveMaxUnanswered consecutive pings are
+// not replied, which detects a half-open connection where writes still
+// succeed but remote node is gone sooner than waiting for KeepAliveTimeout.
+func (rn *RemoteNode) startMeasuringRoundTripTime() {
+	defer rn.LocalNode.wg.Done()
+
+	var err error
+	var startTime time.Time
+	var roundTripTime time.Duration
+	var numUnanswered uint32
+
+	for {
+		select {
+		case <-time.After(util.RandDuration(rn.LocalNode.MeasureRoundTripTimeInterval, 1.0/3.0)):
+		case <-rn.Done():
+			return
+		}
+
+		if rn.IsStopped() {
+			return
+		}
+
+		// keepalive ping is not needed for a draining connection which will
+		// be closed after drain timeout
+		if rn.isConnDraining() {
+			continue
+		}
+
+		startTime = time.Now()
+		err = rn.ping(rn.LocalNode.KeepAlivePingTimeout)
+		if err != nil {
+			if rn.IsStopped() {
+				return
+			}
+			rn.LocalNode.logger.Warningf("Ping error: %v", err)
+			numUnanswered++
+			if rn.LocalNode.KeepAliveMaxUnanswered > 0 && numUnanswered >= rn.LocalNode.KeepAliveMaxUnanswered {
+				rn.stopOnKeepAliveTimeout(fmt.Errorf("keepalive timeout: %d consecutive pings unanswered", numUnanswered))
+			}
+			continue
+		}
+		numUnanswered = 0
+		roundTripTime = time.Since(startTime)
+
+		rn.updateRoundTripTime(roundTripTime)
+	}
+}
+
+// isStalled returns if neither rx nor tx has made progress within
+// stallTimeout
+func (rn *RemoteNode) isStalled(stallTimeout time.Duration) bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return time.Since(rn.lastRxTime) > stallTimeout && time.Since(rn.lastTxTime) > stallTimeout
+}
+
+// markActive records the time of msg sent to or received from remote node if
+// it is not a node control msg, which is used for idle eviction
+func (rn *RemoteNode) markActive(msg *protobuf.Message) {
+	if rn.LocalNode.IdleTimeout == 0 || isNodeControlMsg(msg) {
+		return
+	}
+	rn.Lock()
+	rn.lastActiveTime = time.Now()
+	rn.Unlock()
+}
+
+// LastActiveTime returns the last time a msg other than node control msg is
+// sent to or received from remote node. It is only tracked if IdleTimeout is
+// set, otherwise it is the time remote node is created.
+func (rn *RemoteNode) LastActiveTime() time.Time {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.lastActiveTime
+}
+
+// SetIdleExempt sets if remote node is exempt from being closed after
+// IdleTimeout, e.g. a neighbor that should be kept even when idle
+func (rn *RemoteNode) SetIdleExempt(exempt bool) {
+	rn.Lock()
+	rn.idleExempt = exempt
+	rn.Unlock()
+}
+
+// IsIdleExempt returns if remote node is exempt from being closed after
+// IdleTimeout
+func (rn *RemoteNode) IsIdleExempt() bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return rn.idleExempt
+}
+
+// isIdle returns if remote node is not exempt and has not sent or received
+// any msg other than node control msg within idleTimeout
+func (rn *RemoteNode) isIdle(idleTimeout time.Duration) bool {
+	rn.RLock()
+	defer rn.RUnlock()
+	return !rn.idleExempt && time.Since(rn.lastActiveTime) > idleTimeout
+}
+
+// updateRoundTripTime updates the measured round trip time with a new sample
+func (rn *RemoteNode) updateRoundTripTime(roundTripTime time.Duration) {
+	rn.Lock()
+	if roundTripTime <= 0 {
+		roundTripTime = 1
+	}
+	if rn.roundTripTime > 0 {
+		rn.roundTripTime += time.Duration(roundTripTimeSampleWeight * float64(roundTripTime-rn.roundTripTime))
+	} else {
+		rn.roundTripTime = roundTripTime
+	}
+	rn.Unlock()
+}
+
+// mapRoutingType applies RoutingTypeMapper middleware to routingType of a
+// message in direction
+func (rn *RemoteNode) mapRoutingType(routingType protobuf.RoutingType, direction Direction) protobuf.RoutingType {
+	var shouldCallNextMiddleware bool
+	for _, mw := range rn.LocalNode.middlewareStore.routingTypeMapper.Load().([]RoutingTypeMapper) {
+		routingType, shouldCallNextMiddleware = mw.Func(routingType, direction, rn)
+		if !shouldCallNextMiddleware {
+			break
+		}
+	}
+	return routingType
+}
+
+// checkMessage checks if msg is valid to be sent to remote node
+func (rn *RemoteNode) checkMessage(msg *protobuf.Message) error {
+	if len(msg.MessageId) == 0 {
+		return errors.New("Message ID is empty")
+	}
+
+	if uint32(msg.Size()) > rn.LocalNode.MaxMessageSize {
+		return fmt.Errorf("Msg size %d exceeds max msg size %d", msg.Size(), rn.LocalNode.MaxMessageSize)
+	}
+
+	return nil
+}
+
+// encodeMessage marshals msg into the bytes that will be written to conn
+func (rn *RemoteNode) encodeMessage(msg *protobuf.Message) ([]byte, error) {
+	buf, err := marshalMsg(msg, rn.MessageCodec())
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err = compressMsgBuf(buf, rn.Compression(), rn.LocalNode.CompressionDictionary, rn.LocalNode.CompressionThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(len(buf)) > rn.LocalNode.MaxMessageSize {
+		return nil, fmt.Errorf("Msg size %d exceeds max msg size %d", len(buf), rn.LocalNode.MaxMessageSize)
+	}
+
+	return buf, nil
+}
+
+// ValidateMessage runs the same validation and marshaling as sending msg to
+// remote node without actually sending it. Returns the number of bytes that
+// would be written to conn (including frame header) and error if msg
+// cannot be sent.
+func (rn *RemoteNode) ValidateMessage(msg *protobuf.Message) (int, error) {
+	err := rn.checkMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	buf, err := rn.encodeMessage(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	return rn.LocalNode.framer.FrameSize(len(buf)), nil
+}
+
+// SendMessage marshals and sends msg, will returns a RemoteMessage chan if
+// hasReply is true and reply is received within replyTimeout. The chan
+// receives nil if remote node stops before reply is received.
+func (rn *RemoteNode) SendMessage(msg *protobuf.Message, hasReply bool, replyTimeout time.Duration) (<-chan *RemoteMessage, error) {
+	return rn.sendMessage(context.Background(), msg, hasReply, replyTimeout, rn.enqueueMessage)
+}
+
+// sendMessage is the same as SendMessage, but uses enqueue to add msg to
+// txMsgChan. Waiting for room in tx queues stops when ctx is done.
+func (rn *RemoteNode) sendMessage(ctx context.Context, msg *protobuf.Message, hasReply bool, replyTimeout time.Duration, enqueue func(*protobuf.Message) error) (<-chan *RemoteMessage, error) {
 	if rn.IsStopped() {
 		return nil, errors.New("Remote node has stopped")
 	}
 
-	if len(msg.MessageId) == 0 {
-		return nil, errors.New("Message ID is empty")
+	if rn.IsStopping() && !isNodeControlMsg(msg) {
+		return nil, errors.New("Remote node is stopping")
+	}
+
+	err := rn.checkMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkReplyExpectation(msg, hasReply)
+	if err != nil {
+		if rn.LocalNode.ReplyMismatchPolicy == "error" {
+			return nil, err
+		}
+		rn.LocalNode.logger.Warning(err)
 	}
 
 	_, found := rn.txMsgCache.Get(msg.MessageId)
@@ -477,36 +1804,225 @@ func (rn *RemoteNode) SendMessage(msg *protobuf.Message, hasReply bool, replyTim
 		return nil, nil
 	}
 
-	err := rn.txMsgCache.Add(msg.MessageId, struct{}{})
+	budgetWindow, err := rn.takeSendBudget(msg)
 	if err != nil {
 		return nil, err
 	}
 
-	select {
-	case rn.txMsgChan <- msg:
-	default:
-		return nil, errors.New("Tx msg chan full, discarding msg")
+	err = rn.txMsgCache.Add(msg.MessageId, struct{}{})
+	if err != nil {
+		rn.refundSendBudget(msg, budgetWindow)
+		return nil, err
+	}
+
+	queuedBytes, err := rn.reserveTxQueue(ctx, msg)
+	if err != nil {
+		rn.refundSendBudget(msg, budgetWindow)
+		rn.txMsgCache.Delete(msg.MessageId)
+		return nil, err
+	}
+
+	err = enqueue(msg)
+	if err != nil {
+		rn.refundSendBudget(msg, budgetWindow)
+		rn.releaseTxQueue(queuedBytes)
+		// msg is not sent, so it can be sent again
+		rn.txMsgCache.Delete(msg.MessageId)
+		return nil, err
 	}
 
 	if hasReply {
-		return rn.LocalNode.AllocReplyChan(msg.MessageId, replyTimeout)
+		return rn.LocalNode.allocReplyChan(msg.MessageId, replyTimeout, rn, msg)
 	}
 
 	return nil, nil
 }
 
+// enqueueMessage adds msg to txMsgChan without checking tx msg cache
+func (rn *RemoteNode) enqueueMessage(msg *protobuf.Message) error {
+	if rn.enqueuePriority(msg) {
+		return nil
+	}
+
+	txMsgChan := rn.getTxMsgChan()
+
+	if rn.LocalNode.RemoteBroadcastPacingLen > 0 && isBroadcastMsg(msg) && rn.enqueuePaced(msg) {
+		updateWatermark(&rn.txMsgChanWatermark, len(txMsgChan))
+		return nil
+	}
+
+	select {
+	case txMsgChan <- msg:
+	default:
+		action := rn.LocalNode.backpressure.OnTxFull(rn, msg)
+		if action == BackpressureDropOldest {
+			rn.enqueueDropOldest(msg)
+			break
+		}
+
+		err := rn.LocalNode.ApplyBackpressure(action, rn, func(done <-chan struct{}) bool {
+			select {
+			case txMsgChan <- msg:
+				return true
+			case <-done:
+				return false
+			}
+		}, "Tx msg chan full")
+		if err != nil {
+			atomic.AddUint64(&rn.txDropped, 1)
+			return err
+		}
+	}
+
+	updateWatermark(&rn.txMsgChanWatermark, len(txMsgChan))
+
+	return nil
+}
+
+// SendMessageWithContext sends msg and waits for reply if hasReply is true.
+// Unlike SendMessage, it waits for room if tx msg chan is full instead of
+// discarding msg. Both enqueueing msg and waiting for reply stop when ctx is
+// done, in which case ctx.Err() is returned so callers can tell cancellation
+// (context.Canceled) from deadline (context.DeadlineExceeded). If ctx has no
+// deadline, reply is waited for up to the default reply timeout of remote node.
+func (rn *RemoteNode) SendMessageWithContext(ctx context.Context, msg *protobuf.Message, hasReply bool) (*RemoteMessage, error) {
+	replyTimeout := rn.ReplyTimeout()
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		replyTimeout = time.Until(deadline)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	replyChan, err := rn.sendMessage(ctx, msg, hasReply, replyTimeout, func(msg *protobuf.Message) error {
+		return rn.enqueueMessageWithContext(ctx, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasReply {
+		return nil, nil
+	}
+
+	// deadline of ctx is left to ctx, so that it is reported as
+	// context.DeadlineExceeded instead of racing with a timer of its own
+	var timeout <-chan time.Time
+	if !hasDeadline {
+		timer := time.NewTimer(replyTimeout)
+		defer util.StopTimer(timer)
+		timeout = timer.C
+	}
+
+	select {
+	case replyMsg := <-replyChan:
+		if replyMsg == nil {
+			return nil, errors.New("Remote node has stopped")
+		}
+		return replyMsg, nil
+	case <-timeout:
+		rn.LocalNode.FreeReplyChan(msg.MessageId)
+		rn.countReplyTimeout()
+		return nil, errors.New("Wait for reply timeout")
+	case <-ctx.Done():
+		rn.LocalNode.FreeReplyChan(msg.MessageId)
+		return nil, ctx.Err()
+	case <-rn.Done():
+		rn.LocalNode.FreeReplyChan(msg.MessageId)
+		return nil, errors.New("Remote node has stopped")
+	}
+}
+
+// enqueueMessageWithContext adds msg to txMsgChan, waiting for room until ctx
+// is done or remote node stops
+func (rn *RemoteNode) enqueueMessageWithContext(ctx context.Context, msg *protobuf.Message) error {
+	if rn.enqueuePriority(msg) {
+		return nil
+	}
+
+	txMsgChan := rn.getTxMsgChan()
+
+	select {
+	case txMsgChan <- msg:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rn.Done():
+		return errors.New("Remote node has stopped")
+	}
+
+	updateWatermark(&rn.txMsgChanWatermark, len(txMsgChan))
+
+	return nil
+}
+
+// SendMessageBlocking sends msg without waiting for reply. Unlike
+// SendMessageAsync, it waits up to timeout for room if tx msg chan is full
+// instead of discarding msg, so callers get flow control without retrying.
+// Timeout 0 means waiting until remote node stops. Returns error if timeout
+// elapses or remote node stops before msg is queued.
+func (rn *RemoteNode) SendMessageBlocking(msg *protobuf.Message, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	_, err := rn.sendMessage(ctx, msg, false, 0, func(msg *protobuf.Message) error {
+		err := rn.enqueueMessageWithContext(ctx, msg)
+		if err == context.DeadlineExceeded {
+			return fmt.Errorf("Tx msg chan is still full after %v", timeout)
+		}
+		return err
+	})
+
+	return err
+}
+
 // SendMessageAsync sends msg and returns if there is an error
 func (rn *RemoteNode) SendMessageAsync(msg *protobuf.Message) error {
 	_, err := rn.SendMessage(msg, false, 0)
 	return err
 }
 
+// SendBatch sends msgs without waiting for reply in the order they are in
+// msgs. Returns an error for each msg, which is nil if msg is queued
+// successfully. A msg that fails to be queued does not stop the rest of the
+// batch. Concurrent SendBatch calls are serialized so msgs of different
+// batches are not interleaved with each other, but msgs sent concurrently by
+// other methods (e.g. SendMessage) may be queued between msgs of a batch. Order
+// is only kept among msgs of the same queue: priority msgs (node control msgs
+// and replies) may be sent ahead of earlier msgs in the batch, and paced
+// broadcast msgs may be sent after later ones. Msgs are queued in order, but
+// each multiplexer stream has its own tx loop, so they are only written to
+// conn in order if there is a single stream (NumStreamsToOpen and
+// NumStreamsToAccept are 1).
+func (rn *RemoteNode) SendBatch(msgs []*protobuf.Message) []error {
+	errs := make([]error, len(msgs))
+
+	rn.batchLock.Lock()
+	defer rn.batchLock.Unlock()
+
+	for i, msg := range msgs {
+		if msg == nil {
+			errs[i] = errors.New("Message is nil")
+			continue
+		}
+		_, errs[i] = rn.SendMessage(msg, false, 0)
+	}
+
+	return errs
+}
+
 // SendMessageSync sends msg, returns reply message or error if don't receive
-// reply within replyTimeout. Will use default reply timeout in config if
-// replyTimeout = 0.
+// reply within replyTimeout, which can be set per msg. Will use default reply
+// timeout of remote node (see ReplyTimeout) if replyTimeout = 0. Reply chan is
+// freed if reply is not received.
 func (rn *RemoteNode) SendMessageSync(msg *protobuf.Message, replyTimeout time.Duration) (*RemoteMessage, error) {
 	if replyTimeout == 0 {
-		replyTimeout = rn.LocalNode.DefaultReplyTimeout
+		replyTimeout = rn.ReplyTimeout()
 	}
 
 	replyChan, err := rn.SendMessage(msg, true, replyTimeout)
@@ -514,22 +2030,169 @@ func (rn *RemoteNode) SendMessageSync(msg *protobuf.Message, replyTimeout time.D
 		return nil, err
 	}
 
+	timer := time.NewTimer(replyTimeout)
+	defer util.StopTimer(timer)
+
 	select {
 	case replyMsg := <-replyChan:
+		if replyMsg == nil {
+			return nil, errors.New("Remote node has stopped")
+		}
 		return replyMsg, nil
-	case <-time.After(replyTimeout):
+	case <-timer.C:
+		rn.LocalNode.FreeReplyChan(msg.MessageId)
+		rn.countReplyTimeout()
 		return nil, errors.New("Wait for reply timeout")
+	case <-rn.Done():
+		rn.LocalNode.FreeReplyChan(msg.MessageId)
+		return nil, errors.New("Remote node has stopped")
 	}
 }
 
+// SendMessageSyncTimed is the same as SendMessageSync, but also returns the
+// time between sending msg and receiving reply, which is also used to update
+// the measured round trip time to remote node.
+func (rn *RemoteNode) SendMessageSyncTimed(msg *protobuf.Message, replyTimeout time.Duration) (*RemoteMessage, time.Duration, error) {
+	startTime := time.Now()
+
+	reply, err := rn.SendMessageSync(msg, replyTimeout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	roundTripTime := time.Since(startTime)
+	rn.updateRoundTripTime(roundTripTime)
+
+	return reply, roundTripTime, nil
+}
+
+// SendMessageSyncUntil is the same as SendMessageSync, but waits for reply
+// until deadline instead of a timeout duration. Returns error without sending
+// msg if deadline has already passed. Reply chan is freed if reply is not
+// received before deadline.
+func (rn *RemoteNode) SendMessageSyncUntil(msg *protobuf.Message, deadline time.Time) (*RemoteMessage, error) {
+	replyTimeout := time.Until(deadline)
+	if replyTimeout <= 0 {
+		return nil, errors.New("Deadline exceeded")
+	}
+
+	replyChan, err := rn.SendMessage(msg, true, replyTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(replyTimeout)
+	defer util.StopTimer(timer)
+
+	select {
+	case replyMsg := <-replyChan:
+		if replyMsg == nil {
+			return nil, errors.New("Remote node has stopped")
+		}
+		return replyMsg, nil
+	case <-timer.C:
+		err = rn.LocalNode.FreeReplyChan(msg.MessageId)
+		if err != nil {
+			rn.LocalNode.logger.Warningf("Free reply chan of msg %x error: %v", msg.MessageId, err)
+		}
+		rn.countReplyTimeout()
+		return nil, errors.New("Wait for reply timeout")
+	}
+}
+
+// SendMessageSyncWithRetry is the same as SendMessageSync, but will resend msg
+// up to maxRetries times if reply is not received within replyTimeout. Retries
+// keep the message id of the original msg, so remote node will recognize them
+// as duplicates by its rx msg cache and handle msg at most once, and a reply to
+// any of them will be matched to the same reply chan. As a result, retries only
+// help when msg is lost before being handled by remote node. If remote node has
+// handled msg but the reply is lost, retries will not trigger a new reply.
+// Total wait time is at most (maxRetries + 1) * replyTimeout. Reply chan is
+// freed if reply is not received.
+func (rn *RemoteNode) SendMessageSyncWithRetry(msg *protobuf.Message, replyTimeout time.Duration, maxRetries uint32) (*RemoteMessage, error) {
+	if replyTimeout == 0 {
+		replyTimeout = rn.ReplyTimeout()
+	}
+
+	replyChan, err := rn.SendMessage(msg, true, time.Duration(maxRetries+1)*replyTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(replyTimeout)
+	defer util.StopTimer(timer)
+
+	for i := uint32(0); ; i++ {
+		select {
+		case replyMsg := <-replyChan:
+			if replyMsg == nil {
+				return nil, errors.New("Remote node has stopped")
+			}
+			return replyMsg, nil
+		case <-timer.C:
+		case <-rn.Done():
+			rn.LocalNode.FreeReplyChan(msg.MessageId)
+			return nil, errors.New("Remote node has stopped")
+		}
+
+		if i >= maxRetries {
+			rn.LocalNode.FreeReplyChan(msg.MessageId)
+			rn.countReplyTimeout()
+			return nil, errors.New("Wait for reply timeout")
+		}
+
+		if rn.IsStopped() {
+			rn.LocalNode.FreeReplyChan(msg.MessageId)
+			return nil, errors.New("Remote node has stopped")
+		}
+
+		rn.LocalNode.logger.Infof("Wait for reply of msg %x timeout, retry %d/%d", msg.MessageId, i+1, maxRetries)
+
+		ctx, cancel := context.WithTimeout(context.Background(), replyTimeout)
+		err = rn.resendMessage(ctx, msg)
+		cancel()
+		if err != nil {
+			rn.LocalNode.logger.Warningf("Resend msg %x error: %v", msg.MessageId, err)
+		}
+
+		timer.Reset(replyTimeout)
+	}
+}
+
+// resendMessage adds msg that has already been sent to txMsgChan again. Like
+// sendMessage, msg is counted in tx queues before it is added and uncounted if
+// it fails to be added, as it will be uncounted again once sent. Waiting for
+// room in tx queues stops when ctx is done.
+func (rn *RemoteNode) resendMessage(ctx context.Context, msg *protobuf.Message) error {
+	queuedBytes, err := rn.reserveTxQueue(ctx, msg)
+	if err != nil {
+		return err
+	}
+
+	err = rn.enqueueMessage(msg)
+	if err != nil {
+		rn.releaseTxQueue(queuedBytes)
+		return err
+	}
+
+	return nil
+}
+
 // Ping sends a Ping message to remote node and wait for reply
 func (rn *RemoteNode) Ping() error {
+	return rn.ping(0)
+}
+
+// ping sends a Ping message to remote node and wait for reply within
+// replyTimeout, or the default reply timeout of remote node if replyTimeout is
+// 0
+func (rn *RemoteNode) ping(replyTimeout time.Duration) error {
 	msg, err := rn.LocalNode.NewPingMessage()
 	if err != nil {
 		return err
 	}
 
-	_, err = rn.SendMessageSync(msg, 0)
+	_, err = rn.SendMessageSync(msg, replyTimeout)
 	if err != nil {
 		return err
 	}
@@ -539,6 +2202,17 @@ func (rn *RemoteNode) Ping() error {
 
 // GetNode sends a GetNode message to remote node and wait for reply
 func (rn *RemoteNode) GetNode() (*protobuf.Node, error) {
+	reply, err := rn.getNodeReply()
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Node, nil
+}
+
+// getNodeReply sends a GetNode message to remote node and returns the reply,
+// which also contains the compression codecs supported by remote node
+func (rn *RemoteNode) getNodeReply() (*protobuf.GetNodeReply, error) {
 	msg, err := rn.LocalNode.NewGetNodeMessage()
 	if err != nil {
 		return nil, err
@@ -555,7 +2229,38 @@ func (rn *RemoteNode) GetNode() (*protobuf.Node, error) {
 		return nil, err
 	}
 
-	return replyBody.Node, nil
+	return replyBody, nil
+}
+
+// NotifyDrain sends a Drain message to remote node to notify it that we are
+// about to shut down and it should stop routing new messages to us
+func (rn *RemoteNode) NotifyDrain() error {
+	msg, err := rn.LocalNode.NewDrainMessage()
+	if err != nil {
+		return err
+	}
+
+	err = rn.SendMessageAsync(msg)
+	if err != nil {
+		return err
+	}
+
+	rn.Lock()
+	rn.startDrainLocked()
+	rn.Unlock()
+
+	return nil
+}
+
+// sendAck sends an Ack message to remote node to notify it whether the msg
+// with msgID is delivered to routing chan
+func (rn *RemoteNode) sendAck(msgID []byte, delivered bool) error {
+	msg, err := rn.LocalNode.NewAckMessage(msgID, delivered)
+	if err != nil {
+		return err
+	}
+
+	return rn.SendMessageAsync(msg)
 }
 
 // NotifyStop sends a Stop message to remote node to notify it that we will
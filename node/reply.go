@@ -0,0 +1,188 @@
+package node
+
+import (
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// ReplyValidator is called when a reply is received for a msg sent by
+// SendMessage with hasReply true, before the reply is passed to the reply chan.
+// It returns if reply is a valid reply to request. Invalid reply is discarded
+// and the reply chan keeps waiting for another reply until it expires, so a
+// forged reply does not resolve the request.
+type ReplyValidator func(request, reply *protobuf.Message) bool
+
+// PendingReplyInfo is the information of a reply chan that is still waiting
+// for reply
+type PendingReplyInfo struct {
+	MessageID  []byte
+	RemoteNode *RemoteNode   // The remote node msg is sent to, nil if unknown
+	Age        time.Duration // How long it has been waiting for reply
+	Timeout    time.Duration // How long it waits before reply chan expires
+}
+
+// pendingReply is an entry in pending replies of local node
+type pendingReply struct {
+	remoteNode *RemoteNode
+	request    *protobuf.Message
+	startTime  time.Time
+	timeout    time.Duration
+}
+
+// addPendingReply adds msgID to pending replies
+func (ln *LocalNode) addPendingReply(msgID []byte, remoteNode *RemoteNode, request *protobuf.Message, timeout time.Duration) {
+	ln.pendingReplies.Store(string(msgID), &pendingReply{
+		remoteNode: remoteNode,
+		request:    request,
+		startTime:  time.Now(),
+		timeout:    timeout,
+	})
+}
+
+// SetReplyValidator sets the validator of replies received by local node. nil
+// means all replies are valid. It should be called before local node starts.
+func (ln *LocalNode) SetReplyValidator(validator ReplyValidator) {
+	ln.replyValidator = validator
+}
+
+// ValidateReply returns if reply is valid according to reply validator of
+// local node. It should be called before passing reply to reply chan. Reply is
+// considered valid if there is no validator, or the request is unknown (e.g.
+// reply chan is allocated by AllocReplyChan, or a valid reply has already been
+// received).
+func (ln *LocalNode) ValidateReply(reply *protobuf.Message) bool {
+	if ln.replyValidator == nil {
+		return true
+	}
+
+	value, ok := ln.pendingReplies.Load(string(reply.ReplyToId))
+	if !ok {
+		return true
+	}
+
+	request := value.(*pendingReply).request
+	if request == nil {
+		return true
+	}
+
+	return ln.replyValidator(request, reply)
+}
+
+// ClaimReply marks that a reply to msg with id msgID has been received, and
+// removes msgID from pending replies. It returns false if a reply to msgID has
+// already been claimed, in which case the reply is a duplicate (e.g.
+// retransmitted or forged) and should be discarded instead of being passed to
+// the reply chan. It should be called after the reply is validated.
+func (ln *LocalNode) ClaimReply(msgID []byte) bool {
+	var claimed interface{} = struct{}{}
+	if value, ok := ln.pendingReplies.Load(string(msgID)); ok {
+		claimed = value
+	}
+
+	if ln.repliedCache.Add(msgID, claimed) != nil {
+		return false
+	}
+
+	ln.removePendingReply(msgID)
+
+	return true
+}
+
+// UnclaimReply reverts ClaimReply if the reply could not be passed to the
+// reply chan, so that a later reply to msgID can still be delivered and is
+// validated against the same request
+func (ln *LocalNode) UnclaimReply(msgID []byte) {
+	value, ok := ln.repliedCache.Get(msgID)
+	ln.repliedCache.Delete(msgID)
+	if !ok {
+		return
+	}
+
+	pr, ok := value.(*pendingReply)
+	if !ok {
+		return
+	}
+
+	if _, ok = ln.replyChanCache.Get(msgID); ok {
+		ln.pendingReplies.Store(string(msgID), pr)
+	}
+}
+
+// failPendingReplies frees the reply chans of msg sent to remoteNode that are
+// still waiting for reply, and passes nil to them so that callers waiting for
+// reply return as soon as remoteNode stops instead of after reply timeout
+func (ln *LocalNode) failPendingReplies(remoteNode *RemoteNode) {
+	ln.pendingReplies.Range(func(key, value interface{}) bool {
+		if value.(*pendingReply).remoteNode != remoteNode {
+			return true
+		}
+
+		msgID := []byte(key.(string))
+		replyChan, ok := ln.GetReplyChan(msgID)
+		ln.FreeReplyChan(msgID)
+		if ok {
+			select {
+			case replyChan <- nil:
+			default:
+			}
+		}
+
+		return true
+	})
+}
+
+// removePendingReply removes msgID from pending replies
+func (ln *LocalNode) removePendingReply(msgID []byte) {
+	ln.pendingReplies.Delete(string(msgID))
+}
+
+// isExpired returns if reply chan has expired
+func (pr *pendingReply) isExpired() bool {
+	return time.Since(pr.startTime) > pr.timeout
+}
+
+// cleanupPendingReplies periodically removes expired reply chans from pending
+// replies
+func (ln *LocalNode) cleanupPendingReplies() {
+	defer ln.wg.Done()
+
+	ticker := time.NewTicker(ln.ReplyChanCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ln.pendingReplies.Range(func(key, value interface{}) bool {
+				if value.(*pendingReply).isExpired() {
+					ln.pendingReplies.Delete(key)
+				}
+				return true
+			})
+		case <-ln.Done():
+			return
+		}
+	}
+}
+
+// PendingReplies returns a snapshot of all reply chans that are still waiting
+// for reply, which can be used to debug stuck requests and reply chan leaks.
+// Reply chans that have received a reply, been freed or expired are not
+// included.
+func (ln *LocalNode) PendingReplies() []PendingReplyInfo {
+	pendingReplies := make([]PendingReplyInfo, 0)
+	ln.pendingReplies.Range(func(key, value interface{}) bool {
+		pr := value.(*pendingReply)
+		if pr.isExpired() {
+			return true
+		}
+		pendingReplies = append(pendingReplies, PendingReplyInfo{
+			MessageID:  []byte(key.(string)),
+			RemoteNode: pr.remoteNode,
+			Age:        time.Since(pr.startTime),
+			Timeout:    pr.timeout,
+		})
+		return true
+	})
+	return pendingReplies
+}
@@ -0,0 +1,446 @@
+package node
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestTwoReplies(t *testing.T) {
+	ln := newTestLocalNode(t, newSingleStreamConfig())
+	peer := newTestLocalNode(t, newSingleStreamConfig())
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	msg := newTestMessage(t, ln, []byte("request"))
+	replyChan, err := rn.SendMessage(msg, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteMsg := recvTestMessage(t, peer, time.Second)
+	for _, data := range []string{"first", "second"} {
+		reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte(data))
+		err = remoteMsg.RemoteNode.SendMessageAsync(reply)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case reply := <-replyChan:
+		if !bytes.Equal(reply.Msg.Message, []byte("first")) {
+			t.Fatalf("reply is %q, expecting %q", reply.Msg.Message, "first")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply received")
+	}
+
+	select {
+	case reply := <-replyChan:
+		t.Fatalf("duplicate reply %q is passed to reply chan", reply.Msg.Message)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if pending := ln.PendingReplies(); len(pending) != 0 {
+		t.Fatalf("%d reply chans are still pending", len(pending))
+	}
+}
+
+func TestGetReplyChanKeepsPending(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	rn := newTestIdleRemoteNode(t, ln)
+
+	ln.SetReplyValidator(func(request, reply *protobuf.Message) bool {
+		return bytes.Equal(reply.Message, []byte("valid"))
+	})
+
+	msg := newTestMessage(t, ln, []byte("request"))
+	_, err := rn.SendMessage(msg, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ok := ln.GetReplyChan(msg.MessageId)
+	if !ok {
+		t.Fatal("reply chan not found")
+	}
+	if pending := ln.PendingReplies(); len(pending) != 1 {
+		t.Fatalf("%d reply chans are pending after GetReplyChan, expecting 1", len(pending))
+	}
+
+	// reply is still validated against the request after GetReplyChan
+	if ln.ValidateReply(newTestReply(t, ln, msg.MessageId, []byte("forged"))) {
+		t.Fatal("forged reply is valid after GetReplyChan")
+	}
+
+	if !ln.ClaimReply(msg.MessageId) {
+		t.Fatal("claim reply failed")
+	}
+	if ln.ClaimReply(msg.MessageId) {
+		t.Fatal("reply is claimed twice")
+	}
+	if pending := ln.PendingReplies(); len(pending) != 0 {
+		t.Fatalf("%d reply chans are pending after reply is claimed", len(pending))
+	}
+
+	// unclaimed reply chan is pending and validated again
+	ln.UnclaimReply(msg.MessageId)
+	if pending := ln.PendingReplies(); len(pending) != 1 {
+		t.Fatalf("%d reply chans are pending after reply is unclaimed, expecting 1", len(pending))
+	}
+	if ln.ValidateReply(newTestReply(t, ln, msg.MessageId, []byte("forged"))) {
+		t.Fatal("forged reply is valid after reply is unclaimed")
+	}
+	if !ln.ClaimReply(msg.MessageId) {
+		t.Fatal("claim reply failed after reply is unclaimed")
+	}
+}
+
+func TestPendingRepliesFailOnStop(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	rn := newTestIdleRemoteNode(t, ln)
+	other := newTestIdleRemoteNode(t, ln)
+
+	msg := newTestMessage(t, ln, []byte("request"))
+	replyChan, err := rn.SendMessage(msg, true, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherMsg := newTestMessage(t, ln, []byte("request"))
+	otherReplyChan, err := other.SendMessage(otherMsg, true, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	syncMsg := newTestMessage(t, ln, []byte("request"))
+	syncErrChan := make(chan error, 1)
+	go func() {
+		_, err := rn.SendMessageSync(syncMsg, time.Minute)
+		syncErrChan <- err
+	}()
+
+	waitFor(t, time.Second, func() bool {
+		return len(ln.PendingReplies()) == 3
+	})
+
+	rn.Stop(nil)
+
+	select {
+	case reply := <-replyChan:
+		if reply != nil {
+			t.Fatalf("reply chan receives %v after remote node stops, expecting nil", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reply chan is not notified after remote node stops")
+	}
+
+	select {
+	case err := <-syncErrChan:
+		if err == nil {
+			t.Fatal("send msg sync succeeds after remote node stops")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("send msg sync does not return after remote node stops")
+	}
+
+	// reply chans of other remote nodes are kept
+	select {
+	case reply := <-otherReplyChan:
+		t.Fatalf("reply chan of other remote node receives %v", reply)
+	default:
+	}
+	pending := ln.PendingReplies()
+	if len(pending) != 1 {
+		t.Fatalf("%d reply chans are pending after remote node stops, expecting 1", len(pending))
+	}
+}
+
+func TestReplyMismatchPolicy(t *testing.T) {
+	newMsg := func(ln *LocalNode, msgType protobuf.MessageType, replyToID []byte) *protobuf.Message {
+		msg := newTestMessage(t, ln, nil)
+		msg.MessageType = msgType
+		msg.ReplyToId = replyToID
+		return msg
+	}
+
+	for _, policy := range []string{"log", "error"} {
+		ln := newTestLocalNode(t, &config.Config{ReplyMismatchPolicy: policy})
+		rn := newTestIdleRemoteNode(t, ln)
+
+		tests := []struct {
+			msg      *protobuf.Message
+			hasReply bool
+			mismatch bool
+		}{
+			{newMsg(ln, protobuf.PING, nil), true, false},
+			{newMsg(ln, protobuf.STOP, nil), false, false},
+			{newMsg(ln, protobuf.STOP, nil), true, true},
+			{newMsg(ln, protobuf.PING, nil), false, true},
+			{newMsg(ln, protobuf.PING, []byte("id")), true, true},
+			{newMsg(ln, protobuf.PING, []byte("id")), false, false},
+		}
+
+		for i, test := range tests {
+			_, err := rn.SendMessage(test.msg, test.hasReply, time.Second)
+			if policy == "error" && test.mismatch {
+				if err == nil {
+					t.Fatalf("test %d: mismatched msg is sent with policy %s", i, policy)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("test %d: send msg with policy %s error: %v", i, policy, err)
+			}
+		}
+	}
+}
+
+func TestPendingReplies(t *testing.T) {
+	const shortTimeout = 300 * time.Millisecond
+
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+	idle := newTestIdleRemoteNode(t, ln)
+
+	msg := newTestMessage(t, ln, []byte("request"))
+	_, err := idle.SendMessage(msg, true, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shortMsg := newTestMessage(t, ln, []byte("request"))
+	_, err = idle.SendMessage(shortMsg, true, shortTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allocID, err := ln.GenMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ln.AllocReplyChan(allocID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repliedMsg := newTestMessage(t, ln, []byte("request"))
+	replyChan, err := rn.SendMessage(repliedMsg, true, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteMsg := recvTestMessage(t, peer, time.Second)
+	_, err = remoteMsg.RemoteNode.SendMessage(newTestReply(t, peer, repliedMsg.MessageId, []byte("reply")), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-replyChan:
+	case <-time.After(time.Second):
+		t.Fatal("reply is not received")
+	}
+
+	time.Sleep(shortTimeout / 3)
+
+	expected := map[string]PendingReplyInfo{
+		string(msg.MessageId):      {RemoteNode: idle, Timeout: time.Minute},
+		string(shortMsg.MessageId): {RemoteNode: idle, Timeout: shortTimeout},
+		string(allocID):            {RemoteNode: nil, Timeout: time.Minute},
+	}
+
+	pendingReplies := ln.PendingReplies()
+	if len(pendingReplies) != len(expected) {
+		t.Fatalf("%d pending replies, expecting %d", len(pendingReplies), len(expected))
+	}
+	for _, info := range pendingReplies {
+		e, ok := expected[string(info.MessageID)]
+		if !ok {
+			t.Fatalf("unexpected pending reply of msg %x", info.MessageID)
+		}
+		if info.RemoteNode != e.RemoteNode {
+			t.Fatalf("pending reply of msg %x has remote node %v, expecting %v", info.MessageID, info.RemoteNode, e.RemoteNode)
+		}
+		if info.Timeout != e.Timeout {
+			t.Fatalf("pending reply of msg %x has timeout %v, expecting %v", info.MessageID, info.Timeout, e.Timeout)
+		}
+		if info.Age < shortTimeout/3 || info.Age >= info.Timeout {
+			t.Fatalf("pending reply of msg %x has age %v, expecting between %v and %v", info.MessageID, info.Age, shortTimeout/3, info.Timeout)
+		}
+	}
+
+	// expired reply chan is excluded
+	time.Sleep(shortTimeout)
+	for _, info := range ln.PendingReplies() {
+		if bytes.Equal(info.MessageID, shortMsg.MessageId) {
+			t.Fatal("expired reply chan is still pending")
+		}
+	}
+
+	// freed reply chan is excluded
+	ln.FreeReplyChan(allocID)
+	if n := len(ln.PendingReplies()); n != 1 {
+		t.Fatalf("%d pending replies after reply chan is freed, expecting 1", n)
+	}
+}
+
+func TestReplyValidator(t *testing.T) {
+	// replies are received in the order they are sent
+	ln := newTestLocalNode(t, newSingleStreamConfig())
+	peer := newTestLocalNode(t, newSingleStreamConfig())
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	// valid reply echoes the request, replies to node msg (e.g. keepalive
+	// ping) are not checked
+	requests := make(chan *protobuf.Message, 3)
+	ln.SetReplyValidator(func(request, reply *protobuf.Message) bool {
+		if request.MessageType != protobuf.BYTES {
+			return true
+		}
+		requests <- request
+		return bytes.Equal(reply.Message, request.Message)
+	})
+
+	msg := newTestMessage(t, ln, []byte("request"))
+	replyChan, err := rn.SendMessage(msg, true, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteMsg := recvTestMessage(t, peer, time.Second)
+	for _, data := range []string{"forged", "request"} {
+		_, err = remoteMsg.RemoteNode.SendMessage(newTestReply(t, peer, msg.MessageId, []byte(data)), false, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case reply := <-replyChan:
+		if reply == nil || string(reply.Msg.Message) != "request" {
+			t.Fatalf("reply chan receives %v, expecting the valid reply", reply)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("valid reply after an invalid one is not received")
+	}
+
+	if n := len(requests); n != 2 {
+		t.Fatalf("validator is called %d times, expecting 2", n)
+	}
+	for i := 0; i < 2; i++ {
+		if request := <-requests; !bytes.Equal(request.MessageId, msg.MessageId) {
+			t.Fatalf("validator is called with request %x, expecting %x", request.MessageId, msg.MessageId)
+		}
+	}
+	if n := len(ln.PendingReplies()); n != 0 {
+		t.Fatalf("%d reply chans are pending after valid reply is received", n)
+	}
+
+	// request without valid reply times out
+	msg = newTestMessage(t, ln, []byte("request"))
+	replyChan, err = rn.SendMessage(msg, true, 300*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteMsg = recvTestMessage(t, peer, time.Second)
+	_, err = remoteMsg.RemoteNode.SendMessage(newTestReply(t, peer, msg.MessageId, []byte("forged")), false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reply := <-replyChan:
+		t.Fatalf("reply chan receives invalid reply %v", reply)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestAllocReplyChanOutstanding(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+
+	msgID, err := ln.GenMessageID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ln.AllocReplyChan(msgID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ln.AllocReplyChan(msgID, time.Minute); err == nil {
+		t.Fatal("reply chan is allocated for msg id with an outstanding reply chan")
+	}
+
+	// duplicate reply is discarded even after the first one is consumed
+	if !ln.ClaimReply(msgID) {
+		t.Fatal("claim reply failed")
+	}
+	if ln.ClaimReply(msgID) {
+		t.Fatal("duplicate reply is claimed")
+	}
+	if _, err = ln.AllocReplyChan(msgID, time.Minute); err == nil {
+		t.Fatal("reply chan is allocated for msg id before its reply chan is freed")
+	}
+
+	// msg id can be reused after reply chan is freed
+	ln.FreeReplyChan(msgID)
+	_, err = ln.AllocReplyChan(msgID, time.Minute)
+	if err != nil {
+		t.Fatalf("allocate reply chan after it is freed error: %v", err)
+	}
+	if !ln.ClaimReply(msgID) {
+		t.Fatal("claim reply of reused msg id failed")
+	}
+	ln.FreeReplyChan(msgID)
+
+	// or after it expires
+	_, err = ln.AllocReplyChan(msgID, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 2*time.Second, func() bool {
+		_, ok := ln.GetReplyChan(msgID)
+		return !ok
+	})
+	if _, err = ln.AllocReplyChan(msgID, time.Minute); err != nil {
+		t.Fatalf("allocate reply chan after it expires error: %v", err)
+	}
+
+	if _, err = ln.AllocReplyChan(nil, time.Minute); err == nil {
+		t.Fatal("reply chan is allocated for empty msg id")
+	}
+}
+
+func TestDuplicateReplyAfterConsumed(t *testing.T) {
+	ln := newTestLocalNode(t, newSingleStreamConfig())
+	peer := newTestLocalNode(t, newSingleStreamConfig())
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	msg := newTestMessage(t, ln, []byte("request"))
+	replyChan, err := rn.SendMessage(msg, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteMsg := recvTestMessage(t, peer, time.Second)
+
+	// the first reply is consumed before the duplicate arrives
+	for _, data := range []string{"first", "second"} {
+		err = remoteMsg.RemoteNode.SendMessageAsync(newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case reply := <-replyChan:
+			if data != "first" {
+				t.Fatalf("duplicate reply %q is passed to reply chan", reply.Msg.Message)
+			}
+		case <-time.After(200 * time.Millisecond):
+			if data == "first" {
+				t.Fatal("no reply received")
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package node
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSendMessageSyncWithRetry(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	const replyTimeout = 100 * time.Millisecond
+
+	msg := newTestMessage(t, ln, []byte("request"))
+
+	// peer replies after the first send times out and msg is retried
+	go func() {
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		time.Sleep(replyTimeout * 3 / 2)
+		reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte("reply"))
+		err := remoteMsg.RemoteNode.SendMessageAsync(reply)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	reply, err := rn.SendMessageSyncWithRetry(msg, replyTimeout, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Msg.ReplyToId, msg.MessageId) {
+		t.Fatalf("reply to %x, expecting %x", reply.Msg.ReplyToId, msg.MessageId)
+	}
+	if !bytes.Equal(reply.Msg.Message, []byte("reply")) {
+		t.Fatalf("reply is %q, expecting %q", reply.Msg.Message, "reply")
+	}
+
+	// retried msg is recognized as duplicate and handled once
+	time.Sleep(replyTimeout)
+	value, _ := testMsgChans.Load(peer)
+	if n := len(value.(<-chan *RemoteMessage)); n != 0 {
+		t.Fatalf("peer received %d more msg, expecting retries to be deduplicated", n)
+	}
+	if hits := atomic.LoadUint64(&peer.rxMsgCacheHits); hits == 0 {
+		t.Fatal("retry is not received as duplicate by peer")
+	}
+
+	if pending := ln.PendingReplies(); len(pending) != 0 {
+		t.Fatalf("%d reply chans are still pending", len(pending))
+	}
+}
+
+func TestSendMessageSyncWithRetryTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	msg := newTestMessage(t, ln, []byte("request"))
+
+	_, err := rn.SendMessageSyncWithRetry(msg, 50*time.Millisecond, 1)
+	if err == nil {
+		t.Fatal("send msg without reply should time out")
+	}
+
+	if _, ok := ln.GetReplyChan(msg.MessageId); ok {
+		t.Fatal("reply chan is not freed after timeout")
+	}
+	if pending := ln.PendingReplies(); len(pending) != 0 {
+		t.Fatalf("%d reply chans are still pending", len(pending))
+	}
+
+	recvTestMessage(t, peer, time.Second)
+}
+
+func TestSendMessageSyncWithRetryRemoteNodeStopped(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	msg := newTestMessage(t, ln, []byte("request"))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		rn.Stop(nil)
+	}()
+
+	startTime := time.Now()
+	_, err := rn.SendMessageSyncWithRetry(msg, time.Second, 3)
+	if err == nil {
+		t.Fatal("send msg to stopped remote node should fail")
+	}
+	if elapsed := time.Since(startTime); elapsed > 500*time.Millisecond {
+		t.Fatalf("send returns %v after remote node stops", elapsed)
+	}
+
+	if _, ok := ln.GetReplyChan(msg.MessageId); ok {
+		t.Fatal("reply chan is not freed after remote node stops")
+	}
+}
@@ -0,0 +1,53 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestRoutingTypeMapper(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	// ln sends BROADCAST_PUSH msg as RELAY, which peer receives as DIRECT
+	err := ln.ApplyMiddleware(RoutingTypeMapper{func(routingType protobuf.RoutingType, direction Direction, remoteNode *RemoteNode) (protobuf.RoutingType, bool) {
+		if direction == Egress && remoteNode == rn && routingType == protobuf.BROADCAST_PUSH {
+			return protobuf.RELAY, true
+		}
+		return routingType, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = peer.ApplyMiddleware(RoutingTypeMapper{func(routingType protobuf.RoutingType, direction Direction, remoteNode *RemoteNode) (protobuf.RoutingType, bool) {
+		if direction == Ingress && remoteNode == peerRn && routingType == protobuf.RELAY {
+			return protobuf.DIRECT, true
+		}
+		return routingType, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := newTestMessage(t, ln, []byte("hello"))
+	msg.RoutingType = protobuf.BROADCAST_PUSH
+
+	err = rn.SendMessageAsync(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteMsg := recvTestMessage(t, peer, time.Second)
+	if remoteMsg.Msg.RoutingType != protobuf.DIRECT {
+		t.Fatalf("received msg routing type is %v, expecting %v", remoteMsg.Msg.RoutingType, protobuf.DIRECT)
+	}
+
+	// msg may be shared with other remote nodes and is not modified
+	if msg.RoutingType != protobuf.BROADCAST_PUSH {
+		t.Fatalf("sent msg routing type is changed to %v", msg.RoutingType)
+	}
+}
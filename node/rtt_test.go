@@ -0,0 +1,101 @@
+package node
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestSendMessageSyncTimed(t *testing.T) {
+	const replyDelay = 100 * time.Millisecond
+
+	ln := newTestLocalNode(t, &config.Config{DisableKeepAlivePing: true})
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	if _, ok := rn.RoundTripTime(); ok {
+		t.Fatal("round trip time is measured before any msg is sent")
+	}
+
+	go func() {
+		remoteMsg := recvTestMessage(t, peer, time.Second)
+		time.Sleep(replyDelay)
+		reply := newTestReply(t, peer, remoteMsg.Msg.MessageId, []byte("reply"))
+		err := remoteMsg.RemoteNode.SendMessageAsync(reply)
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	reply, roundTripTime, err := rn.SendMessageSyncTimed(newTestMessage(t, ln, []byte("request")), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Msg.Message, []byte("reply")) {
+		t.Fatalf("reply is %q, expecting %q", reply.Msg.Message, "reply")
+	}
+	if roundTripTime < replyDelay || roundTripTime > time.Second {
+		t.Fatalf("round trip time is %v, expecting between %v and %v", roundTripTime, replyDelay, time.Second)
+	}
+
+	estimate, ok := rn.RoundTripTime()
+	if !ok || estimate != roundTripTime {
+		t.Fatalf("round trip time estimate is %v, expecting %v", estimate, roundTripTime)
+	}
+
+	// timeout does not update the estimate
+	_, roundTripTime, err = rn.SendMessageSyncTimed(newTestMessage(t, ln, []byte("request")), 50*time.Millisecond)
+	if err == nil {
+		t.Fatal("send msg without reply should time out")
+	}
+	if roundTripTime != 0 {
+		t.Fatalf("round trip time is %v after timeout, expecting 0", roundTripTime)
+	}
+	if newEstimate, _ := rn.RoundTripTime(); newEstimate != estimate {
+		t.Fatalf("round trip time estimate is changed to %v after timeout", newEstimate)
+	}
+}
+
+func TestAdaptiveReplyTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		AdaptiveReplyTimeoutFactor: 4,
+		AdaptiveReplyTimeoutFloor:  100 * time.Millisecond,
+	})
+
+	rn := newTestIdleRemoteNode(t, ln)
+	if timeout := rn.ReplyTimeout(); timeout != ln.DefaultReplyTimeout {
+		t.Fatalf("reply timeout before round trip time is measured is %v, expecting default %v", timeout, ln.DefaultReplyTimeout)
+	}
+
+	rn.updateRoundTripTime(10 * time.Millisecond)
+	if timeout := rn.ReplyTimeout(); timeout != ln.AdaptiveReplyTimeoutFloor {
+		t.Fatalf("reply timeout with short round trip time is %v, expecting floor %v", timeout, ln.AdaptiveReplyTimeoutFloor)
+	}
+
+	rn = newTestIdleRemoteNode(t, ln)
+	rn.updateRoundTripTime(50 * time.Millisecond)
+	if timeout := rn.ReplyTimeout(); timeout != 200*time.Millisecond {
+		t.Fatalf("reply timeout is %v, expecting 4 times round trip time %v", timeout, 200*time.Millisecond)
+	}
+
+	// scaled timeout is used when reply timeout is not given
+	startTime := time.Now()
+	_, err := rn.SendMessageSync(newTestMessage(t, ln, []byte("request")), 0)
+	elapsed := time.Since(startTime)
+	if err == nil {
+		t.Fatal("reply is received from idle remote node")
+	}
+	if elapsed < 200*time.Millisecond || elapsed > ln.DefaultReplyTimeout/2 {
+		t.Fatalf("SendMessageSync times out after %v, expecting about %v", elapsed, 200*time.Millisecond)
+	}
+
+	// default timeout is used if factor is not set
+	defaultLn := newTestLocalNode(t, nil)
+	defaultRn := newTestIdleRemoteNode(t, defaultLn)
+	defaultRn.updateRoundTripTime(50 * time.Millisecond)
+	if timeout := defaultRn.ReplyTimeout(); timeout != defaultLn.DefaultReplyTimeout {
+		t.Fatalf("reply timeout without adaptive factor is %v, expecting default %v", timeout, defaultLn.DefaultReplyTimeout)
+	}
+}
@@ -0,0 +1,58 @@
+package node
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nnet/util"
+)
+
+// RxRateLimit returns the max msg per second and max bytes per second
+// received from remote node, 0 means unlimited
+func (rn *RemoteNode) RxRateLimit() (uint32, uint32) {
+	return rn.rxMsgLimiter.Rate(), rn.rxByteLimiter.Rate()
+}
+
+// SetRxRateLimit sets the max msg per second and max bytes per second received
+// from remote node, overriding the default ones in config. 0 means unlimited.
+// Can be called in RemoteNodeReady middleware to set rate limit based on
+// remote node.
+func (rn *RemoteNode) SetRxRateLimit(msgsPerSec, bytesPerSec uint32) {
+	rn.rxMsgLimiter.SetRate(msgsPerSec)
+	rn.rxByteLimiter.SetRate(bytesPerSec)
+}
+
+// limitRxRate takes one msg of frameSize bytes from rx rate limiters. If remote
+// node exceeds rx rate limit, it either waits until remote node is within
+// limit, which stops reading from conn and lets transport flow control push
+// back on remote node, or stops remote node, depending on RxRateLimitPolicy.
+// Returns false if remote node is stopped and msg should be discarded.
+func (rn *RemoteNode) limitRxRate(frameSize int) bool {
+	wait := rn.rxMsgLimiter.Reserve(1)
+	if byteWait := rn.rxByteLimiter.Reserve(frameSize); byteWait > wait {
+		wait = byteWait
+	}
+
+	if wait <= 0 {
+		return true
+	}
+
+	if rn.LocalNode.RxRateLimitPolicy == "stop" {
+		msgsPerSec, bytesPerSec := rn.RxRateLimit()
+		rn.Stop(fmt.Errorf("Rx rate limit exceeded (%d msg/s, %d bytes/s)", msgsPerSec, bytesPerSec))
+		return false
+	}
+
+	atomic.AddUint64(&rn.rxRateLimited, 1)
+
+	timer := time.NewTimer(wait)
+	defer util.StopTimer(timer)
+
+	select {
+	case <-timer.C:
+		return true
+	case <-rn.Done():
+		return false
+	}
+}
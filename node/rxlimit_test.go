@@ -0,0 +1,81 @@
+package node
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestRxRateLimitDelay(t *testing.T) {
+	const rate = 20
+	const numMsgs = 2 * rate
+
+	ln := newTestLocalNode(t, &config.Config{DisableKeepAlivePing: true})
+	peer := newTestLocalNode(t, &config.Config{RxRateLimitMsgs: rate, DisableKeepAlivePing: true})
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	if msgsPerSec, bytesPerSec := peerRn.RxRateLimit(); msgsPerSec != rate || bytesPerSec != 0 {
+		t.Fatalf("rx rate limit is %d msg/s and %d bytes/s, expecting %d msg/s and unlimited", msgsPerSec, bytesPerSec, rate)
+	}
+
+	startTime := time.Now()
+	for i := 0; i < numMsgs; i++ {
+		err := rn.SendMessageAsync(newTestMessage(t, ln, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < numMsgs; i++ {
+		recvTestMessage(t, peer, 5*time.Second)
+	}
+
+	// the first msg are within bucket size, the rest are delayed
+	if elapsed := time.Since(startTime); elapsed < time.Second/2 {
+		t.Fatalf("%d msg are received in %v, expecting rx rate limited to %d msg/s", numMsgs, elapsed, rate)
+	}
+	if peerRn.IsStopped() {
+		t.Fatalf("remote node stops because of %v under delay policy", peerRn.StopReason())
+	}
+	if n := peerRn.Stats().RxHealth.RateLimited; n == 0 {
+		t.Fatal("no msg is counted as rate limited")
+	}
+}
+
+func TestRxRateLimitStop(t *testing.T) {
+	const rate = 5
+
+	ln := newTestLocalNode(t, &config.Config{DisableKeepAlivePing: true})
+	peer := newTestLocalNode(t, &config.Config{
+		RxRateLimitMsgs:      rate,
+		RxRateLimitPolicy:    "stop",
+		DisableKeepAlivePing: true,
+	})
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	for i := 0; i < 4*rate; i++ {
+		err := rn.SendMessageAsync(newTestMessage(t, ln, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, time.Second, peerRn.IsStopped)
+	if reason := peerRn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "Rx rate limit exceeded") {
+		t.Fatalf("remote node stops because of %v, expecting rx rate limit exceeded", reason)
+	}
+}
+
+func TestSetRxRateLimit(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{RxRateLimitMsgs: 100, RxRateLimitBytes: 4096})
+	rn := newTestIdleRemoteNode(t, ln)
+
+	rn.SetRxRateLimit(10, 0)
+	if msgsPerSec, bytesPerSec := rn.RxRateLimit(); msgsPerSec != 10 || bytesPerSec != 0 {
+		t.Fatalf("rx rate limit is %d msg/s and %d bytes/s after set, expecting 10 msg/s and unlimited", msgsPerSec, bytesPerSec)
+	}
+	if stats := rn.Stats(); stats.RxByteRate != 0 {
+		t.Fatalf("rx byte rate is %d, expecting 0 when unlimited", stats.RxByteRate)
+	}
+}
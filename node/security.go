@@ -0,0 +1,151 @@
+package node
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// SecurityLevel is how the conn with a remote node is secured
+type SecurityLevel uint8
+
+const (
+	// SecurityPlaintext means msg are sent unencrypted
+	SecurityPlaintext SecurityLevel = iota
+
+	// SecurityTLS means conn is a TLS conn
+	SecurityTLS
+
+	// SecurityEncrypted means conn is encrypted by a protocol other than TLS
+	// (e.g. Noise), reported by conn implementing SecureConn
+	SecurityEncrypted
+
+	// SecurityInProcess means conn is an in-process loopback conn, so msg never
+	// leave the process
+	SecurityInProcess
+)
+
+// transportLoopback is the transport type of in-process loopback conn
+const transportLoopback = "loopback"
+
+func (l SecurityLevel) String() string {
+	switch l {
+	case SecurityPlaintext:
+		return "plaintext"
+	case SecurityTLS:
+		return "tls"
+	case SecurityEncrypted:
+		return "encrypted"
+	case SecurityInProcess:
+		return "inprocess"
+	default:
+		return "unknown"
+	}
+}
+
+// SecureConn can be implemented by conn returned by a custom transport (e.g.
+// one that runs a Noise handshake) to report how it is secured. Conn that does
+// not implement it is reported as TLS if it is a TLS conn, otherwise
+// plaintext.
+type SecureConn interface {
+	SecurityLevel() SecurityLevel
+}
+
+// connSecurityLevel returns the security level of conn
+func connSecurityLevel(conn net.Conn) SecurityLevel {
+	switch c := conn.(type) {
+	case SecureConn:
+		return c.SecurityLevel()
+	case *tls.Conn:
+		return SecurityTLS
+	default:
+		return SecurityPlaintext
+	}
+}
+
+// SecurityLevel returns how the conn with remote node is secured, which can be
+// used by middleware to enforce policies, e.g. not sending sensitive msg over
+// plaintext conn.
+func (rn *RemoteNode) SecurityLevel() SecurityLevel {
+	return rn.securityLevel
+}
+
+// TransportType returns the transport protocol of the conn with remote node,
+// e.g. tcp, kcp, or the protocol of a registered transport. In-process
+// loopback conn returns loopback.
+func (rn *RemoteNode) TransportType() string {
+	return rn.transportType
+}
+
+// SetTLSConfig makes local node wrap conns it dials and accepts in TLS with
+// config, acting as TLS client on dialed conns and TLS server on accepted
+// conns. Config should be usable for both roles, e.g. with Certificates set,
+// ClientAuth set to require client certificates so that inbound remote nodes
+// present one, and peer certificate verification suitable for the network.
+// Remote node identity can then be checked against its node info with
+// RemoteNodeIdentityVerify middleware. In-process loopback conns are not
+// wrapped. nil disables wrapping. It should be called before local node
+// starts.
+func (ln *LocalNode) SetTLSConfig(config *tls.Config) {
+	ln.tlsConfig = config
+}
+
+// GetTLSConfig returns the TLS config used to wrap conns, nil if conns are not
+// wrapped
+func (ln *LocalNode) GetTLSConfig() *tls.Config {
+	return ln.tlsConfig
+}
+
+// wrapConn wraps conn in TLS if local node has TLS config, otherwise returns
+// conn as it is
+func (ln *LocalNode) wrapConn(conn net.Conn, isOutbound bool) net.Conn {
+	if ln.tlsConfig == nil {
+		return conn
+	}
+	if isOutbound {
+		return tls.Client(conn, ln.tlsConfig)
+	}
+	return tls.Server(conn, ln.tlsConfig)
+}
+
+// PeerCertificate returns the leaf certificate presented by remote node in TLS
+// handshake, or nil if conn is not a TLS conn, handshake has not completed, or
+// remote node presented no certificate
+func (rn *RemoteNode) PeerCertificate() *x509.Certificate {
+	tlsConn, ok := rn.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	peerCerts := tlsConn.ConnectionState().PeerCertificates
+	if len(peerCerts) == 0 {
+		return nil
+	}
+
+	return peerCerts[0]
+}
+
+// verifyIdentity calls RemoteNodeIdentityVerify middleware with the peer
+// certificate and node info of remote node, and returns error if any of them
+// rejects
+func (rn *RemoteNode) verifyIdentity(n *protobuf.Node) error {
+	identityVerify := rn.LocalNode.middlewareStore.remoteNodeIdentityVerify.Load().([]RemoteNodeIdentityVerify)
+	if len(identityVerify) == 0 {
+		return nil
+	}
+
+	cert := rn.PeerCertificate()
+	for _, mw := range identityVerify {
+		err, shouldCallNextMiddleware := mw.Func(rn, cert, n)
+		if err != nil {
+			return err
+		}
+		if !shouldCallNextMiddleware {
+			break
+		}
+	}
+
+	return nil
+}
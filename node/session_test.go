@@ -0,0 +1,107 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestSessionParams(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		MaxMessageSize:    4096,
+		CompressionCodecs: []string{"gzip"},
+		MessageCodecs:     []string{"json"},
+	})
+	peer := newTestLocalNode(t, &config.Config{
+		CompressionCodecs: []string{"gzip"},
+		MessageCodecs:     []string{"json"},
+	})
+
+	if params := newTestIdleRemoteNode(t, ln).SessionParams(); params != (SessionParams{}) {
+		t.Fatalf("session params of remote node not ready is %+v, expecting zero value", params)
+	}
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	params := rn.SessionParams()
+	expected := SessionParams{
+		Transport:      "mem",
+		Multiplexer:    "yamux",
+		MaxMessageSize: 4096,
+		RemoteAddr:     params.RemoteAddr,
+		Compression:    "gzip",
+		MessageCodec:   "json",
+	}
+	if params != expected {
+		t.Fatalf("session params is %+v, expecting %+v", params, expected)
+	}
+	if params.RemoteAddr == "" {
+		t.Fatal("remote addr of session is empty")
+	}
+
+	waitFor(t, 5*time.Second, peerRn.IsReady)
+	if params := peerRn.SessionParams(); params.Transport != "mem" || params.Compression != "gzip" || params.MessageCodec != "json" {
+		t.Fatalf("session params of inbound remote node is %+v", params)
+	}
+}
+
+func TestSessionParamsInProcess(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{InProcessLoopback: true})
+	peer := newTestLocalNode(t, &config.Config{InProcessLoopback: true})
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	params := rn.SessionParams()
+	if params.Transport != "inproc" || params.Multiplexer != "" {
+		t.Fatalf("session params of in-process remote node is %+v", params)
+	}
+}
+
+func TestSetupTimings(t *testing.T) {
+	const getNodeDelay = 200 * time.Millisecond
+
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+
+	// peer is slow to reply node info
+	err := peer.ApplyMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+		if msg.MessageType == protobuf.GET_NODE && len(msg.ReplyToId) == 0 {
+			time.Sleep(getNodeDelay)
+		}
+		return msg, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	timings := rn.SetupTimings()
+	if timings.Dial <= 0 || timings.Multiplexer <= 0 {
+		t.Fatalf("setup timings of outbound remote node is %+v, expecting positive dial and multiplexer time", timings)
+	}
+	if timings.TLSHandshake != 0 {
+		t.Fatalf("setup timings of non-TLS remote node is %+v, expecting zero TLS handshake time", timings)
+	}
+	if timings.GetNode < getNodeDelay {
+		t.Fatalf("setup timings is %+v, expecting get node time at least %v", timings, getNodeDelay)
+	}
+	if timings.Total < timings.GetNode || timings.Total < timings.Dial+timings.Multiplexer {
+		t.Fatalf("setup timings is %+v, expecting total no less than each phase", timings)
+	}
+
+	// inbound remote node is not dialed, and ln replies node info at once
+	waitFor(t, 5*time.Second, peerRn.IsReady)
+	timings = peerRn.SetupTimings()
+	if timings.Dial != 0 {
+		t.Fatalf("setup timings of inbound remote node is %+v, expecting zero dial time", timings)
+	}
+	if timings.Total <= 0 || timings.Total < timings.GetNode {
+		t.Fatalf("setup timings of inbound remote node is %+v, expecting total no less than get node time", timings)
+	}
+
+	if timings := newTestIdleRemoteNode(t, ln).SetupTimings(); timings.Total != 0 {
+		t.Fatalf("setup timings of remote node not ready is %+v, expecting zero total", timings)
+	}
+}
@@ -0,0 +1,186 @@
+package node
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/protobuf"
+)
+
+// nodeGoroutines returns the stacks of goroutines running code of node
+// package, excluding the ones started by tests
+func nodeGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	var stacks []string
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(stack, "nnet/node.") && !strings.Contains(stack, "_test.go") {
+			stacks = append(stacks, stack)
+		}
+	}
+
+	return stacks
+}
+
+func testShutdown(t *testing.T, graceful bool) {
+	hub := newTestLocalNode(t, nil)
+	peers := []*LocalNode{
+		newTestLocalNode(t, nil),
+		newTestLocalNode(t, nil),
+		newTestLocalNode(t, nil),
+	}
+
+	for _, peer := range peers {
+		rn, _ := connectTestNodes(t, peer, hub)
+		err := rn.SetAutoReconnect(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, rn := range hub.GetRemoteNodes() {
+		for i := 0; i < 10; i++ {
+			err := rn.SendMessageAsync(newTestMessage(t, hub, make([]byte, 1000)))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if len(nodeGoroutines()) == 0 {
+		t.Fatal("no goroutine of node package is found before shutdown")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	if graceful {
+		err = hub.ShutdownGracefully(ctx)
+	} else {
+		err = hub.Shutdown(ctx)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if graceful {
+		for _, peer := range peers {
+			for i := 0; i < 10; i++ {
+				recvTestMessage(t, peer, time.Second)
+			}
+		}
+	}
+
+	if _, _, err = hub.Connect(peers[0].Addr); err == nil {
+		t.Fatal("connect after shutdown should fail")
+	}
+
+	for _, peer := range peers {
+		err = peer.Shutdown(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stacks := nodeGoroutines(); len(stacks) > 0 {
+		t.Fatalf("%d goroutines are still running after shutdown:\n%s", len(stacks), strings.Join(stacks, "\n\n"))
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	testShutdown(t, false)
+}
+
+func TestShutdownGracefully(t *testing.T) {
+	testShutdown(t, true)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+	connectTestNodes(t, ln, peer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := ln.Shutdown(ctx)
+	if err != context.Canceled {
+		t.Fatalf("shutdown error is %v, expecting %v", err, context.Canceled)
+	}
+
+	// shutdown can be retried after timeout
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = ln.Shutdown(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRxMsgChanConsumerStopsOnShutdown(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+
+	rxMsgChan, err := ln.GetRxMsgChan(protobuf.RELAY)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one consumer selects on Done and the other on Context
+	exited := make(chan string, 2)
+	go func() {
+		select {
+		case <-rxMsgChan:
+			exited <- "msg"
+		case <-ln.Done():
+			exited <- "done"
+		}
+	}()
+	ctx := ln.Context()
+	go func() {
+		select {
+		case <-rxMsgChan:
+			exited <- "msg"
+		case <-ctx.Done():
+			exited <- "context"
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if n := len(exited); n != 0 {
+		t.Fatalf("%d consumers exit before local node stops", n)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = ln.Shutdown(shutdownCtx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reasons := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case reason := <-exited:
+			reasons[reason] = true
+		case <-time.After(time.Second):
+			t.Fatal("consumer blocked on rx msg chan does not exit after shutdown")
+		}
+	}
+	if !reasons["done"] || !reasons["context"] {
+		t.Fatalf("consumers exit because of %v, expecting done and context", reasons)
+	}
+	if ctx.Err() != context.Canceled {
+		t.Fatalf("context error is %v after shutdown, expecting %v", ctx.Err(), context.Canceled)
+	}
+
+	// context obtained after stop is already canceled
+	if err := ln.Context().Err(); err != context.Canceled {
+		t.Fatalf("context obtained after shutdown has error %v, expecting %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,145 @@
+package node
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+// testAddrConn is a conn with fixed addresses, as conns from net.Pipe do not
+// have addresses that can be parsed
+type testAddrConn struct {
+	net.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (conn *testAddrConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+func (conn *testAddrConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
+
+// connectTestNodesFreezable connects ln and peer through a relay that can be
+// frozen. Once frozen, the relay stops forwarding data in both directions
+// while keeping the conn open, so writes of both nodes block.
+func connectTestNodesFreezable(tb testing.TB, ln, peer *LocalNode) (*RemoteNode, *RemoteNode, func()) {
+	tb.Helper()
+
+	conn, relayConn := net.Pipe()
+	peerRelayConn, peerConn := net.Pipe()
+
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10001}
+	peerAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10002}
+	conn = &testAddrConn{Conn: conn, localAddr: addr, remoteAddr: peerAddr}
+	peerConn = &testAddrConn{Conn: peerConn, localAddr: peerAddr, remoteAddr: addr}
+
+	frozen := make(chan struct{})
+	closed := make(chan struct{})
+	tb.Cleanup(func() {
+		close(closed)
+		relayConn.Close()
+		peerRelayConn.Close()
+	})
+
+	relay := func(dst, src net.Conn) {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case <-frozen:
+				<-closed
+				return
+			default:
+			}
+			_, err = dst.Write(buf[:n])
+			if err != nil {
+				return
+			}
+		}
+	}
+	go relay(relayConn, peerRelayConn)
+	go relay(peerRelayConn, relayConn)
+
+	rn, err := NewRemoteNode(ln, conn, true)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	ln.neighbors.Store(conn.RemoteAddr().String(), rn)
+
+	peerRn, err := NewRemoteNode(peer, peerConn, false)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	peer.neighbors.Store(peerConn.RemoteAddr().String(), peerRn)
+
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- ln.startRemoteNode(rn)
+	}()
+	go func() {
+		errChan <- peer.startRemoteNode(peerRn)
+	}()
+	for i := 0; i < 2; i++ {
+		if err = <-errChan; err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	waitFor(tb, 5*time.Second, func() bool {
+		return rn.IsReady() && peerRn.IsReady()
+	})
+
+	return rn, peerRn, func() { close(frozen) }
+}
+
+func TestStallWatchdogWedgedConn(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{
+		StallTimeout:                 300 * time.Millisecond,
+		MeasureRoundTripTimeInterval: 50 * time.Millisecond,
+		KeepAliveTimeout:             time.Minute,
+	})
+	peer := newTestLocalNode(t, nil)
+	healthyPeer := newTestLocalNode(t, nil)
+
+	wedgedRn, _, freeze := connectTestNodesFreezable(t, ln, peer)
+	rn, _ := connectTestNodes(t, ln, healthyPeer)
+
+	// pings keep both remote nodes making progress before the conn is frozen
+	time.Sleep(2 * ln.StallTimeout)
+	if wedgedRn.IsStopped() {
+		t.Fatalf("remote node stops before conn is frozen because of %v", wedgedRn.StopReason())
+	}
+
+	freeze()
+
+	waitFor(t, 3*time.Second, wedgedRn.IsStopped)
+
+	if reason := wedgedRn.StopReason(); reason == nil || !strings.Contains(reason.Error(), "stall timeout") {
+		t.Fatalf("wedged remote node stops because of %v, expecting stall timeout", reason)
+	}
+	if rn.IsStopped() {
+		t.Fatalf("healthy remote node stops because of %v", rn.StopReason())
+	}
+}
+
+func TestStallWatchdogTinyTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{StallTimeout: time.Nanosecond})
+
+	if interval := watchdogInterval(ln.StallTimeout); interval != minWatchdogInterval {
+		t.Fatalf("watchdog interval is %v, expecting %v", interval, minWatchdogInterval)
+	}
+
+	time.Sleep(5 * minWatchdogInterval)
+	if ln.IsStopped() {
+		t.Fatal("local node stops with tiny stall timeout")
+	}
+}
@@ -0,0 +1,253 @@
+package node
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ChanStats is the occupancy of a msg chan
+type ChanStats struct {
+	Len       int // Number of msg currently buffered
+	Cap       int // Max number of msg that can be buffered
+	Watermark int // Max number of msg buffered since last reset
+}
+
+const (
+	// Weight of a new sample in the moving average of write latency
+	writeLatencySampleWeight = 0.25
+)
+
+// TxHealth is the health of sending msg to a remote node
+type TxHealth struct {
+	Dropped      uint64        // Number of msg dropped before being written to conn, e.g. because tx msg chan is full
+	WriteErrors  uint64        // Number of conn write errors
+	WriteLatency time.Duration // Moving average of time to write a msg to conn, 0 if not measured
+}
+
+// RxHealth is the health of receiving msg from a remote node
+type RxHealth struct {
+	Dropped     uint64 // Number of msg received but dropped, e.g. because it cannot be unmarshaled or a msg chan is full
+	ReadErrors  uint64 // Number of conn read errors
+	RateLimited uint64 // Number of msg whose processing is delayed because rx rate limit is exceeded
+}
+
+// RemoteNodeStats is the statistics of a remote node
+type RemoteNodeStats struct {
+	RxMsgChan    ChanStats
+	TxMsgChan    ChanStats
+	RxHealth     RxHealth
+	TxHealth     TxHealth
+	BytesRx      uint64        // Number of bytes received, including length prefix
+	BytesTx      uint64        // Number of bytes sent, including length prefix
+	MsgRx        uint64        // Number of msg received
+	MsgTx        uint64        // Number of msg sent
+	MsgDropped   uint64        // Number of msg dropped because of backpressure or size limit
+	ConnectedFor time.Duration // Time since remote node is created, or until it stopped
+	RxMsgRate    uint32        // Approximate number of msg received in the last second, only measured if rx msg rate is limited
+	RxByteRate   uint32        // Approximate number of bytes received in the last second, only measured if rx byte rate is limited
+}
+
+// LocalNodeStats is the statistics of local node aggregated from all
+// neighbors
+type LocalNodeStats struct {
+	NumNeighbors          int
+	RxMsgChanLen          int // Total number of msg buffered in rx msg chan of all neighbors
+	TxMsgChanLen          int // Total number of msg buffered in tx msg chan of all neighbors
+	MaxRxMsgChanWatermark int // Max rx msg chan watermark of all neighbors
+	MaxTxMsgChanWatermark int // Max tx msg chan watermark of all neighbors
+}
+
+// NetworkStats is the statistics of all remote nodes of local node. Traffic
+// counters only include remote nodes that are currently connected, while
+// timeout and disconnect counters are since local node is created.
+type NetworkStats struct {
+	NumPeers             int     // Number of remote nodes, including the ones not ready yet
+	NumReady             int     // Number of remote nodes that are ready
+	NumConnecting        int     // Number of remote nodes that are not ready yet
+	NumInbound           int     // Number of inbound remote nodes
+	NumOutbound          int     // Number of outbound remote nodes
+	BytesRx              uint64  // Total number of bytes received
+	BytesTx              uint64  // Total number of bytes sent
+	MsgRx                uint64  // Total number of msg received
+	MsgTx                uint64  // Total number of msg sent
+	MsgDropped           uint64  // Total number of msg dropped
+	RxThroughput         float64 // Sum of average bytes per second received from each remote node
+	TxThroughput         float64 // Sum of average bytes per second sent to each remote node
+	NumKeepAliveTimeouts uint64  // Number of remote nodes stopped because of keepalive timeout
+	NumMsgHandleTimeouts uint64  // Number of msg whose handling exceeds LocalMsgHandleTimeout
+	NumDisconnects       uint64  // Number of remote nodes that have stopped
+	RxMsgCacheHits       uint64  // Number of duplicate msg discarded because message id is in rx msg cache
+	RxMsgCacheMisses     uint64  // Number of msg whose message id is added to rx msg cache
+}
+
+// updateWatermark sets watermark to length if length is larger
+func updateWatermark(watermark *uint32, length int) {
+	for {
+		old := atomic.LoadUint32(watermark)
+		if uint32(length) <= old || atomic.CompareAndSwapUint32(watermark, old, uint32(length)) {
+			return
+		}
+	}
+}
+
+// Stats returns the statistics of remote node
+func (rn *RemoteNode) Stats() *RemoteNodeStats {
+	rn.RLock()
+	rxMsgChan := rn.rxMsgChan
+	txMsgChan := rn.txMsgChan
+	connectedFor := time.Since(rn.createdTime)
+	if !rn.stoppedTime.IsZero() {
+		connectedFor = rn.stoppedTime.Sub(rn.createdTime)
+	}
+	rn.RUnlock()
+
+	// load tx drops first so that rx drops computed from them never underflow
+	txDropped := atomic.LoadUint64(&rn.txDropped)
+	msgDropped := atomic.LoadUint64(&rn.msgDropped)
+
+	return &RemoteNodeStats{
+		RxMsgChan: ChanStats{
+			Len:       len(rxMsgChan),
+			Cap:       cap(rxMsgChan),
+			Watermark: int(atomic.LoadUint32(&rn.rxMsgChanWatermark)),
+		},
+		TxMsgChan: ChanStats{
+			Len:       len(txMsgChan),
+			Cap:       cap(txMsgChan),
+			Watermark: int(atomic.LoadUint32(&rn.txMsgChanWatermark)),
+		},
+		BytesRx:      atomic.LoadUint64(&rn.bytesRx),
+		BytesTx:      atomic.LoadUint64(&rn.bytesTx),
+		MsgRx:        atomic.LoadUint64(&rn.msgRx),
+		MsgTx:        atomic.LoadUint64(&rn.msgTx),
+		MsgDropped:   msgDropped,
+		ConnectedFor: connectedFor,
+		RxMsgRate:    rn.rxMsgLimiter.Used(),
+		RxByteRate:   rn.rxByteLimiter.Used(),
+		RxHealth: RxHealth{
+			Dropped:     msgDropped - txDropped,
+			ReadErrors:  atomic.LoadUint64(&rn.readErrors),
+			RateLimited: atomic.LoadUint64(&rn.rxRateLimited),
+		},
+		TxHealth: TxHealth{
+			Dropped:      txDropped,
+			WriteErrors:  atomic.LoadUint64(&rn.writeErrors),
+			WriteLatency: time.Duration(atomic.LoadInt64(&rn.writeLatency)),
+		},
+	}
+}
+
+// countConnError increases counter of conn read or write errors, unless
+// remote node has stopped, in which case the error is caused by closing conn
+func (rn *RemoteNode) countConnError(counter *uint64) {
+	if !rn.IsStopped() {
+		atomic.AddUint64(counter, 1)
+	}
+}
+
+// updateWriteLatency updates the moving average of write latency with a new
+// sample. It is only called by tx, so there is no concurrent update.
+func (rn *RemoteNode) updateWriteLatency(latency time.Duration) {
+	old := time.Duration(atomic.LoadInt64(&rn.writeLatency))
+	if old > 0 {
+		latency = old + time.Duration(writeLatencySampleWeight*float64(latency-old))
+	}
+	atomic.StoreInt64(&rn.writeLatency, int64(latency))
+}
+
+// ResetStats resets the chan watermarks of remote node to current chan length
+func (rn *RemoteNode) ResetStats() {
+	rn.RLock()
+	rxMsgChan := rn.rxMsgChan
+	txMsgChan := rn.txMsgChan
+	rn.RUnlock()
+
+	atomic.StoreUint32(&rn.rxMsgChanWatermark, uint32(len(rxMsgChan)))
+	atomic.StoreUint32(&rn.txMsgChanWatermark, uint32(len(txMsgChan)))
+}
+
+// Stats returns the statistics of local node aggregated from all neighbors
+func (ln *LocalNode) Stats() (*LocalNodeStats, error) {
+	neighbors, err := ln.GetNeighbors(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &LocalNodeStats{
+		NumNeighbors: len(neighbors),
+	}
+
+	for _, remoteNode := range neighbors {
+		rnStats := remoteNode.Stats()
+		stats.RxMsgChanLen += rnStats.RxMsgChan.Len
+		stats.TxMsgChanLen += rnStats.TxMsgChan.Len
+		if rnStats.RxMsgChan.Watermark > stats.MaxRxMsgChanWatermark {
+			stats.MaxRxMsgChanWatermark = rnStats.RxMsgChan.Watermark
+		}
+		if rnStats.TxMsgChan.Watermark > stats.MaxTxMsgChanWatermark {
+			stats.MaxTxMsgChanWatermark = rnStats.TxMsgChan.Watermark
+		}
+	}
+
+	return stats, nil
+}
+
+// NetworkStats returns the statistics of all remote nodes of local node,
+// including the ones that are not ready yet
+func (ln *LocalNode) NetworkStats() *NetworkStats {
+	stats := &NetworkStats{
+		NumKeepAliveTimeouts: ln.GetNumKeepAliveTimeouts(),
+		NumMsgHandleTimeouts: ln.GetNumMsgHandleTimeouts(),
+		NumDisconnects:       atomic.LoadUint64(&ln.numDisconnects),
+		RxMsgCacheHits:       atomic.LoadUint64(&ln.rxMsgCacheHits),
+		RxMsgCacheMisses:     atomic.LoadUint64(&ln.rxMsgCacheMisses),
+	}
+
+	ln.neighbors.Range(func(key, value interface{}) bool {
+		remoteNode, ok := value.(*RemoteNode)
+		if !ok || remoteNode == nil || remoteNode.IsStopped() {
+			return true
+		}
+
+		stats.NumPeers++
+		if remoteNode.IsReady() {
+			stats.NumReady++
+		} else {
+			stats.NumConnecting++
+		}
+		if remoteNode.IsOutbound {
+			stats.NumOutbound++
+		} else {
+			stats.NumInbound++
+		}
+
+		rnStats := remoteNode.Stats()
+		stats.BytesRx += rnStats.BytesRx
+		stats.BytesTx += rnStats.BytesTx
+		stats.MsgRx += rnStats.MsgRx
+		stats.MsgTx += rnStats.MsgTx
+		stats.MsgDropped += rnStats.MsgDropped
+		if seconds := rnStats.ConnectedFor.Seconds(); seconds > 0 {
+			stats.RxThroughput += float64(rnStats.BytesRx) / seconds
+			stats.TxThroughput += float64(rnStats.BytesTx) / seconds
+		}
+
+		return true
+	})
+
+	return stats
+}
+
+// ResetStats resets the statistics of all neighbors
+func (ln *LocalNode) ResetStats() error {
+	neighbors, err := ln.GetNeighbors(nil)
+	if err != nil {
+		return err
+	}
+
+	for _, remoteNode := range neighbors {
+		remoteNode.ResetStats()
+	}
+
+	return nil
+}
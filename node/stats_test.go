@@ -0,0 +1,335 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+)
+
+func TestTxMsgChanStats(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	rn := newTestIdleRemoteNode(t, ln)
+
+	for i := 0; i < 5; i++ {
+		err := rn.SendMessageAsync(newTestMessage(t, ln, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := rn.Stats().TxMsgChan
+	if stats.Len != 5 || stats.Watermark != 5 || stats.Cap != int(ln.RemoteTxMsgChanLen) {
+		t.Fatalf("tx msg chan stats is %+v, expecting len 5, watermark 5 and cap %d", stats, ln.RemoteTxMsgChanLen)
+	}
+
+	for i := 0; i < 3; i++ {
+		<-rn.txMsgChan
+	}
+
+	stats = rn.Stats().TxMsgChan
+	if stats.Len != 2 || stats.Watermark != 5 {
+		t.Fatalf("tx msg chan stats is %+v, expecting len 2 and watermark 5", stats)
+	}
+
+	rn.ResetStats()
+
+	stats = rn.Stats().TxMsgChan
+	if stats.Len != 2 || stats.Watermark != 2 {
+		t.Fatalf("tx msg chan stats after reset is %+v, expecting len 2 and watermark 2", stats)
+	}
+}
+
+func TestRxMsgChanStats(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	peer := newTestLocalNode(t, nil)
+
+	// the first msg blocks handling, so the following ones stay in rx msg chan
+	release := make(chan struct{})
+	blocked := make(chan struct{}, 1)
+	err := ln.ApplyMiddleware(RemoteNodeMessageReceived{func(rn *RemoteNode, msg *protobuf.Message) (*protobuf.Message, bool) {
+		if msg.MessageType == protobuf.BYTES {
+			select {
+			case blocked <- struct{}{}:
+				<-release
+			default:
+			}
+		}
+		return msg, true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	for i := 0; i < 5; i++ {
+		err = peerRn.SendMessageAsync(newTestMessage(t, peer, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, time.Second, func() bool {
+		return rn.Stats().RxMsgChan.Len == 4
+	})
+	// the first msg may also be buffered before it is handled
+	if stats := rn.Stats().RxMsgChan; stats.Watermark < 4 {
+		t.Fatalf("rx msg chan stats is %+v, expecting watermark at least 4", stats)
+	}
+
+	lnStats, err := ln.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lnStats.NumNeighbors != 1 || lnStats.RxMsgChanLen != 4 || lnStats.MaxRxMsgChanWatermark < 4 {
+		t.Fatalf("local node stats is %+v, expecting 1 neighbor with 4 msg in rx msg chan", lnStats)
+	}
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		recvTestMessage(t, ln, time.Second)
+	}
+
+	stats := rn.Stats().RxMsgChan
+	if stats.Len != 0 || stats.Watermark < 4 {
+		t.Fatalf("rx msg chan stats is %+v, expecting len 0 and watermark at least 4", stats)
+	}
+
+	rn.ResetStats()
+
+	if stats = rn.Stats().RxMsgChan; stats.Watermark != 0 {
+		t.Fatalf("rx msg chan stats after reset is %+v, expecting watermark 0", stats)
+	}
+}
+
+func TestTrafficCounters(t *testing.T) {
+	newConfig := func() *config.Config {
+		return &config.Config{DisableKeepAlivePing: true}
+	}
+	ln := newTestLocalNode(t, newConfig())
+	peer := newTestLocalNode(t, newConfig())
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	rxBefore := rn.Stats()
+	txBefore := peerRn.Stats()
+
+	// counters are read while msgs are being sent and received, and should
+	// never go backwards
+	done := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		var last RemoteNodeStats
+		for {
+			select {
+			case <-done:
+				readErr <- nil
+				return
+			default:
+			}
+			stats := rn.Stats()
+			if stats.BytesRx < last.BytesRx || stats.MsgRx < last.MsgRx {
+				readErr <- fmt.Errorf("rx counters go from %d bytes %d msg to %d bytes %d msg", last.BytesRx, last.MsgRx, stats.BytesRx, stats.MsgRx)
+				return
+			}
+			last = *stats
+		}
+	}()
+
+	numMsgs := 20
+	for i := 0; i < numMsgs; i++ {
+		err := peerRn.SendMessageAsync(newTestMessage(t, peer, []byte{byte(i)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < numMsgs; i++ {
+		recvTestMessage(t, ln, time.Second)
+	}
+	waitFor(t, time.Second, func() bool {
+		return peerRn.Stats().MsgTx-txBefore.MsgTx == uint64(numMsgs)
+	})
+
+	close(done)
+	if err := <-readErr; err != nil {
+		t.Fatal(err)
+	}
+
+	rxAfter := rn.Stats()
+	txAfter := peerRn.Stats()
+	if n := rxAfter.MsgRx - rxBefore.MsgRx; n != uint64(numMsgs) {
+		t.Fatalf("%d msg received, expecting %d", n, numMsgs)
+	}
+	bytesRx := rxAfter.BytesRx - rxBefore.BytesRx
+	bytesTx := txAfter.BytesTx - txBefore.BytesTx
+	if bytesRx == 0 || bytesRx != bytesTx {
+		t.Fatalf("%d bytes received and %d bytes sent, expecting the same non-zero number", bytesRx, bytesTx)
+	}
+	if rxAfter.MsgTx != rxBefore.MsgTx || txAfter.MsgRx != txBefore.MsgRx {
+		t.Fatalf("counters of the other direction changed from %+v and %+v to %+v and %+v", rxBefore, txBefore, rxAfter, txAfter)
+	}
+}
+
+func TestNetworkStats(t *testing.T) {
+	newConfig := func() *config.Config {
+		return &config.Config{DisableKeepAlivePing: true}
+	}
+	ln := newTestLocalNode(t, newConfig())
+	peers := make([]*LocalNode, 3)
+	for i := range peers {
+		peers[i] = newTestLocalNode(t, newConfig())
+	}
+
+	// two outbound and one inbound remote nodes
+	remoteNodes := make([]*RemoteNode, 0, len(peers))
+	peerRemoteNodes := make([]*RemoteNode, 0, len(peers))
+	for i, peer := range peers {
+		var rn, peerRn *RemoteNode
+		if i < 2 {
+			rn, peerRn = connectTestNodes(t, ln, peer)
+		} else {
+			peerRn, rn = connectTestNodes(t, peer, ln)
+		}
+		remoteNodes = append(remoteNodes, rn)
+		peerRemoteNodes = append(peerRemoteNodes, peerRn)
+	}
+
+	for i, rn := range remoteNodes {
+		for j := 0; j <= i; j++ {
+			err := rn.SendMessageAsync(newTestMessage(t, ln, make([]byte, 100*(j+1))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = peerRemoteNodes[i].SendMessageAsync(newTestMessage(t, peers[i], make([]byte, 10*(j+1))))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i, peer := range peers {
+		for j := 0; j <= i; j++ {
+			recvTestMessage(t, peer, time.Second)
+			recvTestMessage(t, ln, time.Second)
+		}
+	}
+	waitFor(t, time.Second, func() bool {
+		for i, rn := range remoteNodes {
+			if rn.Stats().MsgTx < uint64(i+1) {
+				return false
+			}
+		}
+		return true
+	})
+
+	expected := &NetworkStats{
+		NumPeers:    len(remoteNodes),
+		NumReady:    len(remoteNodes),
+		NumInbound:  1,
+		NumOutbound: 2,
+	}
+	for _, rn := range remoteNodes {
+		rnStats := rn.Stats()
+		expected.BytesRx += rnStats.BytesRx
+		expected.BytesTx += rnStats.BytesTx
+		expected.MsgRx += rnStats.MsgRx
+		expected.MsgTx += rnStats.MsgTx
+		expected.MsgDropped += rnStats.MsgDropped
+	}
+
+	stats := ln.NetworkStats()
+	if stats.NumPeers != expected.NumPeers || stats.NumReady != expected.NumReady || stats.NumConnecting != 0 || stats.NumInbound != expected.NumInbound || stats.NumOutbound != expected.NumOutbound {
+		t.Fatalf("network stats is %+v, expecting peer counts of %+v", stats, expected)
+	}
+	if stats.BytesRx != expected.BytesRx || stats.BytesTx != expected.BytesTx || stats.MsgRx != expected.MsgRx || stats.MsgTx != expected.MsgTx || stats.MsgDropped != expected.MsgDropped {
+		t.Fatalf("network stats is %+v, expecting sum of remote node stats %+v", stats, expected)
+	}
+	if stats.MsgTx < 6 || stats.MsgRx < 6 {
+		t.Fatalf("network stats has %d msg sent and %d msg received, expecting at least 6", stats.MsgTx, stats.MsgRx)
+	}
+	if stats.RxThroughput <= 0 || stats.TxThroughput <= 0 {
+		t.Fatalf("network stats has throughput rx %f and tx %f, expecting positive", stats.RxThroughput, stats.TxThroughput)
+	}
+
+	// stopped remote node is excluded
+	remoteNodes[0].Stop(nil)
+	waitFor(t, time.Second, remoteNodes[0].IsStopped)
+	stats = ln.NetworkStats()
+	if stats.NumPeers != 2 || stats.NumOutbound != 1 {
+		t.Fatalf("network stats has %d peers and %d outbound after one stops, expecting 2 and 1", stats.NumPeers, stats.NumOutbound)
+	}
+	if n, e := stats.MsgTx, remoteNodes[1].Stats().MsgTx+remoteNodes[2].Stats().MsgTx; n != e {
+		t.Fatalf("network stats has %d msg sent after remote node stops, expecting %d", n, e)
+	}
+	if stats.NumDisconnects < 1 {
+		t.Fatalf("network stats has %d disconnects, expecting at least 1", stats.NumDisconnects)
+	}
+}
+
+func TestRemoteNodeFinalStats(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{DisableKeepAlivePing: true})
+	peer := newTestLocalNode(t, &config.Config{DisableKeepAlivePing: true})
+
+	var called []string
+	finalStatsChan := make(chan *RemoteNodeStats, 1)
+	disconnected := make(chan struct{})
+	err := ln.ApplyMiddleware(RemoteNodeFinalStats{func(rn *RemoteNode, stats *RemoteNodeStats) bool {
+		called = append(called, "final stats")
+		finalStatsChan <- stats
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ln.ApplyMiddleware(RemoteNodeDisconnected{func(rn *RemoteNode) bool {
+		called = append(called, "disconnected")
+		close(disconnected)
+		return true
+	}, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	sendTestMessages(t, rn, peer, make([]byte, 100), 3)
+	for i := 0; i < 2; i++ {
+		err = peerRn.SendMessageAsync(newTestMessage(t, peer, make([]byte, 50)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		recvTestMessage(t, ln, time.Second)
+	}
+
+	stats := rn.Stats()
+	rn.Stop(nil)
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RemoteNodeDisconnected is not called after remote node stops")
+	}
+	finalStats := <-finalStatsChan
+
+	if len(called) != 2 || called[0] != "final stats" {
+		t.Fatalf("middleware called in order %v, expecting final stats before disconnected", called)
+	}
+
+	// final stats include all traffic before stop, and no longer change
+	if finalStats.MsgTx < stats.MsgTx || finalStats.MsgRx < stats.MsgRx || finalStats.BytesTx < stats.BytesTx || finalStats.BytesRx < stats.BytesRx {
+		t.Fatalf("final stats is %+v, expecting no less than stats before stop %+v", finalStats, stats)
+	}
+	if finalStats.MsgTx < 3 || finalStats.MsgRx < 2 || finalStats.BytesTx < 300 || finalStats.BytesRx < 100 {
+		t.Fatalf("final stats is %+v, expecting at least 3 msg sent and 2 msg received", finalStats)
+	}
+	if finalStats.ConnectedFor <= 0 {
+		t.Fatalf("final stats has connected for %v, expecting positive", finalStats.ConnectedFor)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	after := rn.Stats()
+	if after.MsgTx != finalStats.MsgTx || after.MsgRx != finalStats.MsgRx || after.BytesTx != finalStats.BytesTx || after.BytesRx != finalStats.BytesRx || after.ConnectedFor != finalStats.ConnectedFor {
+		t.Fatalf("stats changes from %+v to %+v after final stats", finalStats, after)
+	}
+}
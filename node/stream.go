@@ -0,0 +1,276 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/nknorg/nnet/util"
+)
+
+// Application streams share the multiplexer with message streams. To avoid
+// ambiguity in which stream is which, message streams are always opened by
+// outbound node while application streams are always opened by inbound node.
+// When outbound node wants a new application stream, it sends an OPEN_STREAM
+// message and inbound node opens the stream for it. The first byte written to
+// each application stream by inbound node tells outbound node how to use it.
+const (
+	// Stream is opened by inbound node and should be accepted by outbound node
+	appStreamOpened byte = 0
+
+	// Stream is opened by inbound node as requested by outbound node, followed
+	// by the length and content of the OPEN_STREAM message id
+	appStreamRequested byte = 1
+
+	// Max number of application streams not yet accepted that can be buffered
+	appStreamChanLen = 32
+)
+
+// OpenStream opens a new application stream to remote node on top of the
+// multiplexer used by the connection. Each stream has its own flow control, so
+// a slow stream will not block messages or other streams on the same
+// connection. Remote node gets the other end of the stream by AcceptStream.
+// EnableAppStreams needs to be true on both nodes.
+func (rn *RemoteNode) OpenStream() (net.Conn, error) {
+	if !rn.LocalNode.EnableAppStreams {
+		return nil, errors.New("App streams are not enabled")
+	}
+
+	if rn.IsStopped() {
+		return nil, errors.New("Remote node has stopped")
+	}
+
+	if !rn.IsReady() {
+		return nil, errors.New("Remote node is not ready")
+	}
+
+	if rn.IsOutbound {
+		return rn.requestAppStream()
+	}
+
+	return rn.openAppStream([]byte{appStreamOpened})
+}
+
+// AcceptStream waits for and returns the next application stream opened by
+// remote node.
+func (rn *RemoteNode) AcceptStream() (net.Conn, error) {
+	if !rn.LocalNode.EnableAppStreams {
+		return nil, errors.New("App streams are not enabled")
+	}
+
+	select {
+	case stream := <-rn.appStreamChan:
+		return stream, nil
+	case <-rn.Done():
+		return nil, errors.New("Remote node has stopped")
+	}
+}
+
+// openAppStream opens a new stream on the multiplexer and writes header to it.
+// Should only be called by inbound node.
+func (rn *RemoteNode) openAppStream(header []byte) (net.Conn, error) {
+	rn.RLock()
+	mux := rn.mux
+	rn.RUnlock()
+
+	if mux == nil {
+		return nil, errors.New("Multiplexer is not ready")
+	}
+
+	stream, err := mux.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeFull(stream, header)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// requestAppStream sends an OPEN_STREAM message to remote node and waits for
+// remote node to open the stream. Should only be called by outbound node.
+func (rn *RemoteNode) requestAppStream() (net.Conn, error) {
+	msg, err := rn.LocalNode.NewOpenStreamMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	streamChan := make(chan net.Conn, 1)
+	key := string(msg.MessageId)
+
+	rn.Lock()
+	rn.pendingAppStreams[key] = streamChan
+	rn.Unlock()
+
+	err = rn.SendMessageAsync(msg)
+	if err != nil {
+		rn.Lock()
+		delete(rn.pendingAppStreams, key)
+		rn.Unlock()
+		return nil, err
+	}
+
+	timer := time.NewTimer(rn.LocalNode.DefaultReplyTimeout)
+	defer util.StopTimer(timer)
+
+	select {
+	case stream := <-streamChan:
+		return stream, nil
+	case <-timer.C:
+	case <-rn.Done():
+	}
+
+	rn.Lock()
+	_, pending := rn.pendingAppStreams[key]
+	delete(rn.pendingAppStreams, key)
+	rn.Unlock()
+
+	// stream has arrived after timeout but before we remove it from pending
+	if !pending {
+		return <-streamChan, nil
+	}
+
+	if rn.IsStopped() {
+		return nil, errors.New("Remote node has stopped")
+	}
+
+	return nil, errors.New("Wait for stream timeout")
+}
+
+// handleOpenStream opens the stream requested by remote node with OPEN_STREAM
+// message id msgID. Should only be called by inbound node.
+func (rn *RemoteNode) handleOpenStream(msgID []byte) error {
+	if !rn.LocalNode.EnableAppStreams {
+		return errors.New("App streams are not enabled")
+	}
+
+	if rn.IsOutbound {
+		return errors.New("Cannot open stream requested by inbound node")
+	}
+
+	if len(msgID) > 255 {
+		return fmt.Errorf("Message id length %d is too long", len(msgID))
+	}
+
+	header := append([]byte{appStreamRequested, byte(len(msgID))}, msgID...)
+	stream, err := rn.openAppStream(header)
+	if err != nil {
+		return err
+	}
+
+	rn.addAppStream(stream)
+
+	return nil
+}
+
+// acceptAppStreams starts a loop that accepts application streams opened by
+// remote node. Should only be called by outbound node.
+func (rn *RemoteNode) acceptAppStreams() {
+	rn.RLock()
+	mux := rn.mux
+	rn.RUnlock()
+
+	for {
+		stream, err := mux.AcceptStream()
+		if rn.IsStopped() {
+			if err == nil {
+				stream.Close()
+			}
+			return
+		}
+
+		if err != nil {
+			rn.Stop(fmt.Errorf("Accept app stream error: %s", err))
+			return
+		}
+
+		rn.LocalNode.wg.Add(1)
+		go rn.handleAppStream(stream)
+	}
+}
+
+// handleAppStream reads the header of an application stream opened by remote
+// node and dispatches it accordingly
+func (rn *RemoteNode) handleAppStream(stream net.Conn) {
+	defer rn.LocalNode.wg.Done()
+
+	err := rn.readAppStreamHeader(stream)
+	if err != nil {
+		rn.LocalNode.logger.Warningf("Handle app stream from %v error: %v", rn, err)
+		stream.Close()
+	}
+}
+
+// readAppStreamHeader reads the header of stream and sends it to AcceptStream
+// or the pending OpenStream call that requested it
+func (rn *RemoteNode) readAppStreamHeader(stream net.Conn) error {
+	err := stream.SetReadDeadline(time.Now().Add(rn.LocalNode.DefaultReplyTimeout))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2)
+	_, err = io.ReadFull(stream, buf[:1])
+	if err != nil {
+		return err
+	}
+
+	switch buf[0] {
+	case appStreamOpened:
+		err = stream.SetReadDeadline(time.Time{})
+		if err != nil {
+			return err
+		}
+
+		rn.addAppStream(stream)
+
+	case appStreamRequested:
+		_, err = io.ReadFull(stream, buf[1:])
+		if err != nil {
+			return err
+		}
+
+		msgID := make([]byte, buf[1])
+		_, err = io.ReadFull(stream, msgID)
+		if err != nil {
+			return err
+		}
+
+		err = stream.SetReadDeadline(time.Time{})
+		if err != nil {
+			return err
+		}
+
+		rn.Lock()
+		streamChan, ok := rn.pendingAppStreams[string(msgID)]
+		delete(rn.pendingAppStreams, string(msgID))
+		rn.Unlock()
+
+		if !ok {
+			return fmt.Errorf("No pending request for app stream %x", msgID)
+		}
+
+		streamChan <- stream
+
+	default:
+		return fmt.Errorf("Unknown app stream type %d", buf[0])
+	}
+
+	return nil
+}
+
+// addAppStream adds stream to be accepted by AcceptStream
+func (rn *RemoteNode) addAppStream(stream net.Conn) {
+	select {
+	case rn.appStreamChan <- stream:
+	default:
+		rn.LocalNode.logger.Warning("App stream chan full, discarding stream")
+		stream.Close()
+	}
+}
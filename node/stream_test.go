@@ -0,0 +1,160 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+// openTestStreams opens a stream from rn and accepts it on peerRn, and returns
+// both ends
+func openTestStreams(tb testing.TB, rn, peerRn *RemoteNode) (net.Conn, net.Conn) {
+	tb.Helper()
+
+	stream, err := rn.OpenStream()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		stream.Close()
+	})
+
+	peerStream, err := peerRn.AcceptStream()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		peerStream.Close()
+	})
+
+	return stream, peerStream
+}
+
+func TestAppStreamsConcurrent(t *testing.T) {
+	const numStreams = 8
+	const dataLen = 256 * 1024
+
+	ln := newTestLocalNode(t, &config.Config{EnableAppStreams: true})
+	peer := newTestLocalNode(t, &config.Config{EnableAppStreams: true})
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	var wg sync.WaitGroup
+	errChan := make(chan error, 2*numStreams)
+
+	for i := 0; i < numStreams; i++ {
+		stream, peerStream := openTestStreams(t, rn, peerRn)
+
+		data := bytes.Repeat([]byte{byte(i)}, dataLen)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := stream.Write(data)
+			if err != nil {
+				errChan <- err
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, dataLen)
+			_, err := io.ReadFull(peerStream, buf)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if !bytes.Equal(buf, data) {
+				errChan <- errors.New("data received from stream is different from sent")
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errChan)
+	for err := range errChan {
+		t.Fatal(err)
+	}
+}
+
+func TestAppStreamsIndependentBackpressure(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{EnableAppStreams: true})
+	peer := newTestLocalNode(t, &config.Config{EnableAppStreams: true})
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	// blocked stream is never read by peer, so its writer is blocked by the
+	// flow control of the stream
+	blocked, _ := openTestStreams(t, rn, peerRn)
+	blockedWritten := make(chan struct{})
+	go func() {
+		blocked.Write(make([]byte, 16*1024*1024))
+		close(blockedWritten)
+	}()
+
+	stream, peerStream := openTestStreams(t, rn, peerRn)
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-blockedWritten:
+		t.Fatal("write to stream that is not read is not blocked")
+	default:
+	}
+
+	// other streams and msg on the same connection are not blocked
+	data := []byte("hello")
+	err := stream.SetWriteDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = peerStream.SetReadDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(peerStream, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data) {
+		t.Fatalf("received %q from stream, expecting %q", buf, data)
+	}
+
+	err = rn.SendMessageAsync(newTestMessage(t, ln, data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, peer, time.Second)
+}
+
+func TestAcceptStreamRemoteNodeStopped(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{EnableAppStreams: true})
+	peer := newTestLocalNode(t, &config.Config{EnableAppStreams: true})
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := rn.AcceptStream()
+		errChan <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	rn.Stop(nil)
+
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Fatal("accept stream should fail after remote node stops")
+		}
+	case <-time.After(stopGracePeriod + 200*time.Millisecond):
+		t.Fatal("accept stream does not return after remote node stops")
+	}
+}
@@ -0,0 +1,241 @@
+package node
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+	"github.com/nknorg/nnet/protobuf"
+	"github.com/nknorg/nnet/util"
+)
+
+// testMsgChans stores the chan of msg received by each test local node
+var testMsgChans sync.Map
+
+// newTestLocalNode creates and starts a local node listening on an unused
+// in-memory port. Fields not set in conf use the default config, except that
+// yamux is used as multiplexer. Received replies are passed to their reply
+// chans like a router does, and other msg can be received by
+// recvTestMessage. Local node is shut down when test finishes.
+func newTestLocalNode(tb testing.TB, conf *config.Config) *LocalNode {
+	tb.Helper()
+
+	ln := newTestUnstartedLocalNode(tb, conf)
+
+	err := ln.Start()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	testMsgChans.Store(ln, serveTestNode(tb, ln))
+
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := ln.Shutdown(ctx)
+		if err != nil {
+			tb.Errorf("shutdown local node error: %v", err)
+		}
+	})
+
+	return ln
+}
+
+// newTestUnstartedLocalNode creates a local node the same way as
+// newTestLocalNode without starting it
+func newTestUnstartedLocalNode(tb testing.TB, conf *config.Config) *LocalNode {
+	tb.Helper()
+
+	if conf == nil {
+		conf = &config.Config{}
+	}
+	if conf.Transport == "" {
+		conf.Transport = "mem"
+	}
+	if conf.Multiplexer == "" {
+		conf.Multiplexer = "yamux"
+	}
+
+	merged, err := config.MergedConfig(conf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	id, err := util.RandBytes(int(merged.NodeIDBytes))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	ln, err := NewLocalNode(id, merged)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return ln
+}
+
+// connectTestNodes connects ln to peer and waits until both sides are ready.
+// Returns the remote node of peer on ln and the remote node of ln on peer.
+func connectTestNodes(tb testing.TB, ln, peer *LocalNode) (*RemoteNode, *RemoteNode) {
+	tb.Helper()
+
+	rn, _, err := ln.Connect(peer.Addr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if rn == nil {
+		tb.Fatal("remote node is nil")
+	}
+
+	var peerRn *RemoteNode
+	waitFor(tb, 5*time.Second, func() bool {
+		if !rn.IsReady() {
+			return false
+		}
+		peerRn = peer.GetRemoteNodeByID(ln.Id)
+		return peerRn != nil
+	})
+
+	return rn, peerRn
+}
+
+// newTestIdleRemoteNode creates a remote node of ln that is not started, so
+// msg sent to it stay in its tx queues until it stops
+func newTestIdleRemoteNode(tb testing.TB, ln *LocalNode) *RemoteNode {
+	tb.Helper()
+
+	conn, peerConn := net.Pipe()
+	tb.Cleanup(func() {
+		peerConn.Close()
+	})
+
+	rn, err := NewRemoteNode(ln, conn, true)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() {
+		rn.Stop(nil)
+	})
+
+	return rn
+}
+
+// waitFor waits until cond returns true, or fails the test after timeout
+func waitFor(tb testing.TB, timeout time.Duration, cond func() bool) {
+	tb.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			tb.Fatalf("condition not met within %v", timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newTestMessage creates a direct msg with data that can be sent from ln
+func newTestMessage(tb testing.TB, ln *LocalNode, data []byte) *protobuf.Message {
+	tb.Helper()
+
+	id, err := ln.GenMessageID()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &protobuf.Message{
+		MessageType: protobuf.BYTES,
+		RoutingType: protobuf.DIRECT,
+		MessageId:   id,
+		Message:     data,
+	}
+}
+
+// newTestReply creates a direct reply to msg with id replyToID that can be
+// sent from ln
+func newTestReply(tb testing.TB, ln *LocalNode, replyToID, data []byte) *protobuf.Message {
+	tb.Helper()
+
+	msg := newTestMessage(tb, ln, data)
+	msg.ReplyToId = replyToID
+
+	return msg
+}
+
+// recvTestMessage receives a msg other than reply received by ln, or fails
+// the test after timeout
+func recvTestMessage(tb testing.TB, ln *LocalNode, timeout time.Duration) *RemoteMessage {
+	tb.Helper()
+
+	value, ok := testMsgChans.Load(ln)
+	if !ok {
+		tb.Fatal("local node is not created by newTestLocalNode")
+	}
+
+	select {
+	case remoteMsg := <-value.(<-chan *RemoteMessage):
+		return remoteMsg
+	case <-time.After(timeout):
+		tb.Fatalf("no msg received within %v", timeout)
+	}
+
+	return nil
+}
+
+// serveTestNode reads msg of routing type DIRECT received by ln until ln
+// stops. Replies are passed to their reply chans and node msg are handled by
+// ln the same way as a router does, bytes msg are passed to the returned chan.
+func serveTestNode(tb testing.TB, ln *LocalNode) <-chan *RemoteMessage {
+	tb.Helper()
+
+	rxMsgChan, err := ln.GetRxMsgChan(protobuf.DIRECT)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	msgChan := make(chan *RemoteMessage, 1024)
+
+	go func() {
+		for {
+			var remoteMsg *RemoteMessage
+			select {
+			case remoteMsg = <-rxMsgChan:
+			case <-ln.Done():
+				return
+			}
+
+			if len(remoteMsg.Msg.ReplyToId) == 0 {
+				if remoteMsg.Msg.MessageType != protobuf.BYTES {
+					ln.HandleRemoteMessage(remoteMsg)
+					continue
+				}
+				select {
+				case msgChan <- remoteMsg:
+				case <-ln.Done():
+					return
+				}
+				continue
+			}
+
+			if !ln.ValidateReply(remoteMsg.Msg) {
+				continue
+			}
+
+			replyChan, ok := ln.GetReplyChan(remoteMsg.Msg.ReplyToId)
+			if !ok || !ln.ClaimReply(remoteMsg.Msg.ReplyToId) {
+				continue
+			}
+
+			select {
+			case replyChan <- remoteMsg:
+			default:
+				ln.UnclaimReply(remoteMsg.Msg.ReplyToId)
+			}
+		}
+	}()
+
+	return msgChan
+}
@@ -0,0 +1,142 @@
+package node
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+// newTestTLSConfig creates a TLS config with a self-signed certificate that
+// can be used for both client and server
+func newTestTLSConfig(tb testing.TB) *tls.Config {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nnet test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &tls.Config{
+		Certificates:       []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		ClientAuth:         tls.RequireAnyClientCert,
+		InsecureSkipVerify: true,
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	ln := newTestLocalNode(t, nil)
+	ln.SetTLSConfig(newTestTLSConfig(t))
+	peer := newTestLocalNode(t, nil)
+	peer.SetTLSConfig(newTestTLSConfig(t))
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	if level := rn.SecurityLevel(); level != SecurityTLS {
+		t.Fatalf("security level is %v, expecting %v", level, SecurityTLS)
+	}
+	if rn.PeerCertificate() == nil || peerRn.PeerCertificate() == nil {
+		t.Fatal("peer certificate is nil after TLS handshake")
+	}
+	if rn.SetupTimings().TLSHandshake <= 0 {
+		t.Fatal("TLS handshake time is not recorded")
+	}
+
+	err := rn.SendMessageAsync(newTestMessage(t, ln, []byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, peer, time.Second)
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{TLSHandshakeTimeout: 100 * time.Millisecond})
+	ln.SetTLSConfig(newTestTLSConfig(t))
+
+	// peer never starts TLS handshake
+	conn, peerConn := net.Pipe()
+	defer peerConn.Close()
+
+	rn, err := NewRemoteNode(ln, ln.wrapConn(conn, false), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ln.startRemoteNode(rn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, time.Second, rn.IsStopped)
+
+	if _, ok := rn.StopReason().(*TLSHandshakeError); !ok {
+		t.Fatalf("remote node stops because of %v, expecting TLS handshake error", rn.StopReason())
+	}
+}
+
+// testTLSPublicKey returns the public key of the certificate in tlsConfig in
+// PKIX, ASN.1 DER form
+func testTLSPublicKey(tb testing.TB, tlsConfig *tls.Config) []byte {
+	tb.Helper()
+
+	cert, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return publicKey
+}
+
+func TestPublicKey(t *testing.T) {
+	tlsConfig := newTestTLSConfig(t)
+	peerTLSConfig := newTestTLSConfig(t)
+
+	ln := newTestLocalNode(t, nil)
+	ln.SetTLSConfig(tlsConfig)
+	peer := newTestLocalNode(t, nil)
+	peer.SetTLSConfig(peerTLSConfig)
+
+	rn, peerRn := connectTestNodes(t, ln, peer)
+
+	if !bytes.Equal(rn.PublicKey(), testTLSPublicKey(t, peerTLSConfig)) {
+		t.Fatalf("public key of remote node is %x, expecting key of peer certificate", rn.PublicKey())
+	}
+	if !bytes.Equal(peerRn.PublicKey(), testTLSPublicKey(t, tlsConfig)) {
+		t.Fatalf("public key of inbound remote node is %x, expecting key of local node certificate", peerRn.PublicKey())
+	}
+
+	// no public key without TLS
+	plainLn := newTestLocalNode(t, nil)
+	plainPeer := newTestLocalNode(t, nil)
+	plainRn, _ := connectTestNodes(t, plainLn, plainPeer)
+	if plainRn.PublicKey() != nil {
+		t.Fatalf("public key of non-TLS remote node is %x, expecting nil", plainRn.PublicKey())
+	}
+}
@@ -0,0 +1,52 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nnet/config"
+)
+
+func TestValidateMessage(t *testing.T) {
+	ln := newTestLocalNode(t, &config.Config{MaxMessageSize: 1024})
+	peer := newTestLocalNode(t, nil)
+	rn, _ := connectTestNodes(t, ln, peer)
+
+	msg := newTestMessage(t, ln, []byte("hello"))
+	buf, err := marshalMsg(msg, msgCodecProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := rn.ValidateMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := ln.framer.FrameSize(len(buf)); n != expected {
+		t.Fatalf("validated size is %d, expecting %d", n, expected)
+	}
+
+	// msg is not sent and can still be sent later
+	time.Sleep(100 * time.Millisecond)
+	value, _ := testMsgChans.Load(peer)
+	if n := len(value.(<-chan *RemoteMessage)); n != 0 {
+		t.Fatalf("peer received %d msg after validation", n)
+	}
+	err = rn.SendMessageAsync(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recvTestMessage(t, peer, time.Second)
+
+	msg = newTestMessage(t, ln, nil)
+	msg.MessageId = nil
+	_, err = rn.ValidateMessage(msg)
+	if err == nil {
+		t.Fatal("msg with empty id should be invalid")
+	}
+
+	_, err = rn.ValidateMessage(newTestMessage(t, ln, make([]byte, 1024)))
+	if err == nil {
+		t.Fatal("msg larger than max msg size should be invalid")
+	}
+}
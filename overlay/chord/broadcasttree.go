@@ -27,6 +27,11 @@ func NewBroadcastTreeRouting(localMsgChan chan<- *node.RemoteMessage, rxMsgChan
 		return nil, err
 	}
 
+	err = r.SetLogger(chord.LocalNode.GetLogger())
+	if err != nil {
+		return nil, err
+	}
+
 	btr := &BroadcastTreeRouting{
 		Routing: r,
 		chord:   chord,
@@ -54,7 +59,7 @@ func (btr *BroadcastTreeRouting) GetNodeToRoute(remoteMsg *node.RemoteMessage) (
 
 	for i := 0; i < maxIdx; i++ {
 		for _, remoteNode := range btr.chord.fingerTable[i].ToRemoteNodeList(false) {
-			if remoteNode != remoteMsg.RemoteNode && !bytes.Equal(remoteNode.Id, remoteMsg.Msg.SrcId) {
+			if remoteNode != remoteMsg.RemoteNode && !bytes.Equal(remoteNode.Id, remoteMsg.Msg.SrcId) && remoteMsg.CanRouteTo(remoteNode) {
 				remoteNodes = append(remoteNodes, remoteNode)
 			}
 		}
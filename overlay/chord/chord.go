@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/gogo/protobuf/proto"
-	"github.com/nknorg/nnet/log"
 	"github.com/nknorg/nnet/node"
 	"github.com/nknorg/nnet/overlay"
 	"github.com/nknorg/nnet/overlay/routing"
@@ -98,6 +97,10 @@ func NewChord(localNode *node.LocalNode) (*Chord, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = directRouting.SetLogger(localNode.GetLogger())
+	if err != nil {
+		return nil, err
+	}
 	err = ovl.AddRouter(protobuf.DIRECT, directRouting)
 	if err != nil {
 		return nil, err
@@ -201,9 +204,9 @@ func (c *Chord) Start(isCreate bool) error {
 
 				for _, succ := range succs {
 					if CompareID(succ.Id, c.LocalNode.Id) != 0 {
-						err = c.Connect(succ.Addr, succ.Id)
+						err = c.ConnectNode(succ)
 						if err != nil {
-							log.Error(err)
+							c.LocalNode.GetLogger().Error(err)
 						}
 					}
 				}
@@ -219,7 +222,7 @@ func (c *Chord) Start(isCreate bool) error {
 			return
 		}
 
-		for _, mw := range c.middlewareStore.networkWillStart {
+		for _, mw := range c.middlewareStore.networkWillStart.Load().([]overlay.NetworkWillStart) {
 			if !mw.Func(c) {
 				break
 			}
@@ -241,7 +244,7 @@ func (c *Chord) Start(isCreate bool) error {
 			return
 		}
 
-		for _, mw := range c.middlewareStore.networkStarted {
+		for _, mw := range c.middlewareStore.networkStarted.Load().([]overlay.NetworkStarted) {
 			if !mw.Func(c) {
 				break
 			}
@@ -254,16 +257,16 @@ func (c *Chord) Start(isCreate bool) error {
 // Stop stops the chord network
 func (c *Chord) Stop(err error) {
 	c.StopOnce.Do(func() {
-		for _, mw := range c.middlewareStore.networkWillStop {
+		for _, mw := range c.middlewareStore.networkWillStop.Load().([]overlay.NetworkWillStop) {
 			if !mw.Func(c) {
 				break
 			}
 		}
 
 		if err != nil {
-			log.Warningf("Chord overlay stops because of error: %s", err)
+			c.LocalNode.GetLogger().Warningf("Chord overlay stops because of error: %s", err)
 		} else {
-			log.Infof("Chord overlay stops")
+			c.LocalNode.GetLogger().Infof("Chord overlay stops")
 		}
 
 		for _, remoteNode := range c.neighbors.ToRemoteNodeList(false) {
@@ -276,7 +279,7 @@ func (c *Chord) Stop(err error) {
 
 		c.StopRouters(err)
 
-		for _, mw := range c.middlewareStore.networkStopped {
+		for _, mw := range c.middlewareStore.networkStopped.Load().([]overlay.NetworkStopped) {
 			if !mw.Func(c) {
 				break
 			}
@@ -296,22 +299,22 @@ func (c *Chord) handleMsg() {
 	var err error
 
 	for {
-		if c.IsStopped() {
+		select {
+		case remoteMsg = <-c.LocalMsgChan:
+		case <-c.Done():
 			return
 		}
 
-		remoteMsg = <-c.LocalMsgChan
-
 		shouldLocalNodeHandleMsg, err = c.handleRemoteMessage(remoteMsg)
 		if err != nil {
-			log.Error(err)
+			c.LocalNode.GetLogger().Error(err)
 			continue
 		}
 
 		if shouldLocalNodeHandleMsg {
 			err = c.LocalNode.HandleRemoteMessage(remoteMsg)
 			if err != nil {
-				log.Error(err)
+				c.LocalNode.GetLogger().Error(err)
 				continue
 			}
 		}
@@ -341,7 +344,7 @@ func (c *Chord) updateSuccessors() {
 
 		err = c.updateNeighborList(c.successors)
 		if err != nil {
-			log.Error("Update successors error:", err)
+			c.LocalNode.GetLogger().Error("Update successors error:", err)
 		}
 	}
 }
@@ -359,7 +362,7 @@ func (c *Chord) updatePredecessors() {
 
 		err = c.updateNeighborList(c.predecessors)
 		if err != nil {
-			log.Error("Update predecessor error:", err)
+			c.LocalNode.GetLogger().Error("Update predecessor error:", err)
 		}
 	}
 }
@@ -387,14 +390,14 @@ func (c *Chord) findNewPredecessors() {
 				}
 			}
 			if !hasInboundNeighbor {
-				log.Warning("Local node has no inbound neighbor, it's possible that local node is unreachable from outside, e.g. behind firewall or NAT.")
+				c.LocalNode.GetLogger().Warning("Local node has no inbound neighbor, it's possible that local node is unreachable from outside, e.g. behind firewall or NAT.")
 				continue
 			}
 		}
 
 		maybeNewNodes, err = c.FindPredecessors(c.predecessors.startID, 1)
 		if err != nil {
-			log.Error("Find predecessors error:", err)
+			c.LocalNode.GetLogger().Error("Find predecessors error:", err)
 			continue
 		}
 
@@ -402,9 +405,9 @@ func (c *Chord) findNewPredecessors() {
 			if c.predecessors.IsIDInRange(n.Id) && !c.predecessors.Exists(n.Id) {
 				existing = c.predecessors.GetFirst()
 				if existing == nil || c.predecessors.cmp(n, existing.Node.Node) < 0 {
-					err = c.Connect(n.Addr, n.Id)
+					err = c.ConnectNode(n)
 					if err != nil {
-						log.Error("Connect to new predecessor error:", err)
+						c.LocalNode.GetLogger().Error("Connect to new predecessor error:", err)
 					}
 				}
 			}
@@ -431,7 +434,7 @@ func (c *Chord) updateFinger() {
 
 			err = c.updateNeighborList(finger)
 			if err != nil {
-				log.Error("Update finger table error:", err)
+				c.LocalNode.GetLogger().Error("Update finger table error:", err)
 			}
 		}
 
@@ -457,7 +460,7 @@ func (c *Chord) findNewFinger() {
 
 			succs, err = c.FindSuccessors(c.fingerTable[i].startID, 1)
 			if err != nil {
-				log.Error("Find successor for finger table error:", err)
+				c.LocalNode.GetLogger().Error("Find successor for finger table error:", err)
 				continue
 			}
 
@@ -469,9 +472,9 @@ func (c *Chord) findNewFinger() {
 				if c.fingerTable[i].IsIDInRange(succs[0].Id) && !c.fingerTable[i].Exists(succs[0].Id) {
 					existing = c.fingerTable[i].GetFirst()
 					if existing == nil || c.fingerTable[i].cmp(succs[0], existing.Node.Node) < 0 {
-						err = c.Connect(succs[0].Addr, succs[0].Id)
+						err = c.ConnectNode(succs[0])
 						if err != nil {
-							log.Error("Connect to new successor error:", err)
+							c.LocalNode.GetLogger().Error("Connect to new successor error:", err)
 						}
 					}
 					break
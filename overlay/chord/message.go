@@ -38,7 +38,7 @@ func NewGetSuccAndPredMessage(numSucc, numPred uint32, msgIDBytes uint8) (*proto
 // NewGetSuccAndPredReply creates a GET_SUCC_AND_PRED reply to send successors
 // and predecessor
 func (c *Chord) NewGetSuccAndPredReply(replyToID []byte, successors, predecessors []*protobuf.Node) (*protobuf.Message, error) {
-	id, err := message.GenID(c.LocalNode.MessageIDBytes)
+	id, err := c.LocalNode.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +67,7 @@ func (c *Chord) NewGetSuccAndPredReply(replyToID []byte, successors, predecessor
 // NewFindSuccAndPredMessage creates a FIND_SUCC_AND_PRED message to find
 // numSucc successors and numPred predecessors of a key
 func (c *Chord) NewFindSuccAndPredMessage(key []byte, numSucc, numPred uint32) (*protobuf.Message, error) {
-	id, err := message.GenID(c.LocalNode.MessageIDBytes)
+	id, err := c.LocalNode.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +97,7 @@ func (c *Chord) NewFindSuccAndPredMessage(key []byte, numSucc, numPred uint32) (
 // NewFindSuccAndPredReply creates a FIND_SUCC_AND_PRED reply to send successors
 // and predecessors
 func (c *Chord) NewFindSuccAndPredReply(replyToID []byte, successors, predecessors []*protobuf.Node) (*protobuf.Message, error) {
-	id, err := message.GenID(c.LocalNode.MessageIDBytes)
+	id, err := c.LocalNode.GenMessageID()
 	if err != nil {
 		return nil, err
 	}
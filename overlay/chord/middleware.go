@@ -2,6 +2,7 @@ package chord
 
 import (
 	"errors"
+	"sync/atomic"
 
 	"github.com/nknorg/nnet/middleware"
 	"github.com/nknorg/nnet/node"
@@ -91,116 +92,85 @@ type NeighborRemoved struct {
 // middlewareStore stores the functions that will be called when certain events
 // are triggered or in some pipeline
 type middlewareStore struct {
-	networkWillStart   []overlay.NetworkWillStart
-	networkStarted     []overlay.NetworkStarted
-	networkWillStop    []overlay.NetworkWillStop
-	networkStopped     []overlay.NetworkStopped
-	successorAdded     []SuccessorAdded
-	successorRemoved   []SuccessorRemoved
-	predecessorAdded   []PredecessorAdded
-	predecessorRemoved []PredecessorRemoved
-	fingerTableAdded   []FingerTableAdded
-	fingerTableRemoved []FingerTableRemoved
-	neighborAdded      []NeighborAdded
-	neighborRemoved    []NeighborRemoved
+	networkWillStart   atomic.Value // []overlay.NetworkWillStart
+	networkStarted     atomic.Value // []overlay.NetworkStarted
+	networkWillStop    atomic.Value // []overlay.NetworkWillStop
+	networkStopped     atomic.Value // []overlay.NetworkStopped
+	successorAdded     atomic.Value // []SuccessorAdded
+	successorRemoved   atomic.Value // []SuccessorRemoved
+	predecessorAdded   atomic.Value // []PredecessorAdded
+	predecessorRemoved atomic.Value // []PredecessorRemoved
+	fingerTableAdded   atomic.Value // []FingerTableAdded
+	fingerTableRemoved atomic.Value // []FingerTableRemoved
+	neighborAdded      atomic.Value // []NeighborAdded
+	neighborRemoved    atomic.Value // []NeighborRemoved
+
+	registry middleware.Registry
 }
 
 // newMiddlewareStore creates a middlewareStore
 func newMiddlewareStore() *middlewareStore {
-	return &middlewareStore{
-		networkWillStart:   make([]overlay.NetworkWillStart, 0),
-		networkStarted:     make([]overlay.NetworkStarted, 0),
-		networkWillStop:    make([]overlay.NetworkWillStop, 0),
-		networkStopped:     make([]overlay.NetworkStopped, 0),
-		successorAdded:     make([]SuccessorAdded, 0),
-		successorRemoved:   make([]SuccessorRemoved, 0),
-		predecessorAdded:   make([]PredecessorAdded, 0),
-		predecessorRemoved: make([]PredecessorRemoved, 0),
-		fingerTableAdded:   make([]FingerTableAdded, 0),
-		fingerTableRemoved: make([]FingerTableRemoved, 0),
-		neighborAdded:      make([]NeighborAdded, 0),
-		neighborRemoved:    make([]NeighborRemoved, 0),
-	}
+	store := &middlewareStore{}
+	store.networkWillStart.Store(make([]overlay.NetworkWillStart, 0))
+	store.networkStarted.Store(make([]overlay.NetworkStarted, 0))
+	store.networkWillStop.Store(make([]overlay.NetworkWillStop, 0))
+	store.networkStopped.Store(make([]overlay.NetworkStopped, 0))
+	store.successorAdded.Store(make([]SuccessorAdded, 0))
+	store.successorRemoved.Store(make([]SuccessorRemoved, 0))
+	store.predecessorAdded.Store(make([]PredecessorAdded, 0))
+	store.predecessorRemoved.Store(make([]PredecessorRemoved, 0))
+	store.fingerTableAdded.Store(make([]FingerTableAdded, 0))
+	store.fingerTableRemoved.Store(make([]FingerTableRemoved, 0))
+	store.neighborAdded.Store(make([]NeighborAdded, 0))
+	store.neighborRemoved.Store(make([]NeighborRemoved, 0))
+	return store
 }
 
 // ApplyMiddleware add a middleware to the store
 func (store *middlewareStore) ApplyMiddleware(mw interface{}) error {
+	_, err := store.AddMiddleware(mw)
+	return err
+}
+
+// AddMiddleware is the same as ApplyMiddleware, but also returns the id of the
+// middleware, which can be used to remove it with RemoveMiddleware
+func (store *middlewareStore) AddMiddleware(mw interface{}) (middleware.ID, error) {
 	switch mw := mw.(type) {
 	case overlay.NetworkWillStart:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.networkWillStart = append(store.networkWillStart, mw)
-		middleware.Sort(store.networkWillStart)
+		return store.registry.Add(&store.networkWillStart, mw)
 	case overlay.NetworkStarted:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.networkStarted = append(store.networkStarted, mw)
-		middleware.Sort(store.networkStarted)
+		return store.registry.Add(&store.networkStarted, mw)
 	case overlay.NetworkWillStop:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.networkWillStop = append(store.networkWillStop, mw)
-		middleware.Sort(store.networkWillStop)
+		return store.registry.Add(&store.networkWillStop, mw)
 	case overlay.NetworkStopped:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.networkStopped = append(store.networkStopped, mw)
-		middleware.Sort(store.networkStopped)
+		return store.registry.Add(&store.networkStopped, mw)
 	case SuccessorAdded:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.successorAdded = append(store.successorAdded, mw)
-		middleware.Sort(store.successorAdded)
+		return store.registry.Add(&store.successorAdded, mw)
 	case SuccessorRemoved:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.successorRemoved = append(store.successorRemoved, mw)
-		middleware.Sort(store.successorRemoved)
+		return store.registry.Add(&store.successorRemoved, mw)
 	case PredecessorAdded:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.predecessorAdded = append(store.predecessorAdded, mw)
-		middleware.Sort(store.predecessorAdded)
+		return store.registry.Add(&store.predecessorAdded, mw)
 	case PredecessorRemoved:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.predecessorRemoved = append(store.predecessorRemoved, mw)
-		middleware.Sort(store.predecessorRemoved)
+		return store.registry.Add(&store.predecessorRemoved, mw)
 	case FingerTableAdded:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.fingerTableAdded = append(store.fingerTableAdded, mw)
-		middleware.Sort(store.fingerTableAdded)
+		return store.registry.Add(&store.fingerTableAdded, mw)
 	case FingerTableRemoved:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.fingerTableRemoved = append(store.fingerTableRemoved, mw)
-		middleware.Sort(store.fingerTableRemoved)
+		return store.registry.Add(&store.fingerTableRemoved, mw)
 	case NeighborAdded:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.neighborAdded = append(store.neighborAdded, mw)
-		middleware.Sort(store.neighborAdded)
+		return store.registry.Add(&store.neighborAdded, mw)
 	case NeighborRemoved:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.neighborRemoved = append(store.neighborRemoved, mw)
-		middleware.Sort(store.neighborRemoved)
+		return store.registry.Add(&store.neighborRemoved, mw)
 	default:
-		return errors.New("unknown middleware type")
+		return 0, errors.New("unknown middleware type")
 	}
+}
 
+// RemoveMiddleware removes the middleware with id returned by AddMiddleware.
+// It can be called at any time, e.g. when a plugin is torn down, but an event
+// already being triggered may still call the removed middleware.
+func (store *middlewareStore) RemoveMiddleware(id middleware.ID) error {
+	if !store.registry.Remove(id) {
+		return errors.New("middleware not found")
+	}
 	return nil
 }
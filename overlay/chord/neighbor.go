@@ -3,23 +3,29 @@ package chord
 import (
 	"errors"
 
-	"github.com/nknorg/nnet/log"
 	"github.com/nknorg/nnet/node"
+	"github.com/nknorg/nnet/protobuf"
 	"github.com/nknorg/nnet/util"
 )
 
 // Connect connects to a remote node. optionally with id info to check if
 // connection has established
 func (c *Chord) Connect(addr string, id []byte) error {
-	if id != nil {
-		remoteNode := c.neighbors.GetByID(id)
+	return c.ConnectNode(&protobuf.Node{Id: id, Addr: addr})
+}
+
+// ConnectNode is the same as Connect, but will try the additional addresses of
+// n in order if n.Addr is unreachable
+func (c *Chord) ConnectNode(n *protobuf.Node) error {
+	if n.Id != nil {
+		remoteNode := c.neighbors.GetByID(n.Id)
 		if remoteNode != nil {
-			log.Infof("Node with id %x is already a neighbor", id)
+			c.LocalNode.GetLogger().Infof("Node with id %x is already a neighbor", n.Id)
 			return c.addRemoteNode(remoteNode)
 		}
 	}
 
-	remoteNode, ready, err := c.LocalNode.Connect(addr)
+	remoteNode, ready, err := c.LocalNode.ConnectNode(n)
 	if err != nil {
 		return err
 	}
@@ -42,7 +48,7 @@ func (c *Chord) addSuccessor(remoteNode *node.RemoteNode) error {
 		if added {
 			index := c.successors.GetIndex(remoteNode.Id)
 			if index >= 0 {
-				for _, mw := range c.middlewareStore.successorAdded {
+				for _, mw := range c.middlewareStore.successorAdded.Load().([]SuccessorAdded) {
 					if !mw.Func(remoteNode, index) {
 						break
 					}
@@ -51,7 +57,7 @@ func (c *Chord) addSuccessor(remoteNode *node.RemoteNode) error {
 		}
 
 		if replaced != nil {
-			for _, mw := range c.middlewareStore.successorRemoved {
+			for _, mw := range c.middlewareStore.successorRemoved.Load().([]SuccessorRemoved) {
 				if !mw.Func(replaced) {
 					break
 				}
@@ -75,7 +81,7 @@ func (c *Chord) addPredecessor(remoteNode *node.RemoteNode) error {
 		if added {
 			index := c.predecessors.GetIndex(remoteNode.Id)
 			if index >= 0 {
-				for _, mw := range c.middlewareStore.predecessorAdded {
+				for _, mw := range c.middlewareStore.predecessorAdded.Load().([]PredecessorAdded) {
 					if !mw.Func(remoteNode, index) {
 						break
 					}
@@ -84,7 +90,7 @@ func (c *Chord) addPredecessor(remoteNode *node.RemoteNode) error {
 		}
 
 		if replaced != nil {
-			for _, mw := range c.middlewareStore.predecessorRemoved {
+			for _, mw := range c.middlewareStore.predecessorRemoved.Load().([]PredecessorRemoved) {
 				if !mw.Func(replaced) {
 					break
 				}
@@ -110,7 +116,7 @@ func (c *Chord) addFingerTable(remoteNode *node.RemoteNode, index int) error {
 		if added {
 			i := finger.GetIndex(remoteNode.Id)
 			if i >= 0 {
-				for _, mw := range c.middlewareStore.fingerTableAdded {
+				for _, mw := range c.middlewareStore.fingerTableAdded.Load().([]FingerTableAdded) {
 					if !mw.Func(remoteNode, index, i) {
 						break
 					}
@@ -119,7 +125,7 @@ func (c *Chord) addFingerTable(remoteNode *node.RemoteNode, index int) error {
 		}
 
 		if replaced != nil {
-			for _, mw := range c.middlewareStore.fingerTableRemoved {
+			for _, mw := range c.middlewareStore.fingerTableRemoved.Load().([]FingerTableRemoved) {
 				if !mw.Func(replaced, index) {
 					break
 				}
@@ -147,7 +153,7 @@ func (c *Chord) addNeighbor(remoteNode *node.RemoteNode) error {
 		if added {
 			index := c.neighbors.GetIndex(remoteNode.Id)
 			if index >= 0 {
-				for _, mw := range c.middlewareStore.neighborAdded {
+				for _, mw := range c.middlewareStore.neighborAdded.Load().([]NeighborAdded) {
 					if !mw.Func(remoteNode, index) {
 						break
 					}
@@ -156,7 +162,7 @@ func (c *Chord) addNeighbor(remoteNode *node.RemoteNode) error {
 		}
 
 		if replaced != nil {
-			for _, mw := range c.middlewareStore.neighborRemoved {
+			for _, mw := range c.middlewareStore.neighborRemoved.Load().([]NeighborRemoved) {
 				if !mw.Func(replaced) {
 					break
 				}
@@ -177,24 +183,24 @@ func (c *Chord) addRemoteNode(remoteNode *node.RemoteNode) error {
 
 	err := c.addSuccessor(remoteNode)
 	if err != nil {
-		log.Error(err)
+		c.LocalNode.GetLogger().Error(err)
 	}
 
 	err = c.addPredecessor(remoteNode)
 	if err != nil {
-		log.Error(err)
+		c.LocalNode.GetLogger().Error(err)
 	}
 
 	for i := range c.fingerTable {
 		err = c.addFingerTable(remoteNode, i)
 		if err != nil {
-			log.Error(err)
+			c.LocalNode.GetLogger().Error(err)
 		}
 	}
 
 	err = c.addNeighbor(remoteNode)
 	if err != nil {
-		log.Error(err)
+		c.LocalNode.GetLogger().Error(err)
 	}
 
 	return nil
@@ -204,7 +210,7 @@ func (c *Chord) addRemoteNode(remoteNode *node.RemoteNode) error {
 func (c *Chord) removeNeighbor(remoteNode *node.RemoteNode) error {
 	removed := c.successors.Remove(remoteNode)
 	if removed {
-		for _, mw := range c.middlewareStore.successorRemoved {
+		for _, mw := range c.middlewareStore.successorRemoved.Load().([]SuccessorRemoved) {
 			if !mw.Func(remoteNode) {
 				break
 			}
@@ -214,7 +220,7 @@ func (c *Chord) removeNeighbor(remoteNode *node.RemoteNode) error {
 			if rn != remoteNode {
 				err := c.addSuccessor(rn)
 				if err != nil {
-					log.Error(err)
+					c.LocalNode.GetLogger().Error(err)
 				}
 			}
 		}
@@ -222,7 +228,7 @@ func (c *Chord) removeNeighbor(remoteNode *node.RemoteNode) error {
 
 	removed = c.predecessors.Remove(remoteNode)
 	if removed {
-		for _, mw := range c.middlewareStore.predecessorRemoved {
+		for _, mw := range c.middlewareStore.predecessorRemoved.Load().([]PredecessorRemoved) {
 			if !mw.Func(remoteNode) {
 				break
 			}
@@ -233,7 +239,7 @@ func (c *Chord) removeNeighbor(remoteNode *node.RemoteNode) error {
 			if neighbors[len(neighbors)-i-1] != remoteNode {
 				err := c.addPredecessor(neighbors[len(neighbors)-i-1])
 				if err != nil {
-					log.Error(err)
+					c.LocalNode.GetLogger().Error(err)
 				}
 			}
 		}
@@ -242,7 +248,7 @@ func (c *Chord) removeNeighbor(remoteNode *node.RemoteNode) error {
 	for i, finger := range c.fingerTable {
 		removed = finger.Remove(remoteNode)
 		if removed {
-			for _, mw := range c.middlewareStore.fingerTableRemoved {
+			for _, mw := range c.middlewareStore.fingerTableRemoved.Load().([]FingerTableRemoved) {
 				if !mw.Func(remoteNode, i) {
 					break
 				}
@@ -252,7 +258,7 @@ func (c *Chord) removeNeighbor(remoteNode *node.RemoteNode) error {
 				if rn != remoteNode {
 					err := c.addFingerTable(rn, i)
 					if err != nil {
-						log.Error(err)
+						c.LocalNode.GetLogger().Error(err)
 					}
 				}
 			}
@@ -261,7 +267,7 @@ func (c *Chord) removeNeighbor(remoteNode *node.RemoteNode) error {
 
 	removed = c.neighbors.Remove(remoteNode)
 	if removed {
-		for _, mw := range c.middlewareStore.neighborRemoved {
+		for _, mw := range c.middlewareStore.neighborRemoved.Load().([]NeighborRemoved) {
 			if !mw.Func(remoteNode) {
 				break
 			}
@@ -305,7 +311,7 @@ func (c *Chord) updateNeighborList(neighborList *NeighborList) error {
 
 	errs := util.NewErrors()
 	for _, newNode := range newNodes {
-		err = c.Connect(newNode.Addr, newNode.Id)
+		err = c.ConnectNode(newNode)
 		if err != nil {
 			errs = append(errs, err)
 		}
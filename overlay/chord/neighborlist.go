@@ -7,7 +7,6 @@ import (
 	"sort"
 	"sync"
 
-	"github.com/nknorg/nnet/log"
 	"github.com/nknorg/nnet/node"
 	"github.com/nknorg/nnet/protobuf"
 )
@@ -190,7 +189,7 @@ func (sl *NeighborList) AddOrReplace(remoteNode *node.RemoteNode) (bool, *node.R
 
 	_, loaded := sl.nodes.LoadOrStore(string(remoteNode.Id), remoteNode)
 	if loaded {
-		log.Info("Node already in neighbor list")
+		remoteNode.LocalNode.GetLogger().Infof("Node %v already in neighbor list", remoteNode)
 		return false, nil, nil
 	}
 
@@ -1,6 +1,8 @@
 package chord
 
 import (
+	"time"
+
 	"github.com/nknorg/nnet/node"
 	"github.com/nknorg/nnet/overlay/routing"
 )
@@ -25,6 +27,11 @@ func NewRelayRouting(localMsgChan chan<- *node.RemoteMessage, rxMsgChan <-chan *
 		return nil, err
 	}
 
+	err = r.SetLogger(chord.LocalNode.GetLogger())
+	if err != nil {
+		return nil, err
+	}
+
 	rr := &RelayRouting{
 		Routing: r,
 		chord:   chord,
@@ -47,7 +54,7 @@ func (rr *RelayRouting) GetNodeToRoute(remoteMsg *node.RemoteMessage) (*node.Loc
 
 	successors := rr.chord.successors.ToRemoteNodeList(true)
 	for i := 0; i < len(successors)-1; i++ {
-		if successors[i] == remoteMsg.RemoteNode {
+		if successors[i] == remoteMsg.RemoteNode || !remoteMsg.CanRouteTo(successors[i]) {
 			continue
 		}
 		if betweenLeftIncl(successors[i].Id, successors[i+1].Id, remoteMsg.Msg.DestId) {
@@ -65,18 +72,22 @@ func (rr *RelayRouting) GetNodeToRoute(remoteMsg *node.RemoteMessage) (*node.Loc
 			continue
 		}
 
-		nextHop := first
-		minRoundTripTime := first.GetRoundTripTime()
+		var nextHop *node.RemoteNode
+		var minRoundTripTime time.Duration
 		for _, rn := range finger.ToRemoteNodeList(true) {
-			if betweenIncl(rr.chord.LocalNode.Id, remoteMsg.Msg.DestId, rn.Id) {
+			if betweenIncl(rr.chord.LocalNode.Id, remoteMsg.Msg.DestId, rn.Id) && remoteMsg.CanRouteTo(rn) {
 				rtt := rn.GetRoundTripTime()
-				if minRoundTripTime == 0 || (rtt > 0 && rtt <= minRoundTripTime) {
+				if nextHop == nil || minRoundTripTime == 0 || (rtt > 0 && rtt <= minRoundTripTime) {
 					nextHop = rn
 					minRoundTripTime = rtt
 				}
 			}
 		}
 
+		if nextHop == nil {
+			continue
+		}
+
 		return nil, []*node.RemoteNode{nextHop}, nil
 	}
 
@@ -3,6 +3,7 @@ package overlay
 import (
 	"time"
 
+	"github.com/nknorg/nnet/middleware"
 	"github.com/nknorg/nnet/node"
 	"github.com/nknorg/nnet/overlay/routing"
 	"github.com/nknorg/nnet/protobuf"
@@ -16,6 +17,8 @@ type Network interface {
 	GetLocalNode() *node.LocalNode
 	GetRouters() []routing.Router
 	ApplyMiddleware(interface{}) error
+	AddMiddleware(interface{}) (middleware.ID, error)
+	RemoveMiddleware(middleware.ID) error
 	SendMessageAsync(msg *protobuf.Message, routingType protobuf.RoutingType) (success bool, err error)
 	SendMessageSync(msg *protobuf.Message, routingType protobuf.RoutingType, replyTimeout time.Duration) (reply *protobuf.Message, success bool, err error)
 }
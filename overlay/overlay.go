@@ -131,6 +131,9 @@ func (ovl *Overlay) SendMessageSync(msg *protobuf.Message, routingType protobuf.
 
 	select {
 	case replyMsg := <-replyChan:
+		if replyMsg == nil {
+			return nil, true, errors.New("Next hop has stopped before reply")
+		}
 		return replyMsg.Msg, true, nil
 	case <-time.After(replyTimeout):
 		return nil, true, errors.New("Wait for reply timeout")
@@ -25,6 +25,11 @@ func NewBroadcastRouting(localMsgChan chan<- *node.RemoteMessage, rxMsgChan <-ch
 		return nil, err
 	}
 
+	err = r.SetLogger(localNode.GetLogger())
+	if err != nil {
+		return nil, err
+	}
+
 	br := &BroadcastRouting{
 		Routing:   r,
 		localNode: localNode,
@@ -46,7 +51,7 @@ func (br *BroadcastRouting) GetNodeToRoute(remoteMsg *node.RemoteMessage) (*node
 	}
 
 	nonSenderNeighbors, err := br.localNode.GetNeighbors(func(rn *node.RemoteNode) bool {
-		return rn != remoteMsg.RemoteNode && !bytes.Equal(rn.Id, remoteMsg.Msg.SrcId)
+		return rn != remoteMsg.RemoteNode && !bytes.Equal(rn.Id, remoteMsg.Msg.SrcId) && remoteMsg.CanRouteTo(rn)
 	})
 	if err != nil {
 		return nil, nil, err
@@ -2,6 +2,7 @@ package routing
 
 import (
 	"errors"
+	"sync/atomic"
 
 	"github.com/nknorg/nnet/middleware"
 	"github.com/nknorg/nnet/node"
@@ -31,7 +32,8 @@ type RemoteMessageRouted struct {
 
 // RemoteMessageReceived is called when a new remote message is received, routed
 // to local node, and prepare to be handled by local node. Message with the same
-// message id will only trigger this middleware once. This can be used to
+// message id will only trigger this middleware once, except replies which are
+// not deduplicated by message id. This can be used to
 // process, modify or discard message. Returns the remote message to be used (or
 // nil to discard the message) and if we should proceed to the next middleware.
 type RemoteMessageReceived struct {
@@ -42,44 +44,49 @@ type RemoteMessageReceived struct {
 // middlewareStore stores the functions that will be called when certain events
 // are triggered or in some pipeline
 type middlewareStore struct {
-	remoteMessageArrived  []RemoteMessageArrived
-	remoteMessageRouted   []RemoteMessageRouted
-	remoteMessageReceived []RemoteMessageReceived
+	remoteMessageArrived  atomic.Value // []RemoteMessageArrived
+	remoteMessageRouted   atomic.Value // []RemoteMessageRouted
+	remoteMessageReceived atomic.Value // []RemoteMessageReceived
+
+	registry middleware.Registry
 }
 
 // newMiddlewareStore creates a middlewareStore
 func newMiddlewareStore() *middlewareStore {
-	return &middlewareStore{
-		remoteMessageArrived:  make([]RemoteMessageArrived, 0),
-		remoteMessageRouted:   make([]RemoteMessageRouted, 0),
-		remoteMessageReceived: make([]RemoteMessageReceived, 0),
-	}
+	store := &middlewareStore{}
+	store.remoteMessageArrived.Store(make([]RemoteMessageArrived, 0))
+	store.remoteMessageRouted.Store(make([]RemoteMessageRouted, 0))
+	store.remoteMessageReceived.Store(make([]RemoteMessageReceived, 0))
+	return store
 }
 
 // ApplyMiddleware add a middleware to the store
 func (store *middlewareStore) ApplyMiddleware(mw interface{}) error {
+	_, err := store.AddMiddleware(mw)
+	return err
+}
+
+// AddMiddleware is the same as ApplyMiddleware, but also returns the id of the
+// middleware, which can be used to remove it with RemoveMiddleware
+func (store *middlewareStore) AddMiddleware(mw interface{}) (middleware.ID, error) {
 	switch mw := mw.(type) {
 	case RemoteMessageArrived:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.remoteMessageArrived = append(store.remoteMessageArrived, mw)
-		middleware.Sort(store.remoteMessageArrived)
+		return store.registry.Add(&store.remoteMessageArrived, mw)
 	case RemoteMessageRouted:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.remoteMessageRouted = append(store.remoteMessageRouted, mw)
-		middleware.Sort(store.remoteMessageRouted)
+		return store.registry.Add(&store.remoteMessageRouted, mw)
 	case RemoteMessageReceived:
-		if mw.Func == nil {
-			return errors.New("middleware function is nil")
-		}
-		store.remoteMessageReceived = append(store.remoteMessageReceived, mw)
-		middleware.Sort(store.remoteMessageReceived)
+		return store.registry.Add(&store.remoteMessageReceived, mw)
 	default:
-		return errors.New("unknown middleware type")
+		return 0, errors.New("unknown middleware type")
 	}
+}
 
+// RemoveMiddleware removes the middleware with id returned by AddMiddleware.
+// It can be called at any time, e.g. when a plugin is torn down, but an event
+// already being triggered may still call the removed middleware.
+func (store *middlewareStore) RemoveMiddleware(id middleware.ID) error {
+	if !store.registry.Remove(id) {
+		return errors.New("middleware not found")
+	}
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 
 	"github.com/nknorg/nnet/common"
 	"github.com/nknorg/nnet/log"
+	"github.com/nknorg/nnet/middleware"
 	"github.com/nknorg/nnet/node"
 	"github.com/nknorg/nnet/util"
 )
@@ -15,6 +16,8 @@ type Router interface {
 	Start() error
 	Stop(error)
 	ApplyMiddleware(interface{}) error
+	AddMiddleware(interface{}) (middleware.ID, error)
+	RemoveMiddleware(middleware.ID) error
 	GetNodeToRoute(remoteMsg *node.RemoteMessage) (localNode *node.LocalNode, remoteNodes []*node.RemoteNode, err error)
 	SendMessage(router Router, remoteMsg *node.RemoteMessage, hasReply bool, replyTimeout time.Duration) (replyChan <-chan *node.RemoteMessage, success bool, err error)
 }
@@ -23,6 +26,7 @@ type Router interface {
 type Routing struct {
 	localMsgChan chan<- *node.RemoteMessage
 	rxMsgChan    <-chan *node.RemoteMessage
+	logger       log.Logger
 	*middlewareStore
 	common.LifeCycle
 }
@@ -32,11 +36,23 @@ func NewRouting(localMsgChan chan<- *node.RemoteMessage, rxMsgChan <-chan *node.
 	r := &Routing{
 		localMsgChan:    localMsgChan,
 		rxMsgChan:       rxMsgChan,
+		logger:          log.GlobalLogger(),
 		middlewareStore: newMiddlewareStore(),
 	}
 	return r, nil
 }
 
+// SetLogger sets the logger used by routing, which is the global logger by
+// default. It should be called before routing starts, usually with the logger
+// of the local node.
+func (r *Routing) SetLogger(logger log.Logger) error {
+	if logger == nil {
+		return errors.New("Logger is nil")
+	}
+	r.logger = logger
+	return nil
+}
+
 // Start starts the message handling process
 func (r *Routing) Start(router Router, numWorkers int) error {
 	r.StartOnce.Do(func() {
@@ -52,9 +68,9 @@ func (r *Routing) Start(router Router, numWorkers int) error {
 func (r *Routing) Stop(err error) {
 	r.StopOnce.Do(func() {
 		if err != nil {
-			log.Warningf("Routing stops because of error: %s", err)
+			r.logger.Warningf("Routing stops because of error: %s", err)
 		} else {
-			log.Infof("Routing stops")
+			r.logger.Infof("Routing stops")
 		}
 
 		r.LifeCycle.Stop()
@@ -74,7 +90,7 @@ func (r *Routing) SendMessage(router Router, remoteMsg *node.RemoteMessage, hasR
 		return nil, false, err
 	}
 
-	for _, mw := range r.middlewareStore.remoteMessageRouted {
+	for _, mw := range r.middlewareStore.remoteMessageRouted.Load().([]RemoteMessageRouted) {
 		remoteMsg, localNode, remoteNodes, shouldCallNextMiddleware = mw.Func(remoteMsg, localNode, remoteNodes)
 		if remoteMsg == nil || !shouldCallNextMiddleware {
 			break
@@ -127,7 +143,7 @@ func (r *Routing) SendMessage(router Router, remoteMsg *node.RemoteMessage, hasR
 func (r *Routing) sendMessageToLocalNode(remoteMsg *node.RemoteMessage, localNode *node.LocalNode) error {
 	var shouldCallNextMiddleware bool
 
-	for _, mw := range r.middlewareStore.remoteMessageReceived {
+	for _, mw := range r.middlewareStore.remoteMessageReceived.Load().([]RemoteMessageReceived) {
 		remoteMsg, shouldCallNextMiddleware = mw.Func(remoteMsg)
 		if remoteMsg == nil || !shouldCallNextMiddleware {
 			break
@@ -139,12 +155,23 @@ func (r *Routing) sendMessageToLocalNode(remoteMsg *node.RemoteMessage, localNod
 	}
 
 	if len(remoteMsg.Msg.ReplyToId) > 0 {
+		if !localNode.ValidateReply(remoteMsg.Msg) {
+			localNode.GetLogger().Warningf("Invalid reply to msg %x, discarding msg", remoteMsg.Msg.ReplyToId)
+			return nil
+		}
+
 		replyChan, ok := localNode.GetReplyChan(remoteMsg.Msg.ReplyToId)
 		if ok && replyChan != nil {
+			if !localNode.ClaimReply(remoteMsg.Msg.ReplyToId) {
+				localNode.GetLogger().Warningf("Duplicate reply to msg %x from %v, discarding msg", remoteMsg.Msg.ReplyToId, remoteMsg.RemoteNode)
+				return nil
+			}
+
 			select {
 			case replyChan <- remoteMsg:
 			default:
-				log.Warning("Reply chan unavailable or full, discarding msg")
+				localNode.UnclaimReply(remoteMsg.Msg.ReplyToId)
+				localNode.GetLogger().Warning("Reply chan unavailable or full, discarding msg")
 			}
 		}
 		return nil
@@ -153,7 +180,18 @@ func (r *Routing) sendMessageToLocalNode(remoteMsg *node.RemoteMessage, localNod
 	select {
 	case r.localMsgChan <- remoteMsg:
 	default:
-		log.Warning("Router local msg chan full, discarding msg")
+		action := localNode.GetBackpressureStrategy().OnRxFull(remoteMsg.RemoteNode, remoteMsg.Msg)
+		err := localNode.ApplyBackpressure(action, remoteMsg.RemoteNode, func(done <-chan struct{}) bool {
+			select {
+			case r.localMsgChan <- remoteMsg:
+				return true
+			case <-done:
+				return false
+			}
+		}, "Router local msg chan full")
+		if err != nil {
+			localNode.GetLogger().Warning(err)
+		}
 	}
 
 	return nil
@@ -167,13 +205,13 @@ func (r *Routing) handleMsg(router Router) {
 	var err error
 
 	for {
-		if r.IsStopped() {
+		select {
+		case remoteMsg = <-r.rxMsgChan:
+		case <-r.Done():
 			return
 		}
 
-		remoteMsg = <-r.rxMsgChan
-
-		for _, mw := range r.middlewareStore.remoteMessageArrived {
+		for _, mw := range r.middlewareStore.remoteMessageArrived.Load().([]RemoteMessageArrived) {
 			remoteMsg, shouldCallNextMiddleware = mw.Func(remoteMsg)
 			if remoteMsg == nil || !shouldCallNextMiddleware {
 				break
@@ -186,7 +224,7 @@ func (r *Routing) handleMsg(router Router) {
 
 		_, _, err = r.SendMessage(router, remoteMsg, false, 0)
 		if err != nil {
-			log.Warning(err)
+			r.logger.Warning(err)
 		}
 	}
 }
@@ -69,6 +69,12 @@ const (
 	FIND_SUCC_AND_PRED MessageType = 4
 	// Message that contains any bytes
 	BYTES MessageType = 5
+	// Application stream message
+	OPEN_STREAM MessageType = 6
+	// Notify remote node that we are draining
+	DRAIN MessageType = 7
+	// Acknowledge whether a message requesting ack is delivered
+	ACK MessageType = 8
 )
 
 var MessageType_name = map[int32]string{
@@ -78,6 +84,9 @@ var MessageType_name = map[int32]string{
 	3: "GET_SUCC_AND_PRED",
 	4: "FIND_SUCC_AND_PRED",
 	5: "BYTES",
+	6: "OPEN_STREAM",
+	7: "DRAIN",
+	8: "ACK",
 }
 var MessageType_value = map[string]int32{
 	"PING":               0,
@@ -86,6 +95,9 @@ var MessageType_value = map[string]int32{
 	"GET_SUCC_AND_PRED":  3,
 	"FIND_SUCC_AND_PRED": 4,
 	"BYTES":              5,
+	"OPEN_STREAM":        6,
+	"DRAIN":              7,
+	"ACK":                8,
 }
 
 func (MessageType) EnumDescriptor() ([]byte, []int) {
@@ -100,6 +112,7 @@ type Message struct {
 	ReplyToId   []byte      `protobuf:"bytes,5,opt,name=reply_to_id,json=replyToId,proto3" json:"reply_to_id,omitempty"`
 	SrcId       []byte      `protobuf:"bytes,6,opt,name=src_id,json=srcId,proto3" json:"src_id,omitempty"`
 	DestId      []byte      `protobuf:"bytes,7,opt,name=dest_id,json=destId,proto3" json:"dest_id,omitempty"`
+	RequestAck  bool        `protobuf:"varint,8,opt,name=request_ack,json=requestAck,proto3" json:"request_ack,omitempty"`
 }
 
 func (m *Message) Reset()      { *m = Message{} }
@@ -183,6 +196,13 @@ func (m *Message) GetDestId() []byte {
 	return nil
 }
 
+func (m *Message) GetRequestAck() bool {
+	if m != nil {
+		return m.RequestAck
+	}
+	return false
+}
+
 type Ping struct {
 }
 
@@ -290,6 +310,12 @@ var xxx_messageInfo_GetNode proto.InternalMessageInfo
 
 type GetNodeReply struct {
 	Node *Node `protobuf:"bytes,1,opt,name=node" json:"node,omitempty"`
+	// Compression codecs that the node can decompress
+	CompressionCodecs []string `protobuf:"bytes,2,rep,name=compression_codecs,json=compressionCodecs" json:"compression_codecs,omitempty"`
+	// Id of the compression dictionary of the node, empty if it has none
+	CompressionDictId []byte `protobuf:"bytes,3,opt,name=compression_dict_id,json=compressionDictId,proto3" json:"compression_dict_id,omitempty"`
+	// Msg codecs that the node can decode
+	MessageCodecs []string `protobuf:"bytes,4,rep,name=message_codecs,json=messageCodecs" json:"message_codecs,omitempty"`
 }
 
 func (m *GetNodeReply) Reset()      { *m = GetNodeReply{} }
@@ -331,6 +357,27 @@ func (m *GetNodeReply) GetNode() *Node {
 	return nil
 }
 
+func (m *GetNodeReply) GetCompressionCodecs() []string {
+	if m != nil {
+		return m.CompressionCodecs
+	}
+	return nil
+}
+
+func (m *GetNodeReply) GetCompressionDictId() []byte {
+	if m != nil {
+		return m.CompressionDictId
+	}
+	return nil
+}
+
+func (m *GetNodeReply) GetMessageCodecs() []string {
+	if m != nil {
+		return m.MessageCodecs
+	}
+	return nil
+}
+
 type Stop struct {
 }
 
@@ -621,6 +668,127 @@ func (m *Bytes) GetData() []byte {
 	return nil
 }
 
+type OpenStream struct {
+}
+
+func (m *OpenStream) Reset()      { *m = OpenStream{} }
+func (*OpenStream) ProtoMessage() {}
+func (*OpenStream) Descriptor() ([]byte, []int) {
+	return fileDescriptor_message_b201eaadc96a9d44, []int{11}
+}
+func (m *OpenStream) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *OpenStream) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_OpenStream.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *OpenStream) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_OpenStream.Merge(dst, src)
+}
+func (m *OpenStream) XXX_Size() int {
+	return m.Size()
+}
+func (m *OpenStream) XXX_DiscardUnknown() {
+	xxx_messageInfo_OpenStream.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_OpenStream proto.InternalMessageInfo
+
+type Drain struct {
+}
+
+func (m *Drain) Reset()      { *m = Drain{} }
+func (*Drain) ProtoMessage() {}
+func (*Drain) Descriptor() ([]byte, []int) {
+	return fileDescriptor_message_b201eaadc96a9d44, []int{12}
+}
+func (m *Drain) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Drain) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Drain.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *Drain) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Drain.Merge(dst, src)
+}
+func (m *Drain) XXX_Size() int {
+	return m.Size()
+}
+func (m *Drain) XXX_DiscardUnknown() {
+	xxx_messageInfo_Drain.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Drain proto.InternalMessageInfo
+
+type Ack struct {
+	MessageId []byte `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	Delivered bool   `protobuf:"varint,2,opt,name=delivered,proto3" json:"delivered,omitempty"`
+}
+
+func (m *Ack) Reset()      { *m = Ack{} }
+func (*Ack) ProtoMessage() {}
+func (*Ack) Descriptor() ([]byte, []int) {
+	return fileDescriptor_message_b201eaadc96a9d44, []int{13}
+}
+func (m *Ack) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Ack) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Ack.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (dst *Ack) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Ack.Merge(dst, src)
+}
+func (m *Ack) XXX_Size() int {
+	return m.Size()
+}
+func (m *Ack) XXX_DiscardUnknown() {
+	xxx_messageInfo_Ack.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Ack proto.InternalMessageInfo
+
+func (m *Ack) GetMessageId() []byte {
+	if m != nil {
+		return m.MessageId
+	}
+	return nil
+}
+
+func (m *Ack) GetDelivered() bool {
+	if m != nil {
+		return m.Delivered
+	}
+	return false
+}
+
 func init() {
 	proto.RegisterType((*Message)(nil), "protobuf.Message")
 	proto.RegisterType((*Ping)(nil), "protobuf.Ping")
@@ -633,6 +801,9 @@ func init() {
 	proto.RegisterType((*FindSuccAndPred)(nil), "protobuf.FindSuccAndPred")
 	proto.RegisterType((*FindSuccAndPredReply)(nil), "protobuf.FindSuccAndPredReply")
 	proto.RegisterType((*Bytes)(nil), "protobuf.Bytes")
+	proto.RegisterType((*OpenStream)(nil), "protobuf.OpenStream")
+	proto.RegisterType((*Drain)(nil), "protobuf.Drain")
+	proto.RegisterType((*Ack)(nil), "protobuf.Ack")
 	proto.RegisterEnum("protobuf.RoutingType", RoutingType_name, RoutingType_value)
 	proto.RegisterEnum("protobuf.MessageType", MessageType_name, MessageType_value)
 }
@@ -690,6 +861,9 @@ func (this *Message) Equal(that interface{}) bool {
 	if !bytes.Equal(this.DestId, that1.DestId) {
 		return false
 	}
+	if this.RequestAck != that1.RequestAck {
+		return false
+	}
 	return true
 }
 func (this *Ping) Equal(that interface{}) bool {
@@ -777,6 +951,25 @@ func (this *GetNodeReply) Equal(that interface{}) bool {
 	if !this.Node.Equal(that1.Node) {
 		return false
 	}
+	if len(this.CompressionCodecs) != len(that1.CompressionCodecs) {
+		return false
+	}
+	for i := range this.CompressionCodecs {
+		if this.CompressionCodecs[i] != that1.CompressionCodecs[i] {
+			return false
+		}
+	}
+	if !bytes.Equal(this.CompressionDictId, that1.CompressionDictId) {
+		return false
+	}
+	if len(this.MessageCodecs) != len(that1.MessageCodecs) {
+		return false
+	}
+	for i := range this.MessageCodecs {
+		if this.MessageCodecs[i] != that1.MessageCodecs[i] {
+			return false
+		}
+	}
 	return true
 }
 func (this *Stop) Equal(that interface{}) bool {
@@ -955,6 +1148,75 @@ func (this *Bytes) Equal(that interface{}) bool {
 	}
 	return true
 }
+func (this *OpenStream) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*OpenStream)
+	if !ok {
+		that2, ok := that.(OpenStream)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	return true
+}
+func (this *Drain) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*Drain)
+	if !ok {
+		that2, ok := that.(Drain)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	return true
+}
+func (this *Ack) Equal(that interface{}) bool {
+	if that == nil {
+		return this == nil
+	}
+
+	that1, ok := that.(*Ack)
+	if !ok {
+		that2, ok := that.(Ack)
+		if ok {
+			that1 = &that2
+		} else {
+			return false
+		}
+	}
+	if that1 == nil {
+		return this == nil
+	} else if this == nil {
+		return false
+	}
+	if !bytes.Equal(this.MessageId, that1.MessageId) {
+		return false
+	}
+	if this.Delivered != that1.Delivered {
+		return false
+	}
+	return true
+}
 func (this *Message) GoString() string {
 	if this == nil {
 		return "nil"
@@ -968,6 +1230,7 @@ func (this *Message) GoString() string {
 	s = append(s, "ReplyToId: "+fmt.Sprintf("%#v", this.ReplyToId)+",\n")
 	s = append(s, "SrcId: "+fmt.Sprintf("%#v", this.SrcId)+",\n")
 	s = append(s, "DestId: "+fmt.Sprintf("%#v", this.DestId)+",\n")
+	s = append(s, "RequestAck: "+fmt.Sprintf("%#v", this.RequestAck)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -1002,11 +1265,14 @@ func (this *GetNodeReply) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 5)
+	s := make([]string, 0, 8)
 	s = append(s, "&protobuf.GetNodeReply{")
 	if this.Node != nil {
 		s = append(s, "Node: "+fmt.Sprintf("%#v", this.Node)+",\n")
 	}
+	s = append(s, "CompressionCodecs: "+fmt.Sprintf("%#v", this.CompressionCodecs)+",\n")
+	s = append(s, "CompressionDictId: "+fmt.Sprintf("%#v", this.CompressionDictId)+",\n")
+	s = append(s, "MessageCodecs: "+fmt.Sprintf("%#v", this.MessageCodecs)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -1082,6 +1348,35 @@ func (this *Bytes) GoString() string {
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
+func (this *OpenStream) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 4)
+	s = append(s, "&protobuf.OpenStream{")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *Drain) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 4)
+	s = append(s, "&protobuf.Drain{")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
+func (this *Ack) GoString() string {
+	if this == nil {
+		return "nil"
+	}
+	s := make([]string, 0, 6)
+	s = append(s, "&protobuf.Ack{")
+	s = append(s, "MessageId: "+fmt.Sprintf("%#v", this.MessageId)+",\n")
+	s = append(s, "Delivered: "+fmt.Sprintf("%#v", this.Delivered)+",\n")
+	s = append(s, "}")
+	return strings.Join(s, "")
+}
 func valueToGoStringMessage(v interface{}, typ string) string {
 	rv := reflect.ValueOf(v)
 	if rv.IsNil() {
@@ -1145,6 +1440,16 @@ func (m *Message) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintMessage(dAtA, i, uint64(len(m.DestId)))
 		i += copy(dAtA[i:], m.DestId)
 	}
+	if m.RequestAck {
+		dAtA[i] = 0x40
+		i++
+		if m.RequestAck {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
 	return i, nil
 }
 
@@ -1227,6 +1532,42 @@ func (m *GetNodeReply) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i += n1
 	}
+	if len(m.CompressionCodecs) > 0 {
+		for _, s := range m.CompressionCodecs {
+			dAtA[i] = 0x12
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
+	if len(m.CompressionDictId) > 0 {
+		dAtA[i] = 0x1a
+		i++
+		i = encodeVarintMessage(dAtA, i, uint64(len(m.CompressionDictId)))
+		i += copy(dAtA[i:], m.CompressionDictId)
+	}
+	if len(m.MessageCodecs) > 0 {
+		for _, s := range m.MessageCodecs {
+			dAtA[i] = 0x22
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
 	return i, nil
 }
 
@@ -1418,52 +1759,123 @@ func (m *Bytes) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
-func encodeVarintMessage(dAtA []byte, offset int, v uint64) int {
-	for v >= 1<<7 {
-		dAtA[offset] = uint8(v&0x7f | 0x80)
-		v >>= 7
-		offset++
-	}
-	dAtA[offset] = uint8(v)
-	return offset + 1
-}
-func NewPopulatedMessage(r randyMessage, easy bool) *Message {
-	this := &Message{}
-	this.RoutingType = RoutingType([]int32{0, 1, 2, 3, 4}[r.Intn(5)])
-	this.MessageType = MessageType([]int32{0, 1, 2, 3, 4, 5}[r.Intn(6)])
-	v1 := r.Intn(100)
-	this.Message = make([]byte, v1)
-	for i := 0; i < v1; i++ {
-		this.Message[i] = byte(r.Intn(256))
-	}
-	v2 := r.Intn(100)
-	this.MessageId = make([]byte, v2)
-	for i := 0; i < v2; i++ {
-		this.MessageId[i] = byte(r.Intn(256))
-	}
-	v3 := r.Intn(100)
-	this.ReplyToId = make([]byte, v3)
-	for i := 0; i < v3; i++ {
-		this.ReplyToId[i] = byte(r.Intn(256))
-	}
-	v4 := r.Intn(100)
-	this.SrcId = make([]byte, v4)
-	for i := 0; i < v4; i++ {
-		this.SrcId[i] = byte(r.Intn(256))
-	}
-	v5 := r.Intn(100)
-	this.DestId = make([]byte, v5)
-	for i := 0; i < v5; i++ {
-		this.DestId[i] = byte(r.Intn(256))
-	}
-	if !easy && r.Intn(10) != 0 {
+func (m *OpenStream) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
 	}
-	return this
+	return dAtA[:n], nil
 }
 
-func NewPopulatedPing(r randyMessage, easy bool) *Ping {
-	this := &Ping{}
-	if !easy && r.Intn(10) != 0 {
+func (m *OpenStream) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	return i, nil
+}
+
+func (m *Drain) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Drain) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	return i, nil
+}
+
+func (m *Ack) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Ack) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.MessageId) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintMessage(dAtA, i, uint64(len(m.MessageId)))
+		i += copy(dAtA[i:], m.MessageId)
+	}
+	if m.Delivered {
+		dAtA[i] = 0x10
+		i++
+		if m.Delivered {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i++
+	}
+	return i, nil
+}
+
+func encodeVarintMessage(dAtA []byte, offset int, v uint64) int {
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return offset + 1
+}
+func NewPopulatedMessage(r randyMessage, easy bool) *Message {
+	this := &Message{}
+	this.RoutingType = RoutingType([]int32{0, 1, 2, 3, 4}[r.Intn(5)])
+	this.MessageType = MessageType([]int32{0, 1, 2, 3, 4, 5}[r.Intn(6)])
+	v1 := r.Intn(100)
+	this.Message = make([]byte, v1)
+	for i := 0; i < v1; i++ {
+		this.Message[i] = byte(r.Intn(256))
+	}
+	v2 := r.Intn(100)
+	this.MessageId = make([]byte, v2)
+	for i := 0; i < v2; i++ {
+		this.MessageId[i] = byte(r.Intn(256))
+	}
+	v3 := r.Intn(100)
+	this.ReplyToId = make([]byte, v3)
+	for i := 0; i < v3; i++ {
+		this.ReplyToId[i] = byte(r.Intn(256))
+	}
+	v4 := r.Intn(100)
+	this.SrcId = make([]byte, v4)
+	for i := 0; i < v4; i++ {
+		this.SrcId[i] = byte(r.Intn(256))
+	}
+	v5 := r.Intn(100)
+	this.DestId = make([]byte, v5)
+	for i := 0; i < v5; i++ {
+		this.DestId[i] = byte(r.Intn(256))
+	}
+	this.RequestAck = bool(bool(r.Intn(2) == 0))
+	if !easy && r.Intn(10) != 0 {
+	}
+	return this
+}
+
+func NewPopulatedPing(r randyMessage, easy bool) *Ping {
+	this := &Ping{}
+	if !easy && r.Intn(10) != 0 {
 	}
 	return this
 }
@@ -1487,6 +1899,21 @@ func NewPopulatedGetNodeReply(r randyMessage, easy bool) *GetNodeReply {
 	if r.Intn(10) != 0 {
 		this.Node = NewPopulatedNode(r, easy)
 	}
+	v6 := r.Intn(10)
+	this.CompressionCodecs = make([]string, v6)
+	for i := 0; i < v6; i++ {
+		this.CompressionCodecs[i] = string(randStringMessage(r))
+	}
+	v7 := r.Intn(100)
+	this.CompressionDictId = make([]byte, v7)
+	for i := 0; i < v7; i++ {
+		this.CompressionDictId[i] = byte(r.Intn(256))
+	}
+	v8 := r.Intn(10)
+	this.MessageCodecs = make([]string, v8)
+	for i := 0; i < v8; i++ {
+		this.MessageCodecs[i] = string(randStringMessage(r))
+	}
 	if !easy && r.Intn(10) != 0 {
 	}
 	return this
@@ -1511,16 +1938,16 @@ func NewPopulatedGetSuccAndPred(r randyMessage, easy bool) *GetSuccAndPred {
 func NewPopulatedGetSuccAndPredReply(r randyMessage, easy bool) *GetSuccAndPredReply {
 	this := &GetSuccAndPredReply{}
 	if r.Intn(10) != 0 {
-		v6 := r.Intn(5)
-		this.Successors = make([]*Node, v6)
-		for i := 0; i < v6; i++ {
+		v9 := r.Intn(5)
+		this.Successors = make([]*Node, v9)
+		for i := 0; i < v9; i++ {
 			this.Successors[i] = NewPopulatedNode(r, easy)
 		}
 	}
 	if r.Intn(10) != 0 {
-		v7 := r.Intn(5)
-		this.Predecessors = make([]*Node, v7)
-		for i := 0; i < v7; i++ {
+		v10 := r.Intn(5)
+		this.Predecessors = make([]*Node, v10)
+		for i := 0; i < v10; i++ {
 			this.Predecessors[i] = NewPopulatedNode(r, easy)
 		}
 	}
@@ -1531,9 +1958,9 @@ func NewPopulatedGetSuccAndPredReply(r randyMessage, easy bool) *GetSuccAndPredR
 
 func NewPopulatedFindSuccAndPred(r randyMessage, easy bool) *FindSuccAndPred {
 	this := &FindSuccAndPred{}
-	v8 := r.Intn(100)
-	this.Key = make([]byte, v8)
-	for i := 0; i < v8; i++ {
+	v11 := r.Intn(100)
+	this.Key = make([]byte, v11)
+	for i := 0; i < v11; i++ {
 		this.Key[i] = byte(r.Intn(256))
 	}
 	this.NumSucc = uint32(r.Uint32())
@@ -1546,16 +1973,16 @@ func NewPopulatedFindSuccAndPred(r randyMessage, easy bool) *FindSuccAndPred {
 func NewPopulatedFindSuccAndPredReply(r randyMessage, easy bool) *FindSuccAndPredReply {
 	this := &FindSuccAndPredReply{}
 	if r.Intn(10) != 0 {
-		v9 := r.Intn(5)
-		this.Successors = make([]*Node, v9)
-		for i := 0; i < v9; i++ {
+		v12 := r.Intn(5)
+		this.Successors = make([]*Node, v12)
+		for i := 0; i < v12; i++ {
 			this.Successors[i] = NewPopulatedNode(r, easy)
 		}
 	}
 	if r.Intn(10) != 0 {
-		v10 := r.Intn(5)
-		this.Predecessors = make([]*Node, v10)
-		for i := 0; i < v10; i++ {
+		v13 := r.Intn(5)
+		this.Predecessors = make([]*Node, v13)
+		for i := 0; i < v13; i++ {
 			this.Predecessors[i] = NewPopulatedNode(r, easy)
 		}
 	}
@@ -1566,9 +1993,9 @@ func NewPopulatedFindSuccAndPredReply(r randyMessage, easy bool) *FindSuccAndPre
 
 func NewPopulatedBytes(r randyMessage, easy bool) *Bytes {
 	this := &Bytes{}
-	v11 := r.Intn(100)
-	this.Data = make([]byte, v11)
-	for i := 0; i < v11; i++ {
+	v14 := r.Intn(100)
+	this.Data = make([]byte, v14)
+	for i := 0; i < v14; i++ {
 		this.Data[i] = byte(r.Intn(256))
 	}
 	if !easy && r.Intn(10) != 0 {
@@ -1576,6 +2003,33 @@ func NewPopulatedBytes(r randyMessage, easy bool) *Bytes {
 	return this
 }
 
+func NewPopulatedOpenStream(r randyMessage, easy bool) *OpenStream {
+	this := &OpenStream{}
+	if !easy && r.Intn(10) != 0 {
+	}
+	return this
+}
+
+func NewPopulatedDrain(r randyMessage, easy bool) *Drain {
+	this := &Drain{}
+	if !easy && r.Intn(10) != 0 {
+	}
+	return this
+}
+
+func NewPopulatedAck(r randyMessage, easy bool) *Ack {
+	this := &Ack{}
+	v15 := r.Intn(100)
+	this.MessageId = make([]byte, v15)
+	for i := 0; i < v15; i++ {
+		this.MessageId[i] = byte(r.Intn(256))
+	}
+	this.Delivered = bool(bool(r.Intn(2) == 0))
+	if !easy && r.Intn(10) != 0 {
+	}
+	return this
+}
+
 type randyMessage interface {
 	Float32() float32
 	Float64() float64
@@ -1595,9 +2049,9 @@ func randUTF8RuneMessage(r randyMessage) rune {
 	return rune(ru + 61)
 }
 func randStringMessage(r randyMessage) string {
-	v12 := r.Intn(100)
-	tmps := make([]rune, v12)
-	for i := 0; i < v12; i++ {
+	v16 := r.Intn(100)
+	tmps := make([]rune, v16)
+	for i := 0; i < v16; i++ {
 		tmps[i] = randUTF8RuneMessage(r)
 	}
 	return string(tmps)
@@ -1619,11 +2073,11 @@ func randFieldMessage(dAtA []byte, r randyMessage, fieldNumber int, wire int) []
 	switch wire {
 	case 0:
 		dAtA = encodeVarintPopulateMessage(dAtA, uint64(key))
-		v13 := r.Int63()
+		v17 := r.Int63()
 		if r.Intn(2) == 0 {
-			v13 *= -1
+			v17 *= -1
 		}
-		dAtA = encodeVarintPopulateMessage(dAtA, uint64(v13))
+		dAtA = encodeVarintPopulateMessage(dAtA, uint64(v17))
 	case 1:
 		dAtA = encodeVarintPopulateMessage(dAtA, uint64(key))
 		dAtA = append(dAtA, byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)))
@@ -1680,6 +2134,9 @@ func (m *Message) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovMessage(uint64(l))
 	}
+	if m.RequestAck {
+		n += 2
+	}
 	return n
 }
 
@@ -1720,6 +2177,22 @@ func (m *GetNodeReply) Size() (n int) {
 		l = m.Node.Size()
 		n += 1 + l + sovMessage(uint64(l))
 	}
+	if len(m.CompressionCodecs) > 0 {
+		for _, s := range m.CompressionCodecs {
+			l = len(s)
+			n += 1 + l + sovMessage(uint64(l))
+		}
+	}
+	l = len(m.CompressionDictId)
+	if l > 0 {
+		n += 1 + l + sovMessage(uint64(l))
+	}
+	if len(m.MessageCodecs) > 0 {
+		for _, s := range m.MessageCodecs {
+			l = len(s)
+			n += 1 + l + sovMessage(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -1821,6 +2294,40 @@ func (m *Bytes) Size() (n int) {
 	return n
 }
 
+func (m *OpenStream) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	return n
+}
+
+func (m *Drain) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	return n
+}
+
+func (m *Ack) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.MessageId)
+	if l > 0 {
+		n += 1 + l + sovMessage(uint64(l))
+	}
+	if m.Delivered {
+		n += 2
+	}
+	return n
+}
+
 func sovMessage(x uint64) (n int) {
 	for {
 		n++
@@ -1846,6 +2353,7 @@ func (this *Message) String() string {
 		`ReplyToId:` + fmt.Sprintf("%v", this.ReplyToId) + `,`,
 		`SrcId:` + fmt.Sprintf("%v", this.SrcId) + `,`,
 		`DestId:` + fmt.Sprintf("%v", this.DestId) + `,`,
+		`RequestAck:` + fmt.Sprintf("%v", this.RequestAck) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1883,6 +2391,9 @@ func (this *GetNodeReply) String() string {
 	}
 	s := strings.Join([]string{`&GetNodeReply{`,
 		`Node:` + strings.Replace(fmt.Sprintf("%v", this.Node), "Node", "Node", 1) + `,`,
+		`CompressionCodecs:` + fmt.Sprintf("%v", this.CompressionCodecs) + `,`,
+		`CompressionDictId:` + fmt.Sprintf("%v", this.CompressionDictId) + `,`,
+		`MessageCodecs:` + fmt.Sprintf("%v", this.MessageCodecs) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -1951,6 +2462,35 @@ func (this *Bytes) String() string {
 	}, "")
 	return s
 }
+func (this *OpenStream) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&OpenStream{`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *Drain) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&Drain{`,
+		`}`,
+	}, "")
+	return s
+}
+func (this *Ack) String() string {
+	if this == nil {
+		return "nil"
+	}
+	s := strings.Join([]string{`&Ack{`,
+		`MessageId:` + fmt.Sprintf("%v", this.MessageId) + `,`,
+		`Delivered:` + fmt.Sprintf("%v", this.Delivered) + `,`,
+		`}`,
+	}, "")
+	return s
+}
 func valueToStringMessage(v interface{}) string {
 	rv := reflect.ValueOf(v)
 	if rv.IsNil() {
@@ -2181,6 +2721,26 @@ func (m *Message) Unmarshal(dAtA []byte) error {
 				m.DestId = []byte{}
 			}
 			iNdEx = postIndex
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field RequestAck", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessage
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.RequestAck = bool(v != 0)
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMessage(dAtA[iNdEx:])
@@ -2414,6 +2974,95 @@ func (m *GetNodeReply) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CompressionCodecs", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessage
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMessage
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CompressionCodecs = append(m.CompressionCodecs, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CompressionDictId", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessage
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthMessage
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CompressionDictId = append(m.CompressionDictId[:0], dAtA[iNdEx:postIndex]...)
+			if m.CompressionDictId == nil {
+				m.CompressionDictId = []byte{}
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MessageCodecs", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessage
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMessage
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MessageCodecs = append(m.MessageCodecs, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMessage(dAtA[iNdEx:])
@@ -2997,6 +3646,207 @@ func (m *Bytes) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *OpenStream) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMessage
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: OpenStream: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: OpenStream: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMessage(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthMessage
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Drain) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMessage
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Drain: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Drain: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMessage(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthMessage
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Ack) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMessage
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Ack: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Ack: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MessageId", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessage
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthMessage
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MessageId = append(m.MessageId[:0], dAtA[iNdEx:postIndex]...)
+			if m.MessageId == nil {
+				m.MessageId = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Delivered", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMessage
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.Delivered = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMessage(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthMessage
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipMessage(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -3105,44 +3955,54 @@ var (
 func init() { proto.RegisterFile("protobuf/message.proto", fileDescriptor_message_b201eaadc96a9d44) }
 
 var fileDescriptor_message_b201eaadc96a9d44 = []byte{
-	// 617 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x53, 0x3d, 0x6f, 0xd3, 0x50,
-	0x14, 0xf5, 0x4b, 0x9c, 0xaf, 0x9b, 0x10, 0xcc, 0x2b, 0x2d, 0xa1, 0x88, 0xa7, 0xca, 0x53, 0x55,
-	0x89, 0x54, 0x0a, 0x0b, 0x03, 0x4b, 0x3e, 0xdc, 0x60, 0xa9, 0xa4, 0x91, 0xed, 0x0e, 0x9d, 0x4c,
-	0xeb, 0xf7, 0x08, 0x11, 0xc4, 0x2f, 0xf2, 0xc7, 0x10, 0x26, 0x26, 0x66, 0x7e, 0x06, 0x3f, 0x81,
-	0x9f, 0xc0, 0xd8, 0xb1, 0x23, 0x71, 0x17, 0xc6, 0x8e, 0x8c, 0xe8, 0x3d, 0xdb, 0x6d, 0x12, 0x95,
-	0x95, 0xc9, 0xf7, 0x9e, 0x73, 0xcf, 0xf1, 0x3d, 0x57, 0x36, 0xec, 0xcc, 0x03, 0x1e, 0xf1, 0x8b,
-	0xf8, 0xfd, 0xe1, 0x8c, 0x85, 0xe1, 0xf9, 0x84, 0xb5, 0x25, 0x80, 0xab, 0x39, 0xbe, 0xfb, 0x62,
-	0x32, 0x8d, 0x3e, 0xc4, 0x17, 0x6d, 0x8f, 0xcf, 0x0e, 0x27, 0x7c, 0xc2, 0x0f, 0x6f, 0x15, 0xa2,
-	0x93, 0x8d, 0xac, 0x52, 0xe1, 0xee, 0xd6, 0x2d, 0xed, 0x73, 0x9a, 0xb9, 0xe9, 0x5f, 0x0b, 0x50,
-	0x79, 0x9b, 0xfa, 0xe3, 0x57, 0xd0, 0x08, 0x78, 0x1c, 0x4d, 0xfd, 0x89, 0x1b, 0x2d, 0xe6, 0xac,
-	0x85, 0xf6, 0xd0, 0x7e, 0xb3, 0xb3, 0xdd, 0xce, 0x75, 0x6d, 0x2b, 0x65, 0x9d, 0xc5, 0x9c, 0x59,
-	0xf5, 0xe0, 0xae, 0x11, 0xca, 0x6c, 0xc9, 0x54, 0x59, 0xd8, 0x54, 0x66, 0xaf, 0x48, 0x95, 0xb3,
-	0xbb, 0x06, 0xb7, 0xa0, 0x92, 0xb5, 0xad, 0xe2, 0x1e, 0xda, 0x6f, 0x58, 0x79, 0x8b, 0x9f, 0x03,
-	0xe4, 0x9e, 0x53, 0xda, 0x52, 0x25, 0x59, 0xcb, 0x10, 0x93, 0x62, 0x02, 0xf5, 0x80, 0xcd, 0x3f,
-	0x2d, 0xdc, 0x88, 0x0b, 0xbe, 0x94, 0xf2, 0x12, 0x72, 0xb8, 0x49, 0xf1, 0x36, 0x94, 0xc3, 0xc0,
-	0x13, 0x54, 0x59, 0x52, 0xa5, 0x30, 0xf0, 0x4c, 0x8a, 0x9f, 0x40, 0x85, 0xb2, 0x30, 0x12, 0x78,
-	0x45, 0xe2, 0x65, 0xd1, 0x9a, 0x54, 0x2f, 0x83, 0x3a, 0x9e, 0xfa, 0x13, 0xbd, 0x0e, 0x35, 0xf1,
-	0xb4, 0x84, 0x91, 0x5e, 0x83, 0xca, 0x90, 0x45, 0x23, 0x4e, 0x99, 0xde, 0x81, 0x46, 0x56, 0x4a,
-	0x0a, 0xeb, 0xa0, 0x8a, 0x33, 0xca, 0x23, 0xd5, 0x3b, 0xcd, 0xbb, 0xa8, 0x72, 0x44, 0x72, 0xc2,
-	0xd3, 0x8e, 0xf8, 0x5c, 0x3f, 0x82, 0xe6, 0x90, 0x45, 0x76, 0xec, 0x79, 0x5d, 0x9f, 0x8e, 0x03,
-	0x46, 0xf1, 0x53, 0xa8, 0xfa, 0xf1, 0xcc, 0x0d, 0x63, 0xcf, 0x93, 0x0e, 0x0f, 0xac, 0x8a, 0x1f,
-	0xcf, 0xc4, 0x44, 0x4e, 0xcd, 0x03, 0x46, 0xe5, 0x1d, 0x53, 0x4a, 0xa8, 0xf4, 0x05, 0x6c, 0xad,
-	0xfb, 0xa4, 0xab, 0xb4, 0x01, 0x84, 0x11, 0x0b, 0x43, 0x1e, 0x84, 0x2d, 0xb4, 0x57, 0xbc, 0x67,
-	0xa1, 0x95, 0x09, 0xdc, 0x81, 0x86, 0x70, 0x67, 0xb9, 0xa2, 0x70, 0xaf, 0x62, 0x6d, 0x46, 0x3f,
-	0x83, 0x87, 0x47, 0x53, 0x9f, 0xae, 0x66, 0xd0, 0xa0, 0xf8, 0x91, 0x2d, 0xe4, 0xfa, 0x0d, 0x4b,
-	0x94, 0x6b, 0xa9, 0x0a, 0xff, 0x4e, 0x55, 0x5c, 0x4f, 0xf5, 0x19, 0x1e, 0x6f, 0x58, 0xff, 0xbf,
-	0x58, 0xcf, 0xa0, 0xd4, 0x5b, 0x44, 0x2c, 0xc4, 0x18, 0x54, 0x7a, 0x1e, 0x9d, 0x67, 0x69, 0x64,
-	0x7d, 0xf0, 0x0e, 0xea, 0x2b, 0x5f, 0x3c, 0x06, 0x28, 0x0f, 0x4c, 0xcb, 0xe8, 0x3b, 0x9a, 0x82,
-	0x6b, 0x50, 0xb2, 0x8c, 0xe3, 0xee, 0x99, 0x86, 0x30, 0x86, 0x66, 0xcf, 0x3a, 0xe9, 0x0e, 0xfa,
-	0x5d, 0xdb, 0x71, 0xc7, 0xa7, 0xf6, 0x1b, 0xad, 0xb0, 0x89, 0x1d, 0x1f, 0x6b, 0xc5, 0x75, 0xcc,
-	0xb1, 0x0c, 0x43, 0x53, 0x0f, 0xa6, 0x50, 0x5f, 0xf9, 0x33, 0x70, 0x15, 0xd4, 0xb1, 0x39, 0x1a,
-	0x6a, 0x0a, 0x6e, 0x40, 0x75, 0x68, 0x38, 0xee, 0xe8, 0x64, 0x60, 0x68, 0x48, 0xe0, 0xb6, 0x73,
-	0x32, 0xd6, 0x0a, 0x78, 0x1b, 0x1e, 0x09, 0xdc, 0x3e, 0xed, 0xf7, 0xdd, 0xee, 0x68, 0xe0, 0x8e,
-	0x2d, 0x63, 0xa0, 0x15, 0xf1, 0x0e, 0xe0, 0x23, 0x73, 0x34, 0xd8, 0xc0, 0x55, 0xb1, 0x66, 0xef,
-	0xcc, 0x31, 0x6c, 0xad, 0xd4, 0x7b, 0x7d, 0xb9, 0x24, 0xca, 0xd5, 0x92, 0x28, 0x37, 0x4b, 0x82,
-	0xfe, 0x2c, 0x09, 0xfa, 0x92, 0x10, 0xf4, 0x3d, 0x21, 0xe8, 0x47, 0x42, 0xd0, 0xcf, 0x84, 0xa0,
-	0xcb, 0x84, 0xa0, 0x5f, 0x09, 0x41, 0xbf, 0x13, 0xa2, 0xdc, 0x24, 0x04, 0x7d, 0xbb, 0x26, 0xca,
-	0xe5, 0x35, 0x51, 0xae, 0xae, 0x89, 0x72, 0x51, 0x96, 0x47, 0x7c, 0xf9, 0x37, 0x00, 0x00, 0xff,
-	0xff, 0xc6, 0x6c, 0x4c, 0x39, 0x95, 0x04, 0x00, 0x00,
+	// 775 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x54, 0xcb, 0x8e, 0xe3, 0x44,
+	0x14, 0x4d, 0xc5, 0x49, 0x9c, 0xdc, 0x64, 0x32, 0x9e, 0x6a, 0x7a, 0x08, 0x03, 0x14, 0x91, 0x25,
+	0xa4, 0xd6, 0x48, 0x93, 0x96, 0x9a, 0x0d, 0x0b, 0x36, 0x4e, 0xec, 0x6e, 0x22, 0x7a, 0x92, 0xa8,
+	0xec, 0x59, 0xf4, 0xca, 0xa4, 0x5d, 0x45, 0xb0, 0x7a, 0x62, 0x1b, 0x3f, 0x90, 0xc2, 0x8a, 0x2f,
+	0x40, 0xfc, 0x05, 0x7c, 0xc2, 0x7c, 0x02, 0xcb, 0x5e, 0xce, 0x92, 0x76, 0x6f, 0x58, 0xce, 0x92,
+	0x25, 0xaa, 0xb2, 0xdd, 0x79, 0x68, 0xd8, 0xce, 0x2a, 0xf7, 0x9e, 0x73, 0xcf, 0x71, 0x9d, 0x5b,
+	0xaa, 0xc0, 0xd3, 0x28, 0x0e, 0xd3, 0xf0, 0x3a, 0xfb, 0xe1, 0x74, 0xcd, 0x93, 0x64, 0xb9, 0xe2,
+	0x23, 0x09, 0xe0, 0x76, 0x85, 0x3f, 0x7b, 0xb1, 0xf2, 0xd3, 0x1f, 0xb3, 0xeb, 0x91, 0x17, 0xae,
+	0x4f, 0x57, 0xe1, 0x2a, 0x3c, 0x7d, 0x50, 0x88, 0x4e, 0x36, 0xb2, 0x2a, 0x84, 0xcf, 0x8e, 0x1e,
+	0xe8, 0x20, 0x64, 0xa5, 0x9b, 0xfe, 0x47, 0x1d, 0xd4, 0x97, 0x85, 0x3f, 0xfe, 0x1a, 0x7a, 0x71,
+	0x98, 0xa5, 0x7e, 0xb0, 0x72, 0xd3, 0x4d, 0xc4, 0x07, 0x68, 0x88, 0x4e, 0xfa, 0x67, 0xc7, 0xa3,
+	0x4a, 0x37, 0xa2, 0x05, 0xeb, 0x6c, 0x22, 0x4e, 0xbb, 0xf1, 0xb6, 0x11, 0xca, 0xf2, 0x90, 0x85,
+	0xb2, 0x7e, 0xa8, 0x2c, 0x3f, 0x51, 0x28, 0xd7, 0xdb, 0x06, 0x0f, 0x40, 0x2d, 0xdb, 0x81, 0x32,
+	0x44, 0x27, 0x3d, 0x5a, 0xb5, 0xf8, 0x73, 0x80, 0xca, 0xd3, 0x67, 0x83, 0x86, 0x24, 0x3b, 0x25,
+	0x32, 0x65, 0x98, 0x40, 0x37, 0xe6, 0xd1, 0xeb, 0x8d, 0x9b, 0x86, 0x82, 0x6f, 0x16, 0xbc, 0x84,
+	0x9c, 0x70, 0xca, 0xf0, 0x31, 0xb4, 0x92, 0xd8, 0x13, 0x54, 0x4b, 0x52, 0xcd, 0x24, 0xf6, 0xa6,
+	0x0c, 0x7f, 0x0c, 0x2a, 0xe3, 0x49, 0x2a, 0x70, 0x55, 0xe2, 0x2d, 0xd1, 0x4e, 0x19, 0xfe, 0x42,
+	0xf8, 0xfd, 0x94, 0x09, 0x6e, 0xe9, 0xdd, 0x0c, 0xda, 0x43, 0x74, 0xd2, 0xa6, 0x50, 0x42, 0x86,
+	0x77, 0xa3, 0xb7, 0xa0, 0xb1, 0xf0, 0x83, 0x95, 0xde, 0x85, 0x8e, 0xf8, 0xa5, 0xe2, 0x4b, 0x7a,
+	0x07, 0xd4, 0x0b, 0x9e, 0xce, 0x42, 0xc6, 0xf5, 0x37, 0x08, 0x7a, 0x65, 0x2d, 0x39, 0xac, 0x43,
+	0x43, 0x2c, 0x5a, 0xae, 0xb1, 0x7b, 0xd6, 0xdf, 0x2e, 0x43, 0x8e, 0x48, 0x0e, 0xbf, 0x00, 0xec,
+	0x85, 0xeb, 0x28, 0xe6, 0x49, 0xe2, 0x87, 0x81, 0xeb, 0x85, 0x8c, 0x7b, 0xc9, 0xa0, 0x3e, 0x54,
+	0x4e, 0x3a, 0xf4, 0xc9, 0x0e, 0x33, 0x91, 0x04, 0x1e, 0xc1, 0xd1, 0xee, 0x38, 0xf3, 0x3d, 0x99,
+	0xa4, 0xd8, 0xdc, 0xee, 0xbc, 0xe9, 0x7b, 0x22, 0xd4, 0x97, 0xd0, 0xaf, 0x76, 0x58, 0x5a, 0x37,
+	0xa4, 0xf5, 0xa3, 0x12, 0x2d, 0x6c, 0x45, 0x34, 0x3b, 0x0d, 0x23, 0xfd, 0x1c, 0xfa, 0x17, 0x3c,
+	0xb5, 0x33, 0xcf, 0x33, 0x02, 0xb6, 0x88, 0x39, 0xc3, 0x9f, 0x40, 0x3b, 0xc8, 0xd6, 0x6e, 0x92,
+	0x79, 0x9e, 0xcc, 0xf1, 0x88, 0xaa, 0x41, 0xb6, 0x16, 0x13, 0x15, 0x15, 0xc5, 0x9c, 0x0d, 0xea,
+	0x0f, 0x94, 0x50, 0xe9, 0x1b, 0x38, 0xda, 0xf7, 0x29, 0x16, 0x32, 0x02, 0x10, 0x46, 0x3c, 0x49,
+	0xc2, 0x38, 0x19, 0xa0, 0xa1, 0xf2, 0x9e, 0xb5, 0xec, 0x4c, 0xe0, 0x33, 0xe8, 0x09, 0x77, 0x5e,
+	0x29, 0xea, 0xef, 0x55, 0xec, 0xcd, 0xe8, 0x57, 0xf0, 0xf8, 0xdc, 0x0f, 0xd8, 0x6e, 0x06, 0x0d,
+	0x94, 0x1b, 0xbe, 0x91, 0xc7, 0xef, 0x51, 0x51, 0xee, 0xa5, 0xaa, 0xff, 0x7f, 0x2a, 0x65, 0x3f,
+	0xd5, 0x2f, 0xf0, 0xd1, 0x81, 0xf5, 0x87, 0x8b, 0xf5, 0x29, 0x34, 0xc7, 0x9b, 0x94, 0x27, 0x18,
+	0x43, 0x83, 0x2d, 0xd3, 0x65, 0x99, 0x46, 0xd6, 0x7a, 0x0f, 0x60, 0x1e, 0xf1, 0xc0, 0x4e, 0x63,
+	0xbe, 0x5c, 0xeb, 0x2a, 0x34, 0xcd, 0x78, 0xe9, 0x07, 0xfa, 0x18, 0x14, 0xc3, 0xbb, 0x39, 0x78,
+	0x47, 0xe8, 0xf0, 0x1d, 0x7d, 0x06, 0x1d, 0xc6, 0x5f, 0xfb, 0x3f, 0xf3, 0xea, 0x1e, 0xdb, 0x74,
+	0x0b, 0x3c, 0xff, 0x1e, 0xba, 0x3b, 0x8f, 0x1e, 0x03, 0xb4, 0xcc, 0x29, 0xb5, 0x26, 0x8e, 0x56,
+	0xc3, 0x1d, 0x68, 0x52, 0xeb, 0xd2, 0xb8, 0xd2, 0x10, 0xc6, 0xd0, 0x1f, 0xd3, 0xb9, 0x61, 0x4e,
+	0x0c, 0xdb, 0x71, 0x17, 0xaf, 0xec, 0x6f, 0xb5, 0xfa, 0x21, 0x76, 0x79, 0xa9, 0x29, 0xfb, 0x98,
+	0x43, 0x2d, 0x4b, 0x6b, 0x3c, 0xff, 0x0d, 0x41, 0x77, 0xe7, 0xdf, 0x01, 0xb7, 0xa1, 0xb1, 0x98,
+	0xce, 0x2e, 0xb4, 0x1a, 0xee, 0x41, 0xfb, 0xc2, 0x72, 0xdc, 0xd9, 0xdc, 0xb4, 0x34, 0x24, 0x70,
+	0xdb, 0x99, 0x2f, 0xb4, 0x3a, 0x3e, 0x86, 0x27, 0x02, 0xb7, 0x5f, 0x4d, 0x26, 0xae, 0x31, 0x33,
+	0xdd, 0x05, 0xb5, 0x4c, 0x4d, 0xc1, 0x4f, 0x01, 0x9f, 0x4f, 0x67, 0xe6, 0x01, 0xde, 0x10, 0xe7,
+	0x1c, 0x5f, 0x39, 0x96, 0xad, 0x35, 0xf1, 0x63, 0xe8, 0xce, 0x17, 0xd6, 0xcc, 0xb5, 0x1d, 0x6a,
+	0x19, 0x2f, 0xb5, 0x96, 0xe0, 0x4c, 0x6a, 0x4c, 0x67, 0x9a, 0x8a, 0x55, 0x50, 0x8c, 0xc9, 0x77,
+	0x5a, 0x7b, 0xfc, 0xcd, 0xed, 0x1d, 0xa9, 0xbd, 0xbd, 0x23, 0xb5, 0x77, 0x77, 0x04, 0xfd, 0x7b,
+	0x47, 0xd0, 0xaf, 0x39, 0x41, 0x7f, 0xe6, 0x04, 0xbd, 0xc9, 0x09, 0xfa, 0x2b, 0x27, 0xe8, 0x36,
+	0x27, 0xe8, 0xef, 0x9c, 0xa0, 0x7f, 0x72, 0x52, 0x7b, 0x97, 0x13, 0xf4, 0xfb, 0x3d, 0xa9, 0xdd,
+	0xde, 0x93, 0xda, 0xdb, 0x7b, 0x52, 0xbb, 0x6e, 0xc9, 0x5b, 0xfc, 0xea, 0xbf, 0x01, 0x00, 0xd0,
+	0x50, 0x2b, 0x8f, 0xbe, 0x05, 0x00, 0x00,
 }
@@ -30,6 +30,8 @@ type Node struct {
 	Id   []byte `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 	Addr string `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
 	Data []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// Additional addresses of node, tried in order if addr is unreachable
+	Addrs []string `protobuf:"bytes,4,rep,name=addrs" json:"addrs,omitempty"`
 }
 
 func (m *Node) Reset()      { *m = Node{} }
@@ -85,6 +87,13 @@ func (m *Node) GetData() []byte {
 	return nil
 }
 
+func (m *Node) GetAddrs() []string {
+	if m != nil {
+		return m.Addrs
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Node)(nil), "protobuf.Node")
 }
@@ -116,17 +125,26 @@ func (this *Node) Equal(that interface{}) bool {
 	if !bytes.Equal(this.Data, that1.Data) {
 		return false
 	}
+	if len(this.Addrs) != len(that1.Addrs) {
+		return false
+	}
+	for i := range this.Addrs {
+		if this.Addrs[i] != that1.Addrs[i] {
+			return false
+		}
+	}
 	return true
 }
 func (this *Node) GoString() string {
 	if this == nil {
 		return "nil"
 	}
-	s := make([]string, 0, 7)
+	s := make([]string, 0, 8)
 	s = append(s, "&protobuf.Node{")
 	s = append(s, "Id: "+fmt.Sprintf("%#v", this.Id)+",\n")
 	s = append(s, "Addr: "+fmt.Sprintf("%#v", this.Addr)+",\n")
 	s = append(s, "Data: "+fmt.Sprintf("%#v", this.Data)+",\n")
+	s = append(s, "Addrs: "+fmt.Sprintf("%#v", this.Addrs)+",\n")
 	s = append(s, "}")
 	return strings.Join(s, "")
 }
@@ -171,6 +189,21 @@ func (m *Node) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintNode(dAtA, i, uint64(len(m.Data)))
 		i += copy(dAtA[i:], m.Data)
 	}
+	if len(m.Addrs) > 0 {
+		for _, s := range m.Addrs {
+			dAtA[i] = 0x22
+			i++
+			l = len(s)
+			for l >= 1<<7 {
+				dAtA[i] = uint8(uint64(l)&0x7f | 0x80)
+				l >>= 7
+				i++
+			}
+			dAtA[i] = uint8(l)
+			i++
+			i += copy(dAtA[i:], s)
+		}
+	}
 	return i, nil
 }
 
@@ -196,6 +229,11 @@ func NewPopulatedNode(r randyNode, easy bool) *Node {
 	for i := 0; i < v2; i++ {
 		this.Data[i] = byte(r.Intn(256))
 	}
+	v3 := r.Intn(10)
+	this.Addrs = make([]string, v3)
+	for i := 0; i < v3; i++ {
+		this.Addrs[i] = string(randStringNode(r))
+	}
 	if !easy && r.Intn(10) != 0 {
 	}
 	return this
@@ -220,9 +258,9 @@ func randUTF8RuneNode(r randyNode) rune {
 	return rune(ru + 61)
 }
 func randStringNode(r randyNode) string {
-	v3 := r.Intn(100)
-	tmps := make([]rune, v3)
-	for i := 0; i < v3; i++ {
+	v4 := r.Intn(100)
+	tmps := make([]rune, v4)
+	for i := 0; i < v4; i++ {
 		tmps[i] = randUTF8RuneNode(r)
 	}
 	return string(tmps)
@@ -244,11 +282,11 @@ func randFieldNode(dAtA []byte, r randyNode, fieldNumber int, wire int) []byte {
 	switch wire {
 	case 0:
 		dAtA = encodeVarintPopulateNode(dAtA, uint64(key))
-		v4 := r.Int63()
+		v5 := r.Int63()
 		if r.Intn(2) == 0 {
-			v4 *= -1
+			v5 *= -1
 		}
-		dAtA = encodeVarintPopulateNode(dAtA, uint64(v4))
+		dAtA = encodeVarintPopulateNode(dAtA, uint64(v5))
 	case 1:
 		dAtA = encodeVarintPopulateNode(dAtA, uint64(key))
 		dAtA = append(dAtA, byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)))
@@ -291,6 +329,12 @@ func (m *Node) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovNode(uint64(l))
 	}
+	if len(m.Addrs) > 0 {
+		for _, s := range m.Addrs {
+			l = len(s)
+			n += 1 + l + sovNode(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -315,6 +359,7 @@ func (this *Node) String() string {
 		`Id:` + fmt.Sprintf("%v", this.Id) + `,`,
 		`Addr:` + fmt.Sprintf("%v", this.Addr) + `,`,
 		`Data:` + fmt.Sprintf("%v", this.Data) + `,`,
+		`Addrs:` + fmt.Sprintf("%v", this.Addrs) + `,`,
 		`}`,
 	}, "")
 	return s
@@ -447,6 +492,35 @@ func (m *Node) Unmarshal(dAtA []byte) error {
 				m.Data = []byte{}
 			}
 			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Addrs", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowNode
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthNode
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Addrs = append(m.Addrs, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipNode(dAtA[iNdEx:])
@@ -576,18 +650,19 @@ var (
 func init() { proto.RegisterFile("protobuf/node.proto", fileDescriptor_node_5ac078e24f8773e1) }
 
 var fileDescriptor_node_5ac078e24f8773e1 = []byte{
-	// 196 bytes of a gzipped FileDescriptorProto
+	// 209 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x12, 0x2e, 0x28, 0xca, 0x2f,
 	0xc9, 0x4f, 0x2a, 0x4d, 0xd3, 0xcf, 0xcb, 0x4f, 0x49, 0xd5, 0x03, 0xf3, 0x84, 0x38, 0x60, 0x82,
 	0x52, 0xba, 0xe9, 0x99, 0x25, 0x19, 0xa5, 0x49, 0x7a, 0xc9, 0xf9, 0xb9, 0xfa, 0xe9, 0xf9, 0xe9,
-	0xf9, 0xfa, 0x70, 0xe5, 0x20, 0x1e, 0x98, 0x03, 0x66, 0x41, 0x34, 0x2a, 0xd9, 0x71, 0xb1, 0xf8,
+	0xf9, 0xfa, 0x70, 0xe5, 0x20, 0x1e, 0x98, 0x03, 0x66, 0x41, 0x34, 0x2a, 0x85, 0x70, 0xb1, 0xf8,
 	0xe5, 0xa7, 0xa4, 0x0a, 0xf1, 0x71, 0x31, 0x65, 0xa6, 0x48, 0x30, 0x2a, 0x30, 0x6a, 0xf0, 0x04,
 	0x31, 0x65, 0xa6, 0x08, 0x09, 0x71, 0xb1, 0x24, 0xa6, 0xa4, 0x14, 0x49, 0x30, 0x29, 0x30, 0x6a,
-	0x70, 0x06, 0x81, 0xd9, 0x20, 0xb1, 0x94, 0xc4, 0x92, 0x44, 0x09, 0x66, 0xb0, 0x2a, 0x30, 0xdb,
-	0xc9, 0xe6, 0xc2, 0x43, 0x39, 0x86, 0x1b, 0x0f, 0xe5, 0x18, 0x3e, 0x3c, 0x94, 0x63, 0xfc, 0xf1,
-	0x50, 0x8e, 0xb1, 0xe1, 0x91, 0x1c, 0xe3, 0x8a, 0x47, 0x72, 0x8c, 0x3b, 0x1e, 0xc9, 0x31, 0x9e,
-	0x78, 0x24, 0xc7, 0x78, 0xe1, 0x91, 0x1c, 0xe3, 0x83, 0x47, 0x72, 0x8c, 0x2f, 0x1e, 0xc9, 0x31,
-	0x7c, 0x78, 0x24, 0xc7, 0x38, 0xe1, 0xb1, 0x1c, 0xc3, 0x85, 0xc7, 0x72, 0x0c, 0x37, 0x1e, 0xcb,
-	0x31, 0x24, 0xb1, 0x81, 0x1d, 0x61, 0x0c, 0x08, 0x00, 0x00, 0xff, 0xff, 0xdd, 0x89, 0xa1, 0xd7,
-	0xd4, 0x00, 0x00, 0x00,
+	0x70, 0x06, 0x81, 0xd9, 0x20, 0xb1, 0x94, 0xc4, 0x92, 0x44, 0x09, 0x66, 0xb0, 0x2a, 0x30, 0x5b,
+	0x48, 0x84, 0x8b, 0x15, 0x24, 0x57, 0x2c, 0xc1, 0xa2, 0xc0, 0xac, 0xc1, 0x19, 0x04, 0xe1, 0x38,
+	0xd9, 0x5c, 0x78, 0x28, 0xc7, 0x70, 0xe3, 0xa1, 0x1c, 0xc3, 0x87, 0x87, 0x72, 0x8c, 0x3f, 0x1e,
+	0xca, 0x31, 0x36, 0x3c, 0x92, 0x63, 0x5c, 0xf1, 0x48, 0x8e, 0x71, 0xc7, 0x23, 0x39, 0xc6, 0x13,
+	0x8f, 0xe4, 0x18, 0x2f, 0x3c, 0x92, 0x63, 0x7c, 0xf0, 0x48, 0x8e, 0xf1, 0xc5, 0x23, 0x39, 0x86,
+	0x0f, 0x8f, 0xe4, 0x18, 0x27, 0x3c, 0x96, 0x63, 0xb8, 0xf0, 0x58, 0x8e, 0xe1, 0xc6, 0x63, 0x39,
+	0x86, 0x24, 0x36, 0xb0, 0xd3, 0x8c, 0x01, 0x03, 0x00, 0x81, 0x28, 0x3d, 0x1a, 0xea, 0x00, 0x00,
+	0x00,
 }
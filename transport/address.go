@@ -5,9 +5,13 @@ import (
 	"net"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// Protocol of address without scheme
+const defaultProtocol = "tcp"
+
 // Address is a URI for a node
 type Address struct {
 	Transport Transport
@@ -31,8 +35,14 @@ func NewAddress(protocol, host string, port uint16) (*Address, error) {
 	return addr, nil
 }
 
-// Parse parses a raw addr string into an Address struct
+// Parse parses a raw addr string into an Address struct. Addr is a URI whose
+// scheme is the transport protocol, e.g. tcp://127.0.0.1:30001. Plain
+// host:port without scheme is treated as tcp.
 func Parse(rawAddr string) (*Address, error) {
+	if !strings.Contains(rawAddr, "://") {
+		rawAddr = defaultProtocol + "://" + rawAddr
+	}
+
 	u, err := url.Parse(rawAddr)
 	if err != nil {
 		return nil, err
@@ -63,12 +73,12 @@ func Parse(rawAddr string) (*Address, error) {
 }
 
 func (addr *Address) String() string {
-	return fmt.Sprintf("%s://%s:%d", addr.Transport, addr.Host, addr.Port)
+	return fmt.Sprintf("%s://%s", addr.Transport, addr.ConnRemoteAddr())
 }
 
 // ConnRemoteAddr returns the remote address string that transport can dial
 func (addr *Address) ConnRemoteAddr() string {
-	return fmt.Sprintf("%s:%d", addr.Host, addr.Port)
+	return net.JoinHostPort(addr.Host, strconv.Itoa(int(addr.Port)))
 }
 
 // Dial dials the remote address using local transport
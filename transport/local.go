@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// localConnHost is the host of the addresses of conns of local transports
+// (e.g. unix socket, in-memory pipe), which do not have host:port addresses
+const localConnHost = "localhost"
+
+// nextLocalConnPort is used to give each dialed or accepted conn of local
+// transports a distinct port, so that conns can be told apart by address
+var nextLocalConnPort uint32
+
+// localAddr is the host:port style address of a conn or listener of a local
+// transport
+type localAddr struct {
+	network string
+	port    uint32
+}
+
+func (addr *localAddr) Network() string {
+	return addr.network
+}
+
+func (addr *localAddr) String() string {
+	return net.JoinHostPort(localConnHost, strconv.FormatUint(uint64(addr.port), 10))
+}
+
+// newLocalConnAddr returns a new address with a distinct port for one side of
+// a conn of a local transport
+func newLocalConnAddr(network string) *localAddr {
+	return &localAddr{network: network, port: atomic.AddUint32(&nextLocalConnPort, 1)}
+}
+
+// localConn is a conn of a local transport with host:port style addresses,
+// which are needed by local node to identify conns and remote nodes
+type localConn struct {
+	net.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (conn *localConn) LocalAddr() net.Addr {
+	return conn.localAddr
+}
+
+func (conn *localConn) RemoteAddr() net.Addr {
+	return conn.remoteAddr
+}
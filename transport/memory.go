@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memListeners stores the in-memory listeners in current process by port
+var (
+	memListeners     = make(map[uint16]*memListener)
+	memListenersLock sync.Mutex
+)
+
+// MemoryTransport is the transport layer based on in-memory pipe. Nodes using
+// it can only connect to nodes in the same process, without opening any
+// socket, which is useful for tests and simulations.
+type MemoryTransport struct{}
+
+// NewMemoryTransport creates a new in-memory transport layer
+func NewMemoryTransport() *MemoryTransport {
+	t := &MemoryTransport{}
+	return t
+}
+
+// Dial connects to the in-memory listener on the port of addr
+func (t *MemoryTransport) Dial(addr string, dialTimeout time.Duration) (net.Conn, error) {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	memListenersLock.Lock()
+	listener, ok := memListeners[uint16(port)]
+	memListenersLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("No in-memory listener on port %d", port)
+	}
+
+	return listener.dial(dialTimeout)
+}
+
+// Listen listens for in-memory conns to port. If port is 0, an unused port is
+// chosen.
+func (t *MemoryTransport) Listen(port uint16) (net.Listener, error) {
+	memListenersLock.Lock()
+	defer memListenersLock.Unlock()
+
+	if port == 0 {
+		for p := uint16(1); p < math.MaxUint16; p++ {
+			if _, ok := memListeners[p]; !ok {
+				port = p
+				break
+			}
+		}
+		if port == 0 {
+			return nil, errors.New("No unused in-memory port")
+		}
+	}
+
+	if _, ok := memListeners[port]; ok {
+		return nil, fmt.Errorf("In-memory port %d is already in use", port)
+	}
+
+	listener := &memListener{
+		addr:      &localAddr{network: t.GetNetwork(), port: uint32(port)},
+		port:      port,
+		connChan:  make(chan net.Conn),
+		closeChan: make(chan struct{}),
+	}
+	memListeners[port] = listener
+
+	return listener, nil
+}
+
+// GetNetwork returns the network used (tcp or udp)
+func (t *MemoryTransport) GetNetwork() string {
+	return "mem"
+}
+
+func (t *MemoryTransport) String() string {
+	return "mem"
+}
+
+// memListener is an in-memory listener
+type memListener struct {
+	addr      *localAddr
+	port      uint16
+	connChan  chan net.Conn
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+// dial creates a pipe and passes one end of it to Accept, waiting for at most
+// dialTimeout (0 means no timeout)
+func (l *memListener) dial(dialTimeout time.Duration) (net.Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	clientAddr := newLocalConnAddr(l.addr.network)
+
+	var timeoutChan <-chan time.Time
+	if dialTimeout > 0 {
+		timer := time.NewTimer(dialTimeout)
+		defer timer.Stop()
+		timeoutChan = timer.C
+	}
+
+	select {
+	case l.connChan <- &localConn{Conn: serverConn, localAddr: l.addr, remoteAddr: clientAddr}:
+		return &localConn{Conn: clientConn, localAddr: clientAddr, remoteAddr: l.addr}, nil
+	case <-l.closeChan:
+		clientConn.Close()
+		serverConn.Close()
+		return nil, errors.New("In-memory listener is closed")
+	case <-timeoutChan:
+		clientConn.Close()
+		serverConn.Close()
+		return nil, errors.New("Dial in-memory listener timeout")
+	}
+}
+
+// Accept waits for and returns the next in-memory conn
+func (l *memListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connChan:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, errors.New("In-memory listener is closed")
+	}
+}
+
+// Close stops listening so that the port can be reused
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		memListenersLock.Lock()
+		if memListeners[l.port] == l {
+			delete(memListeners, l.port)
+		}
+		memListenersLock.Unlock()
+		close(l.closeChan)
+	})
+	return nil
+}
+
+// Addr returns the address of listener
+func (l *memListener) Addr() net.Addr {
+	return l.addr
+}
@@ -3,6 +3,7 @@ package transport
 import (
 	"errors"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -14,14 +15,50 @@ type Transport interface {
 	String() string
 }
 
+// transportFactories maps transport protocol (scheme of address) to the
+// function that creates the transport
+var (
+	transportFactories = map[string]func() Transport{
+		"kcp":  func() Transport { return NewKCPTransport() },
+		"mem":  func() Transport { return NewMemoryTransport() },
+		"tcp":  func() Transport { return NewTCPTransport() },
+		"unix": func() Transport { return NewUnixTransport() },
+	}
+	transportFactoriesLock sync.RWMutex
+)
+
+// RegisterTransport registers a transport for protocol so that addresses with
+// protocol as scheme (e.g. ws://host:port for protocol ws) can be parsed and
+// dialed. It returns error if protocol is already registered.
+func RegisterTransport(protocol string, newTransport func() Transport) error {
+	if protocol == "" {
+		return errors.New("Protocol is empty")
+	}
+	if newTransport == nil {
+		return errors.New("Transport factory is nil")
+	}
+
+	transportFactoriesLock.Lock()
+	defer transportFactoriesLock.Unlock()
+
+	if _, ok := transportFactories[protocol]; ok {
+		return errors.New("Protocol " + protocol + " is already registered")
+	}
+
+	transportFactories[protocol] = newTransport
+
+	return nil
+}
+
 // NewTransport creates a transport based on conf
 func NewTransport(protocol string) (Transport, error) {
-	switch protocol {
-	case "kcp":
-		return NewKCPTransport(), nil
-	case "tcp":
-		return NewTCPTransport(), nil
-	default:
+	transportFactoriesLock.RLock()
+	newTransport, ok := transportFactories[protocol]
+	transportFactoriesLock.RUnlock()
+
+	if !ok {
 		return nil, errors.New("Unknown protocol " + protocol)
 	}
+
+	return newTransport(), nil
 }
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// UnixTransport is the transport layer based on unix domain socket. Nodes
+// using it can only connect to nodes on the same host. Port is mapped to a
+// socket file in SocketDir, so addresses are still in host:port form.
+type UnixTransport struct {
+	SocketDir string
+}
+
+// NewUnixTransport creates a new unix domain socket transport layer with
+// socket files in the temp dir
+func NewUnixTransport() *UnixTransport {
+	t := &UnixTransport{
+		SocketDir: os.TempDir(),
+	}
+	return t
+}
+
+// socketPath returns the path of socket file of port
+func (t *UnixTransport) socketPath(port uint16) string {
+	return filepath.Join(t.SocketDir, fmt.Sprintf("nnet-%d.sock", port))
+}
+
+// Dial connects to the socket file of the port of addr
+func (t *UnixTransport) Dial(addr string, dialTimeout time.Duration) (net.Conn, error) {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout(t.GetNetwork(), t.socketPath(uint16(port)), dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAddr := newLocalConnAddr(t.GetNetwork())
+	serverAddr := &localAddr{network: t.GetNetwork(), port: uint32(port)}
+
+	return &localConn{Conn: conn, localAddr: clientAddr, remoteAddr: serverAddr}, nil
+}
+
+// Listen listens on the socket file of port, removing the stale socket file
+// left by a previous process if there is one. Port 0 is not supported as
+// there is no OS assigned port.
+func (t *UnixTransport) Listen(port uint16) (net.Listener, error) {
+	if port == 0 {
+		return nil, errors.New("Unix transport requires a non-zero port")
+	}
+
+	path := t.socketPath(port)
+	if conn, err := net.Dial(t.GetNetwork(), path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("Socket file %s is already in use", path)
+	}
+	os.Remove(path)
+
+	listener, err := net.Listen(t.GetNetwork(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unixListener{
+		Listener: listener,
+		addr:     &localAddr{network: t.GetNetwork(), port: uint32(port)},
+	}, nil
+}
+
+// GetNetwork returns the network used (tcp or udp)
+func (t *UnixTransport) GetNetwork() string {
+	return "unix"
+}
+
+func (t *UnixTransport) String() string {
+	return "unix"
+}
+
+// unixListener is a unix domain socket listener whose accepted conns have
+// host:port style addresses
+type unixListener struct {
+	net.Listener
+	addr *localAddr
+}
+
+// Accept waits for and returns the next conn
+func (l *unixListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &localConn{Conn: conn, localAddr: l.addr, remoteAddr: newLocalConnAddr(l.addr.network)}, nil
+}
+
+// Addr returns the address of listener
+func (l *unixListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,132 @@
+package util
+
+import (
+	"errors"
+	mrand "math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is the error returned by FaultyConn when an error is
+// injected
+var ErrInjectedFault = errors.New("Injected fault")
+
+// FaultyConnConfig is the configuration of faults that FaultyConn injects.
+// Zero value of each field means the fault is disabled.
+type FaultyConnConfig struct {
+	MaxReadSize      int           // Max number of bytes returned by each Read, used to cause short reads
+	ReadDelay        time.Duration // Delay before each Read
+	WriteDelay       time.Duration // Delay before each Write
+	FlipByteRate     float64       // Probability that each byte read is corrupted
+	ReadErrorAfter   int64         // Read returns ErrInjectedFault after this many bytes have been read
+	WriteErrorAfter  int64         // Write returns ErrInjectedFault after this many bytes have been written
+	RandomSeed       int64         // Seed of the random source used to corrupt bytes
+	CloseOnInjection bool          // Close underlying conn when an error is injected
+}
+
+// FaultyConn is a net.Conn wrapper that injects faults into Read and Write.
+// It is intended for testing how code handles partial reads, latency, data
+// corruption and connection errors, and should not be used in production.
+type FaultyConn struct {
+	net.Conn
+	config FaultyConnConfig
+
+	sync.Mutex
+	rand         *mrand.Rand
+	bytesRead    int64
+	bytesWritten int64
+}
+
+// NewFaultyConn wraps conn into a FaultyConn that injects faults according to
+// config
+func NewFaultyConn(conn net.Conn, config FaultyConnConfig) *FaultyConn {
+	return &FaultyConn{
+		Conn:   conn,
+		config: config,
+		rand:   mrand.New(mrand.NewSource(config.RandomSeed)),
+	}
+}
+
+// Read reads from underlying conn with faults injected
+func (fc *FaultyConn) Read(b []byte) (int, error) {
+	if fc.config.ReadDelay > 0 {
+		time.Sleep(fc.config.ReadDelay)
+	}
+
+	if fc.config.MaxReadSize > 0 && len(b) > fc.config.MaxReadSize {
+		b = b[:fc.config.MaxReadSize]
+	}
+
+	fc.Lock()
+	if fc.config.ReadErrorAfter > 0 {
+		remaining := fc.config.ReadErrorAfter - fc.bytesRead
+		if remaining <= 0 {
+			fc.Unlock()
+			return 0, fc.injectError()
+		}
+		if int64(len(b)) > remaining {
+			b = b[:remaining]
+		}
+	}
+	fc.Unlock()
+
+	n, err := fc.Conn.Read(b)
+
+	fc.Lock()
+	fc.bytesRead += int64(n)
+	if fc.config.FlipByteRate > 0 {
+		for i := 0; i < n; i++ {
+			if fc.rand.Float64() < fc.config.FlipByteRate {
+				b[i] = ^b[i]
+			}
+		}
+	}
+	fc.Unlock()
+
+	return n, err
+}
+
+// Write writes to underlying conn with faults injected
+func (fc *FaultyConn) Write(b []byte) (int, error) {
+	if fc.config.WriteDelay > 0 {
+		time.Sleep(fc.config.WriteDelay)
+	}
+
+	var injectError bool
+
+	fc.Lock()
+	if fc.config.WriteErrorAfter > 0 {
+		remaining := fc.config.WriteErrorAfter - fc.bytesWritten
+		if remaining <= 0 {
+			fc.Unlock()
+			return 0, fc.injectError()
+		}
+		if int64(len(b)) > remaining {
+			b = b[:remaining]
+			injectError = true
+		}
+	}
+	fc.Unlock()
+
+	n, err := fc.Conn.Write(b)
+
+	fc.Lock()
+	fc.bytesWritten += int64(n)
+	fc.Unlock()
+
+	if err == nil && injectError {
+		err = fc.injectError()
+	}
+
+	return n, err
+}
+
+// injectError closes underlying conn if configured and returns
+// ErrInjectedFault
+func (fc *FaultyConn) injectError() error {
+	if fc.config.CloseOnInjection {
+		fc.Conn.Close()
+	}
+	return ErrInjectedFault
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket rate limiter. Bucket size is the number of
+// tokens generated in one second.
+type RateLimiter struct {
+	sync.Mutex
+	rate     float64 // tokens per second, 0 means unlimited
+	tokens   float64
+	lastTime time.Time
+}
+
+// NewRateLimiter creates a rate limiter that generates rate tokens per second.
+// Rate 0 means unlimited.
+func NewRateLimiter(rate uint32) *RateLimiter {
+	return &RateLimiter{
+		rate:     float64(rate),
+		tokens:   float64(rate),
+		lastTime: time.Now(),
+	}
+}
+
+// SetRate changes the number of tokens generated per second. Rate 0 means
+// unlimited.
+func (rl *RateLimiter) SetRate(rate uint32) {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.refill()
+	rl.rate = float64(rate)
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+}
+
+// Rate returns the number of tokens generated per second
+func (rl *RateLimiter) Rate() uint32 {
+	rl.Lock()
+	defer rl.Unlock()
+	return uint32(rl.rate)
+}
+
+// Wait takes n tokens from bucket and blocks until they are available. n can be
+// larger than bucket size, in which case tokens are borrowed from the future
+// and later calls will wait longer.
+func (rl *RateLimiter) Wait(n int) {
+	wait := rl.Reserve(n)
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Reserve takes n tokens from bucket without blocking and returns how long the
+// caller should wait before the tokens are available, 0 if they are available
+// now. Like Wait, tokens not yet available are borrowed from the future.
+func (rl *RateLimiter) Reserve(n int) time.Duration {
+	rl.Lock()
+	defer rl.Unlock()
+
+	if rl.rate == 0 {
+		return 0
+	}
+
+	rl.refill()
+	rl.tokens -= float64(n)
+
+	if rl.tokens < 0 {
+		return time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+	}
+
+	return 0
+}
+
+// Used returns the number of tokens taken from bucket that have not been
+// refilled yet, which approximates the tokens taken in the last second. It
+// can be larger than rate if tokens are borrowed from the future. Returns 0 if
+// rate is unlimited.
+func (rl *RateLimiter) Used() uint32 {
+	rl.Lock()
+	defer rl.Unlock()
+
+	if rl.rate == 0 {
+		return 0
+	}
+
+	rl.refill()
+
+	return uint32(rl.rate - rl.tokens)
+}
+
+// refill adds the tokens generated since last refill, caller should hold the
+// lock
+func (rl *RateLimiter) refill() {
+	now := time.Now()
+	rl.tokens += now.Sub(rl.lastTime).Seconds() * rl.rate
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.lastTime = now
+}
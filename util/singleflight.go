@@ -0,0 +1,54 @@
+package util
+
+import "sync"
+
+// singleFlightCall is an in-flight or completed SingleFlight call
+type singleFlightCall struct {
+	wg   sync.WaitGroup
+	val  interface{}
+	err  error
+	dups int
+}
+
+// SingleFlight suppresses duplicate function calls with the same key that are
+// in flight at the same time
+type SingleFlight struct {
+	sync.Mutex
+	calls map[string]*singleFlightCall
+}
+
+// NewSingleFlight creates a SingleFlight
+func NewSingleFlight() *SingleFlight {
+	return &SingleFlight{
+		calls: make(map[string]*singleFlightCall),
+	}
+}
+
+// Do calls fn and returns its result. If there is already an in-flight call
+// with the same key, Do waits for it to complete and returns its result instead
+// of calling fn again. Shared is true if the result is given to more than one
+// caller.
+func (sf *SingleFlight) Do(key string, fn func() (interface{}, error)) (interface{}, bool, error) {
+	sf.Lock()
+	if call, ok := sf.calls[key]; ok {
+		call.dups++
+		sf.Unlock()
+		call.wg.Wait()
+		return call.val, true, call.err
+	}
+
+	call := &singleFlightCall{}
+	call.wg.Add(1)
+	sf.calls[key] = call
+	sf.Unlock()
+
+	call.val, call.err = fn()
+	call.wg.Done()
+
+	sf.Lock()
+	delete(sf.calls, key)
+	shared := call.dups > 0
+	sf.Unlock()
+
+	return call.val, shared, call.err
+}